@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/ll/pcap"
+	"os"
+	"time"
+)
+
+func (c *cli) capture(args []string) (err error) {
+	fs := newFlagSet("capture", c)
+	cycles := fs.Int("n", 100, "number of polling cycles, 0 polls until interrupted")
+	interval := fs.Duration("interval", 10*time.Millisecond, "time between cycles")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	if err = needArgs(fs, 1, 1, "file"); err != nil {
+		return
+	}
+
+	n, err := ecmd.CountSlaves(c.ctx, c.c, ecmd.Options{})
+	if err != nil {
+		return
+	}
+
+	f, err := os.Create(fs.Arg(0))
+	if err != nil {
+		return
+	}
+	bw := bufio.NewWriter(f)
+	defer func() {
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	// not created with CreateCaptureFile, the bus is closed by run
+	cf, err := pcap.NewCaptureFramer(c.framer, bw, c.encap)
+	if err != nil {
+		return
+	}
+	cmdr := ecmd.NewCommandFramer(cf)
+
+	// without a number of cycles, the capture runs until interrupted
+	interrupted := func() bool { return *cycles == 0 && c.ctx.Err() != nil }
+
+	var lost int
+	i := 0
+	for ; *cycles == 0 || i < *cycles; i++ {
+		if i > 0 {
+			select {
+			case <-c.ctx.Done():
+			case <-time.After(*interval):
+			}
+		}
+		if interrupted() {
+			break
+		}
+		if err = c.ctx.Err(); err != nil {
+			return
+		}
+
+		_, _, err = ecmd.ExecuteReadContext(c.ctx, cmdr, ecfr.BroadcastAddr(ecad.ALStatus), ecad.ALStatusReg{}.Len(), uint16(n), ecmd.Options{FramelossTries: 1})
+		if interrupted() {
+			break
+		}
+		if err != nil {
+			lost++
+		}
+		if cf.Err != nil {
+			return cf.Err
+		}
+	}
+	fmt.Fprintf(c.out, "captured %d cycles of %d slaves, %d failed\n", i, n, lost)
+	return nil
+}
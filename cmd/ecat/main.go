@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/ll/pcap"
+	"github.com/distributed/ecat/ll/raw"
+	"github.com/distributed/ecat/ll/udp"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `usage: ecat [flags] command [arguments]
+
+commands:
+  scan                          list the slaves on the bus
+  diag [-n scans] [-interval d] [-clear]
+                                error counters and suspect cables
+  reg read [slave] offset [n]   read n bytes of ESC memory
+  reg write [slave] offset data write hex bytes to ESC memory
+  sii dump [slave] [-o file]    read the SII image
+  sii write [slave] file        write an SII image
+  sii decode [slave] [-f file]  decode the SII of a slave or an image file
+  state get [slave]             show the AL state
+  state set [slave] state       request INIT, PREOP, BOOT, SAFEOP or OP
+  sdo read [slave] index:sub    upload an object
+  sdo write [slave] index:sub data
+                                download hex bytes to an object
+  watchdog get [slave]          show the watchdog times and expirations
+  watchdog set [slave] [-pd d] [-pdi d] [-unit d] [-clear]
+                                configure the watchdogs
+  capture [-n cycles] [-interval d] file
+                                record bus traffic while polling the slaves
+
+slaves are selected with -p position (default 0) or -a station address.
+
+flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := &cli{ctx: ctx, out: os.Stdout}
+	if err := c.run(os.Args[1:]); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "ecat: %v\n", err)
+		}
+		os.Exit(2)
+	}
+}
+
+type cli struct {
+	ctx context.Context
+	out io.Writer
+
+	// set by tests to keep the simulated bus between runs
+	sim *simBus
+
+	// the bus without capturing
+	framer ecmd.Framer
+	encap  pcap.Encapsulation
+	c      ecmd.Commander
+}
+
+type command func(c *cli, args []string) error
+
+var commands = map[string]map[string]command{
+	"scan":     {"": (*cli).scan},
+	"diag":     {"": (*cli).diag},
+	"capture":  {"": (*cli).capture},
+	"reg":      {"read": (*cli).regRead, "write": (*cli).regWrite},
+	"sii":      {"dump": (*cli).siiDump, "write": (*cli).siiWrite, "decode": (*cli).siiDecode},
+	"state":    {"get": (*cli).stateGet, "set": (*cli).stateSet},
+	"sdo":      {"read": (*cli).sdoRead, "write": (*cli).sdoWrite},
+	"watchdog": {"get": (*cli).watchdogGet, "set": (*cli).watchdogSet},
+}
+
+func (c *cli) run(args []string) (err error) {
+	fs := flag.NewFlagSet("ecat", flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	udpIface := fs.String("udp", "", "use EtherCAT over UDP on this interface")
+	group := fs.String("group", "", "multicast group for -udp")
+	target := fs.String("target", "", "use EtherCAT over UDP with this unicast gateway, host[:port]")
+	bind := fs.String("bind", "", "local address for -udp and -target, host:port")
+	ethIface := fs.String("eth", "", "use raw Ethernet on this interface")
+	useSim := fs.Bool("sim", false, "use a simulated bus")
+	simSlaves := fs.Int("sim-slaves", 3, "number of simulated slaves")
+	cycle := fs.Duration("cycle", 10*time.Millisecond, "time to wait for returning frames")
+	pcapPath := fs.String("pcap", "", "record the traffic to this pcapng file")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	sub, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	cmd, args := sub[""], args[1:]
+	if cmd == nil {
+		if len(args) == 0 || sub[args[0]] == nil {
+			return fmt.Errorf("%s needs one of %s", fs.Arg(0), strings.Join(subcommandNames(sub), ", "))
+		}
+		cmd, args = sub[args[0]], args[1:]
+	}
+
+	var framer ecmd.Framer
+	encap := pcap.EncapEthernet
+	switch {
+	case *useSim:
+		if c.sim == nil {
+			c.sim = newSimBus(*simSlaves)
+		}
+		framer = c.sim.bus
+	case *udpIface != "" || *target != "":
+		if framer, err = udpFramer(*udpIface, *group, *target, *bind, *cycle); err != nil {
+			return
+		}
+		encap = pcap.EncapUDP
+	case *ethIface != "":
+		var iface *net.Interface
+		if iface, err = net.InterfaceByName(*ethIface); err != nil {
+			return
+		}
+		if framer, err = raw.NewEthernetFramer(iface, *cycle); err != nil {
+			return
+		}
+	default:
+		return errors.New("select a bus with -udp, -target, -eth or -sim")
+	}
+
+	c.framer, c.encap = framer, encap
+	if *pcapPath != "" {
+		var cf *pcap.CaptureFramer
+		if cf, err = pcap.CreateCaptureFile(framer, *pcapPath, encap); err != nil {
+			closeFramer(framer)
+			return
+		}
+		framer = cf
+	}
+	defer func() {
+		if cerr := closeFramer(framer); err == nil {
+			err = cerr
+		}
+	}()
+
+	c.c = ecmd.NewCommandFramer(framer)
+	return cmd(c, args)
+}
+
+func udpFramer(ifname, group, target, bind string, cycle time.Duration) (f *udp.UDPFramer, err error) {
+	cfg := udp.Config{CycleTime: cycle}
+	if ifname != "" {
+		if cfg.Interface, err = net.InterfaceByName(ifname); err != nil {
+			return
+		}
+	}
+
+	if target != "" {
+		if _, _, err = net.SplitHostPort(target); err != nil {
+			// the port is optional
+			target = net.JoinHostPort(target, "0")
+		}
+		if cfg.Target, err = net.ResolveUDPAddr("udp", target); err != nil {
+			return
+		}
+		if cfg.Target.IP.IsMulticast() {
+			return nil, fmt.Errorf("target %v is a multicast group, use -udp and -group", cfg.Target)
+		}
+	} else {
+		ip := net.ParseIP(group)
+		if ip == nil || !ip.IsMulticast() {
+			return nil, fmt.Errorf("invalid multicast group %q", group)
+		}
+		cfg.Target = &net.UDPAddr{IP: ip}
+		cfg.DebugAddr = &net.UDPAddr{IP: ip, Port: udp.DefaultDebugPort}
+	}
+
+	if bind != "" {
+		if cfg.LocalAddr, err = net.ResolveUDPAddr("udp", bind); err != nil {
+			return
+		}
+	}
+	return udp.NewUDPFramerConfig(cfg)
+}
+
+func subcommandNames(sub map[string]command) (names []string) {
+	for _, n := range []string{"read", "write", "dump", "decode", "get", "set"} {
+		if sub[n] != nil {
+			names = append(names, n)
+		}
+	}
+	return
+}
+
+func closeFramer(f ecmd.Framer) error {
+	if c, ok := f.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// selects a slave by position or station address.
+type slaveFlags struct {
+	position int
+	station  uint
+}
+
+func newFlagSet(name string, c *cli) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	return fs
+}
+
+func addSlaveFlags(fs *flag.FlagSet) *slaveFlags {
+	s := &slaveFlags{}
+	fs.IntVar(&s.position, "p", 0, "position of the slave")
+	fs.UintVar(&s.station, "a", 0, "station address of the slave, instead of -p")
+	return s
+}
+
+func (s *slaveFlags) addr(offset uint16) ecfr.DatagramAddress {
+	if s.station != 0 {
+		return ecfr.FixedAddr(uint16(s.station), offset)
+	}
+	return ecfr.PositionalAddr(int16(-s.position), offset)
+}
+
+func parseUint(s string, bits int) (uint64, error) {
+	v, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return v, nil
+}
+
+// hex bytes, optionally separated by spaces, colons or commas.
+func parseHex(args []string) (b []byte, err error) {
+	s := strings.Join(args, "")
+	s = strings.NewReplacer(" ", "", ":", "", ",", "", "0x", "").Replace(s)
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("odd number of hex digits in %q", s)
+	}
+	for i := 0; i < len(s); i += 2 {
+		v, err := strconv.ParseUint(s[i:i+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex byte %q", s[i:i+2])
+		}
+		b = append(b, uint8(v))
+	}
+	return
+}
+
+func (c *cli) hexdump(base uint16, b []byte) {
+	for i := 0; i < len(b); i += 16 {
+		end := i + 16
+		if end > len(b) {
+			end = len(b)
+		}
+		fmt.Fprintf(c.out, "%04x: % x\n", int(base)+i, b[i:end])
+	}
+}
+
+func needArgs(fs *flag.FlagSet, min, max int, what string) error {
+	if n := fs.NArg(); n < min || n > max {
+		return fmt.Errorf("%s: want %s", fs.Name(), what)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/distributed/ecat/ecee"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ll/pcap"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCLI struct {
+	t *testing.T
+	c *cli
+	b bytes.Buffer
+}
+
+func newTestCLI(t *testing.T) *testCLI {
+	tc := &testCLI{t: t}
+	tc.c = &cli{ctx: context.Background(), out: &tc.b, sim: newSimBus(3)}
+	return tc
+}
+
+// runs a command against the simulated bus and returns its output.
+func (tc *testCLI) run(args ...string) string {
+	tc.t.Helper()
+	tc.b.Reset()
+	if err := tc.c.run(append([]string{"-sim"}, args...)); err != nil {
+		tc.t.Fatalf("ecat %s: %v\n%s", strings.Join(args, " "), err, tc.b.String())
+	}
+	return tc.b.String()
+}
+
+func (tc *testCLI) fail(args ...string) error {
+	tc.t.Helper()
+	tc.b.Reset()
+	err := tc.c.run(append([]string{"-sim"}, args...))
+	if err == nil {
+		tc.t.Fatalf("ecat %s succeeded\n%s", strings.Join(args, " "), tc.b.String())
+	}
+	return err
+}
+
+func wantOutput(t *testing.T, out string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Fatalf("want %q in output\n%s", w, out)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tc := newTestCLI(t)
+	out := tc.run("scan")
+	wantOutput(t, out, "3 slaves", "0x00000ec4  0x00010002")
+	if n := strings.Count(out, "INIT+ERR"); n != 3 {
+		t.Fatalf("want 3 slaves in INIT with error\n%s", out)
+	}
+
+	wantOutput(t, tc.run("diag", "-clear"), "3 slaves")
+}
+
+func TestReg(t *testing.T) {
+	tc := newTestCLI(t)
+	tc.run("reg", "write", "-p", "1", "0x10", "34:12")
+	wantOutput(t, tc.run("reg", "read", "-p", "1", "0x10", "2"), "0010: 34 12")
+	wantOutput(t, tc.run("reg", "read", "-a", "0x1234", "0x10", "2"), "0010: 34 12")
+	wantOutput(t, tc.run("reg", "read", "-p", "0", "0x10", "2"), "0010: 00 00")
+
+	tc.fail("reg", "write", "0x10", "123")
+	tc.fail("reg", "read")
+	tc.fail("reg", "erase")
+}
+
+func TestSII(t *testing.T) {
+	tc := newTestCLI(t)
+	wantOutput(t, tc.run("sii", "decode", "-p", "2"),
+		"SIM0002 simulated slave 2", "product code     0x00010002", "checksum         0x", " ok",
+		"mailbox          out 0x1000/128 in 0x1080/128 protocols 0x0004")
+
+	path := filepath.Join(t.TempDir(), "sii.bin")
+	tc.run("sii", "dump", "-p", "2", "-o", path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, simSII(2)[:len(b)]) {
+		t.Fatalf("dumped image differs from the simulated one")
+	}
+
+	// give slave 0 the image of slave 2
+	wantOutput(t, tc.run("sii", "write", path), "wrote")
+	wantOutput(t, tc.run("sii", "decode"), "SIM0002 simulated slave 2")
+	wantOutput(t, tc.run("sii", "decode", "-f", path), "SIM0002 simulated slave 2")
+
+	b[2*ecee.SIIConfiguredStationAlias] ^= 0xff
+	if err = os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	tc.fail("sii", "write", path)
+}
+
+func TestState(t *testing.T) {
+	tc := newTestCLI(t)
+	wantOutput(t, tc.run("state", "get", "-p", "1"), "INIT, error")
+	wantOutput(t, tc.run("state", "set", "-p", "1", "preop"), "PREOP")
+	wantOutput(t, tc.run("state", "get", "-p", "1"), "PREOP\n")
+	wantOutput(t, tc.run("state", "get", "-p", "0"), "INIT, error")
+
+	tc.fail("state", "set", "-p", "1", "running")
+}
+
+func TestSDO(t *testing.T) {
+	tc := newTestCLI(t)
+	wantOutput(t, tc.run("sdo", "read", "-p", "1", "0x1008"), `"SIM0001"`)
+	wantOutput(t, tc.run("sdo", "read", "-p", "1", "0x1018:1"), "c4 0e 00 00")
+
+	tc.run("sdo", "write", "-p", "1", "0x2000", "beef")
+	wantOutput(t, tc.run("sdo", "read", "-p", "1", "0x2000"), "be ef")
+
+	// segmented both ways
+	long := strings.Repeat("0123456789abcdef", 12)
+	tc.run("sdo", "write", "-p", "2", "0x2001", long)
+	out := tc.run("sdo", "read", "-p", "2", "0x2001")
+	if d, err := parseHex(strings.Fields(out)); err != nil || len(d) != len(long)/2 {
+		t.Fatalf("read back %q", out)
+	}
+
+	tc.fail("sdo", "read", "-p", "1", "0x3000")
+	tc.fail("sdo", "read", "-p", "1", "0x1008:x")
+}
+
+func TestWatchdog(t *testing.T) {
+	tc := newTestCLI(t)
+	wantOutput(t, tc.run("watchdog", "get", "-p", "1"), "unit          100µs", "process data  100ms, ok, 0 expirations")
+	tc.run("watchdog", "set", "-p", "1", "-unit", "1ms", "-pd", "20ms", "-pdi", "0", "-clear")
+	wantOutput(t, tc.run("watchdog", "get", "-p", "1"), "unit          1ms", "process data  20ms", "pdi           0s")
+	wantOutput(t, tc.run("watchdog", "get", "-p", "0"), "process data  100ms")
+
+	tc.fail("watchdog", "set", "-pd", "1h")
+}
+
+func TestCapture(t *testing.T) {
+	tc := newTestCLI(t)
+	path := filepath.Join(t.TempDir(), "bus.pcapng")
+	wantOutput(t, tc.run("capture", "-n", "5", "-interval", "0", path), "captured 5 cycles of 3 slaves, 0 failed")
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		if _, err = r.ReadPacket(); err != nil {
+			break
+		}
+		n++
+	}
+	if n != 10 {
+		t.Fatalf("want 10 packets, have %d", n)
+	}
+}
+
+// answers frames sent over UDP with the slaves of a simulated bus.
+func TestUntilInterrupted(t *testing.T) {
+	tc := newTestCLI(t)
+	interrupt := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+		tc.c.ctx = ctx
+	}
+
+	interrupt()
+	path := filepath.Join(t.TempDir(), "bus.pcapng")
+	wantOutput(t, tc.run("capture", "-n", "0", "-interval", "1ms", path), "cycles of 3 slaves, 0 failed")
+
+	interrupt()
+	wantOutput(t, tc.run("diag", "-n", "0", "-interval", "1ms"), "3 slaves")
+
+	// a given number of scans is cut short by an interrupt
+	interrupt()
+	if err := tc.fail("diag", "-n", "1000000", "-interval", "1ms"); err != context.DeadlineExceeded {
+		t.Fatalf("want deadline exceeded, have %v", err)
+	}
+}
+
+func serveUDP(t *testing.T, b *simBus) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var fr ecfr.Frame
+			if _, err = fr.Overlay(buf[:n]); err != nil {
+				continue
+			}
+			for _, s := range b.slaves {
+				s.ProcessFrame(&fr)
+			}
+			if d, err := fr.Commit(); err == nil {
+				conn.WriteToUDP(d, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestUDPTarget(t *testing.T) {
+	addr := serveUDP(t, newSimBus(2))
+	var b bytes.Buffer
+	c := &cli{ctx: context.Background(), out: &b}
+	if err := c.run([]string{"-target", addr.String(), "-bind", "127.0.0.1:0", "scan"}); err != nil {
+		t.Fatalf("%v\n%s", err, b.String())
+	}
+	wantOutput(t, b.String(), "2 slaves", "0x00010001")
+
+	if err := c.run([]string{"-target", "239.1.2.3", "scan"}); err == nil {
+		t.Fatal("accepted a multicast target")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var b bytes.Buffer
+	c := &cli{ctx: context.Background(), out: &b}
+	if err := c.run(nil); err == nil || !strings.Contains(b.String(), "commands:") {
+		t.Fatalf("want usage, have %v\n%s", err, b.String())
+	}
+	if err := c.run([]string{"scan"}); err == nil {
+		t.Fatal("ran without a bus")
+	}
+	if err := c.run([]string{"-sim", "bogus"}); err == nil {
+		t.Fatal("ran an unknown command")
+	}
+}
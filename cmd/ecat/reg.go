@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/distributed/ecat/ecmd"
+)
+
+func (c *cli) regRead(args []string) error {
+	fs := newFlagSet("reg read", c)
+	slave := addSlaveFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 1, 2, "offset [n]"); err != nil {
+		return err
+	}
+
+	offs, err := parseUint(fs.Arg(0), 16)
+	if err != nil {
+		return err
+	}
+	n := uint64(1)
+	if fs.NArg() > 1 {
+		if n, err = parseUint(fs.Arg(1), 16); err != nil {
+			return err
+		}
+	}
+
+	d, _, err := ecmd.ExecuteReadContext(c.ctx, c.c, slave.addr(uint16(offs)), int(n), 1, ecmd.Options{})
+	if err != nil {
+		return err
+	}
+	c.hexdump(uint16(offs), d)
+	return nil
+}
+
+func (c *cli) regWrite(args []string) error {
+	fs := newFlagSet("reg write", c)
+	slave := addSlaveFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 2, 1<<16, "offset data"); err != nil {
+		return err
+	}
+
+	offs, err := parseUint(fs.Arg(0), 16)
+	if err != nil {
+		return err
+	}
+	w, err := parseHex(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	_, err = ecmd.ExecuteWriteContext(c.ctx, c.c, slave.addr(uint16(offs)), w, 1, ecmd.Options{})
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecdiag"
+	"github.com/distributed/ecat/ecee"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"time"
+)
+
+func (c *cli) scan(args []string) error {
+	fs := newFlagSet("scan", c)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 0, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	n, err := ecmd.CountSlaves(c.ctx, c.c, ecmd.Options{})
+	if err != nil {
+		return err
+	}
+
+	type reads struct{ esc, station, al *ecmd.BatchOp }
+	ops := make([]reads, n)
+	var b ecmd.Batch
+	for i := range ops {
+		pos := int16(-i)
+		ops[i].esc = b.Read(ecfr.PositionalAddr(pos, ecad.Type), 4, 1)
+		ops[i].station = b.Read(ecfr.PositionalAddr(pos, ecad.ConfiguredStationAddress), 2, 1)
+		ops[i].al = b.Read(ecfr.PositionalAddr(pos, ecad.ALStatus), ecad.ALStatusReg{}.Len(), 1)
+	}
+	if err = b.ExecuteContext(c.ctx, c.c); err != nil {
+		return err
+	}
+	if err = b.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "%d slaves\n", n)
+	fmt.Fprintf(c.out, "pos  station  type  rev  build   state   vendor      product     revision\n")
+	for i, o := range ops {
+		var al ecad.ALStatusReg
+		if err = al.Decode(o.al.Data); err != nil {
+			return err
+		}
+		state := al.State.String()
+		if al.Error {
+			state += "+ERR"
+		}
+
+		ee, err := ecee.NewContext(c.ctx, c.c, ecfr.PositionalAddr(int16(-i), 0))
+		if err != nil {
+			return err
+		}
+		var id [3]uint32
+		for j := range id {
+			w := uint32(ecee.SIIVendorID + 2*j)
+			lo, err := ee.ReadWordContext(c.ctx, w)
+			if err != nil {
+				return err
+			}
+			hi, err := ee.ReadWordContext(c.ctx, w+1)
+			if err != nil {
+				return err
+			}
+			id[j] = uint32(lo) | uint32(hi)<<16
+		}
+		ee.Close()
+
+		e := o.esc.Data
+		fmt.Fprintf(c.out, "%3d  %#04x   %#02x  %#02x  %#04x  %-7s %#08x  %#08x  %#08x\n",
+			i, uint16(o.station.Data[0])|uint16(o.station.Data[1])<<8,
+			e[0], e[1], uint16(e[2])|uint16(e[3])<<8,
+			state, id[0], id[1], id[2])
+	}
+	return nil
+}
+
+func (c *cli) diag(args []string) error {
+	fs := newFlagSet("diag", c)
+	scans := fs.Int("n", 1, "number of scans, 0 scans until interrupted")
+	interval := fs.Duration("interval", time.Second, "time between scans")
+	clear := fs.Bool("clear", false, "clear the error counters first")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 0, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	d := ecdiag.New()
+	if *clear {
+		if err := d.Clear(c.ctx, c.c); err != nil {
+			return err
+		}
+	}
+
+	// without a number of scans, scanning goes on until interrupted
+	interrupted := func() bool { return *scans == 0 && c.ctx.Err() != nil }
+
+	for i := 0; *scans == 0 || i < *scans; i++ {
+		if i > 0 {
+			select {
+			case <-c.ctx.Done():
+			case <-time.After(*interval):
+			}
+		}
+		if interrupted() {
+			return nil
+		}
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+
+		r, err := d.Scan(c.ctx, c.c)
+		if interrupted() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(c.out, r.MultilineSummary())
+	}
+	return nil
+}
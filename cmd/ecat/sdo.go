@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecee"
+	"github.com/distributed/ecat/ecoe"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func parseObject(s string) (index uint16, sub uint8, err error) {
+	is, ss := s, "0"
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		is, ss = s[:i], s[i+1:]
+	}
+	iv, err := strconv.ParseUint(is, 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid object index %q", is)
+	}
+	sv, err := strconv.ParseUint(ss, 0, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid object subindex %q", ss)
+	}
+	return uint16(iv), uint8(sv), nil
+}
+
+// opens the mailbox of the slave. if the slave has none configured, it is
+// set up from the standard mailbox in the SII.
+func (c *cli) openMailbox(slave *slaveFlags) (m *ecoe.Mailbox, err error) {
+	if m, err = ecoe.OpenMailbox(c.ctx, c.c, slave.addr(0)); err == nil {
+		return
+	}
+
+	ee, err := ecee.NewContext(c.ctx, c.c, slave.addr(0))
+	if err != nil {
+		return
+	}
+	defer ee.Close()
+	var w [4]uint16
+	for i := range w {
+		if w[i], err = ee.ReadWordContext(c.ctx, uint32(ecee.SIIStandardMailbox+i)); err != nil {
+			return
+		}
+	}
+	if w[1] == 0 || w[3] == 0 {
+		return nil, fmt.Errorf("slave has no mailbox")
+	}
+
+	return ecoe.ConfigureMailbox(c.ctx, c.c, slave.addr(0), ecoe.SyncManager{Start: w[0], Length: w[1]}, ecoe.SyncManager{Start: w[2], Length: w[3]})
+}
+
+func printable(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *cli) sdoRead(args []string) error {
+	fs := newFlagSet("sdo read", c)
+	slave := addSlaveFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 1, 1, "index:sub"); err != nil {
+		return err
+	}
+	index, sub, err := parseObject(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	m, err := c.openMailbox(slave)
+	if err != nil {
+		return err
+	}
+	if err = m.Flush(c.ctx); err != nil {
+		return err
+	}
+	d, err := m.Upload(c.ctx, index, sub)
+	if err != nil {
+		return err
+	}
+
+	if printable(d) {
+		fmt.Fprintf(c.out, "% x  %q\n", d, d)
+	} else {
+		fmt.Fprintf(c.out, "% x\n", d)
+	}
+	return nil
+}
+
+func (c *cli) sdoWrite(args []string) error {
+	fs := newFlagSet("sdo write", c)
+	slave := addSlaveFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 2, 1<<16, "index:sub data"); err != nil {
+		return err
+	}
+	index, sub, err := parseObject(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	d, err := parseHex(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	m, err := c.openMailbox(slave)
+	if err != nil {
+		return err
+	}
+	if err = m.Flush(c.ctx); err != nil {
+		return err
+	}
+	return m.Download(c.ctx, index, sub, d)
+}
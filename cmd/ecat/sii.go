@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecee"
+	"os"
+)
+
+func (c *cli) readSII(slave *slaveFlags) ([]byte, error) {
+	ee, err := ecee.NewContext(c.ctx, c.c, slave.addr(0))
+	if err != nil {
+		return nil, err
+	}
+	defer ee.Close()
+	return ecee.ReadSII(c.ctx, ee)
+}
+
+func (c *cli) siiDump(args []string) error {
+	fs := newFlagSet("sii dump", c)
+	slave := addSlaveFlags(fs)
+	out := fs.String("o", "", "write the binary image to this file instead of a hex dump")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 0, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	b, err := c.readSII(slave)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return os.WriteFile(*out, b, 0666)
+	}
+	c.hexdump(0, b)
+	return nil
+}
+
+func (c *cli) siiWrite(args []string) error {
+	fs := newFlagSet("sii write", c)
+	slave := addSlaveFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 1, 1, "file"); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// refuse what would not even decode
+	if _, err = ecee.DecodeSII(b); err != nil {
+		return err
+	}
+	if uint8(b[2*ecee.SIIChecksum]) != ecee.Checksum(b) {
+		return fmt.Errorf("checksum of the ESC configuration area is %#02x, want %#02x", b[2*ecee.SIIChecksum], ecee.Checksum(b))
+	}
+
+	ee, err := ecee.NewContext(c.ctx, c.c, slave.addr(0))
+	if err != nil {
+		return err
+	}
+	defer ee.Close()
+	if err = ecee.WriteSII(c.ctx, ee, b); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "wrote %d bytes\n", len(b))
+	return nil
+}
+
+func (c *cli) siiDecode(args []string) error {
+	fs := newFlagSet("sii decode", c)
+	slave := addSlaveFlags(fs)
+	file := fs.String("f", "", "decode this image file instead of the SII of a slave")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 0, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	var b []byte
+	var err error
+	if *file != "" {
+		b, err = os.ReadFile(*file)
+	} else {
+		b, err = c.readSII(slave)
+	}
+	if err != nil {
+		return err
+	}
+
+	s, err := ecee.DecodeSII(b)
+	if err != nil {
+		return err
+	}
+
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(c.out, format+"\n", args...)
+	}
+	p("name             %s", s.Name)
+	p("order            %s", s.Order)
+	p("group            %s", s.Group)
+	p("vendor id        %#08x", s.VendorID)
+	p("product code     %#08x", s.ProductCode)
+	p("revision         %#08x", s.RevisionNumber)
+	p("serial number    %#08x", s.SerialNumber)
+	p("pdi control      %#04x", s.PDIControl)
+	p("station alias    %#04x", s.ConfiguredStationAlias)
+	check := "ok"
+	if uint8(s.Checksum) != ecee.Checksum(b) {
+		check = fmt.Sprintf("want %#02x", ecee.Checksum(b))
+	}
+	p("checksum         %#02x %s", s.Checksum, check)
+	m := s.StandardMailbox
+	p("mailbox          out %#04x/%d in %#04x/%d protocols %#04x", m.OutOffset, m.OutSize, m.InOffset, m.InSize, s.MailboxProtocol)
+	m = s.BootstrapMailbox
+	p("bootstrap mbx    out %#04x/%d in %#04x/%d", m.OutOffset, m.OutSize, m.InOffset, m.InSize)
+	p("size             %d bytes, version %d", s.Size, s.Version)
+	for _, cat := range s.Categories {
+		p("category %5d   %d bytes", cat.Type, len(cat.Data))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/distributed/ecat/ecee"
+	"github.com/distributed/ecat/sim"
+)
+
+const (
+	simVendorID = 0x00000ec4
+	simMbxOut   = 0x1000
+	simMbxIn    = 0x1080
+	simMbxLen   = 0x80
+)
+
+// a bus of simulated slaves with SII and CoE, for trying out commands and
+// for tests.
+type simBus struct {
+	bus    *sim.L2Bus
+	slaves []*sim.L2Slave
+	ods    []sim.ObjectDictionary
+}
+
+func simSII(i int) []byte {
+	name := fmt.Sprintf("SIM%04d simulated slave %d", i, i)
+	general := make([]byte, 32)
+	// group, image, order and name string indices
+	copy(general, []byte{1, 0, 2, 3})
+
+	s := &ecee.SII{
+		PDIControl:      0x0005,
+		VendorID:        simVendorID,
+		ProductCode:     0x00010000 + uint32(i),
+		RevisionNumber:  0x00000001,
+		SerialNumber:    uint32(1000 + i),
+		StandardMailbox: ecee.SIIMailbox{OutOffset: simMbxOut, OutSize: simMbxLen, InOffset: simMbxIn, InSize: simMbxLen},
+		MailboxProtocol: ecee.SIIMailboxCoE,
+		Size:            2048,
+		Version:         1,
+		Categories: []ecee.SIICategory{
+			ecee.StringsCategory("Sim", fmt.Sprintf("SIM%04d", i), name),
+			{Type: ecee.SIICategoryGeneral, Data: general},
+		},
+	}
+	return s.Encode()
+}
+
+func simOD(i int) sim.ObjectDictionary {
+	return sim.ObjectDictionary{
+		{Index: 0x1000}:              {Data: []byte{0x00, 0x00, 0x00, 0x00}, Access: sim.ODReadOnly},
+		{Index: 0x1008}:              {Data: []byte(fmt.Sprintf("SIM%04d", i)), Access: sim.ODReadOnly, Variable: true},
+		{Index: 0x1018, SubIndex: 0}: {Data: []byte{0x02}, Access: sim.ODReadOnly},
+		{Index: 0x1018, SubIndex: 1}: {Data: []byte{simVendorID & 0xff, simVendorID >> 8, 0, 0}, Access: sim.ODReadOnly},
+		{Index: 0x1018, SubIndex: 2}: {Data: []byte{uint8(i), 0, 0x01, 0}, Access: sim.ODReadOnly},
+		{Index: 0x2000}:              {Data: make([]byte, 2)},
+		{Index: 0x2001}:              {Data: make([]byte, 0), Variable: true, MaxLength: 256},
+	}
+}
+
+func newSimBus(n int) *simBus {
+	b := &simBus{bus: &sim.L2Bus{}}
+	for i := 0; i < n; i++ {
+		s := sim.NewL2Slave()
+		// cannot fail, the image is small
+		s.EEPROM.LoadSII(bytes.NewReader(simSII(i)))
+		od := simOD(i)
+		s.Mailbox = sim.NewCoEServer(od)
+		// as left behind by an earlier master
+		s.SetupMailbox(simMbxOut, simMbxLen, simMbxIn, simMbxLen)
+
+		b.slaves = append(b.slaves, s)
+		b.ods = append(b.ods, od)
+		b.bus.Slaves = append(b.bus.Slaves, s)
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecmd"
+	"strings"
+	"time"
+)
+
+func (c *cli) readALStatus(slave *slaveFlags) (st ecad.ALStatusReg, code uint16, err error) {
+	// status and status code in one read, the code follows at 0x134
+	d, _, err := ecmd.ExecuteReadContext(c.ctx, c.c, slave.addr(ecad.ALStatus), ecad.ALStatusCode-ecad.ALStatus+2, 1, ecmd.Options{})
+	if err != nil {
+		return
+	}
+	if err = st.Decode(d); err != nil {
+		return
+	}
+	code = uint16(d[ecad.ALStatusCode-ecad.ALStatus]) | uint16(d[ecad.ALStatusCode-ecad.ALStatus+1])<<8
+	return
+}
+
+func (c *cli) stateGet(args []string) error {
+	fs := newFlagSet("state get", c)
+	slave := addSlaveFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 0, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	st, code, err := c.readALStatus(slave)
+	if err != nil {
+		return err
+	}
+	if st.Error {
+		fmt.Fprintf(c.out, "%v, error, status code %#04x\n", st.State, code)
+	} else {
+		fmt.Fprintf(c.out, "%v\n", st.State)
+	}
+	return nil
+}
+
+func parseState(s string) (ecad.ALState, error) {
+	for _, st := range []ecad.ALState{ecad.ALStateInit, ecad.ALStatePreOp, ecad.ALStateBootstrap, ecad.ALStateSafeOp, ecad.ALStateOp} {
+		if strings.EqualFold(s, st.String()) {
+			return st, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid state %q, want INIT, PREOP, BOOT, SAFEOP or OP", s)
+}
+
+func (c *cli) stateSet(args []string) error {
+	fs := newFlagSet("state set", c)
+	slave := addSlaveFlags(fs)
+	timeout := fs.Duration("timeout", 5*time.Second, "time for the slave to reach the state")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 1, 1, "state"); err != nil {
+		return err
+	}
+	want, err := parseState(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	st, _, err := c.readALStatus(slave)
+	if err != nil {
+		return err
+	}
+	// a slave in error ignores requests that do not acknowledge it
+	ctl := ecad.ALControlReg{State: want, ErrorAck: st.Error}
+	w := make([]byte, ctl.Len())
+	ctl.Encode(w)
+	if _, err = ecmd.ExecuteWriteContext(c.ctx, c.c, slave.addr(ecad.ALControl), w, 1, ecmd.Options{}); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for {
+		st, code, err := c.readALStatus(slave)
+		if err != nil {
+			return err
+		}
+		if st.Error {
+			return fmt.Errorf("slave refused %v in %v, status code %#04x", want, st.State, code)
+		}
+		if st.State == want {
+			fmt.Fprintf(c.out, "%v\n", st.State)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("slave still in %v after %v", st.State, *timeout)
+		}
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
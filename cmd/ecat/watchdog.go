@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecwd"
+	"time"
+)
+
+func (c *cli) watchdogGet(args []string) error {
+	fs := newFlagSet("watchdog get", c)
+	slave := addSlaveFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 0, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	wd, err := ecwd.Read(c.ctx, c.c, slave.addr(0))
+	if err != nil {
+		return err
+	}
+	cfg := ecwd.ConfigOf(wd)
+	state := "ok"
+	if !wd.ProcessDataOK {
+		state = "expired"
+	}
+	fmt.Fprintf(c.out, "unit          %v\n", cfg.Unit)
+	fmt.Fprintf(c.out, "process data  %v, %s, %d expirations\n", cfg.ProcessData, state, wd.CounterProcessData)
+	fmt.Fprintf(c.out, "pdi           %v, %d expirations\n", cfg.PDI, wd.CounterPDI)
+	return nil
+}
+
+func (c *cli) watchdogSet(args []string) error {
+	fs := newFlagSet("watchdog set", c)
+	slave := addSlaveFlags(fs)
+	var cfg ecwd.Config
+	fs.DurationVar(&cfg.Unit, "unit", ecwd.DefaultUnit, "resolution of the watchdog times")
+	fs.DurationVar(&cfg.ProcessData, "pd", 100*time.Millisecond, "process data watchdog time, 0 disables")
+	fs.DurationVar(&cfg.PDI, "pdi", 100*time.Millisecond, "PDI watchdog time, 0 disables")
+	clear := fs.Bool("clear", false, "clear the expiration counters")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := needArgs(fs, 0, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	if err := ecwd.Configure(c.ctx, c.c, slave.addr(0), 1, cfg); err != nil {
+		return err
+	}
+	if *clear {
+		return ecwd.ClearCounters(c.ctx, c.c, slave.addr(0), 1)
+	}
+	return nil
+}
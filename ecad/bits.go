@@ -0,0 +1,58 @@
+package ecad
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registers are little endian, bit 0 is the lowest bit of the first byte.
+// the bounds are checked by the callers.
+func getBits(b []byte, bit, width uint) (v uint64) {
+	for i := uint(0); i < width; i++ {
+		pos := bit + i
+		if b[pos/8]&(1<<(pos%8)) != 0 {
+			v |= 1 << i
+		}
+	}
+	return
+}
+
+func putBits(b []byte, bit, width uint, v uint64) {
+	for i := uint(0); i < width; i++ {
+		pos := bit + i
+		if v&(1<<i) != 0 {
+			b[pos/8] |= 1 << (pos % 8)
+		} else {
+			b[pos/8] &^= 1 << (pos % 8)
+		}
+	}
+}
+
+func boolBits(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// the top bit of width bits is the sign
+func signMagnitude(v int64, width uint) uint64 {
+	if v < 0 {
+		return uint64(-v) | 1<<(width-1)
+	}
+	return uint64(v)
+}
+
+// appends "label 0,2" for the set elements of bits
+func appendSetBits(s []string, label string, bits []bool) []string {
+	var set []string
+	for i, b := range bits {
+		if b {
+			set = append(set, fmt.Sprint(i))
+		}
+	}
+	if len(set) == 0 {
+		return s
+	}
+	return append(s, label+" "+strings.Join(set, ","))
+}
@@ -1,13 +1,16 @@
 package ecad
 
+//go:generate go run gen_registers.go
+
 const (
-	Type                 = 0x0000
-	Revision             = 0x0001
-	Build                = 0x0002
-	FMMUsSupported       = 0x0004
-	RAMSize              = 0x0006
-	PortDescriptor       = 0x0007
-	ESCFeaturesSupported = 0x0008
+	Type                  = 0x0000
+	Revision              = 0x0001
+	Build                 = 0x0002
+	FMMUsSupported        = 0x0004
+	SyncManagersSupported = 0x0005
+	RAMSize               = 0x0006
+	PortDescriptor        = 0x0007
+	ESCFeaturesSupported  = 0x0008
 
 	ConfiguredStationAddress = 0x0010
 	ConfiguredStationAlias   = 0x0012
@@ -22,8 +25,23 @@ const (
 	ALStatusCode = 0x0134
 	PDIControl   = 0x0140
 
+	PDIConfiguration = 0x0150
+
 	ECATEventMask = 0x0200
 
+	RXErrorCounter                 = 0x0300
+	ForwardedRXErrorCounter        = 0x0308
+	ECATProcessingUnitErrorCounter = 0x030c
+	PDIErrorCounter                = 0x030d
+	LostLinkCounter                = 0x0310
+
+	WatchdogDivider            = 0x0400
+	WatchdogTimePDI            = 0x0410
+	WatchdogTimeProcessData    = 0x0420
+	WatchdogStatusProcessData  = 0x0440
+	WatchdogCounterProcessData = 0x0442
+	WatchdogCounterPDI         = 0x0443
+
 	ESIEEPROMInterface   = 0x0500
 	EEPROMConfiguration  = 0x0500
 	EEPROMPDIAccessState = 0x0501
@@ -41,4 +59,27 @@ const (
 	SyncManagerStatusOffset        = 0x05
 	SyncManagerActivateOffset      = 0x06
 	SyncManagerPDIControlOffset    = 0x07
+
+	DCReceiveTimePort0        = 0x0900
+	DCReceiveTimePort1        = 0x0904
+	DCReceiveTimePort2        = 0x0908
+	DCReceiveTimePort3        = 0x090c
+	DCSystemTime              = 0x0910
+	DCReceiveTimeEPU          = 0x0918
+	DCSystemTimeOffset        = 0x0920
+	DCSystemTimeDelay         = 0x0928
+	DCSystemTimeDifference    = 0x092c
+	DCSpeedCounterStart       = 0x0930
+	DCSpeedCounterDiff        = 0x0932
+	DCSystemTimeDiffFilter    = 0x0934
+	DCSpeedCounterFilterDepth = 0x0935
+	DCCyclicUnitControl       = 0x0980
+	DCActivation              = 0x0981
+	DCPulseLength             = 0x0982
+	DCSync0Status             = 0x098e
+	DCSync1Status             = 0x098f
+	DCStartTimeCyclic         = 0x0990
+	DCNextSync1Pulse          = 0x0998
+	DCSync0CycleTime          = 0x09a0
+	DCSync1CycleTime          = 0x09a4
 )
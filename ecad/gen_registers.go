@@ -0,0 +1,400 @@
+//go:build ignore
+// +build ignore
+
+// generates registers.go from the register table below. offsets are
+// referenced by their ecad constant, so that decoders and addresses cannot
+// drift apart. the bit offsets of fields are checked against the constants
+// by TestFieldOffsets.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strings"
+)
+
+type enumValue struct {
+	Name  string
+	Value int
+	Text  string
+}
+
+type enum struct {
+	Name   string
+	Type   string
+	Doc    string
+	Values []enumValue
+}
+
+type field struct {
+	Name string
+	// bit offset from the start of the register and width in bits
+	Bit, Width int
+	// bool, uint8 to uint64, signmag (sign and magnitude, as int64), an
+	// enum or a register, registers have to be byte aligned
+	Type string
+	// arrays have Count elements, Stride bits apart
+	Count, Stride int
+	Text          string
+}
+
+type register struct {
+	Name string
+	// ecad constant of the address
+	Offset string
+	Len    int
+	Doc    string
+	Fields []field
+}
+
+var enums = []enum{
+	{"PortType", "uint8", "is the physical layer of a port in the port descriptor.", []enumValue{
+		{"PortNotImplemented", 0, "not implemented"},
+		{"PortNotConfigured", 1, "not configured"},
+		{"PortEBUS", 2, "EBUS"},
+		{"PortMII", 3, "MII"},
+	}},
+	{"LoopSetting", "uint8", "is the loop control of a port in the DL control register.", []enumValue{
+		{"LoopAuto", 0, "auto"},
+		{"LoopAutoClose", 1, "auto close"},
+		{"LoopOpen", 2, "open"},
+		{"LoopClosed", 3, "closed"},
+	}},
+	{"ALState", "uint8", "is the state of the application layer state machine.", []enumValue{
+		{"ALStateInit", 1, "INIT"},
+		{"ALStatePreOp", 2, "PREOP"},
+		{"ALStateBootstrap", 3, "BOOT"},
+		{"ALStateSafeOp", 4, "SAFEOP"},
+		{"ALStateOp", 8, "OP"},
+	}},
+	{"PDIType", "uint8", "is the process data interface selected in PDI control.", []enumValue{
+		{"PDINone", 0x00, "none"},
+		{"PDIDigitalIO", 0x04, "digital I/O"},
+		{"PDISPI", 0x05, "SPI slave"},
+		{"PDIBridge", 0x07, "EtherCAT bridge"},
+		{"PDIAsync16", 0x08, "16 bit asynchronous microcontroller"},
+		{"PDIAsync8", 0x09, "8 bit asynchronous microcontroller"},
+		{"PDISync16", 0x0a, "16 bit synchronous microcontroller"},
+		{"PDISync8", 0x0b, "8 bit synchronous microcontroller"},
+		{"PDIOnChipBus", 0x80, "on-chip bus"},
+	}},
+}
+
+func ports(name string, bit, width, stride int, typ, text string) field {
+	return field{Name: name, Bit: bit, Width: width, Type: typ, Count: 4, Stride: stride, Text: text}
+}
+
+var registers = []register{
+	{"PortDescriptorReg", "PortDescriptor", 1, "describes the physical layer of the ports.", []field{
+		ports("Ports", 0, 2, 2, "PortType", "port"),
+	}},
+	{"ESCFeaturesReg", "ESCFeaturesSupported", 2, "lists the optional features of the ESC.", []field{
+		{Name: "ByteFMMU", Bit: 0, Width: 1, Type: "bool", Text: "byte oriented FMMU"},
+		{Name: "UnusedRegisterAccess", Bit: 1, Width: 1, Type: "bool", Text: "unused register access"},
+		{Name: "DistributedClocks", Bit: 2, Width: 1, Type: "bool", Text: "distributed clocks"},
+		{Name: "DC64", Bit: 3, Width: 1, Type: "bool", Text: "64 bit DC"},
+		{Name: "LowJitterEBUS", Bit: 4, Width: 1, Type: "bool", Text: "low jitter EBUS"},
+		{Name: "EnhancedLinkEBUS", Bit: 5, Width: 1, Type: "bool", Text: "enhanced link detection EBUS"},
+		{Name: "EnhancedLinkMII", Bit: 6, Width: 1, Type: "bool", Text: "enhanced link detection MII"},
+		{Name: "SeparateFCSErrors", Bit: 7, Width: 1, Type: "bool", Text: "separate FCS errors"},
+		{Name: "EnhancedDCSync", Bit: 8, Width: 1, Type: "bool", Text: "enhanced DC sync activation"},
+		{Name: "NoLRW", Bit: 9, Width: 1, Type: "bool", Text: "LRW not supported"},
+		{Name: "NoReadWrite", Bit: 10, Width: 1, Type: "bool", Text: "BRW, APRW, FPRW not supported"},
+		{Name: "FixedFMMUSMConfig", Bit: 11, Width: 1, Type: "bool", Text: "fixed FMMU/SM configuration"},
+	}},
+	{"ESCInformation", "Type", 10, "is the information block at the start of the register space.", []field{
+		{Name: "Type", Bit: 0, Width: 8, Type: "uint8", Text: "type"},
+		{Name: "Revision", Bit: 8, Width: 8, Type: "uint8", Text: "revision"},
+		{Name: "Build", Bit: 16, Width: 16, Type: "uint16", Text: "build"},
+		{Name: "FMMUs", Bit: 32, Width: 8, Type: "uint8", Text: "FMMUs"},
+		{Name: "SyncManagers", Bit: 40, Width: 8, Type: "uint8", Text: "sync managers"},
+		{Name: "RAMSize", Bit: 48, Width: 8, Type: "uint8", Text: "RAM KiB"},
+		{Name: "Ports", Bit: 56, Width: 8, Type: "PortDescriptorReg", Text: "ports"},
+		{Name: "Features", Bit: 64, Width: 16, Type: "ESCFeaturesReg", Text: "features"},
+	}},
+	{"DLControlReg", "DLControl", 4, "controls forwarding and the loops of the ports.", []field{
+		{Name: "DropNonEtherCAT", Bit: 0, Width: 1, Type: "bool", Text: "drop non-EtherCAT frames"},
+		{Name: "TemporaryLoop", Bit: 1, Width: 1, Type: "bool", Text: "temporary loop control"},
+		ports("Loop", 8, 2, 2, "LoopSetting", "loop"),
+		{Name: "RXFIFOSize", Bit: 16, Width: 3, Type: "uint8", Text: "RX FIFO size"},
+		{Name: "LowJitterEBUS", Bit: 19, Width: 1, Type: "bool", Text: "low jitter EBUS"},
+		{Name: "ShortLinkDown", Bit: 22, Width: 1, Type: "bool", Text: "short EBUS remote link down"},
+		{Name: "StationAlias", Bit: 24, Width: 1, Type: "bool", Text: "station alias"},
+	}},
+	{"DLStatusReg", "DLStatus", 2, "reports the PDI and the link and loop state of the ports.", []field{
+		{Name: "PDIOperational", Bit: 0, Width: 1, Type: "bool", Text: "PDI operational"},
+		{Name: "PDIWatchdogOK", Bit: 1, Width: 1, Type: "bool", Text: "PDI watchdog not expired"},
+		{Name: "EnhancedLinkDetection", Bit: 2, Width: 1, Type: "bool", Text: "enhanced link detection"},
+		ports("PhysicalLink", 4, 1, 1, "bool", "physical link"),
+		ports("LoopClosed", 8, 1, 2, "bool", "loop closed"),
+		ports("Communication", 9, 1, 2, "bool", "communication"),
+	}},
+	{"ALControlReg", "ALControl", 2, "requests application layer state changes.", []field{
+		{Name: "State", Bit: 0, Width: 4, Type: "ALState", Text: "state"},
+		{Name: "ErrorAck", Bit: 4, Width: 1, Type: "bool", Text: "error acknowledge"},
+		{Name: "IDRequest", Bit: 5, Width: 1, Type: "bool", Text: "device identification request"},
+	}},
+	{"ALStatusReg", "ALStatus", 2, "reports the application layer state.", []field{
+		{Name: "State", Bit: 0, Width: 4, Type: "ALState", Text: "state"},
+		{Name: "Error", Bit: 4, Width: 1, Type: "bool", Text: "error"},
+		{Name: "IDLoaded", Bit: 5, Width: 1, Type: "bool", Text: "device identification loaded"},
+	}},
+	{"PDIControlReg", "PDIControl", 2, "selects the process data interface.", []field{
+		{Name: "Type", Bit: 0, Width: 8, Type: "PDIType", Text: "PDI"},
+		{Name: "DeviceEmulation", Bit: 8, Width: 1, Type: "bool", Text: "device emulation"},
+		{Name: "EnhancedLinkDetection", Bit: 9, Width: 1, Type: "bool", Text: "enhanced link detection"},
+		{Name: "DCSyncOut", Bit: 10, Width: 1, Type: "bool", Text: "DC sync out unit"},
+		{Name: "DCLatchIn", Bit: 11, Width: 1, Type: "bool", Text: "DC latch in unit"},
+	}},
+	{"ErrorCounters", "RXErrorCounter", 20, "are the error counters of the ports and the ESC, they are cleared by writing.", []field{
+		ports("InvalidFrames", 0, 8, 16, "uint8", "invalid frames"),
+		ports("RXErrors", 8, 8, 16, "uint8", "RX errors"),
+		ports("ForwardedRXErrors", 64, 8, 8, "uint8", "forwarded RX errors"),
+		{Name: "ProcessingUnitErrors", Bit: 96, Width: 8, Type: "uint8", Text: "processing unit errors"},
+		{Name: "PDIErrors", Bit: 104, Width: 8, Type: "uint8", Text: "PDI errors"},
+		ports("LostLinks", 128, 8, 8, "uint8", "lost links"),
+	}},
+	{"Watchdog", "WatchdogDivider", 0x44, "configures the watchdogs and reports their state. times are in units of the divided clock.", []field{
+		{Name: "Divider", Bit: 0, Width: 16, Type: "uint16", Text: "divider"},
+		{Name: "TimePDI", Bit: 0x10 * 8, Width: 16, Type: "uint16", Text: "PDI time"},
+		{Name: "TimeProcessData", Bit: 0x20 * 8, Width: 16, Type: "uint16", Text: "process data time"},
+		{Name: "ProcessDataOK", Bit: 0x40 * 8, Width: 1, Type: "bool", Text: "process data watchdog not expired"},
+		{Name: "CounterProcessData", Bit: 0x42 * 8, Width: 8, Type: "uint8", Text: "process data expirations"},
+		{Name: "CounterPDI", Bit: 0x43 * 8, Width: 8, Type: "uint8", Text: "PDI expirations"},
+	}},
+	{"DCTimes", "DCReceiveTimePort0", 0x36, "are the distributed clock receive and system times, in ns.", []field{
+		ports("ReceiveTime", 0, 32, 32, "uint32", "receive time"),
+		{Name: "SystemTime", Bit: 0x10 * 8, Width: 64, Type: "uint64", Text: "system time"},
+		{Name: "ReceiveTimeEPU", Bit: 0x18 * 8, Width: 64, Type: "uint64", Text: "EPU receive time"},
+		{Name: "SystemTimeOffset", Bit: 0x20 * 8, Width: 64, Type: "uint64", Text: "offset"},
+		{Name: "SystemTimeDelay", Bit: 0x28 * 8, Width: 32, Type: "uint32", Text: "delay"},
+		{Name: "SystemTimeDifference", Bit: 0x2c * 8, Width: 32, Type: "signmag", Text: "difference"},
+		{Name: "SpeedCounterStart", Bit: 0x30 * 8, Width: 15, Type: "uint16", Text: "speed counter start"},
+		{Name: "SpeedCounterDiff", Bit: 0x32 * 8, Width: 16, Type: "uint16", Text: "speed counter diff"},
+		{Name: "SystemTimeDiffFilter", Bit: 0x34 * 8, Width: 4, Type: "uint8", Text: "difference filter depth"},
+		{Name: "SpeedCounterFilter", Bit: 0x35 * 8, Width: 4, Type: "uint8", Text: "speed counter filter depth"},
+	}},
+	{"DCSync", "DCCyclicUnitControl", 0x28, "configures the SYNC signals of the distributed clock.", []field{
+		{Name: "CyclicUnitPDI", Bit: 0, Width: 1, Type: "bool", Text: "cyclic unit controlled by PDI"},
+		{Name: "CyclicOperation", Bit: 8, Width: 1, Type: "bool", Text: "cyclic operation"},
+		{Name: "Sync0", Bit: 9, Width: 1, Type: "bool", Text: "SYNC0"},
+		{Name: "Sync1", Bit: 10, Width: 1, Type: "bool", Text: "SYNC1"},
+		{Name: "PulseLength", Bit: 0x02 * 8, Width: 16, Type: "uint16", Text: "pulse length"},
+		{Name: "Sync0Status", Bit: 0x0e * 8, Width: 1, Type: "bool", Text: "SYNC0 triggered"},
+		{Name: "Sync1Status", Bit: 0x0f * 8, Width: 1, Type: "bool", Text: "SYNC1 triggered"},
+		{Name: "StartTime", Bit: 0x10 * 8, Width: 64, Type: "uint64", Text: "start time"},
+		{Name: "NextSync1", Bit: 0x18 * 8, Width: 64, Type: "uint64", Text: "next SYNC1"},
+		{Name: "Sync0CycleTime", Bit: 0x20 * 8, Width: 32, Type: "uint32", Text: "SYNC0 cycle"},
+		{Name: "Sync1CycleTime", Bit: 0x24 * 8, Width: 32, Type: "uint32", Text: "SYNC1 cycle"},
+	}},
+}
+
+func isEnum(t string) bool {
+	for _, e := range enums {
+		if e.Name == t {
+			return true
+		}
+	}
+	return false
+}
+
+func isRegister(t string) bool {
+	for _, r := range registers {
+		if r.Name == t {
+			return true
+		}
+	}
+	return false
+}
+
+func goType(f field) string {
+	t := f.Type
+	if t == "signmag" {
+		t = "int64"
+	}
+	if f.Count > 1 {
+		return fmt.Sprintf("[%d]%s", f.Count, t)
+	}
+	return t
+}
+
+type gen struct {
+	bytes.Buffer
+}
+
+func (g *gen) p(format string, args ...interface{}) {
+	fmt.Fprintf(g, format, args...)
+	g.WriteByte('\n')
+}
+
+func (g *gen) enum(e enum) {
+	g.p("// %s %s", e.Name, e.Doc)
+	g.p("type %s %s", e.Name, e.Type)
+	g.p("const (")
+	for _, v := range e.Values {
+		g.p("%s %s = %#02x", v.Name, e.Name, v.Value)
+	}
+	g.p(")")
+	g.p("func (v %s) String() string {", e.Name)
+	g.p("switch v {")
+	for _, v := range e.Values {
+		g.p("case %s:", v.Name)
+		g.p("return %q", v.Text)
+	}
+	g.p("}")
+	g.p("return fmt.Sprintf(\"%s(%%#x)\", %s(v))", e.Name, e.Type)
+	g.p("}")
+	g.p("")
+}
+
+// element i of field f, bit offset and Go expression
+func elem(f field, i int) (int, string) {
+	if f.Count > 1 {
+		return f.Bit + i*f.Stride, fmt.Sprintf("r.%s[%d]", f.Name, i)
+	}
+	return f.Bit, "r." + f.Name
+}
+
+func count(f field) int {
+	if f.Count > 1 {
+		return f.Count
+	}
+	return 1
+}
+
+func (g *gen) decode(r register) {
+	g.p("// Decode decodes the register from b, which starts at %s.", r.Offset)
+	g.p("func (r *%s) Decode(b []byte) error {", r.Name)
+	g.p("if len(b) < %d {", r.Len)
+	g.p("return fmt.Errorf(\"%s needs %d bytes, have %%d\", len(b))", r.Name, r.Len)
+	g.p("}")
+	for _, f := range r.Fields {
+		for i := 0; i < count(f); i++ {
+			bit, x := elem(f, i)
+			switch {
+			case f.Type == "bool":
+				g.p("%s = getBits(b, %d, 1) != 0", x, bit)
+			case f.Type == "signmag":
+				g.p("%s = int64(getBits(b, %d, %d))", x, bit, f.Width-1)
+				g.p("if getBits(b, %d, 1) != 0 {", bit+f.Width-1)
+				g.p("%s = -%s", x, x)
+				g.p("}")
+			case isRegister(f.Type):
+				g.p("%s.Decode(b[%d:])", x, bit/8)
+			default:
+				g.p("%s = %s(getBits(b, %d, %d))", x, f.Type, bit, f.Width)
+			}
+		}
+	}
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *gen) encode(r register) {
+	g.p("// Encode writes the fields to b, which starts at %s. bits without a", r.Offset)
+	g.p("// field are left alone.")
+	g.p("func (r %s) Encode(b []byte) error {", r.Name)
+	g.p("if len(b) < %d {", r.Len)
+	g.p("return fmt.Errorf(\"%s needs %d bytes, have %%d\", len(b))", r.Name, r.Len)
+	g.p("}")
+	for _, f := range r.Fields {
+		for i := 0; i < count(f); i++ {
+			bit, x := elem(f, i)
+			switch {
+			case f.Type == "bool":
+				g.p("putBits(b, %d, 1, boolBits(%s))", bit, x)
+			case f.Type == "signmag":
+				g.p("putBits(b, %d, %d, signMagnitude(%s, %d))", bit, f.Width, x, f.Width)
+			case isRegister(f.Type):
+				g.p("%s.Encode(b[%d:])", x, bit/8)
+			default:
+				g.p("putBits(b, %d, %d, uint64(%s))", bit, f.Width, x)
+			}
+		}
+	}
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *gen) str(r register) {
+	g.p("func (r %s) String() string {", r.Name)
+	g.p("var s []string")
+	for _, f := range r.Fields {
+		switch {
+		case f.Type == "bool" && f.Count > 1:
+			g.p("s = appendSetBits(s, %q, r.%s[:])", f.Text, f.Name)
+		case f.Type == "bool":
+			g.p("if r.%s {", f.Name)
+			g.p("s = append(s, %q)", f.Text)
+			g.p("}")
+		case isRegister(f.Type):
+			g.p("s = append(s, fmt.Sprintf(\"%s {%%v}\", r.%s))", f.Text, f.Name)
+		case isEnum(f.Type):
+			g.p("s = append(s, fmt.Sprintf(\"%s %%v\", r.%s))", f.Text, f.Name)
+		default:
+			g.p("s = append(s, fmt.Sprintf(\"%s %%d\", r.%s))", f.Text, f.Name)
+		}
+	}
+	g.p("return strings.Join(s, \", \")")
+	g.p("}")
+	g.p("")
+}
+
+func (g *gen) register(r register) {
+	g.p("// %s %s", r.Name, r.Doc)
+	g.p("type %s struct {", r.Name)
+	for _, f := range r.Fields {
+		g.p("%s %s", f.Name, goType(f))
+	}
+	g.p("}")
+	g.p("")
+	g.p("func (%s) Offset() uint16 { return %s }", r.Name, r.Offset)
+	g.p("")
+	g.p("func (%s) Len() int { return %d }", r.Name, r.Len)
+	g.p("")
+	g.decode(r)
+	g.encode(r)
+	g.str(r)
+}
+
+func main() {
+	var g gen
+	g.p("// Code generated by gen_registers.go; DO NOT EDIT.")
+	g.p("")
+	g.p("package ecad")
+	g.p("")
+	g.p("import (")
+	g.p("\"fmt\"")
+	g.p("\"strings\"")
+	g.p(")")
+	g.p("")
+
+	for _, e := range enums {
+		g.enum(e)
+	}
+	for _, r := range registers {
+		for _, f := range r.Fields {
+			if isRegister(f.Type) && f.Bit%8 != 0 {
+				log.Fatalf("%s.%s: registers have to be byte aligned", r.Name, f.Name)
+			}
+			last := f.Bit + (count(f)-1)*f.Stride + f.Width
+			if last > r.Len*8 {
+				log.Fatalf("%s.%s exceeds the register", r.Name, f.Name)
+			}
+		}
+		g.register(r)
+	}
+
+	src, err := format.Source(g.Bytes())
+	if err != nil {
+		log.Fatalf("formatting generated code: %v\n%s", err, strings.TrimSpace(g.String()))
+	}
+	if err = os.WriteFile("registers.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
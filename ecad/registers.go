@@ -0,0 +1,927 @@
+// Code generated by gen_registers.go; DO NOT EDIT.
+
+package ecad
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PortType is the physical layer of a port in the port descriptor.
+type PortType uint8
+
+const (
+	PortNotImplemented PortType = 0x00
+	PortNotConfigured  PortType = 0x01
+	PortEBUS           PortType = 0x02
+	PortMII            PortType = 0x03
+)
+
+func (v PortType) String() string {
+	switch v {
+	case PortNotImplemented:
+		return "not implemented"
+	case PortNotConfigured:
+		return "not configured"
+	case PortEBUS:
+		return "EBUS"
+	case PortMII:
+		return "MII"
+	}
+	return fmt.Sprintf("PortType(%#x)", uint8(v))
+}
+
+// LoopSetting is the loop control of a port in the DL control register.
+type LoopSetting uint8
+
+const (
+	LoopAuto      LoopSetting = 0x00
+	LoopAutoClose LoopSetting = 0x01
+	LoopOpen      LoopSetting = 0x02
+	LoopClosed    LoopSetting = 0x03
+)
+
+func (v LoopSetting) String() string {
+	switch v {
+	case LoopAuto:
+		return "auto"
+	case LoopAutoClose:
+		return "auto close"
+	case LoopOpen:
+		return "open"
+	case LoopClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("LoopSetting(%#x)", uint8(v))
+}
+
+// ALState is the state of the application layer state machine.
+type ALState uint8
+
+const (
+	ALStateInit      ALState = 0x01
+	ALStatePreOp     ALState = 0x02
+	ALStateBootstrap ALState = 0x03
+	ALStateSafeOp    ALState = 0x04
+	ALStateOp        ALState = 0x08
+)
+
+func (v ALState) String() string {
+	switch v {
+	case ALStateInit:
+		return "INIT"
+	case ALStatePreOp:
+		return "PREOP"
+	case ALStateBootstrap:
+		return "BOOT"
+	case ALStateSafeOp:
+		return "SAFEOP"
+	case ALStateOp:
+		return "OP"
+	}
+	return fmt.Sprintf("ALState(%#x)", uint8(v))
+}
+
+// PDIType is the process data interface selected in PDI control.
+type PDIType uint8
+
+const (
+	PDINone      PDIType = 0x00
+	PDIDigitalIO PDIType = 0x04
+	PDISPI       PDIType = 0x05
+	PDIBridge    PDIType = 0x07
+	PDIAsync16   PDIType = 0x08
+	PDIAsync8    PDIType = 0x09
+	PDISync16    PDIType = 0x0a
+	PDISync8     PDIType = 0x0b
+	PDIOnChipBus PDIType = 0x80
+)
+
+func (v PDIType) String() string {
+	switch v {
+	case PDINone:
+		return "none"
+	case PDIDigitalIO:
+		return "digital I/O"
+	case PDISPI:
+		return "SPI slave"
+	case PDIBridge:
+		return "EtherCAT bridge"
+	case PDIAsync16:
+		return "16 bit asynchronous microcontroller"
+	case PDIAsync8:
+		return "8 bit asynchronous microcontroller"
+	case PDISync16:
+		return "16 bit synchronous microcontroller"
+	case PDISync8:
+		return "8 bit synchronous microcontroller"
+	case PDIOnChipBus:
+		return "on-chip bus"
+	}
+	return fmt.Sprintf("PDIType(%#x)", uint8(v))
+}
+
+// PortDescriptorReg describes the physical layer of the ports.
+type PortDescriptorReg struct {
+	Ports [4]PortType
+}
+
+func (PortDescriptorReg) Offset() uint16 { return PortDescriptor }
+
+func (PortDescriptorReg) Len() int { return 1 }
+
+// Decode decodes the register from b, which starts at PortDescriptor.
+func (r *PortDescriptorReg) Decode(b []byte) error {
+	if len(b) < 1 {
+		return fmt.Errorf("PortDescriptorReg needs 1 bytes, have %d", len(b))
+	}
+	r.Ports[0] = PortType(getBits(b, 0, 2))
+	r.Ports[1] = PortType(getBits(b, 2, 2))
+	r.Ports[2] = PortType(getBits(b, 4, 2))
+	r.Ports[3] = PortType(getBits(b, 6, 2))
+	return nil
+}
+
+// Encode writes the fields to b, which starts at PortDescriptor. bits without a
+// field are left alone.
+func (r PortDescriptorReg) Encode(b []byte) error {
+	if len(b) < 1 {
+		return fmt.Errorf("PortDescriptorReg needs 1 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 2, uint64(r.Ports[0]))
+	putBits(b, 2, 2, uint64(r.Ports[1]))
+	putBits(b, 4, 2, uint64(r.Ports[2]))
+	putBits(b, 6, 2, uint64(r.Ports[3]))
+	return nil
+}
+
+func (r PortDescriptorReg) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("port %v", r.Ports))
+	return strings.Join(s, ", ")
+}
+
+// ESCFeaturesReg lists the optional features of the ESC.
+type ESCFeaturesReg struct {
+	ByteFMMU             bool
+	UnusedRegisterAccess bool
+	DistributedClocks    bool
+	DC64                 bool
+	LowJitterEBUS        bool
+	EnhancedLinkEBUS     bool
+	EnhancedLinkMII      bool
+	SeparateFCSErrors    bool
+	EnhancedDCSync       bool
+	NoLRW                bool
+	NoReadWrite          bool
+	FixedFMMUSMConfig    bool
+}
+
+func (ESCFeaturesReg) Offset() uint16 { return ESCFeaturesSupported }
+
+func (ESCFeaturesReg) Len() int { return 2 }
+
+// Decode decodes the register from b, which starts at ESCFeaturesSupported.
+func (r *ESCFeaturesReg) Decode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("ESCFeaturesReg needs 2 bytes, have %d", len(b))
+	}
+	r.ByteFMMU = getBits(b, 0, 1) != 0
+	r.UnusedRegisterAccess = getBits(b, 1, 1) != 0
+	r.DistributedClocks = getBits(b, 2, 1) != 0
+	r.DC64 = getBits(b, 3, 1) != 0
+	r.LowJitterEBUS = getBits(b, 4, 1) != 0
+	r.EnhancedLinkEBUS = getBits(b, 5, 1) != 0
+	r.EnhancedLinkMII = getBits(b, 6, 1) != 0
+	r.SeparateFCSErrors = getBits(b, 7, 1) != 0
+	r.EnhancedDCSync = getBits(b, 8, 1) != 0
+	r.NoLRW = getBits(b, 9, 1) != 0
+	r.NoReadWrite = getBits(b, 10, 1) != 0
+	r.FixedFMMUSMConfig = getBits(b, 11, 1) != 0
+	return nil
+}
+
+// Encode writes the fields to b, which starts at ESCFeaturesSupported. bits without a
+// field are left alone.
+func (r ESCFeaturesReg) Encode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("ESCFeaturesReg needs 2 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 1, boolBits(r.ByteFMMU))
+	putBits(b, 1, 1, boolBits(r.UnusedRegisterAccess))
+	putBits(b, 2, 1, boolBits(r.DistributedClocks))
+	putBits(b, 3, 1, boolBits(r.DC64))
+	putBits(b, 4, 1, boolBits(r.LowJitterEBUS))
+	putBits(b, 5, 1, boolBits(r.EnhancedLinkEBUS))
+	putBits(b, 6, 1, boolBits(r.EnhancedLinkMII))
+	putBits(b, 7, 1, boolBits(r.SeparateFCSErrors))
+	putBits(b, 8, 1, boolBits(r.EnhancedDCSync))
+	putBits(b, 9, 1, boolBits(r.NoLRW))
+	putBits(b, 10, 1, boolBits(r.NoReadWrite))
+	putBits(b, 11, 1, boolBits(r.FixedFMMUSMConfig))
+	return nil
+}
+
+func (r ESCFeaturesReg) String() string {
+	var s []string
+	if r.ByteFMMU {
+		s = append(s, "byte oriented FMMU")
+	}
+	if r.UnusedRegisterAccess {
+		s = append(s, "unused register access")
+	}
+	if r.DistributedClocks {
+		s = append(s, "distributed clocks")
+	}
+	if r.DC64 {
+		s = append(s, "64 bit DC")
+	}
+	if r.LowJitterEBUS {
+		s = append(s, "low jitter EBUS")
+	}
+	if r.EnhancedLinkEBUS {
+		s = append(s, "enhanced link detection EBUS")
+	}
+	if r.EnhancedLinkMII {
+		s = append(s, "enhanced link detection MII")
+	}
+	if r.SeparateFCSErrors {
+		s = append(s, "separate FCS errors")
+	}
+	if r.EnhancedDCSync {
+		s = append(s, "enhanced DC sync activation")
+	}
+	if r.NoLRW {
+		s = append(s, "LRW not supported")
+	}
+	if r.NoReadWrite {
+		s = append(s, "BRW, APRW, FPRW not supported")
+	}
+	if r.FixedFMMUSMConfig {
+		s = append(s, "fixed FMMU/SM configuration")
+	}
+	return strings.Join(s, ", ")
+}
+
+// ESCInformation is the information block at the start of the register space.
+type ESCInformation struct {
+	Type         uint8
+	Revision     uint8
+	Build        uint16
+	FMMUs        uint8
+	SyncManagers uint8
+	RAMSize      uint8
+	Ports        PortDescriptorReg
+	Features     ESCFeaturesReg
+}
+
+func (ESCInformation) Offset() uint16 { return Type }
+
+func (ESCInformation) Len() int { return 10 }
+
+// Decode decodes the register from b, which starts at Type.
+func (r *ESCInformation) Decode(b []byte) error {
+	if len(b) < 10 {
+		return fmt.Errorf("ESCInformation needs 10 bytes, have %d", len(b))
+	}
+	r.Type = uint8(getBits(b, 0, 8))
+	r.Revision = uint8(getBits(b, 8, 8))
+	r.Build = uint16(getBits(b, 16, 16))
+	r.FMMUs = uint8(getBits(b, 32, 8))
+	r.SyncManagers = uint8(getBits(b, 40, 8))
+	r.RAMSize = uint8(getBits(b, 48, 8))
+	r.Ports.Decode(b[7:])
+	r.Features.Decode(b[8:])
+	return nil
+}
+
+// Encode writes the fields to b, which starts at Type. bits without a
+// field are left alone.
+func (r ESCInformation) Encode(b []byte) error {
+	if len(b) < 10 {
+		return fmt.Errorf("ESCInformation needs 10 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 8, uint64(r.Type))
+	putBits(b, 8, 8, uint64(r.Revision))
+	putBits(b, 16, 16, uint64(r.Build))
+	putBits(b, 32, 8, uint64(r.FMMUs))
+	putBits(b, 40, 8, uint64(r.SyncManagers))
+	putBits(b, 48, 8, uint64(r.RAMSize))
+	r.Ports.Encode(b[7:])
+	r.Features.Encode(b[8:])
+	return nil
+}
+
+func (r ESCInformation) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("type %d", r.Type))
+	s = append(s, fmt.Sprintf("revision %d", r.Revision))
+	s = append(s, fmt.Sprintf("build %d", r.Build))
+	s = append(s, fmt.Sprintf("FMMUs %d", r.FMMUs))
+	s = append(s, fmt.Sprintf("sync managers %d", r.SyncManagers))
+	s = append(s, fmt.Sprintf("RAM KiB %d", r.RAMSize))
+	s = append(s, fmt.Sprintf("ports {%v}", r.Ports))
+	s = append(s, fmt.Sprintf("features {%v}", r.Features))
+	return strings.Join(s, ", ")
+}
+
+// DLControlReg controls forwarding and the loops of the ports.
+type DLControlReg struct {
+	DropNonEtherCAT bool
+	TemporaryLoop   bool
+	Loop            [4]LoopSetting
+	RXFIFOSize      uint8
+	LowJitterEBUS   bool
+	ShortLinkDown   bool
+	StationAlias    bool
+}
+
+func (DLControlReg) Offset() uint16 { return DLControl }
+
+func (DLControlReg) Len() int { return 4 }
+
+// Decode decodes the register from b, which starts at DLControl.
+func (r *DLControlReg) Decode(b []byte) error {
+	if len(b) < 4 {
+		return fmt.Errorf("DLControlReg needs 4 bytes, have %d", len(b))
+	}
+	r.DropNonEtherCAT = getBits(b, 0, 1) != 0
+	r.TemporaryLoop = getBits(b, 1, 1) != 0
+	r.Loop[0] = LoopSetting(getBits(b, 8, 2))
+	r.Loop[1] = LoopSetting(getBits(b, 10, 2))
+	r.Loop[2] = LoopSetting(getBits(b, 12, 2))
+	r.Loop[3] = LoopSetting(getBits(b, 14, 2))
+	r.RXFIFOSize = uint8(getBits(b, 16, 3))
+	r.LowJitterEBUS = getBits(b, 19, 1) != 0
+	r.ShortLinkDown = getBits(b, 22, 1) != 0
+	r.StationAlias = getBits(b, 24, 1) != 0
+	return nil
+}
+
+// Encode writes the fields to b, which starts at DLControl. bits without a
+// field are left alone.
+func (r DLControlReg) Encode(b []byte) error {
+	if len(b) < 4 {
+		return fmt.Errorf("DLControlReg needs 4 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 1, boolBits(r.DropNonEtherCAT))
+	putBits(b, 1, 1, boolBits(r.TemporaryLoop))
+	putBits(b, 8, 2, uint64(r.Loop[0]))
+	putBits(b, 10, 2, uint64(r.Loop[1]))
+	putBits(b, 12, 2, uint64(r.Loop[2]))
+	putBits(b, 14, 2, uint64(r.Loop[3]))
+	putBits(b, 16, 3, uint64(r.RXFIFOSize))
+	putBits(b, 19, 1, boolBits(r.LowJitterEBUS))
+	putBits(b, 22, 1, boolBits(r.ShortLinkDown))
+	putBits(b, 24, 1, boolBits(r.StationAlias))
+	return nil
+}
+
+func (r DLControlReg) String() string {
+	var s []string
+	if r.DropNonEtherCAT {
+		s = append(s, "drop non-EtherCAT frames")
+	}
+	if r.TemporaryLoop {
+		s = append(s, "temporary loop control")
+	}
+	s = append(s, fmt.Sprintf("loop %v", r.Loop))
+	s = append(s, fmt.Sprintf("RX FIFO size %d", r.RXFIFOSize))
+	if r.LowJitterEBUS {
+		s = append(s, "low jitter EBUS")
+	}
+	if r.ShortLinkDown {
+		s = append(s, "short EBUS remote link down")
+	}
+	if r.StationAlias {
+		s = append(s, "station alias")
+	}
+	return strings.Join(s, ", ")
+}
+
+// DLStatusReg reports the PDI and the link and loop state of the ports.
+type DLStatusReg struct {
+	PDIOperational        bool
+	PDIWatchdogOK         bool
+	EnhancedLinkDetection bool
+	PhysicalLink          [4]bool
+	LoopClosed            [4]bool
+	Communication         [4]bool
+}
+
+func (DLStatusReg) Offset() uint16 { return DLStatus }
+
+func (DLStatusReg) Len() int { return 2 }
+
+// Decode decodes the register from b, which starts at DLStatus.
+func (r *DLStatusReg) Decode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("DLStatusReg needs 2 bytes, have %d", len(b))
+	}
+	r.PDIOperational = getBits(b, 0, 1) != 0
+	r.PDIWatchdogOK = getBits(b, 1, 1) != 0
+	r.EnhancedLinkDetection = getBits(b, 2, 1) != 0
+	r.PhysicalLink[0] = getBits(b, 4, 1) != 0
+	r.PhysicalLink[1] = getBits(b, 5, 1) != 0
+	r.PhysicalLink[2] = getBits(b, 6, 1) != 0
+	r.PhysicalLink[3] = getBits(b, 7, 1) != 0
+	r.LoopClosed[0] = getBits(b, 8, 1) != 0
+	r.LoopClosed[1] = getBits(b, 10, 1) != 0
+	r.LoopClosed[2] = getBits(b, 12, 1) != 0
+	r.LoopClosed[3] = getBits(b, 14, 1) != 0
+	r.Communication[0] = getBits(b, 9, 1) != 0
+	r.Communication[1] = getBits(b, 11, 1) != 0
+	r.Communication[2] = getBits(b, 13, 1) != 0
+	r.Communication[3] = getBits(b, 15, 1) != 0
+	return nil
+}
+
+// Encode writes the fields to b, which starts at DLStatus. bits without a
+// field are left alone.
+func (r DLStatusReg) Encode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("DLStatusReg needs 2 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 1, boolBits(r.PDIOperational))
+	putBits(b, 1, 1, boolBits(r.PDIWatchdogOK))
+	putBits(b, 2, 1, boolBits(r.EnhancedLinkDetection))
+	putBits(b, 4, 1, boolBits(r.PhysicalLink[0]))
+	putBits(b, 5, 1, boolBits(r.PhysicalLink[1]))
+	putBits(b, 6, 1, boolBits(r.PhysicalLink[2]))
+	putBits(b, 7, 1, boolBits(r.PhysicalLink[3]))
+	putBits(b, 8, 1, boolBits(r.LoopClosed[0]))
+	putBits(b, 10, 1, boolBits(r.LoopClosed[1]))
+	putBits(b, 12, 1, boolBits(r.LoopClosed[2]))
+	putBits(b, 14, 1, boolBits(r.LoopClosed[3]))
+	putBits(b, 9, 1, boolBits(r.Communication[0]))
+	putBits(b, 11, 1, boolBits(r.Communication[1]))
+	putBits(b, 13, 1, boolBits(r.Communication[2]))
+	putBits(b, 15, 1, boolBits(r.Communication[3]))
+	return nil
+}
+
+func (r DLStatusReg) String() string {
+	var s []string
+	if r.PDIOperational {
+		s = append(s, "PDI operational")
+	}
+	if r.PDIWatchdogOK {
+		s = append(s, "PDI watchdog not expired")
+	}
+	if r.EnhancedLinkDetection {
+		s = append(s, "enhanced link detection")
+	}
+	s = appendSetBits(s, "physical link", r.PhysicalLink[:])
+	s = appendSetBits(s, "loop closed", r.LoopClosed[:])
+	s = appendSetBits(s, "communication", r.Communication[:])
+	return strings.Join(s, ", ")
+}
+
+// ALControlReg requests application layer state changes.
+type ALControlReg struct {
+	State     ALState
+	ErrorAck  bool
+	IDRequest bool
+}
+
+func (ALControlReg) Offset() uint16 { return ALControl }
+
+func (ALControlReg) Len() int { return 2 }
+
+// Decode decodes the register from b, which starts at ALControl.
+func (r *ALControlReg) Decode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("ALControlReg needs 2 bytes, have %d", len(b))
+	}
+	r.State = ALState(getBits(b, 0, 4))
+	r.ErrorAck = getBits(b, 4, 1) != 0
+	r.IDRequest = getBits(b, 5, 1) != 0
+	return nil
+}
+
+// Encode writes the fields to b, which starts at ALControl. bits without a
+// field are left alone.
+func (r ALControlReg) Encode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("ALControlReg needs 2 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 4, uint64(r.State))
+	putBits(b, 4, 1, boolBits(r.ErrorAck))
+	putBits(b, 5, 1, boolBits(r.IDRequest))
+	return nil
+}
+
+func (r ALControlReg) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("state %v", r.State))
+	if r.ErrorAck {
+		s = append(s, "error acknowledge")
+	}
+	if r.IDRequest {
+		s = append(s, "device identification request")
+	}
+	return strings.Join(s, ", ")
+}
+
+// ALStatusReg reports the application layer state.
+type ALStatusReg struct {
+	State    ALState
+	Error    bool
+	IDLoaded bool
+}
+
+func (ALStatusReg) Offset() uint16 { return ALStatus }
+
+func (ALStatusReg) Len() int { return 2 }
+
+// Decode decodes the register from b, which starts at ALStatus.
+func (r *ALStatusReg) Decode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("ALStatusReg needs 2 bytes, have %d", len(b))
+	}
+	r.State = ALState(getBits(b, 0, 4))
+	r.Error = getBits(b, 4, 1) != 0
+	r.IDLoaded = getBits(b, 5, 1) != 0
+	return nil
+}
+
+// Encode writes the fields to b, which starts at ALStatus. bits without a
+// field are left alone.
+func (r ALStatusReg) Encode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("ALStatusReg needs 2 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 4, uint64(r.State))
+	putBits(b, 4, 1, boolBits(r.Error))
+	putBits(b, 5, 1, boolBits(r.IDLoaded))
+	return nil
+}
+
+func (r ALStatusReg) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("state %v", r.State))
+	if r.Error {
+		s = append(s, "error")
+	}
+	if r.IDLoaded {
+		s = append(s, "device identification loaded")
+	}
+	return strings.Join(s, ", ")
+}
+
+// PDIControlReg selects the process data interface.
+type PDIControlReg struct {
+	Type                  PDIType
+	DeviceEmulation       bool
+	EnhancedLinkDetection bool
+	DCSyncOut             bool
+	DCLatchIn             bool
+}
+
+func (PDIControlReg) Offset() uint16 { return PDIControl }
+
+func (PDIControlReg) Len() int { return 2 }
+
+// Decode decodes the register from b, which starts at PDIControl.
+func (r *PDIControlReg) Decode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("PDIControlReg needs 2 bytes, have %d", len(b))
+	}
+	r.Type = PDIType(getBits(b, 0, 8))
+	r.DeviceEmulation = getBits(b, 8, 1) != 0
+	r.EnhancedLinkDetection = getBits(b, 9, 1) != 0
+	r.DCSyncOut = getBits(b, 10, 1) != 0
+	r.DCLatchIn = getBits(b, 11, 1) != 0
+	return nil
+}
+
+// Encode writes the fields to b, which starts at PDIControl. bits without a
+// field are left alone.
+func (r PDIControlReg) Encode(b []byte) error {
+	if len(b) < 2 {
+		return fmt.Errorf("PDIControlReg needs 2 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 8, uint64(r.Type))
+	putBits(b, 8, 1, boolBits(r.DeviceEmulation))
+	putBits(b, 9, 1, boolBits(r.EnhancedLinkDetection))
+	putBits(b, 10, 1, boolBits(r.DCSyncOut))
+	putBits(b, 11, 1, boolBits(r.DCLatchIn))
+	return nil
+}
+
+func (r PDIControlReg) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("PDI %v", r.Type))
+	if r.DeviceEmulation {
+		s = append(s, "device emulation")
+	}
+	if r.EnhancedLinkDetection {
+		s = append(s, "enhanced link detection")
+	}
+	if r.DCSyncOut {
+		s = append(s, "DC sync out unit")
+	}
+	if r.DCLatchIn {
+		s = append(s, "DC latch in unit")
+	}
+	return strings.Join(s, ", ")
+}
+
+// ErrorCounters are the error counters of the ports and the ESC, they are cleared by writing.
+type ErrorCounters struct {
+	InvalidFrames        [4]uint8
+	RXErrors             [4]uint8
+	ForwardedRXErrors    [4]uint8
+	ProcessingUnitErrors uint8
+	PDIErrors            uint8
+	LostLinks            [4]uint8
+}
+
+func (ErrorCounters) Offset() uint16 { return RXErrorCounter }
+
+func (ErrorCounters) Len() int { return 20 }
+
+// Decode decodes the register from b, which starts at RXErrorCounter.
+func (r *ErrorCounters) Decode(b []byte) error {
+	if len(b) < 20 {
+		return fmt.Errorf("ErrorCounters needs 20 bytes, have %d", len(b))
+	}
+	r.InvalidFrames[0] = uint8(getBits(b, 0, 8))
+	r.InvalidFrames[1] = uint8(getBits(b, 16, 8))
+	r.InvalidFrames[2] = uint8(getBits(b, 32, 8))
+	r.InvalidFrames[3] = uint8(getBits(b, 48, 8))
+	r.RXErrors[0] = uint8(getBits(b, 8, 8))
+	r.RXErrors[1] = uint8(getBits(b, 24, 8))
+	r.RXErrors[2] = uint8(getBits(b, 40, 8))
+	r.RXErrors[3] = uint8(getBits(b, 56, 8))
+	r.ForwardedRXErrors[0] = uint8(getBits(b, 64, 8))
+	r.ForwardedRXErrors[1] = uint8(getBits(b, 72, 8))
+	r.ForwardedRXErrors[2] = uint8(getBits(b, 80, 8))
+	r.ForwardedRXErrors[3] = uint8(getBits(b, 88, 8))
+	r.ProcessingUnitErrors = uint8(getBits(b, 96, 8))
+	r.PDIErrors = uint8(getBits(b, 104, 8))
+	r.LostLinks[0] = uint8(getBits(b, 128, 8))
+	r.LostLinks[1] = uint8(getBits(b, 136, 8))
+	r.LostLinks[2] = uint8(getBits(b, 144, 8))
+	r.LostLinks[3] = uint8(getBits(b, 152, 8))
+	return nil
+}
+
+// Encode writes the fields to b, which starts at RXErrorCounter. bits without a
+// field are left alone.
+func (r ErrorCounters) Encode(b []byte) error {
+	if len(b) < 20 {
+		return fmt.Errorf("ErrorCounters needs 20 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 8, uint64(r.InvalidFrames[0]))
+	putBits(b, 16, 8, uint64(r.InvalidFrames[1]))
+	putBits(b, 32, 8, uint64(r.InvalidFrames[2]))
+	putBits(b, 48, 8, uint64(r.InvalidFrames[3]))
+	putBits(b, 8, 8, uint64(r.RXErrors[0]))
+	putBits(b, 24, 8, uint64(r.RXErrors[1]))
+	putBits(b, 40, 8, uint64(r.RXErrors[2]))
+	putBits(b, 56, 8, uint64(r.RXErrors[3]))
+	putBits(b, 64, 8, uint64(r.ForwardedRXErrors[0]))
+	putBits(b, 72, 8, uint64(r.ForwardedRXErrors[1]))
+	putBits(b, 80, 8, uint64(r.ForwardedRXErrors[2]))
+	putBits(b, 88, 8, uint64(r.ForwardedRXErrors[3]))
+	putBits(b, 96, 8, uint64(r.ProcessingUnitErrors))
+	putBits(b, 104, 8, uint64(r.PDIErrors))
+	putBits(b, 128, 8, uint64(r.LostLinks[0]))
+	putBits(b, 136, 8, uint64(r.LostLinks[1]))
+	putBits(b, 144, 8, uint64(r.LostLinks[2]))
+	putBits(b, 152, 8, uint64(r.LostLinks[3]))
+	return nil
+}
+
+func (r ErrorCounters) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("invalid frames %d", r.InvalidFrames))
+	s = append(s, fmt.Sprintf("RX errors %d", r.RXErrors))
+	s = append(s, fmt.Sprintf("forwarded RX errors %d", r.ForwardedRXErrors))
+	s = append(s, fmt.Sprintf("processing unit errors %d", r.ProcessingUnitErrors))
+	s = append(s, fmt.Sprintf("PDI errors %d", r.PDIErrors))
+	s = append(s, fmt.Sprintf("lost links %d", r.LostLinks))
+	return strings.Join(s, ", ")
+}
+
+// Watchdog configures the watchdogs and reports their state. times are in units of the divided clock.
+type Watchdog struct {
+	Divider            uint16
+	TimePDI            uint16
+	TimeProcessData    uint16
+	ProcessDataOK      bool
+	CounterProcessData uint8
+	CounterPDI         uint8
+}
+
+func (Watchdog) Offset() uint16 { return WatchdogDivider }
+
+func (Watchdog) Len() int { return 68 }
+
+// Decode decodes the register from b, which starts at WatchdogDivider.
+func (r *Watchdog) Decode(b []byte) error {
+	if len(b) < 68 {
+		return fmt.Errorf("Watchdog needs 68 bytes, have %d", len(b))
+	}
+	r.Divider = uint16(getBits(b, 0, 16))
+	r.TimePDI = uint16(getBits(b, 128, 16))
+	r.TimeProcessData = uint16(getBits(b, 256, 16))
+	r.ProcessDataOK = getBits(b, 512, 1) != 0
+	r.CounterProcessData = uint8(getBits(b, 528, 8))
+	r.CounterPDI = uint8(getBits(b, 536, 8))
+	return nil
+}
+
+// Encode writes the fields to b, which starts at WatchdogDivider. bits without a
+// field are left alone.
+func (r Watchdog) Encode(b []byte) error {
+	if len(b) < 68 {
+		return fmt.Errorf("Watchdog needs 68 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 16, uint64(r.Divider))
+	putBits(b, 128, 16, uint64(r.TimePDI))
+	putBits(b, 256, 16, uint64(r.TimeProcessData))
+	putBits(b, 512, 1, boolBits(r.ProcessDataOK))
+	putBits(b, 528, 8, uint64(r.CounterProcessData))
+	putBits(b, 536, 8, uint64(r.CounterPDI))
+	return nil
+}
+
+func (r Watchdog) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("divider %d", r.Divider))
+	s = append(s, fmt.Sprintf("PDI time %d", r.TimePDI))
+	s = append(s, fmt.Sprintf("process data time %d", r.TimeProcessData))
+	if r.ProcessDataOK {
+		s = append(s, "process data watchdog not expired")
+	}
+	s = append(s, fmt.Sprintf("process data expirations %d", r.CounterProcessData))
+	s = append(s, fmt.Sprintf("PDI expirations %d", r.CounterPDI))
+	return strings.Join(s, ", ")
+}
+
+// DCTimes are the distributed clock receive and system times, in ns.
+type DCTimes struct {
+	ReceiveTime          [4]uint32
+	SystemTime           uint64
+	ReceiveTimeEPU       uint64
+	SystemTimeOffset     uint64
+	SystemTimeDelay      uint32
+	SystemTimeDifference int64
+	SpeedCounterStart    uint16
+	SpeedCounterDiff     uint16
+	SystemTimeDiffFilter uint8
+	SpeedCounterFilter   uint8
+}
+
+func (DCTimes) Offset() uint16 { return DCReceiveTimePort0 }
+
+func (DCTimes) Len() int { return 54 }
+
+// Decode decodes the register from b, which starts at DCReceiveTimePort0.
+func (r *DCTimes) Decode(b []byte) error {
+	if len(b) < 54 {
+		return fmt.Errorf("DCTimes needs 54 bytes, have %d", len(b))
+	}
+	r.ReceiveTime[0] = uint32(getBits(b, 0, 32))
+	r.ReceiveTime[1] = uint32(getBits(b, 32, 32))
+	r.ReceiveTime[2] = uint32(getBits(b, 64, 32))
+	r.ReceiveTime[3] = uint32(getBits(b, 96, 32))
+	r.SystemTime = uint64(getBits(b, 128, 64))
+	r.ReceiveTimeEPU = uint64(getBits(b, 192, 64))
+	r.SystemTimeOffset = uint64(getBits(b, 256, 64))
+	r.SystemTimeDelay = uint32(getBits(b, 320, 32))
+	r.SystemTimeDifference = int64(getBits(b, 352, 31))
+	if getBits(b, 383, 1) != 0 {
+		r.SystemTimeDifference = -r.SystemTimeDifference
+	}
+	r.SpeedCounterStart = uint16(getBits(b, 384, 15))
+	r.SpeedCounterDiff = uint16(getBits(b, 400, 16))
+	r.SystemTimeDiffFilter = uint8(getBits(b, 416, 4))
+	r.SpeedCounterFilter = uint8(getBits(b, 424, 4))
+	return nil
+}
+
+// Encode writes the fields to b, which starts at DCReceiveTimePort0. bits without a
+// field are left alone.
+func (r DCTimes) Encode(b []byte) error {
+	if len(b) < 54 {
+		return fmt.Errorf("DCTimes needs 54 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 32, uint64(r.ReceiveTime[0]))
+	putBits(b, 32, 32, uint64(r.ReceiveTime[1]))
+	putBits(b, 64, 32, uint64(r.ReceiveTime[2]))
+	putBits(b, 96, 32, uint64(r.ReceiveTime[3]))
+	putBits(b, 128, 64, uint64(r.SystemTime))
+	putBits(b, 192, 64, uint64(r.ReceiveTimeEPU))
+	putBits(b, 256, 64, uint64(r.SystemTimeOffset))
+	putBits(b, 320, 32, uint64(r.SystemTimeDelay))
+	putBits(b, 352, 32, signMagnitude(r.SystemTimeDifference, 32))
+	putBits(b, 384, 15, uint64(r.SpeedCounterStart))
+	putBits(b, 400, 16, uint64(r.SpeedCounterDiff))
+	putBits(b, 416, 4, uint64(r.SystemTimeDiffFilter))
+	putBits(b, 424, 4, uint64(r.SpeedCounterFilter))
+	return nil
+}
+
+func (r DCTimes) String() string {
+	var s []string
+	s = append(s, fmt.Sprintf("receive time %d", r.ReceiveTime))
+	s = append(s, fmt.Sprintf("system time %d", r.SystemTime))
+	s = append(s, fmt.Sprintf("EPU receive time %d", r.ReceiveTimeEPU))
+	s = append(s, fmt.Sprintf("offset %d", r.SystemTimeOffset))
+	s = append(s, fmt.Sprintf("delay %d", r.SystemTimeDelay))
+	s = append(s, fmt.Sprintf("difference %d", r.SystemTimeDifference))
+	s = append(s, fmt.Sprintf("speed counter start %d", r.SpeedCounterStart))
+	s = append(s, fmt.Sprintf("speed counter diff %d", r.SpeedCounterDiff))
+	s = append(s, fmt.Sprintf("difference filter depth %d", r.SystemTimeDiffFilter))
+	s = append(s, fmt.Sprintf("speed counter filter depth %d", r.SpeedCounterFilter))
+	return strings.Join(s, ", ")
+}
+
+// DCSync configures the SYNC signals of the distributed clock.
+type DCSync struct {
+	CyclicUnitPDI   bool
+	CyclicOperation bool
+	Sync0           bool
+	Sync1           bool
+	PulseLength     uint16
+	Sync0Status     bool
+	Sync1Status     bool
+	StartTime       uint64
+	NextSync1       uint64
+	Sync0CycleTime  uint32
+	Sync1CycleTime  uint32
+}
+
+func (DCSync) Offset() uint16 { return DCCyclicUnitControl }
+
+func (DCSync) Len() int { return 40 }
+
+// Decode decodes the register from b, which starts at DCCyclicUnitControl.
+func (r *DCSync) Decode(b []byte) error {
+	if len(b) < 40 {
+		return fmt.Errorf("DCSync needs 40 bytes, have %d", len(b))
+	}
+	r.CyclicUnitPDI = getBits(b, 0, 1) != 0
+	r.CyclicOperation = getBits(b, 8, 1) != 0
+	r.Sync0 = getBits(b, 9, 1) != 0
+	r.Sync1 = getBits(b, 10, 1) != 0
+	r.PulseLength = uint16(getBits(b, 16, 16))
+	r.Sync0Status = getBits(b, 112, 1) != 0
+	r.Sync1Status = getBits(b, 120, 1) != 0
+	r.StartTime = uint64(getBits(b, 128, 64))
+	r.NextSync1 = uint64(getBits(b, 192, 64))
+	r.Sync0CycleTime = uint32(getBits(b, 256, 32))
+	r.Sync1CycleTime = uint32(getBits(b, 288, 32))
+	return nil
+}
+
+// Encode writes the fields to b, which starts at DCCyclicUnitControl. bits without a
+// field are left alone.
+func (r DCSync) Encode(b []byte) error {
+	if len(b) < 40 {
+		return fmt.Errorf("DCSync needs 40 bytes, have %d", len(b))
+	}
+	putBits(b, 0, 1, boolBits(r.CyclicUnitPDI))
+	putBits(b, 8, 1, boolBits(r.CyclicOperation))
+	putBits(b, 9, 1, boolBits(r.Sync0))
+	putBits(b, 10, 1, boolBits(r.Sync1))
+	putBits(b, 16, 16, uint64(r.PulseLength))
+	putBits(b, 112, 1, boolBits(r.Sync0Status))
+	putBits(b, 120, 1, boolBits(r.Sync1Status))
+	putBits(b, 128, 64, uint64(r.StartTime))
+	putBits(b, 192, 64, uint64(r.NextSync1))
+	putBits(b, 256, 32, uint64(r.Sync0CycleTime))
+	putBits(b, 288, 32, uint64(r.Sync1CycleTime))
+	return nil
+}
+
+func (r DCSync) String() string {
+	var s []string
+	if r.CyclicUnitPDI {
+		s = append(s, "cyclic unit controlled by PDI")
+	}
+	if r.CyclicOperation {
+		s = append(s, "cyclic operation")
+	}
+	if r.Sync0 {
+		s = append(s, "SYNC0")
+	}
+	if r.Sync1 {
+		s = append(s, "SYNC1")
+	}
+	s = append(s, fmt.Sprintf("pulse length %d", r.PulseLength))
+	if r.Sync0Status {
+		s = append(s, "SYNC0 triggered")
+	}
+	if r.Sync1Status {
+		s = append(s, "SYNC1 triggered")
+	}
+	s = append(s, fmt.Sprintf("start time %d", r.StartTime))
+	s = append(s, fmt.Sprintf("next SYNC1 %d", r.NextSync1))
+	s = append(s, fmt.Sprintf("SYNC0 cycle %d", r.Sync0CycleTime))
+	s = append(s, fmt.Sprintf("SYNC1 cycle %d", r.Sync1CycleTime))
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,187 @@
+package ecad
+
+import (
+	"bytes"
+	"testing"
+)
+
+type register interface {
+	Offset() uint16
+	Len() int
+	Encode(b []byte) error
+	String() string
+}
+
+func TestRegisterDecode(t *testing.T) {
+	var dls DLStatusReg
+	// PDI operational, link on ports 0 and 1, port 0 open with
+	// communication, port 1 closed
+	if err := dls.Decode([]byte{0x31, 0x06}); err != nil {
+		t.Fatal(err)
+	}
+	want := DLStatusReg{
+		PDIOperational: true,
+		PhysicalLink:   [4]bool{true, true},
+		LoopClosed:     [4]bool{false, true},
+		Communication:  [4]bool{true},
+	}
+	if dls != want {
+		t.Fatalf("want %+v, got %+v", want, dls)
+	}
+	if s := dls.String(); s != "PDI operational, physical link 0,1, loop closed 1, communication 0" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	var info ESCInformation
+	if err := info.Decode([]byte{0x11, 0x02, 0x03, 0x00, 0x08, 0x08, 0x08, 0x0f, 0xcc, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != 0x11 || info.Build != 3 || info.Ports.Ports != [4]PortType{PortMII, PortMII, PortNotImplemented, PortNotImplemented} {
+		t.Fatalf("unexpected information %+v", info)
+	}
+	if !info.Features.DistributedClocks || !info.Features.DC64 || !info.Features.EnhancedDCSync || info.Features.ByteFMMU {
+		t.Fatalf("unexpected features %v", info.Features)
+	}
+
+	var dc DCTimes
+	b := make([]byte, dc.Len())
+	// local copy 5 ns behind
+	copy(b[DCSystemTimeDifference-DCReceiveTimePort0:], []byte{0x05, 0x00, 0x00, 0x80})
+	if err := dc.Decode(b); err != nil {
+		t.Fatal(err)
+	}
+	if dc.SystemTimeDifference != -5 {
+		t.Fatalf("want difference -5, got %d", dc.SystemTimeDifference)
+	}
+
+	if err := dls.Decode([]byte{0x00}); err == nil {
+		t.Fatalf("decoding from too short buffer did not fail")
+	}
+}
+
+func TestRegisterRoundtrip(t *testing.T) {
+	regs := []register{
+		ESCInformation{Type: 0x11, Build: 0x1234, Ports: PortDescriptorReg{Ports: [4]PortType{PortEBUS, PortMII}}, Features: ESCFeaturesReg{DistributedClocks: true}},
+		DLControlReg{DropNonEtherCAT: true, Loop: [4]LoopSetting{LoopAuto, LoopClosed, LoopOpen, LoopAutoClose}, RXFIFOSize: 7, StationAlias: true},
+		ALControlReg{State: ALStateSafeOp, ErrorAck: true},
+		ALStatusReg{State: ALStateOp, Error: true},
+		PDIControlReg{Type: PDISPI, DCSyncOut: true},
+		ErrorCounters{InvalidFrames: [4]uint8{1, 2, 3, 4}, RXErrors: [4]uint8{5, 6, 7, 8}, PDIErrors: 9, LostLinks: [4]uint8{0, 0, 0, 10}},
+		Watchdog{Divider: 2498, TimePDI: 1000, TimeProcessData: 100, ProcessDataOK: true, CounterPDI: 3},
+		DCTimes{ReceiveTime: [4]uint32{1, 2, 3, 4}, SystemTime: 1 << 40, SystemTimeDifference: -12345, SpeedCounterStart: 0x1000},
+		DCSync{CyclicOperation: true, Sync0: true, StartTime: 1 << 33, Sync0CycleTime: 1000000},
+	}
+
+	for _, r := range regs {
+		b := make([]byte, r.Len())
+		if err := r.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Encode(b[:r.Len()-1]); err == nil {
+			t.Errorf("%T: encoding to too short buffer did not fail", r)
+		}
+
+		var d register
+		var err error
+		switch r.(type) {
+		case ESCInformation:
+			var v ESCInformation
+			err, d = v.Decode(b), &v
+		case DLControlReg:
+			var v DLControlReg
+			err, d = v.Decode(b), &v
+		case ALControlReg:
+			var v ALControlReg
+			err, d = v.Decode(b), &v
+		case ALStatusReg:
+			var v ALStatusReg
+			err, d = v.Decode(b), &v
+		case PDIControlReg:
+			var v PDIControlReg
+			err, d = v.Decode(b), &v
+		case ErrorCounters:
+			var v ErrorCounters
+			err, d = v.Decode(b), &v
+		case Watchdog:
+			var v Watchdog
+			err, d = v.Decode(b), &v
+		case DCTimes:
+			var v DCTimes
+			err, d = v.Decode(b), &v
+		case DCSync:
+			var v DCSync
+			err, d = v.Decode(b), &v
+		}
+		if err != nil {
+			t.Fatalf("%T: %v", r, err)
+		}
+
+		b2 := make([]byte, r.Len())
+		d.Encode(b2)
+		if !bytes.Equal(b, b2) || d.String() != r.String() {
+			t.Errorf("%T does not round trip: %v, decoded %v", r, r, d)
+		}
+	}
+}
+
+func TestEncodeKeepsOtherBits(t *testing.T) {
+	b := []byte{0xff, 0xff}
+	ALControlReg{State: ALStateInit}.Encode(b)
+	if b[0] != 0xc1 || b[1] != 0xff {
+		t.Fatalf("encoding touched bits without field: % x", b)
+	}
+}
+
+// the bit offsets of fields in gen_registers.go are literals, they have to
+// agree with the address constants
+func TestFieldOffsets(t *testing.T) {
+	for _, f := range []struct {
+		r    register
+		addr uint16
+	}{
+		{ErrorCounters{RXErrors: [4]uint8{1}}, RXErrorCounter + 1},
+		{ErrorCounters{ForwardedRXErrors: [4]uint8{1}}, ForwardedRXErrorCounter},
+		{ErrorCounters{ProcessingUnitErrors: 1}, ECATProcessingUnitErrorCounter},
+		{ErrorCounters{PDIErrors: 1}, PDIErrorCounter},
+		{ErrorCounters{LostLinks: [4]uint8{1}}, LostLinkCounter},
+		{Watchdog{TimePDI: 1}, WatchdogTimePDI},
+		{Watchdog{TimeProcessData: 1}, WatchdogTimeProcessData},
+		{Watchdog{ProcessDataOK: true}, WatchdogStatusProcessData},
+		{Watchdog{CounterProcessData: 1}, WatchdogCounterProcessData},
+		{Watchdog{CounterPDI: 1}, WatchdogCounterPDI},
+		{DCTimes{ReceiveTime: [4]uint32{0, 1}}, DCReceiveTimePort1},
+		{DCTimes{ReceiveTime: [4]uint32{0, 0, 0, 1}}, DCReceiveTimePort3},
+		{DCTimes{SystemTime: 1}, DCSystemTime},
+		{DCTimes{ReceiveTimeEPU: 1}, DCReceiveTimeEPU},
+		{DCTimes{SystemTimeOffset: 1}, DCSystemTimeOffset},
+		{DCTimes{SystemTimeDelay: 1}, DCSystemTimeDelay},
+		{DCTimes{SystemTimeDifference: 1}, DCSystemTimeDifference},
+		{DCTimes{SpeedCounterStart: 1}, DCSpeedCounterStart},
+		{DCTimes{SpeedCounterDiff: 1}, DCSpeedCounterDiff},
+		{DCTimes{SystemTimeDiffFilter: 1}, DCSystemTimeDiffFilter},
+		{DCTimes{SpeedCounterFilter: 1}, DCSpeedCounterFilterDepth},
+		{DCSync{CyclicOperation: true}, DCActivation},
+		{DCSync{PulseLength: 1}, DCPulseLength},
+		{DCSync{Sync0Status: true}, DCSync0Status},
+		{DCSync{Sync1Status: true}, DCSync1Status},
+		{DCSync{StartTime: 1}, DCStartTimeCyclic},
+		{DCSync{NextSync1: 1}, DCNextSync1Pulse},
+		{DCSync{Sync0CycleTime: 1}, DCSync0CycleTime},
+		{DCSync{Sync1CycleTime: 1}, DCSync1CycleTime},
+	} {
+		b := make([]byte, f.r.Len())
+		if err := f.r.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		at := -1
+		for i, v := range b {
+			if v != 0 {
+				at = i
+				break
+			}
+		}
+		if want := int(f.addr - f.r.Offset()); at != want {
+			t.Errorf("%T {%v} encodes at offset %#x, want %#x", f.r, f.r, at, want)
+		}
+	}
+}
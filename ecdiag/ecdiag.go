@@ -0,0 +1,183 @@
+package ecdiag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"time"
+)
+
+// SlaveDiag holds the diagnostic registers of a slave.
+type SlaveDiag struct {
+	Position int
+	DLStatus ecad.DLStatusReg
+	Errors   ecad.ErrorCounters
+	Watchdog ecad.Watchdog
+	// increments of the error counters since the previous scan
+	Delta ecad.ErrorCounters
+}
+
+type Report struct {
+	Time   time.Time
+	Slaves []SlaveDiag
+	// cables derived from the DL status of the slaves
+	Links []Link
+	// cables with errors since the previous scan, most errors first
+	Suspects []Suspect
+	// the number of slaves changed since the previous scan, deltas are
+	// counted from the previous values of the same positions anyway
+	SlaveCountChanged bool
+}
+
+func (r *Report) MultilineSummary() string {
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintf(b, "%d slaves at %v\n", len(r.Slaves), r.Time.Format(time.RFC3339))
+	if r.SlaveCountChanged {
+		fmt.Fprintf(b, "slave count changed\n")
+	}
+	for _, sd := range r.Slaves {
+		e, d := sd.Errors, sd.Delta
+		fmt.Fprintf(b, "  %d: invalid %v (+%v) rx %v (+%v) fwd %v (+%v) lost %v (+%v) pu %d pdi %d\n",
+			sd.Position,
+			e.InvalidFrames, d.InvalidFrames,
+			e.RXErrors, d.RXErrors,
+			e.ForwardedRXErrors, d.ForwardedRXErrors,
+			e.LostLinks, d.LostLinks,
+			e.ProcessingUnitErrors, e.PDIErrors)
+		if !sd.Watchdog.ProcessDataOK {
+			fmt.Fprintf(b, "     process data watchdog expired, counter %d\n", sd.Watchdog.CounterProcessData)
+		}
+	}
+	for _, s := range r.Suspects {
+		fmt.Fprintf(b, "suspect %v\n", s)
+	}
+	return b.String()
+}
+
+// Diagnostics reads the error counters, DL status and watchdog status of all
+// slaves and tracks their increments from scan to scan.
+type Diagnostics struct {
+	Options ecmd.Options
+
+	prev []ecad.ErrorCounters
+}
+
+func New() *Diagnostics {
+	return &Diagnostics{}
+}
+
+// Scan reads the registers of all slaves. the deltas of the first scan, and
+// the first scan after Clear, count from 0.
+func (d *Diagnostics) Scan(ctx context.Context, c ecmd.Commander) (r *Report, err error) {
+	n, err := ecmd.CountSlaves(ctx, c, d.Options)
+	if err != nil {
+		return
+	}
+
+	b := ecmd.Batch{Options: d.Options}
+	type reads struct{ dl, errs, wd *ecmd.BatchOp }
+	ops := make([]reads, n)
+	for i := range ops {
+		pos := int16(-i)
+		ops[i].dl = b.Read(ecfr.PositionalAddr(pos, ecad.DLStatus), ecad.DLStatusReg{}.Len(), 1)
+		ops[i].errs = b.Read(ecfr.PositionalAddr(pos, ecad.RXErrorCounter), ecad.ErrorCounters{}.Len(), 1)
+		ops[i].wd = b.Read(ecfr.PositionalAddr(pos, ecad.WatchdogDivider), ecad.Watchdog{}.Len(), 1)
+	}
+	if err = b.ExecuteContext(ctx, c); err != nil {
+		return
+	}
+	if err = b.Err(); err != nil {
+		return
+	}
+
+	r = &Report{Time: time.Now(), Slaves: make([]SlaveDiag, n)}
+	r.SlaveCountChanged = d.prev != nil && len(d.prev) != n
+	dl := make([]ecad.DLStatusReg, n)
+	for i, o := range ops {
+		sd := &r.Slaves[i]
+		sd.Position = i
+		if err = sd.DLStatus.Decode(o.dl.Data); err != nil {
+			return nil, err
+		}
+		if err = sd.Errors.Decode(o.errs.Data); err != nil {
+			return nil, err
+		}
+		if err = sd.Watchdog.Decode(o.wd.Data); err != nil {
+			return nil, err
+		}
+
+		var prev ecad.ErrorCounters
+		if i < len(d.prev) {
+			prev = d.prev[i]
+		}
+		sd.Delta = delta(sd.Errors, prev)
+		dl[i] = sd.DLStatus
+	}
+
+	r.Links = links(dl)
+	r.Suspects = locate(r.Links, r.Slaves)
+
+	d.prev = d.prev[:0]
+	for _, sd := range r.Slaves {
+		d.prev = append(d.prev, sd.Errors)
+	}
+	return
+}
+
+func delta(cur, prev ecad.ErrorCounters) (d ecad.ErrorCounters) {
+	for p := 0; p < 4; p++ {
+		d.InvalidFrames[p] = ecmd.CounterIncrement(cur.InvalidFrames[p], prev.InvalidFrames[p])
+		d.RXErrors[p] = ecmd.CounterIncrement(cur.RXErrors[p], prev.RXErrors[p])
+		d.ForwardedRXErrors[p] = ecmd.CounterIncrement(cur.ForwardedRXErrors[p], prev.ForwardedRXErrors[p])
+		d.LostLinks[p] = ecmd.CounterIncrement(cur.LostLinks[p], prev.LostLinks[p])
+	}
+	d.ProcessingUnitErrors = ecmd.CounterIncrement(cur.ProcessingUnitErrors, prev.ProcessingUnitErrors)
+	d.PDIErrors = ecmd.CounterIncrement(cur.PDIErrors, prev.PDIErrors)
+	return
+}
+
+// Clear resets the error counters of all slaves.
+func (d *Diagnostics) Clear(ctx context.Context, c ecmd.Commander) (err error) {
+	n, err := ecmd.CountSlaves(ctx, c, d.Options)
+	if err != nil {
+		return
+	}
+
+	// one write clears each group of counters, the reserved bytes in
+	// between are ignored
+	b := ecmd.Batch{Options: d.Options}
+	b.Write(ecfr.BroadcastAddr(ecad.RXErrorCounter), make([]byte, ecad.ErrorCounters{}.Len()), uint16(n))
+	if err = b.ExecuteContext(ctx, c); err != nil {
+		return
+	}
+	if err = b.Err(); err != nil {
+		return
+	}
+
+	d.prev = nil
+	return
+}
+
+// Run scans right away and then every interval until ctx is done or a scan
+// fails, passing every report to report.
+func (d *Diagnostics) Run(ctx context.Context, c ecmd.Commander, interval time.Duration, report func(*Report)) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		r, err := d.Scan(ctx, c)
+		if err != nil {
+			return err
+		}
+		report(r)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
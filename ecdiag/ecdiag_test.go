@@ -0,0 +1,187 @@
+package ecdiag
+
+import (
+	"context"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+)
+
+// master - 0 - 1 - 3, with 2 branching off port 3 of slave 1. positions
+// are given in frame order.
+func newTestTopology(t *testing.T) (*sim.Topology, []*sim.L2Slave, map[Link]*sim.Cable) {
+	slaves := make([]*sim.L2Slave, 4)
+	for i := range slaves {
+		slaves[i] = sim.NewL2Slave()
+	}
+	slaves[1].Ports[3].Type = sim.PortEBUS
+
+	topo := sim.NewTopology()
+	cables := make(map[Link]*sim.Cable)
+	connect := func(l Link, c *sim.Cable, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		cables[l] = c
+	}
+	c, err := topo.ConnectMaster(sim.MasterPrimary, slaves[0], 0)
+	connect(Link{Port{Master, 0}, Port{0, 0}}, c, err)
+	c, err = topo.Connect(slaves[0], 1, slaves[1], 0)
+	connect(Link{Port{0, 1}, Port{1, 0}}, c, err)
+	c, err = topo.Connect(slaves[1], 3, slaves[2], 0)
+	connect(Link{Port{1, 3}, Port{2, 0}}, c, err)
+	c, err = topo.Connect(slaves[1], 1, slaves[3], 0)
+	connect(Link{Port{1, 1}, Port{3, 0}}, c, err)
+	return topo, slaves, cables
+}
+
+func TestScanLocatesCable(t *testing.T) {
+	topo, _, cables := newTestTopology(t)
+	c := ecmd.NewCommandFramer(topo)
+	d := New()
+	ctx := context.Background()
+
+	r, err := d.Scan(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Slaves) != 4 {
+		t.Fatalf("want 4 slaves, have %d", len(r.Slaves))
+	}
+	if len(r.Links) != len(cables) {
+		t.Fatalf("want %d links, have %v", len(cables), r.Links)
+	}
+	for _, l := range r.Links {
+		if cables[l] == nil {
+			t.Fatalf("unexpected link %v in %v", l, r.Links)
+		}
+	}
+	if len(r.Suspects) != 0 {
+		t.Fatalf("want no suspects on a clean bus, have %v", r.Suspects)
+	}
+
+	bad := Link{Port{1, 1}, Port{3, 0}}
+	cables[bad].CorruptFrames = 2
+	r, err = d.Scan(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Suspects) != 1 {
+		t.Fatalf("want 1 suspect, have %v", r.Suspects)
+	}
+	s := r.Suspects[0]
+	if s.Link != bad || s.InvalidFrames != 2 || s.RXErrors != 2 {
+		t.Fatalf("unexpected suspect %v", s)
+	}
+	// the frames corrupted on the way out are forwarded back through
+	// slave 1 and 0
+	if fwd := r.Slaves[0].Delta.ForwardedRXErrors[1]; fwd == 0 {
+		t.Fatalf("want forwarded rx errors at slave 0 port 1, have %v", r.Slaves[0].Delta)
+	}
+
+	// counters stay, deltas do not
+	r, err = d.Scan(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Suspects) != 0 {
+		t.Fatalf("want no new suspects, have %v", r.Suspects)
+	}
+	if r.Slaves[3].Errors.InvalidFrames[0] != 2 {
+		t.Fatalf("want 2 invalid frames at slave 3, have %v", r.Slaves[3].Errors)
+	}
+
+	if err = d.Clear(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	r, err = d.Scan(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sd := range r.Slaves {
+		if sd.Errors != sd.Delta || sd.Errors.InvalidFrames != [4]uint8{} || sd.Errors.ForwardedRXErrors != [4]uint8{} {
+			t.Fatalf("counters of slave %d not cleared: %v", sd.Position, sd.Errors)
+		}
+	}
+}
+
+func TestScanLostLink(t *testing.T) {
+	topo, slaves, _ := newTestTopology(t)
+	c := ecmd.NewCommandFramer(topo)
+	d := New()
+	ctx := context.Background()
+
+	if _, err := d.Scan(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := topo.BreakCable(slaves[1], 3); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.Scan(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.SlaveCountChanged || len(r.Slaves) != 3 {
+		t.Fatalf("want 3 slaves and a changed count, have %d", len(r.Slaves))
+	}
+	want := Link{Port{1, 3}, Port{Unknown, 0}}
+	for _, s := range r.Suspects {
+		if s.Link == want && s.LostLinks == 1 {
+			return
+		}
+	}
+	t.Fatalf("want lost link at %v, have %v", want, r.Suspects)
+}
+
+func TestLinks(t *testing.T) {
+	// 0 has a branch on port 2 with 1 and 2 chained, 3 follows on port 1
+	comm := [][4]bool{
+		{true, true, true, false},
+		{true, true, false, false},
+		{true, false, false, false},
+		{true, false, false, false},
+	}
+	want := []Link{
+		{Port{Master, 0}, Port{0, 0}},
+		{Port{0, 1}, Port{1, 0}},
+		{Port{1, 1}, Port{2, 0}},
+		{Port{0, 2}, Port{3, 0}},
+	}
+
+	var dl []ecad.DLStatusReg
+	for _, c := range comm {
+		dl = append(dl, ecad.DLStatusReg{Communication: c})
+	}
+	ls := links(dl)
+	if len(ls) != len(want) {
+		t.Fatalf("want %v, have %v", want, ls)
+	}
+	for i := range want {
+		if ls[i] != want[i] {
+			t.Fatalf("link %d: want %v, have %v", i, want[i], ls[i])
+		}
+	}
+}
+
+func TestScanManySlaves(t *testing.T) {
+	// 3 reads per slave take more than one cycle
+	bus := &sim.L2Bus{}
+	for i := 0; i < 100; i++ {
+		bus.Slaves = append(bus.Slaves, sim.NewL2Slave())
+	}
+	c := ecmd.NewCommandFramer(bus)
+	d := New()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		r, err := d.Scan(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Slaves) != 100 {
+			t.Fatalf("want 100 slaves, have %d", len(r.Slaves))
+		}
+	}
+}
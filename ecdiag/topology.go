@@ -0,0 +1,161 @@
+package ecdiag
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"sort"
+)
+
+const (
+	// position of the master in a Port
+	Master = -1
+	// position of the far end of a link that does not communicate
+	Unknown = -2
+)
+
+// frames pass the ports of an ESC in this order
+var portOrder = [4]int{0, 3, 1, 2}
+
+// Port is a port of the slave at Position, or of the master.
+type Port struct {
+	Position int
+	Port     int
+}
+
+func (p Port) String() string {
+	switch p.Position {
+	case Master:
+		return "master"
+	case Unknown:
+		return "?"
+	}
+	return fmt.Sprintf("slave %d port %d", p.Position, p.Port)
+}
+
+// Link is a cable, Upstream is the end closer to the master.
+type Link struct {
+	Upstream   Port
+	Downstream Port
+}
+
+func (l Link) String() string {
+	return fmt.Sprintf("%v - %v", l.Upstream, l.Downstream)
+}
+
+// links derives the cables from the communication bits of the DL status of
+// the slaves in position order. frames enter a slave on its first
+// communicating port in port order and leave it through the others, so every
+// slave hangs off the next free port of the closest slave before it.
+func links(dl []ecad.DLStatusReg) (ls []Link) {
+	type open struct {
+		position int
+		ports    []int
+	}
+	stack := []open{{Master, []int{0}}}
+
+	for pos, st := range dl {
+		var active []int
+		for _, p := range portOrder {
+			if st.Communication[p] {
+				active = append(active, p)
+			}
+		}
+		// a slave without communicating ports cannot have been reached,
+		// the bits are stale. assume the usual entry port.
+		if len(active) == 0 {
+			active = []int{0}
+		}
+
+		for len(stack) > 0 && len(stack[len(stack)-1].ports) == 0 {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			// more slaves than the ports suggest, chain the rest
+			stack = append(stack, open{pos - 1, []int{1}})
+		}
+
+		top := &stack[len(stack)-1]
+		ls = append(ls, Link{
+			Upstream:   Port{top.position, top.ports[0]},
+			Downstream: Port{pos, active[0]},
+		})
+		top.ports = top.ports[1:]
+
+		stack = append(stack, open{pos, active[1:]})
+	}
+	return
+}
+
+// Suspect is a cable that errors were counted on, together with the ports
+// of its ends.
+type Suspect struct {
+	Link Link
+	// increments of the respective counters at both ends of the link
+	InvalidFrames int
+	RXErrors      int
+	LostLinks     int
+}
+
+func (s Suspect) Total() int {
+	return s.InvalidFrames + s.RXErrors + s.LostLinks
+}
+
+func (s Suspect) String() string {
+	return fmt.Sprintf("%v: %d invalid frames, %d rx errors, %d lost links",
+		s.Link, s.InvalidFrames, s.RXErrors, s.LostLinks)
+}
+
+// locates the cables attached to ports that counted errors. forwarded RX
+// errors are left out, they point upstream to where the error was counted
+// first.
+func locate(ls []Link, slaves []SlaveDiag) (suspects []Suspect) {
+	byPort := make(map[Port]int)
+	for i, l := range ls {
+		byPort[l.Upstream] = i
+		byPort[l.Downstream] = i
+	}
+
+	byLink := make(map[Link]*Suspect)
+	for _, sd := range slaves {
+		for p := 0; p < 4; p++ {
+			d := sd.Delta
+			if d.InvalidFrames[p] == 0 && d.RXErrors[p] == 0 && d.LostLinks[p] == 0 {
+				continue
+			}
+
+			port := Port{sd.Position, p}
+			var l Link
+			if i, ok := byPort[port]; ok {
+				l = ls[i]
+			} else {
+				// a cable not communicating anymore leads away from
+				// the master
+				l = Link{Upstream: port, Downstream: Port{Unknown, 0}}
+			}
+
+			s := byLink[l]
+			if s == nil {
+				s = &Suspect{Link: l}
+				byLink[l] = s
+			}
+			s.InvalidFrames += int(d.InvalidFrames[p])
+			s.RXErrors += int(d.RXErrors[p])
+			s.LostLinks += int(d.LostLinks[p])
+		}
+	}
+
+	for _, s := range byLink {
+		suspects = append(suspects, *s)
+	}
+	sort.Slice(suspects, func(i, j int) bool {
+		a, b := suspects[i], suspects[j]
+		if a.Total() != b.Total() {
+			return a.Total() > b.Total()
+		}
+		if a.Link.Upstream.Position != b.Link.Upstream.Position {
+			return a.Link.Upstream.Position < b.Link.Upstream.Position
+		}
+		return a.Link.Upstream.Port < b.Link.Upstream.Port
+	})
+	return
+}
@@ -1,6 +1,7 @@
 package ecee
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/distributed/ecat/ecad"
@@ -20,16 +21,23 @@ type blindEEPROM struct {
 type EEPROM interface {
 	ReadWord(addr uint32) (word uint16, err error)
 	WriteWord(addr uint32, word uint16) (err error)
+	// like ReadWord and WriteWord, but abort when ctx is done
+	ReadWordContext(ctx context.Context, addr uint32) (word uint16, err error)
+	WriteWordContext(ctx context.Context, addr uint32, word uint16) (err error)
 	Close() error
 }
 
 func New(commander ecmd.Commander, addr ecfr.DatagramAddress) (EEPROM, error) {
+	return NewContext(context.Background(), commander, addr)
+}
+
+func NewContext(ctx context.Context, commander ecmd.Commander, addr ecfr.DatagramAddress) (EEPROM, error) {
 	ee := &blindEEPROM{
 		addr:      addr,
 		commander: commander,
 	}
 
-	err := ee.waitForIdle(0)
+	err := ee.waitForIdle(ctx, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +45,17 @@ func New(commander ecmd.Commander, addr ecfr.DatagramAddress) (EEPROM, error) {
 	return ee, nil
 }
 
-func (ee *blindEEPROM) waitForIdle(timeout time.Duration) error {
+func (ee *blindEEPROM) read(ctx context.Context, addr ecfr.DatagramAddress, n int) (d []byte, err error) {
+	d, _, err = ecmd.ExecuteReadContext(ctx, ee.commander, addr, n, 1, ecmd.Options{})
+	return
+}
+
+func (ee *blindEEPROM) write(ctx context.Context, addr ecfr.DatagramAddress, w []byte) (err error) {
+	_, err = ecmd.ExecuteWriteContext(ctx, ee.commander, addr, w, 1, ecmd.Options{})
+	return
+}
+
+func (ee *blindEEPROM) waitForIdle(ctx context.Context, timeout time.Duration) error {
 	if timeout == 0 {
 		timeout = 250 * time.Millisecond
 	}
@@ -47,7 +65,7 @@ func (ee *blindEEPROM) waitForIdle(timeout time.Duration) error {
 	for {
 		addr := ee.addr
 		addr.SetOffset(ecad.EEPROMControlStatus)
-		rb, err := ecmd.ExecuteRead(ee.commander, addr, 2, 1)
+		rb, err := ee.read(ctx, addr, 2)
 		if err != nil {
 			return err
 		}
@@ -57,18 +75,22 @@ func (ee *blindEEPROM) waitForIdle(timeout time.Duration) error {
 		}
 
 		if time.Now().After(tot) {
-
+			return errors.New("timeout waiting for EEPROM interface to become idle")
 		}
 	}
 }
 
 func (ee *blindEEPROM) ReadWord(addr uint32) (word uint16, err error) {
+	return ee.ReadWordContext(context.Background(), addr)
+}
+
+func (ee *blindEEPROM) ReadWordContext(ctx context.Context, addr uint32) (word uint16, err error) {
 	if ee.closed {
 		err = errors.New("ecee eeprom is already closed")
 		return
 	}
 
-	err = ee.waitForIdle(0)
+	err = ee.waitForIdle(ctx, 0)
 	if err != nil {
 		return
 	}
@@ -82,7 +104,7 @@ func (ee *blindEEPROM) ReadWord(addr uint32) (word uint16, err error) {
 	wb[1] = uint8(addr >> 8)
 	wb[2] = uint8(addr >> 16)
 	wb[3] = uint8(addr >> 24)
-	err = ecmd.ExecuteWrite(ee.commander, dgaddr, wb, 1)
+	err = ee.write(ctx, dgaddr, wb)
 	if err != nil {
 		return
 	}
@@ -90,12 +112,12 @@ func (ee *blindEEPROM) ReadWord(addr uint32) (word uint16, err error) {
 	// write "read command"
 	dgaddr.SetOffset(ecad.EEPROMControlStatus)
 	wb = []byte{0x00, 0x01} // read command
-	err = ecmd.ExecuteWrite(ee.commander, dgaddr, wb, 1)
+	err = ee.write(ctx, dgaddr, wb)
 	if err != nil {
 		return
 	}
 
-	err = ee.waitForIdle(0)
+	err = ee.waitForIdle(ctx, 0)
 	if err != nil {
 		return
 	}
@@ -103,7 +125,7 @@ func (ee *blindEEPROM) ReadWord(addr uint32) (word uint16, err error) {
 	// check error bits
 	dgaddr.SetOffset(ecad.EEPROMControlStatus)
 	var rb []byte
-	rb, err = ecmd.ExecuteRead(ee.commander, dgaddr, 2, 1)
+	rb, err = ee.read(ctx, dgaddr, 2)
 	if err != nil {
 		return
 	}
@@ -114,7 +136,7 @@ func (ee *blindEEPROM) ReadWord(addr uint32) (word uint16, err error) {
 	}
 
 	dgaddr.SetOffset(ecad.EEPROMData)
-	rb, err = ecmd.ExecuteRead(ee.commander, dgaddr, 4, 1)
+	rb, err = ee.read(ctx, dgaddr, 4)
 	if err != nil {
 		return
 	}
@@ -126,12 +148,16 @@ func (ee *blindEEPROM) ReadWord(addr uint32) (word uint16, err error) {
 }
 
 func (ee *blindEEPROM) WriteWord(addr uint32, word uint16) (err error) {
+	return ee.WriteWordContext(context.Background(), addr, word)
+}
+
+func (ee *blindEEPROM) WriteWordContext(ctx context.Context, addr uint32, word uint16) (err error) {
 	if ee.closed {
 		err = errors.New("ecee eeprom is already closed")
 		return
 	}
 
-	err = ee.waitForIdle(0)
+	err = ee.waitForIdle(ctx, 0)
 	if err != nil {
 		return
 	}
@@ -145,15 +171,15 @@ func (ee *blindEEPROM) WriteWord(addr uint32, word uint16) (err error) {
 	wb[1] = uint8(addr >> 8)
 	wb[2] = uint8(addr >> 16)
 	wb[3] = uint8(addr >> 24)
-	err = ecmd.ExecuteWrite(ee.commander, dgaddr, wb, 1)
+	err = ee.write(ctx, dgaddr, wb)
 	if err != nil {
 		return
 	}
-	
+
 	// write data
 	dgaddr.SetOffset(ecad.EEPROMData)
 	wb = []byte{uint8(word), uint8(word >> 8)}
-	err = ecmd.ExecuteWrite(ee.commander, dgaddr, wb, 1)
+	err = ee.write(ctx, dgaddr, wb)
 	if err != nil {
 		return
 	}
@@ -161,12 +187,12 @@ func (ee *blindEEPROM) WriteWord(addr uint32, word uint16) (err error) {
 	// write "write command"
 	dgaddr.SetOffset(ecad.EEPROMControlStatus)
 	wb = []byte{0x01, 0x02} // write command
-	err = ecmd.ExecuteWrite(ee.commander, dgaddr, wb, 1)
+	err = ee.write(ctx, dgaddr, wb)
 	if err != nil {
 		return
 	}
 
-	err = ee.waitForIdle(0)
+	err = ee.waitForIdle(ctx, 0)
 	if err != nil {
 		return
 	}
@@ -174,7 +200,7 @@ func (ee *blindEEPROM) WriteWord(addr uint32, word uint16) (err error) {
 	// check error bits
 	dgaddr.SetOffset(ecad.EEPROMControlStatus)
 	var rb []byte
-	rb, err = ecmd.ExecuteRead(ee.commander, dgaddr, 2, 1)
+	rb, err = ee.read(ctx, dgaddr, 2)
 	if err != nil {
 		return
 	}
@@ -185,11 +211,11 @@ func (ee *blindEEPROM) WriteWord(addr uint32, word uint16) (err error) {
 	}
 
 	dgaddr.SetOffset(ecad.EEPROMData)
-	rb, err = ecmd.ExecuteRead(ee.commander, dgaddr, 4, 1)
+	rb, err = ee.read(ctx, dgaddr, 4)
 	if err != nil {
 		return
 	}
-	
+
 	return
 }
 
@@ -0,0 +1,307 @@
+package ecee
+
+import (
+	"context"
+	"fmt"
+)
+
+// word addresses of the SII
+const (
+	SIIPDIControl             = 0x00
+	SIIPDIConfiguration       = 0x01
+	SIIConfiguredStationAlias = 0x04
+	SIIChecksum               = 0x07
+	SIIVendorID               = 0x08
+	SIIProductCode            = 0x0a
+	SIIRevisionNumber         = 0x0c
+	SIISerialNumber           = 0x0e
+	SIIBootstrapMailbox       = 0x14
+	SIIStandardMailbox        = 0x18
+	SIIMailboxProtocol        = 0x1c
+	SIISize                   = 0x3e
+	SIIVersion                = 0x3f
+	SIIFirstCategory          = 0x40
+)
+
+// category types
+const (
+	SIICategoryStrings   = 10
+	SIICategoryDataTypes = 20
+	SIICategoryGeneral   = 30
+	SIICategoryFMMU      = 40
+	SIICategorySyncM     = 41
+	SIICategoryTXPDO     = 50
+	SIICategoryRXPDO     = 51
+	SIICategoryDC        = 60
+	SIICategoryEnd       = 0xffff
+)
+
+// mailbox protocols
+const (
+	SIIMailboxAoE = 0x01
+	SIIMailboxEoE = 0x02
+	SIIMailboxCoE = 0x04
+	SIIMailboxFoE = 0x08
+	SIIMailboxSoE = 0x10
+	SIIMailboxVoE = 0x20
+)
+
+type SIICategory struct {
+	Type uint16
+	Data []byte
+}
+
+// SIIMailbox is the area of a write (Out) and a read (In) mailbox.
+type SIIMailbox struct {
+	OutOffset, OutSize uint16
+	InOffset, InSize   uint16
+}
+
+// SII is the decoded content of a slave information interface image.
+type SII struct {
+	PDIControl             uint16
+	PDIConfiguration       uint16
+	ConfiguredStationAlias uint16
+	Checksum               uint16
+
+	VendorID       uint32
+	ProductCode    uint32
+	RevisionNumber uint32
+	SerialNumber   uint32
+
+	BootstrapMailbox SIIMailbox
+	StandardMailbox  SIIMailbox
+	MailboxProtocol  uint16
+
+	// EEPROM size in bytes
+	Size    int
+	Version uint16
+
+	Categories []SIICategory
+	// of the strings category, string indices start at 1
+	Strings []string
+	// from the general category
+	Group, Image, Order, Name string
+}
+
+func word(b []byte, w int) uint16 {
+	return uint16(b[2*w]) | uint16(b[2*w+1])<<8
+}
+
+func dword(b []byte, w int) uint32 {
+	return uint32(word(b, w)) | uint32(word(b, w+1))<<16
+}
+
+// DecodeSII decodes an SII image, as read from the EEPROM or an image file.
+func DecodeSII(b []byte) (s *SII, err error) {
+	if len(b) < 2*SIIFirstCategory {
+		return nil, fmt.Errorf("SII image of %d bytes is shorter than its header", len(b))
+	}
+
+	s = &SII{
+		PDIControl:             word(b, SIIPDIControl),
+		PDIConfiguration:       word(b, SIIPDIConfiguration),
+		ConfiguredStationAlias: word(b, SIIConfiguredStationAlias),
+		Checksum:               word(b, SIIChecksum),
+		VendorID:               dword(b, SIIVendorID),
+		ProductCode:            dword(b, SIIProductCode),
+		RevisionNumber:         dword(b, SIIRevisionNumber),
+		SerialNumber:           dword(b, SIISerialNumber),
+		MailboxProtocol:        word(b, SIIMailboxProtocol),
+		Size:                   (int(word(b, SIISize)) + 1) * 1024 / 8,
+		Version:                word(b, SIIVersion),
+	}
+	for i, mbx := range []*SIIMailbox{&s.BootstrapMailbox, &s.StandardMailbox} {
+		w := SIIBootstrapMailbox + 4*i
+		*mbx = SIIMailbox{word(b, w), word(b, w+1), word(b, w+2), word(b, w+3)}
+	}
+
+	var general []byte
+	for w := SIIFirstCategory; ; {
+		if 2*(w+2) > len(b) {
+			// images may end without an end category
+			break
+		}
+		typ, l := word(b, w), int(word(b, w+1))
+		if typ == SIICategoryEnd {
+			break
+		}
+		w += 2
+		if 2*(w+l) > len(b) {
+			return nil, fmt.Errorf("category %d at word %#x exceeds the image", typ, w-2)
+		}
+
+		c := SIICategory{typ, b[2*w : 2*(w+l)]}
+		s.Categories = append(s.Categories, c)
+		w += l
+
+		switch typ {
+		case SIICategoryStrings:
+			if s.Strings, err = decodeStrings(c.Data); err != nil {
+				return nil, err
+			}
+		case SIICategoryGeneral:
+			if len(c.Data) < 4 {
+				return nil, fmt.Errorf("general category of %d bytes is too short", len(c.Data))
+			}
+			general = c.Data
+		}
+	}
+
+	// the strings may follow the general category
+	if general != nil {
+		s.Group, s.Image, s.Order, s.Name = s.StringAt(general[0]), s.StringAt(general[1]), s.StringAt(general[2]), s.StringAt(general[3])
+	}
+	return
+}
+
+// Encode returns the image of the header fields and Categories, followed by
+// the end category. Strings and the names of the general category are not
+// encoded, they are taken from Categories. the checksum of the ESC
+// configuration area is calculated.
+func (s *SII) Encode() []byte {
+	b := make([]byte, 2*SIIFirstCategory)
+	put := func(w int, v uint16) {
+		b[2*w], b[2*w+1] = uint8(v), uint8(v>>8)
+	}
+	put32 := func(w int, v uint32) {
+		put(w, uint16(v))
+		put(w+1, uint16(v>>16))
+	}
+
+	put(SIIPDIControl, s.PDIControl)
+	put(SIIPDIConfiguration, s.PDIConfiguration)
+	put(SIIConfiguredStationAlias, s.ConfiguredStationAlias)
+	put(SIIChecksum, uint16(Checksum(b)))
+	put32(SIIVendorID, s.VendorID)
+	put32(SIIProductCode, s.ProductCode)
+	put32(SIIRevisionNumber, s.RevisionNumber)
+	put32(SIISerialNumber, s.SerialNumber)
+	for i, mbx := range []SIIMailbox{s.BootstrapMailbox, s.StandardMailbox} {
+		w := SIIBootstrapMailbox + 4*i
+		put(w, mbx.OutOffset)
+		put(w+1, mbx.OutSize)
+		put(w+2, mbx.InOffset)
+		put(w+3, mbx.InSize)
+	}
+	put(SIIMailboxProtocol, s.MailboxProtocol)
+	if s.Size > 0 {
+		put(SIISize, uint16(s.Size*8/1024-1))
+	}
+	put(SIIVersion, s.Version)
+
+	for _, c := range s.Categories {
+		d := c.Data
+		if len(d)%2 != 0 {
+			d = append(d[:len(d):len(d)], 0)
+		}
+		l := len(d) / 2
+		b = append(b, uint8(c.Type), uint8(c.Type>>8), uint8(l), uint8(l>>8))
+		b = append(b, d...)
+	}
+	return append(b, 0xff, 0xff)
+}
+
+// Checksum returns the CRC-8 over the ESC configuration area preceding the
+// checksum word, the polynomial is x^8 + x^2 + x + 1 and the initial value
+// 0xff.
+func Checksum(b []byte) uint8 {
+	crc := uint8(0xff)
+	for _, v := range b[:2*SIIChecksum] {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// StringsCategory encodes strs as a strings category.
+func StringsCategory(strs ...string) SIICategory {
+	d := []byte{uint8(len(strs))}
+	for _, str := range strs {
+		d = append(d, uint8(len(str)))
+		d = append(d, str...)
+	}
+	return SIICategory{SIICategoryStrings, d}
+}
+
+func decodeStrings(d []byte) (strs []string, err error) {
+	if len(d) < 1 {
+		return nil, fmt.Errorf("empty strings category")
+	}
+	n := int(d[0])
+	d = d[1:]
+	for i := 0; i < n; i++ {
+		if len(d) < 1 || int(d[0]) > len(d)-1 {
+			return nil, fmt.Errorf("string %d exceeds the strings category", i+1)
+		}
+		strs = append(strs, string(d[1:1+d[0]]))
+		d = d[1+d[0]:]
+	}
+	return
+}
+
+// StringAt returns the string with index i, "" for index 0 and invalid
+// indices.
+func (s *SII) StringAt(i uint8) string {
+	if i == 0 || int(i) > len(s.Strings) {
+		return ""
+	}
+	return s.Strings[i-1]
+}
+
+// ReadSII reads the SII image of an EEPROM, up to and including the end
+// category marker.
+func ReadSII(ctx context.Context, ee EEPROM) (b []byte, err error) {
+	read := func(n int) error {
+		for i := 0; i < n; i++ {
+			w, err := ee.ReadWordContext(ctx, uint32(len(b)/2))
+			if err != nil {
+				return err
+			}
+			b = append(b, uint8(w), uint8(w>>8))
+		}
+		return nil
+	}
+
+	if err = read(SIIFirstCategory); err != nil {
+		return
+	}
+	size := (int(word(b, SIISize)) + 1) * 1024 / 8
+	for len(b)+4 <= size {
+		if err = read(2); err != nil {
+			return
+		}
+		typ, l := word(b, len(b)/2-2), int(word(b, len(b)/2-1))
+		if typ == SIICategoryEnd {
+			// the end category has no length
+			b = b[:len(b)-2]
+			break
+		}
+		if len(b)+2*l > size {
+			return nil, fmt.Errorf("category %d at word %#x exceeds the EEPROM size of %d bytes", typ, len(b)/2-2, size)
+		}
+		if err = read(l); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteSII writes an SII image to the EEPROM, word by word.
+func WriteSII(ctx context.Context, ee EEPROM, b []byte) error {
+	if len(b)%2 != 0 {
+		return fmt.Errorf("SII image has odd length %d", len(b))
+	}
+	for w := 0; w < len(b)/2; w++ {
+		if err := ee.WriteWordContext(ctx, uint32(w), word(b, w)); err != nil {
+			return fmt.Errorf("writing word %#x: %v", w, err)
+		}
+	}
+	return nil
+}
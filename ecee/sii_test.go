@@ -0,0 +1,90 @@
+package ecee_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/distributed/ecat/ecee"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+)
+
+func testSII() *ecee.SII {
+	general := make([]byte, 32)
+	// group, image, order, name
+	copy(general, []byte{1, 0, 2, 3})
+	return &ecee.SII{
+		PDIControl:      0x0c08,
+		VendorID:        0x00000002,
+		ProductCode:     0x044c2c52,
+		RevisionNumber:  0x00110000,
+		StandardMailbox: ecee.SIIMailbox{0x1000, 0x80, 0x1080, 0x80},
+		MailboxProtocol: ecee.SIIMailboxCoE,
+		Size:            2048,
+		Version:         1,
+		Categories: []ecee.SIICategory{
+			ecee.StringsCategory("DigOut", "EL1100", "EL1100 8Ch. Dig. Output"),
+			{ecee.SIICategoryGeneral, general},
+			// odd length, padded to words
+			{ecee.SIICategoryFMMU, []byte{1, 2, 3}},
+		},
+	}
+}
+
+func TestSIIRoundtrip(t *testing.T) {
+	b := testSII().Encode()
+	s, err := ecee.DecodeSII(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.VendorID != 2 || s.ProductCode != 0x044c2c52 || s.RevisionNumber != 0x00110000 || s.Size != 2048 {
+		t.Fatalf("unexpected identity %+v", s)
+	}
+	if s.StandardMailbox != (ecee.SIIMailbox{0x1000, 0x80, 0x1080, 0x80}) {
+		t.Fatalf("unexpected mailbox %+v", s.StandardMailbox)
+	}
+	if s.Group != "DigOut" || s.Image != "" || s.Order != "EL1100" || s.Name != "EL1100 8Ch. Dig. Output" {
+		t.Fatalf("unexpected general strings %q %q %q %q", s.Group, s.Image, s.Order, s.Name)
+	}
+	if len(s.Categories) != 3 || !bytes.Equal(s.Categories[2].Data, []byte{1, 2, 3, 0}) {
+		t.Fatalf("unexpected categories %+v", s.Categories)
+	}
+	if uint8(s.Checksum) != ecee.Checksum(b) {
+		t.Fatalf("checksum %#02x does not match %#02x", s.Checksum, ecee.Checksum(b))
+	}
+
+	if _, err = ecee.DecodeSII(b[:len(b)-4]); err == nil {
+		t.Fatalf("truncated category decoded")
+	}
+}
+
+func TestReadWriteSII(t *testing.T) {
+	slave := sim.NewL2Slave()
+	c := ecmd.NewCommandFramer(&sim.L2Bus{Slaves: []sim.FrameProcessor{slave}})
+	ctx := context.Background()
+
+	ee, err := ecee.New(c, ecfr.PositionalAddr(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := testSII().Encode()
+	if err = ecee.WriteSII(ctx, ee, img); err != nil {
+		t.Fatal(err)
+	}
+	// the sim slave does not reload, the configuration area is valid
+	// anyway
+	if slave.EEPROM.Array[ecee.SIIChecksum] != uint16(ecee.Checksum(img)) {
+		t.Fatalf("checksum word not written")
+	}
+
+	b, err := ecee.ReadSII(ctx, ee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, img) {
+		t.Fatalf("read back\n% x\nwant\n% x", b, img)
+	}
+}
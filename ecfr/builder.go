@@ -0,0 +1,80 @@
+package ecfr
+
+import (
+	"fmt"
+)
+
+// Builder appends datagrams to a command frame and keeps the last datagram
+// flags consistent. it does not allocate once the datagram structures of
+// its frame have grown to the number of datagrams built.
+type Builder struct {
+	f *Frame
+}
+
+// NewBuilder points f to buf and returns a Builder filling it.
+func NewBuilder(f *Frame, buf []byte) (Builder, error) {
+	if err := f.Reset(buf); err != nil {
+		return Builder{}, err
+	}
+	f.Header.SetType(FrameTypeCommand)
+	return Builder{f: f}, nil
+}
+
+func (b Builder) Frame() *Frame {
+	return b.f
+}
+
+// Remaining returns the number of data bytes the next datagram may carry.
+func (b Builder) Remaining() int {
+	return b.f.Remaining()
+}
+
+// Add appends a datagram with datalen bytes of zeroed data. the address
+// type has to fit the command, unless either of them has no address type.
+func (b Builder) Add(cmd CommandType, addr DatagramAddress, datalen int) (dg *Datagram, err error) {
+	if _, ok := commandTypeName[cmd]; !ok {
+		err = fmt.Errorf("invalid command %v", cmd)
+		return
+	}
+	want := DatagramAddressFromCommand(0, cmd).Type()
+	if want != UninitializedDatagramAddressType && addr.Type() != UninitializedDatagramAddressType && addr.Type() != want {
+		err = fmt.Errorf("address %v does not fit command %v", addr, cmd)
+		return
+	}
+
+	dg, err = b.f.NewDatagram(datalen)
+	if err != nil {
+		return
+	}
+
+	data := dg.Data()
+	for i := range data {
+		data[i] = 0
+	}
+	dg.Command = cmd
+	dg.Index = 0
+	dg.Addr32 = addr.Addr32()
+	dg.Interrupt = 0
+	dg.WorkingCounter = 0
+
+	if n := len(b.f.Datagrams); n > 1 {
+		b.f.Datagrams[n-2].SetLast(false)
+	}
+	dg.SetLast(true)
+	return
+}
+
+// AddData appends a datagram carrying a copy of data.
+func (b Builder) AddData(cmd CommandType, addr DatagramAddress, data []byte) (dg *Datagram, err error) {
+	dg, err = b.Add(cmd, addr, len(data))
+	if err != nil {
+		return
+	}
+	copy(dg.Data(), data)
+	return
+}
+
+// Commit writes down the frame, see Frame.Commit.
+func (b Builder) Commit() ([]byte, error) {
+	return b.f.Commit()
+}
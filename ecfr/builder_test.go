@@ -0,0 +1,155 @@
+package ecfr
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	var fr Frame
+	b, err := NewBuilder(&fr, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Remaining() != 64-FrameOverheadLen-DatagramOverheadLength {
+		t.Fatalf("unexpected capacity %d of empty frame", b.Remaining())
+	}
+
+	dg, err := b.AddData(FPWR, FixedAddr(0x1001, 0x120), []byte{0x02, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dg.Interrupt = 0x1234
+	dg.SetRoundtrip(true)
+
+	if _, err = b.Add(APRD, FixedAddr(0x1001, 0x130), 2); err == nil {
+		t.Fatalf("positional command with fixed address was accepted")
+	}
+	if _, err = b.Add(CommandType(0x42), FixedAddr(0x1001, 0x130), 2); err == nil {
+		t.Fatalf("invalid command was accepted")
+	}
+	if _, err = b.Add(FPRD, FixedAddr(0x1001, 0x130), b.Remaining()+1); err == nil {
+		t.Fatalf("datagram exceeding the frame was accepted")
+	}
+	if _, err = b.Add(FPRD, FixedAddr(0x1001, 0x130), b.Remaining()); err != nil {
+		t.Fatalf("datagram filling the frame was rejected: %v", err)
+	}
+	if b.Remaining() != 0 {
+		t.Fatalf("full frame has room for %d bytes", b.Remaining())
+	}
+
+	d, err := b.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var in Frame
+	if _, err = in.OverlayStrict(d, ParseOptions{}); err != nil {
+		t.Fatalf("built frame does not parse strictly: %v", err)
+	}
+	if len(in.Datagrams) != 2 || in.Datagrams[0].Last() || !in.Datagrams[1].Last() {
+		t.Fatalf("unexpected datagrams\n%s", in.MultilineSummary())
+	}
+	if dg := in.Datagrams[0]; dg.Interrupt != 0x1234 || !dg.Roundtrip() || dg.Command != FPWR || !bytes.Equal(dg.Data(), []byte{0x02, 0x00}) {
+		t.Fatalf("unexpected first datagram %s", dg.Summary())
+	}
+}
+
+func TestBuilderAllocs(t *testing.T) {
+	var fr Frame
+	buf := make([]byte, 1500)
+	data := make([]byte, 16)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		b, err := NewBuilder(&fr, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 8; i++ {
+			if _, err = b.AddData(LRW, DatagramAddressFromCommand(0x10000, LRW), data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err = b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("want no allocations building a frame, have %v", allocs)
+	}
+}
+
+func FuzzOverlayCommit(f *testing.F) {
+	var fr Frame
+	b, _ := NewBuilder(&fr, make([]byte, 64))
+	b.AddData(APWR, PositionalAddr(-1, 0x10), []byte{0x01, 0x10})
+	b.Add(BRD, DatagramAddress{}, 4)
+	d, _ := b.Commit()
+	f.Add(append([]byte(nil), d...))
+	f.Add([]byte{0x0c, 0x10, 0x07, 0x00})
+
+	f.Fuzz(func(t *testing.T, d []byte) {
+		var lenient Frame
+		// must not panic
+		lenient.Overlay(append([]byte(nil), d...))
+
+		orig := append([]byte(nil), d...)
+		var fr Frame
+		if _, err := fr.OverlayStrict(d, ParseOptions{}); err != nil {
+			return
+		}
+		c, err := fr.Commit()
+		if err != nil {
+			t.Fatalf("committing a strictly parsed frame failed: %v", err)
+		}
+		n := FrameOverheadLen + int(fr.Header.FrameLength())
+		if !bytes.Equal(c, orig[:n]) {
+			t.Fatalf("commit changed frame\nhave % x\nwant % x", c, orig[:n])
+		}
+	})
+}
+
+func FuzzBuilder(f *testing.F) {
+	f.Add([]byte{byte(FPRD), 2, byte(LWR), 8}, uint16(0x1001))
+	f.Add([]byte{byte(BWR), 255, byte(NOP), 0, byte(APRW), 1}, uint16(0))
+
+	f.Fuzz(func(t *testing.T, spec []byte, addr uint16) {
+		var fr Frame
+		b, err := NewBuilder(&fr, make([]byte, 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var cmds []CommandType
+		for i := 0; i+1 < len(spec); i += 2 {
+			cmd := CommandType(spec[i])
+			dg, err := b.Add(cmd, DatagramAddressFromCommand(uint32(addr), cmd), int(spec[i+1]))
+			if err != nil {
+				continue
+			}
+			dg.Index = uint8(len(cmds))
+			cmds = append(cmds, cmd)
+		}
+		if len(cmds) == 0 {
+			return
+		}
+
+		d, err := b.Commit()
+		if err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+
+		var in Frame
+		if _, err = in.OverlayStrict(d, ParseOptions{}); err != nil {
+			t.Fatalf("built frame does not parse strictly: %v", err)
+		}
+		if len(in.Datagrams) != len(cmds) {
+			t.Fatalf("built %d datagrams, parsed %d", len(cmds), len(in.Datagrams))
+		}
+		for i, dg := range in.Datagrams {
+			if dg.Command != cmds[i] || int(dg.Index) != i || dg.Addr32 != uint32(addr) {
+				t.Fatalf("datagram %d differs: %s", i, dg.Summary())
+			}
+		}
+	})
+}
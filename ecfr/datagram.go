@@ -43,7 +43,7 @@ func (dg *Datagram) Overlay(d []byte) (b []byte, err error) {
 func (dg *Datagram) Commit() (d []byte, err error) {
 	_, err = dg.DatagramHeader.Commit()
 	if err != nil {
-
+		return
 	}
 
 	// the data is already committed
@@ -99,6 +99,10 @@ func (dh *DatagramHeader) Overlay(d []byte) (b []byte, err error) {
 }
 
 func (dh *DatagramHeader) Commit() (d []byte, err error) {
+	if len(dh.buffer) < datagramHeaderLength {
+		err = fmt.Errorf("cannot commit datagram header: buffer too short, need %d bytes, have %d", datagramHeaderLength, len(dh.buffer))
+		return
+	}
 	b := dh.buffer
 
 	b = putUint8(b, uint8(dh.Command))
@@ -135,6 +139,16 @@ func (dh *DatagramHeader) Last() bool {
 	return (dh.LenWord & (1 << lastindicatorBit)) == 0
 }
 
+// SetRoundtrip sets the circulating bit, slaves set it on frames that pass
+// the bus a second time.
+func (dh *DatagramHeader) SetRoundtrip(rt bool) {
+	if rt {
+		dh.LenWord |= (1 << roundtripBit)
+	} else {
+		dh.LenWord &^= (1 << roundtripBit)
+	}
+}
+
 func (dh *DatagramHeader) SetLast(last bool) {
 	if last {
 		dh.LenWord &^= (1 << lastindicatorBit)
@@ -228,7 +242,7 @@ const (
 	datagramFooterLength   = 2
 	DatagramOverheadLength = datagramHeaderLength + datagramFooterLength
 
-	datagramDataLengthMask = (1 << 12) - 1
+	datagramDataLengthMask = (1 << 11) - 1
 	datagramMaxDataLength  = datagramDataLengthMask
 )
 
@@ -334,6 +348,10 @@ func FixedAddr(stationaddr uint16, offset uint16) DatagramAddress {
 	return DatagramAddress{uint32(stationaddr) | uint32(offset)<<16, Fixed}
 }
 
+func BroadcastAddr(offset uint16) DatagramAddress {
+	return DatagramAddress{uint32(offset) << 16, Broadcast}
+}
+
 var datagramAddressByOperation = map[CommandType]DatagramAddressType{
 	NOP:  UninitializedDatagramAddressType,
 	APRD: Positional,
@@ -349,7 +367,7 @@ var datagramAddressByOperation = map[CommandType]DatagramAddressType{
 	LWR:  Logical,
 	LRW:  Logical,
 	ARMW: Positional,
-	FRMW: Positional,
+	FRMW: Fixed,
 }
 
 const (
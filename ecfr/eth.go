@@ -3,6 +3,7 @@ package ecfr
 import (
 	"errors"
 	"fmt"
+	"hash/crc32"
 )
 
 type ETHAddr [6]byte
@@ -23,15 +24,29 @@ func sliceToETHADDR(s []byte) ETHAddr {
 	return e
 }
 
+// FCSMismatch is returned by CheckFCS for frames with a wrong frame check
+// sequence.
+var FCSMismatch = errors.New("ethernet frame check sequence mismatch")
+
 // payload len is len(ef.GetPayload())
 // capacity for payload is: cap(ef.GetPayload()) - ef.GetFooterLen()
+//
+// the frame buffer always holds room for the FCS at its end. link layers
+// that have the NIC append the FCS send GetFrameBufNoFCS.
 type ETHFrame struct {
 	Destination, Source ETHAddr
-	Type                uint16
+	// EtherType of the payload, the VLAN tag is described by UseVlan and
+	// VLANTCI
+	Type uint16
 
+	// the header length depends on UseVlan, set it before filling in the
+	// payload
 	UseVlan bool
 	VLANTCI uint16
 
+	// compute the FCS in WriteDown
+	WriteFCS bool
+
 	framebuf []byte
 }
 
@@ -58,11 +73,26 @@ func OverlayETHFrame(fb []byte) (*ETHFrame, error) {
 	if ef.Type == etherTypeVLAN {
 		ef.UseVlan = true
 		ef.VLANTCI, _ = getUint16BE(fb[offsetVLANTCI:])
-
+		ef.Type, _ = getUint16BE(fb[offsetVLANType:])
 	}
 	return ef, nil
 }
 
+// SetVLAN tags the frame with an 802.1Q header. prio is the priority code
+// point, 0 to 7, real-time traffic usually goes with high priorities.
+func (ef *ETHFrame) SetVLAN(prio uint8, vid uint16) {
+	ef.UseVlan = true
+	ef.VLANTCI = uint16(prio&0x07)<<13 | vid&0x0fff
+}
+
+func (ef *ETHFrame) VLANPriority() uint8 {
+	return uint8(ef.VLANTCI >> 13)
+}
+
+func (ef *ETHFrame) VLANID() uint16 {
+	return ef.VLANTCI & 0x0fff
+}
+
 func (ef *ETHFrame) GetHeaderLen() int {
 	vlanlen := 0
 	if ef.UseVlan {
@@ -83,6 +113,11 @@ func (ef *ETHFrame) GetFrameBuf() []byte {
 	return ef.framebuf
 }
 
+// GetFrameBufNoFCS returns the frame without the FCS.
+func (ef *ETHFrame) GetFrameBufNoFCS() []byte {
+	return ef.framebuf[:len(ef.framebuf)-ef.GetFooterLen()]
+}
+
 func (ef *ETHFrame) GetPayload() []byte {
 	return ef.framebuf[ef.GetHeaderLen() : len(ef.framebuf)-ef.GetFooterLen()]
 }
@@ -118,28 +153,104 @@ func (ef *ETHFrame) SetPayloadLen(npl int) error {
 	return nil
 }
 
+// SetPayloadLenPadded is SetPayloadLen, but pads payloads shorter than the
+// minimum frame length with zeros.
+func (ef *ETHFrame) SetPayloadLenPadded(npl int) error {
+	minpl := min_framelen_with_fcs - ef.GetHeaderLen() - ef.GetFooterLen()
+	if npl >= minpl {
+		return ef.SetPayloadLen(npl)
+	}
+
+	if err := ef.SetPayloadLen(minpl); err != nil {
+		return err
+	}
+	pad := ef.GetPayload()[npl:]
+	for i := range pad {
+		pad[i] = 0
+	}
+	return nil
+}
+
+// WriteDown writes the header fields to the frame buffer, and the FCS if
+// WriteFCS is set.
 func (ef *ETHFrame) WriteDown() error {
-	// bounds should already be checked
-	// TODO: API clarification
+	if len(ef.framebuf) < min_framelen_with_fcs {
+		return fmt.Errorf("WriteDown: frame of %d bytes too short, need at least %d", len(ef.framebuf), min_framelen_with_fcs)
+	}
 
-	copy(ef.framebuf[0:6], ef.Destination[:])
-	copy(ef.framebuf[6:12], ef.Source[:])
+	copy(ef.framebuf[offsetDestination:offsetSource], ef.Destination[:])
+	copy(ef.framebuf[offsetSource:offsetVLANOrType], ef.Source[:])
 
-	pos := 12
+	b := ef.framebuf[offsetVLANOrType:]
 	if ef.UseVlan {
-		return errors.New("VLAN tags are not supported")
+		b = putUint16BE(b, etherTypeVLAN)
+		b = putUint16BE(b, ef.VLANTCI)
+	}
+	putUint16BE(b, ef.Type)
+
+	if ef.WriteFCS {
+		ef.UpdateFCS()
 	}
 
-	ef.framebuf[pos] = uint8(ef.Type >> 8)
-	ef.framebuf[pos] = uint8(ef.Type)
+	return nil
+}
 
-	pos += 2
+// the FCS is the CRC-32 of the frame, transmitted least significant byte
+// first.
+func (ef *ETHFrame) fcs() uint32 {
+	return crc32.ChecksumIEEE(ef.GetFrameBufNoFCS())
+}
 
-	// TODO: how to deal with the FCS?
+// UpdateFCS computes the FCS over the frame buffer as it is, call it after
+// WriteDown.
+func (ef *ETHFrame) UpdateFCS() {
+	putUint32(ef.framebuf[len(ef.framebuf)-fcs_len:], ef.fcs())
+}
 
+func (ef *ETHFrame) CheckFCS() error {
+	if xgetUint32(ef.framebuf[len(ef.framebuf)-fcs_len:]) != ef.fcs() {
+		return FCSMismatch
+	}
 	return nil
 }
 
+// WrapFrame commits f and copies it to the payload, padded to the minimum
+// frame length, and writes down the frame with EtherType EtherCAT.
+func (ef *ETHFrame) WrapFrame(f *Frame) error {
+	b, err := f.Commit()
+	if err != nil {
+		return err
+	}
+
+	if err = ef.SetPayloadLenPadded(len(b)); err != nil {
+		return err
+	}
+	copy(ef.GetPayload(), b)
+
+	ef.Type = EtherTypeEtherCAT
+	return ef.WriteDown()
+}
+
+// UnwrapFrame overlays f over the EtherCAT frame in the payload, padding
+// is cut off. f points into the frame buffer of ef.
+func (ef *ETHFrame) UnwrapFrame(f *Frame) error {
+	if ef.Type != EtherTypeEtherCAT {
+		return fmt.Errorf("UnwrapFrame: not an EtherCAT frame, EtherType %#04x", ef.Type)
+	}
+
+	pl := ef.GetPayload()
+	if len(pl) < FrameOverheadLen {
+		return errors.New("UnwrapFrame: payload too short for EtherCAT frame header")
+	}
+	n := int(xgetUint16(pl)&((1<<11)-1)) + FrameOverheadLen
+	if n > len(pl) {
+		return fmt.Errorf("UnwrapFrame: EtherCAT frame of %d bytes exceeds payload of %d bytes", n, len(pl))
+	}
+
+	_, err := f.Overlay(pl[:n])
+	return err
+}
+
 // TODO: +4 bytes of FCS for future compatibility?
 const (
 	min_framelen_with_fcs = 64
@@ -147,14 +258,17 @@ const (
 	min_headerandpayload  = min_framelen_with_fcs - fcs_len
 
 	// inclduing fcs
-	max_framelen_novlan = 1522
-	max_framelen_vlan   = 1526
+	max_framelen_novlan = 1518
+	max_framelen_vlan   = 1522
 	max_framelen        = max_framelen_vlan
 
 	offsetDestination = 0
 	offsetSource      = 6
 	offsetVLANOrType  = 12
 	offsetVLANTCI     = 14
+	offsetVLANType    = 16
 
 	etherTypeVLAN = 0x8100
+
+	EtherTypeEtherCAT = 0x88a4
 )
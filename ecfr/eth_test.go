@@ -1,6 +1,7 @@
 package ecfr
 
 import (
+	"hash/crc32"
 	"reflect"
 	"testing"
 )
@@ -80,3 +81,103 @@ func TestETHFrameDecoding(t *testing.T) {
 		t.Fatalf("want eth type %#04x, got %#04x", wantethtype, ef.Type)
 	}
 }
+
+func TestETHFrameVLANRoundtrip(t *testing.T) {
+	ef, err := OverlayETHFrame(make([]byte, max_framelen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef.Destination = ETHAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	ef.Source = ETHAddr{0x01, 0x01, 0x01, 0x01, 0x01, 0x01}
+	ef.Type = EtherTypeEtherCAT
+	ef.SetVLAN(6, 0x123)
+	if err = ef.SetPayloadLen(100); err != nil {
+		t.Fatal(err)
+	}
+	if err = ef.WriteDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	wanthdr := []byte{0x81, 0x00, 0xc1, 0x23, 0x88, 0xa4}
+	if hdr := ef.GetFrameBuf()[12:18]; !reflect.DeepEqual(hdr, wanthdr) {
+		t.Fatalf("want tag and type % x, got % x", wanthdr, hdr)
+	}
+
+	df, err := OverlayETHFrame(ef.GetFrameBuf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !df.UseVlan || df.VLANPriority() != 6 || df.VLANID() != 0x123 || df.Type != EtherTypeEtherCAT {
+		t.Fatalf("decoded unexpected header %+v", df)
+	}
+	if len(df.GetPayload()) != 100 || &df.GetPayload()[0] != &ef.GetPayload()[0] {
+		t.Fatalf("decoded payload does not match")
+	}
+}
+
+func TestETHFrameFCS(t *testing.T) {
+	ef, err := OverlayETHFrame(makeEmptyFrameBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef.Type = EtherTypeEtherCAT
+	ef.WriteFCS = true
+	copy(ef.GetPayload(), "some payload")
+	if err = ef.WriteDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ef.CheckFCS(); err != nil {
+		t.Fatalf("FCS check failed after WriteDown: %v", err)
+	}
+	// the CRC over a frame including its FCS yields the magic residue
+	if crc := crc32.ChecksumIEEE(ef.GetFrameBuf()); crc != 0x2144df1c {
+		t.Fatalf("FCS in wrong byte order, CRC residue %#08x", crc)
+	}
+
+	ef.GetPayload()[3] ^= 0x01
+	if err = ef.CheckFCS(); err != FCSMismatch {
+		t.Fatalf("want FCSMismatch for corrupted frame, got %v", err)
+	}
+}
+
+func TestETHFrameWrapUnwrap(t *testing.T) {
+	fr, err := PointFrameTo(make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.Header.SetType(1)
+	dg, err := fr.NewDatagram(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dg.Command = APRD
+	dg.Addr32 = PositionalAddr(0, 0x10).Addr32()
+
+	ef, err := OverlayETHFrame(make([]byte, max_framelen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef.SetVLAN(7, 0)
+	if err = ef.WrapFrame(&fr); err != nil {
+		t.Fatal(err)
+	}
+	if len(ef.GetFrameBuf()) != min_framelen_with_fcs {
+		t.Fatalf("want frame padded to %d bytes, got %d", min_framelen_with_fcs, len(ef.GetFrameBuf()))
+	}
+
+	df, err := OverlayETHFrame(ef.GetFrameBuf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uf Frame
+	if err = df.UnwrapFrame(&uf); err != nil {
+		t.Fatal(err)
+	}
+	if len(uf.Datagrams) != 1 || uf.Datagrams[0].Command != APRD || uf.Datagrams[0].Addr32 != dg.Addr32 || len(uf.Datagrams[0].Data()) != 2 {
+		t.Fatalf("unwrapped unexpected frame %s", uf.MultilineSummary())
+	}
+	if uf.ByteLen() != fr.ByteLen() {
+		t.Fatalf("padding was not cut off, have %d bytes, want %d", uf.ByteLen(), fr.ByteLen())
+	}
+}
@@ -16,53 +16,60 @@ type Frame struct {
 	buffer    []byte
 }
 
+// Overlay points f to the frame in d, reusing the datagram structures of f.
+// the datagrams have to fit in the frame length of the header. errors are of
+// type *ParseError, b are the bytes following the frame.
 func (f *Frame) Overlay(d []byte) (b []byte, err error) {
-	b, err = f.Header.Overlay(d)
-	if err != nil {
-		return
-	}
-
-	dgbl := f.Header.FrameLength()
-	if int(dgbl) > len(b) {
-		err = fmt.Errorf("frame expected %d bytes, only have %d", dgbl, len(b))
-		return
-	}
-
-	for {
-		f.Datagrams = append(f.Datagrams, &Datagram{})
-		i := len(f.Datagrams) - 1
-
-		b, err = f.Datagrams[i].Overlay(b)
-		if err != nil {
-			return
-		}
-
-		if f.Datagrams[i].Last() {
-			break
-		}
-	}
-
-	f.buffer = d
+	return f.overlay(d, nil)
+}
 
+func PointFrameTo(d []byte) (f Frame, err error) {
+	err = f.Reset(d)
 	return
 }
 
-func PointFrameTo(d []byte) (f Frame, err error) {
+// Reset points f to d like PointFrameTo, but keeps the datagram structures
+// of f for reuse.
+func (f *Frame) Reset(d []byte) error {
 	if len(d) < FrameOverheadLen {
-		err = errors.New("buffer too small to even contain frame header")
-		return
+		return errors.New("buffer too small to even contain frame header")
 	}
 
 	d[0] = 0
 	d[1] = 0
-	_, err = f.Header.Overlay(d)
+	f.Datagrams = f.Datagrams[:0]
+	_, err := f.Header.Overlay(d)
 	if err != nil {
-		return
+		return err
 	}
 
 	f.buffer = d
+	return nil
+}
 
-	return
+// Buffer returns the whole buffer f points to, for filling in a frame to be
+// overlaid.
+func (f *Frame) Buffer() []byte {
+	return f.buffer[:cap(f.buffer)]
+}
+
+// appends a datagram to f.Datagrams, reusing one left from an earlier use of
+// f if there is one.
+func (f *Frame) nextDatagram() *Datagram {
+	n := len(f.Datagrams)
+	if n < cap(f.Datagrams) {
+		f.Datagrams = f.Datagrams[:n+1]
+		if dg := f.Datagrams[n]; dg != nil {
+			*dg = Datagram{}
+			return dg
+		}
+	} else {
+		f.Datagrams = append(f.Datagrams, nil)
+	}
+
+	dg := &Datagram{}
+	f.Datagrams[n] = dg
+	return dg
 }
 
 func (f *Frame) Commit() (d []byte, err error) {
@@ -111,28 +118,38 @@ func (f *Frame) ByteLen() int {
 	return clen
 }
 
-func (f *Frame) NewDatagram(datalen int) (*Datagram, error) {
-	curlen := f.ByteLen()
-	maxlen := len(f.buffer)
-	curfree := maxlen - curlen
-	//fmt.Printf("curlen %d, maxlen %d, curfree %d\n", curlen, maxlen, curfree)
-	if datalen <= curfree {
-		dgram, err := PointDatagramTo(f.buffer[curlen:])
-		if err != nil {
-			return nil, err
-		}
+// Remaining returns the number of data bytes a datagram appended to f could
+// carry.
+func (f *Frame) Remaining() int {
+	n := len(f.buffer) - f.ByteLen() - DatagramOverheadLength
+	if n < 0 {
+		return 0
+	}
+	if n > datagramMaxDataLength {
+		return datagramMaxDataLength
+	}
+	return n
+}
 
-		err = dgram.SetDataLen(datalen)
-		if err != nil {
-			return nil, err
-		}
+func (f *Frame) NewDatagram(datalen int) (*Datagram, error) {
+	if datalen < 0 || datalen > f.Remaining() {
+		return nil, fmt.Errorf("datagram with %d bytes of data does not fit, frame has room for %d", datalen, f.Remaining())
+	}
 
-		f.Datagrams = append(f.Datagrams, &dgram)
+	dgram, err := PointDatagramTo(f.buffer[f.ByteLen():])
+	if err != nil {
+		return nil, err
+	}
 
-		return &dgram, nil
+	err = dgram.SetDataLen(datalen)
+	if err != nil {
+		return nil, err
 	}
-	panic("datalen too high")
-	return nil, errors.New("datalen too high")
+
+	dg := f.nextDatagram()
+	*dg = dgram
+
+	return dg, nil
 }
 
 func (f *Frame) MultilineSummary() string {
@@ -2,8 +2,31 @@ package ecfr
 
 import (
 	"errors"
+	"fmt"
 )
 
+// FrameType is the protocol type in the EtherCAT frame header, it tells how
+// the rest of the frame is laid out.
+type FrameType uint8
+
+const (
+	FrameTypeCommand          FrameType = 1
+	FrameTypeNetworkVariables FrameType = 4
+	FrameTypeMailbox          FrameType = 5
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameTypeCommand:
+		return "EtherCAT commands"
+	case FrameTypeNetworkVariables:
+		return "network variables"
+	case FrameTypeMailbox:
+		return "mailbox gateway"
+	}
+	return fmt.Sprintf("reserved frame type %d", uint8(t))
+}
+
 type Header struct {
 	Word   uint16
 	buffer []byte
@@ -23,12 +46,11 @@ func (h *Header) FrameLength() uint16 {
 	return h.Word & ((1 << 11) - 1)
 }
 
-// TODO: data type?
-func (h *Header) Type() uint8 {
-	return uint8(h.Word>>12) & 0x0f
+func (h *Header) Type() FrameType {
+	return FrameType(h.Word>>12) & 0x0f
 }
 
-func (h *Header) SetType(t uint8) {
+func (h *Header) SetType(t FrameType) {
 	h.Word &^= 0xf000
 	h.Word |= uint16(t&0x0f) << 12
 }
@@ -38,3 +60,13 @@ func (h *Header) Commit() (d []byte, err error) {
 	d = h.buffer[:2]
 	return
 }
+
+// PeekFrameType returns the type of the EtherCAT frame in b without
+// overlaying it.
+func PeekFrameType(b []byte) (FrameType, error) {
+	var h Header
+	if _, err := h.Overlay(b); err != nil {
+		return 0, err
+	}
+	return h.Type(), nil
+}
@@ -0,0 +1,92 @@
+package ecfr
+
+import (
+	"fmt"
+)
+
+const (
+	MailboxHeaderLen = 6
+)
+
+// MailboxHeader is the header in front of every mailbox message.
+type MailboxHeader struct {
+	// length of the data following the header
+	Length uint16
+	// station address of the source or destination
+	Address  uint16
+	Channel  uint8
+	Priority uint8
+	Type     uint8
+	Counter  uint8
+}
+
+func (mh *MailboxHeader) Overlay(d []byte) (b []byte, err error) {
+	if len(d) < MailboxHeaderLen {
+		err = fmt.Errorf("need %d bytes for mailbox header, have %d", MailboxHeaderLen, len(d))
+		return
+	}
+
+	var cp, tc uint8
+	mh.Length, b = getUint16(d)
+	mh.Address, b = getUint16(b)
+	cp, b = getUint8(b)
+	tc, b = getUint8(b)
+	mh.Channel = cp & 0x3f
+	mh.Priority = cp >> 6
+	mh.Type = tc & 0x0f
+	mh.Counter = (tc >> 4) & 0x07
+	return
+}
+
+// Encode writes the header to d and returns the bytes following it.
+func (mh MailboxHeader) Encode(d []byte) (b []byte, err error) {
+	if len(d) < MailboxHeaderLen {
+		err = fmt.Errorf("need %d bytes for mailbox header, have %d", MailboxHeaderLen, len(d))
+		return
+	}
+
+	b = putUint16(d, mh.Length)
+	b = putUint16(b, mh.Address)
+	b = putUint8(b, mh.Channel&0x3f|mh.Priority<<6)
+	b = putUint8(b, mh.Type&0x0f|(mh.Counter&0x07)<<4)
+	return
+}
+
+// MailboxFrame is an EtherCAT frame of type FrameTypeMailbox, exchanged with
+// a mailbox gateway. it carries one mailbox message, Data points into the
+// frame buffer.
+type MailboxFrame struct {
+	Header  Header
+	Mailbox MailboxHeader
+	Data    []byte
+}
+
+func (f *MailboxFrame) Overlay(d []byte) (b []byte, err error) {
+	b, err = f.Header.Overlay(d)
+	if err != nil {
+		return
+	}
+	if t := f.Header.Type(); t != FrameTypeMailbox {
+		err = fmt.Errorf("not a mailbox gateway frame, type %v", t)
+		return
+	}
+
+	fl := int(f.Header.FrameLength())
+	if fl > len(b) {
+		err = fmt.Errorf("frame expected %d bytes, only have %d", fl, len(b))
+		return
+	}
+	b = b[:fl]
+
+	b, err = f.Mailbox.Overlay(b)
+	if err != nil {
+		return
+	}
+	if int(f.Mailbox.Length) > len(b) {
+		err = fmt.Errorf("mailbox message expected %d bytes, only have %d", f.Mailbox.Length, len(b))
+		return
+	}
+	f.Data = b[:f.Mailbox.Length]
+	b = b[f.Mailbox.Length:]
+	return
+}
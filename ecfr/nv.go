@@ -0,0 +1,150 @@
+package ecfr
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	nvPublisherHeaderLen = 12
+	nvHeaderLen          = 8
+)
+
+// NetworkVariable is a variable of a network variable frame. Data points
+// into the frame buffer.
+type NetworkVariable struct {
+	ID      uint16
+	Hash    uint16
+	Quality uint16
+	Data    []byte
+}
+
+// NVFrame is an EtherCAT frame of type FrameTypeNetworkVariables, sent by a
+// publisher to all subscribers. subscribers pick their variables by
+// publisher and variable ID.
+type NVFrame struct {
+	Header Header
+	// identifies the publisher, usually its MAC address
+	Publisher  ETHAddr
+	CycleIndex uint16
+	Variables  []NetworkVariable
+}
+
+// Overlay decodes the network variable frame in d, the variables point into
+// d.
+func (f *NVFrame) Overlay(d []byte) (b []byte, err error) {
+	f.Variables = f.Variables[:0]
+	b, err = f.Header.Overlay(d)
+	if err != nil {
+		return
+	}
+	if t := f.Header.Type(); t != FrameTypeNetworkVariables {
+		err = fmt.Errorf("not a network variable frame, type %v", t)
+		return
+	}
+
+	fl := int(f.Header.FrameLength())
+	if fl > len(b) {
+		err = fmt.Errorf("frame expected %d bytes, only have %d", fl, len(b))
+		return
+	}
+	b = b[:fl]
+
+	if len(b) < nvPublisherHeaderLen {
+		err = fmt.Errorf("need %d bytes for publisher header, have %d", nvPublisherHeaderLen, len(b))
+		return
+	}
+	f.Publisher = sliceToETHADDR(b)
+	var count uint16
+	count, b = getUint16(b[6:])
+	f.CycleIndex, b = getUint16(b)
+	// reserved
+	b = b[2:]
+
+	for i := 0; i < int(count); i++ {
+		if len(b) < nvHeaderLen {
+			err = fmt.Errorf("network variable %d: need %d bytes for header, have %d", i, nvHeaderLen, len(b))
+			return
+		}
+
+		var nv NetworkVariable
+		var l uint16
+		nv.ID, b = getUint16(b)
+		nv.Hash, b = getUint16(b)
+		l, b = getUint16(b)
+		nv.Quality, b = getUint16(b)
+		if int(l) > len(b) {
+			err = fmt.Errorf("network variable %d: need %d bytes of data, have %d", i, l, len(b))
+			return
+		}
+		nv.Data = b[:l]
+		b = b[l:]
+
+		f.Variables = append(f.Variables, nv)
+	}
+
+	return
+}
+
+// Variable returns the variable with the given ID, as a subscriber would.
+func (f *NVFrame) Variable(id uint16) (*NetworkVariable, bool) {
+	for i := range f.Variables {
+		if f.Variables[i].ID == id {
+			return &f.Variables[i], true
+		}
+	}
+	return nil, false
+}
+
+func (f *NVFrame) ByteLen() int {
+	l := FrameOverheadLen + nvPublisherHeaderLen
+	for _, nv := range f.Variables {
+		l += nvHeaderLen + len(nv.Data)
+	}
+	return l
+}
+
+// Encode writes the frame to d, with the frame type and length set in the
+// header, and returns the bytes written.
+func (f *NVFrame) Encode(d []byte) (b []byte, err error) {
+	n := f.ByteLen()
+	if n > len(d) {
+		err = fmt.Errorf("network variable frame needs %d bytes, have %d", n, len(d))
+		return
+	}
+	if n-FrameOverheadLen >= 1<<11 {
+		err = fmt.Errorf("network variable frame of %d bytes exceeds the maximum frame length", n)
+		return
+	}
+
+	lenmask := uint16((1 << 11) - 1)
+	f.Header.Word &^= lenmask
+	f.Header.Word |= uint16(n-FrameOverheadLen) & lenmask
+	f.Header.SetType(FrameTypeNetworkVariables)
+
+	p := putUint16(d, f.Header.Word)
+	copy(p, f.Publisher[:])
+	p = putUint16(p[6:], uint16(len(f.Variables)))
+	p = putUint16(p, f.CycleIndex)
+	p = putUint16(p, 0)
+
+	for _, nv := range f.Variables {
+		p = putUint16(p, nv.ID)
+		p = putUint16(p, nv.Hash)
+		p = putUint16(p, uint16(len(nv.Data)))
+		p = putUint16(p, nv.Quality)
+		copy(p, nv.Data)
+		p = p[len(nv.Data):]
+	}
+
+	return d[:n], nil
+}
+
+func (f *NVFrame) MultilineSummary() string {
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintf(b, "nv frame len %#03x publisher %v cycle %d\n", f.ByteLen(), f.Publisher, f.CycleIndex)
+	for _, nv := range f.Variables {
+		fmt.Fprintf(b, "  id %#04x hash %#04x quality %#04x len %d\n", nv.ID, nv.Hash, nv.Quality, len(nv.Data))
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package ecfr
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNVFrameRoundtrip(t *testing.T) {
+	out := NVFrame{
+		Publisher:  ETHAddr{0x00, 0x01, 0x05, 0x11, 0x22, 0x33},
+		CycleIndex: 7,
+		Variables: []NetworkVariable{
+			{ID: 1, Hash: 0xbeef, Quality: 0, Data: []byte{0x01, 0x02, 0x03, 0x04}},
+			{ID: 2, Hash: 0xcafe, Quality: 1, Data: []byte{0xaa}},
+		},
+	}
+
+	b, err := out.Encode(make([]byte, 1500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2+12+8+4+8+1 {
+		t.Fatalf("unexpected encoded length %d", len(b))
+	}
+	if typ, err := PeekFrameType(b); err != nil || typ != FrameTypeNetworkVariables {
+		t.Fatalf("want type %v, got %v (err %v)", FrameTypeNetworkVariables, typ, err)
+	}
+
+	var in NVFrame
+	if _, err = in.Overlay(b); err != nil {
+		t.Fatal(err)
+	}
+	if in.Publisher != out.Publisher || in.CycleIndex != 7 || len(in.Variables) != 2 {
+		t.Fatalf("decoded unexpected frame %s", in.MultilineSummary())
+	}
+
+	nv, ok := in.Variable(2)
+	if !ok || nv.Hash != 0xcafe || nv.Quality != 1 || !bytes.Equal(nv.Data, []byte{0xaa}) {
+		t.Fatalf("unexpected variable 2: %+v", nv)
+	}
+	if _, ok = in.Variable(3); ok {
+		t.Fatalf("found variable that was not published")
+	}
+
+	var tr NVFrame
+	if _, err = tr.Overlay(b[:len(b)-1]); err == nil {
+		t.Fatalf("overlaying truncated frame did not fail")
+	}
+}
+
+func TestNVFrameRejectsCommandFrame(t *testing.T) {
+	fr, err := PointFrameTo(make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.Header.SetType(FrameTypeCommand)
+	if _, err = fr.NewDatagram(2); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fr.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nvf NVFrame
+	if _, err = nvf.Overlay(b); err == nil {
+		t.Fatalf("command frame overlaid as network variable frame")
+	}
+}
+
+func TestMailboxFrame(t *testing.T) {
+	b := []byte{
+		0x0a, 0x50, // length 10, mailbox gateway
+		0x02, 0x00, 0x01, 0x10, 0x40, 0x23, // mailbox header: 2 bytes, station 0x1001, prio 1, counter 2, CoE
+		0xab, 0xcd,
+		0x00, 0x00, // padding
+	}
+
+	var mf MailboxFrame
+	if _, err := mf.Overlay(b); err != nil {
+		t.Fatal(err)
+	}
+	mh := mf.Mailbox
+	if mh.Length != 2 || mh.Address != 0x1001 || mh.Priority != 1 || mh.Counter != 2 || mh.Type != 3 {
+		t.Fatalf("unexpected mailbox header %+v", mh)
+	}
+	if !bytes.Equal(mf.Data, []byte{0xab, 0xcd}) {
+		t.Fatalf("unexpected mailbox data % x", mf.Data)
+	}
+
+	enc := make([]byte, MailboxHeaderLen)
+	if _, err := mh.Encode(enc); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(enc, b[2:2+MailboxHeaderLen]) {
+		t.Fatalf("mailbox header encoded to % x, want % x", enc, b[2:2+MailboxHeaderLen])
+	}
+}
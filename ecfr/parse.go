@@ -0,0 +1,183 @@
+package ecfr
+
+import (
+	"fmt"
+)
+
+// ParseErrorKind classifies malformed frames.
+type ParseErrorKind int
+
+const (
+	// not even the frame or a datagram header fits
+	TruncatedHeader ParseErrorKind = iota + 1
+	// the frame header claims more bytes than there are
+	TruncatedFrame
+	// a datagram exceeds the frame length
+	TruncatedDatagram
+	// the datagrams do not add up to the frame length
+	LengthMismatch
+	ReservedBits
+	InvalidCommand
+	TooManyDatagrams
+	WrongFrameType
+)
+
+func (k ParseErrorKind) String() string {
+	switch k {
+	case TruncatedHeader:
+		return "truncated header"
+	case TruncatedFrame:
+		return "truncated frame"
+	case TruncatedDatagram:
+		return "truncated datagram"
+	case LengthMismatch:
+		return "length mismatch"
+	case ReservedBits:
+		return "reserved bits set"
+	case InvalidCommand:
+		return "invalid command"
+	case TooManyDatagrams:
+		return "too many datagrams"
+	case WrongFrameType:
+		return "wrong frame type"
+	}
+	return fmt.Sprintf("ParseErrorKind(%d)", int(k))
+}
+
+// ParseError describes why a frame could not be overlaid.
+type ParseError struct {
+	Kind ParseErrorKind
+	// index of the offending datagram, -1 for the frame as a whole
+	Datagram int
+	// byte offset of the offending header in the frame
+	Offset int
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	if e.Datagram < 0 {
+		return fmt.Sprintf("%v: %s", e.Kind, e.Reason)
+	}
+	return fmt.Sprintf("%v in datagram %d at offset %d: %s", e.Kind, e.Datagram, e.Offset, e.Reason)
+}
+
+// IsParseError tells whether err is a ParseError of the given kind.
+func IsParseError(err error, kind ParseErrorKind) bool {
+	pe, ok := err.(*ParseError)
+	return ok && pe.Kind == kind
+}
+
+const (
+	// a frame of maximum length full of datagrams without data
+	DefaultMaxDatagrams = ((1 << 11) - 1) / DatagramOverheadLength
+
+	headerReservedMask   = 1 << 11
+	datagramReservedMask = 0x7 << 11
+	frameLengthMask      = (1 << 11) - 1
+)
+
+// ParseOptions are the limits of OverlayStrict.
+type ParseOptions struct {
+	// 0 for DefaultMaxDatagrams
+	MaxDatagrams int
+}
+
+func (o ParseOptions) maxDatagrams() int {
+	if o.MaxDatagrams <= 0 {
+		return DefaultMaxDatagrams
+	}
+	return o.MaxDatagrams
+}
+
+// OverlayStrict is Overlay for frames that cannot be trusted. in addition,
+// the frame has to be an EtherCAT command frame, the datagrams have to fill
+// the frame length exactly, reserved bits have to be clear and commands
+// valid. errors are of type *ParseError.
+func (f *Frame) OverlayStrict(d []byte, opts ParseOptions) (b []byte, err error) {
+	return f.overlay(d, &opts)
+}
+
+func parseError(kind ParseErrorKind, dg, offs int, format string, args ...interface{}) error {
+	return &ParseError{Kind: kind, Datagram: dg, Offset: offs, Reason: fmt.Sprintf(format, args...)}
+}
+
+// overlays d, strict checks are skipped if strict is nil. returns the bytes
+// following the frame.
+func (f *Frame) overlay(d []byte, strict *ParseOptions) (b []byte, err error) {
+	f.Datagrams = f.Datagrams[:0]
+	if len(d) < FrameOverheadLen {
+		err = parseError(TruncatedHeader, -1, 0, "need %d bytes for frame header, have %d", FrameOverheadLen, len(d))
+		return
+	}
+	b, _ = f.Header.Overlay(d)
+
+	fl := int(f.Header.FrameLength())
+	if fl > len(b) {
+		err = parseError(TruncatedFrame, -1, 0, "frame expected %d bytes, only have %d", fl, len(b))
+		return
+	}
+	body := b[:fl]
+	b = b[fl:]
+
+	if strict != nil {
+		if t := f.Header.Type(); t != FrameTypeCommand {
+			err = parseError(WrongFrameType, -1, 0, "frame type is %v", t)
+			return
+		}
+		if f.Header.Word&headerReservedMask != 0 {
+			err = parseError(ReservedBits, -1, 0, "frame header %#04x", f.Header.Word)
+			return
+		}
+	}
+
+	offs := FrameOverheadLen
+	for i := 0; ; i++ {
+		if strict != nil && i >= strict.maxDatagrams() {
+			err = parseError(TooManyDatagrams, i, offs, "more than %d datagrams", strict.maxDatagrams())
+			return
+		}
+		if len(body) == 0 {
+			err = parseError(LengthMismatch, i, offs, "frame length ends before the last datagram")
+			return
+		}
+		if len(body) < datagramHeaderLength {
+			err = parseError(TruncatedHeader, i, offs, "need %d bytes for datagram header, have %d", datagramHeaderLength, len(body))
+			return
+		}
+
+		dg := f.nextDatagram()
+		rest, _ := dg.DatagramHeader.Overlay(body)
+		dl := int(dg.DataLength())
+		if dl+datagramFooterLength > len(rest) {
+			err = parseError(TruncatedDatagram, i, offs, "need %d bytes of data and working counter, have %d", dl+datagramFooterLength, len(rest))
+			return
+		}
+
+		if strict != nil {
+			if _, ok := commandTypeName[dg.Command]; !ok {
+				err = parseError(InvalidCommand, i, offs, "%v", dg.Command)
+				return
+			}
+			if dg.LenWord&datagramReservedMask != 0 {
+				err = parseError(ReservedBits, i, offs, "length word %#04x", dg.LenWord)
+				return
+			}
+		}
+
+		// cannot fail, the lengths are checked
+		body, _ = dg.Overlay(body)
+		offs += DatagramOverheadLength + dl
+
+		if dg.Last() {
+			break
+		}
+	}
+
+	if strict != nil && len(body) != 0 {
+		err = parseError(LengthMismatch, -1, offs, "%d bytes follow the last datagram within the frame length", len(body))
+		return
+	}
+
+	f.buffer = d
+	return
+}
@@ -0,0 +1,118 @@
+package ecfr
+
+import (
+	"testing"
+)
+
+// a committed command frame with two datagrams of 2 bytes of data
+func makeParseTestFrame(t *testing.T) []byte {
+	fr, err := PointFrameTo(make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.Header.SetType(FrameTypeCommand)
+	for i := 0; i < 2; i++ {
+		dg, err := fr.NewDatagram(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dg.Command = FPRD
+		dg.Index = uint8(i)
+		dg.SetLast(i == 1)
+	}
+	b, err := fr.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// room for padding behind the frame
+	return append(b, 0, 0, 0, 0)[:len(b)]
+}
+
+func TestOverlayStrict(t *testing.T) {
+	var fr Frame
+	b := makeParseTestFrame(t)
+	if _, err := fr.OverlayStrict(b, ParseOptions{}); err != nil {
+		t.Fatalf("well formed frame rejected: %v", err)
+	}
+	if len(fr.Datagrams) != 2 {
+		t.Fatalf("want 2 datagrams, have %d", len(fr.Datagrams))
+	}
+
+	second := FrameOverheadLen + DatagramOverheadLength + 2
+	tests := []struct {
+		name   string
+		mangle func(b []byte) []byte
+		kind   ParseErrorKind
+		dg     int
+		// also rejected by Overlay
+		lenient bool
+	}{
+		{"empty", func(b []byte) []byte { return b[:1] }, TruncatedHeader, -1, true},
+		{"frame cut", func(b []byte) []byte { return b[:len(b)-1] }, TruncatedFrame, -1, true},
+		{"datagram exceeds frame", func(b []byte) []byte {
+			b[second+6] = 3
+			return b
+		}, TruncatedDatagram, 1, true},
+		{"last datagram not marked", func(b []byte) []byte {
+			b[second+7] |= 0x80
+			return b
+		}, LengthMismatch, 2, true},
+		{"bytes after last datagram", func(b []byte) []byte {
+			b[0] += 2
+			return b[:len(b)+2]
+		}, LengthMismatch, -1, false},
+		{"frame header reserved", func(b []byte) []byte {
+			b[1] |= 0x08
+			return b
+		}, ReservedBits, -1, false},
+		{"datagram reserved", func(b []byte) []byte {
+			b[second+7] |= 0x08
+			return b
+		}, ReservedBits, 1, false},
+		{"invalid command", func(b []byte) []byte {
+			b[FrameOverheadLen] = 0x42
+			return b
+		}, InvalidCommand, 0, false},
+		{"network variables", func(b []byte) []byte {
+			b[1] = b[1]&0x0f | uint8(FrameTypeNetworkVariables)<<4
+			return b
+		}, WrongFrameType, -1, false},
+	}
+
+	for _, tc := range tests {
+		b := tc.mangle(makeParseTestFrame(t))
+
+		_, err := fr.OverlayStrict(b, ParseOptions{})
+		pe, ok := err.(*ParseError)
+		if !ok || pe.Kind != tc.kind || pe.Datagram != tc.dg {
+			t.Errorf("%s: want %v in datagram %d, got %v", tc.name, tc.kind, tc.dg, err)
+			continue
+		}
+
+		_, err = fr.Overlay(b)
+		if tc.lenient != (err != nil) {
+			t.Errorf("%s: Overlay returned %v", tc.name, err)
+		}
+		if tc.lenient && !IsParseError(err, tc.kind) {
+			t.Errorf("%s: Overlay returned %v, want %v", tc.name, err, tc.kind)
+		}
+	}
+
+	_, err := fr.OverlayStrict(makeParseTestFrame(t), ParseOptions{MaxDatagrams: 1})
+	if !IsParseError(err, TooManyDatagrams) {
+		t.Fatalf("want too many datagrams, got %v", err)
+	}
+}
+
+func TestOverlayReusesFrame(t *testing.T) {
+	var fr Frame
+	b := makeParseTestFrame(t)
+	for i := 0; i < 3; i++ {
+		if _, err := fr.Overlay(b); err != nil {
+			t.Fatal(err)
+		}
+		if len(fr.Datagrams) != 2 {
+			t.Fatalf("overlay %d: want 2 datagrams, have %d", i, len(fr.Datagrams))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package ecfr
+
+// FramePool hands out frames with buffers of a fixed length and takes them
+// back for reuse, so that steady state cycling does not allocate. it is not
+// safe for concurrent use.
+type FramePool struct {
+	buflen int
+	free   []*Frame
+}
+
+func NewFramePool(buflen int) *FramePool {
+	if buflen < FrameOverheadLen {
+		buflen = FrameOverheadLen
+	}
+	return &FramePool{buflen: buflen}
+}
+
+// Get returns a frame pointing to a zeroed buffer of the pool's length.
+func (p *FramePool) Get() *Frame {
+	var f *Frame
+	if n := len(p.free); n > 0 {
+		f = p.free[n-1]
+		p.free = p.free[:n-1]
+	} else {
+		f = &Frame{buffer: make([]byte, p.buflen)}
+	}
+
+	// overlaying may have shortened the buffer
+	buf := f.Buffer()
+	for i := range buf {
+		buf[i] = 0
+	}
+	// cannot fail, the buffer holds at least a header
+	f.Reset(buf)
+	return f
+}
+
+// Put returns frames to the pool. neither the frames nor their datagrams
+// may be used afterwards.
+func (p *FramePool) Put(frames ...*Frame) {
+	p.free = append(p.free, frames...)
+}
@@ -0,0 +1,46 @@
+package ecfr
+
+import (
+	"testing"
+)
+
+func TestFramePoolReuse(t *testing.T) {
+	p := NewFramePool(64)
+	in := new(Frame)
+
+	cycle := func() {
+		fr := p.Get()
+		if len(fr.Datagrams) != 0 {
+			t.Fatalf("frame from pool has %d datagrams", len(fr.Datagrams))
+		}
+		for i := 0; i < 3; i++ {
+			dg, err := fr.NewDatagram(4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dg.Data()[0] != 0 {
+				t.Fatalf("frame from pool is not cleared")
+			}
+			dg.Command = APRD
+			dg.Data()[0] = 0xff
+			dg.SetLast(i == 2)
+		}
+
+		b, err := fr.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = in.Overlay(b); err != nil {
+			t.Fatal(err)
+		}
+		if len(in.Datagrams) != 3 {
+			t.Fatalf("overlaid %d datagrams, want 3", len(in.Datagrams))
+		}
+		p.Put(fr)
+	}
+
+	cycle()
+	if allocs := testing.AllocsPerRun(10, cycle); allocs != 0 {
+		t.Fatalf("want no allocations reusing frames, have %v", allocs)
+	}
+}
@@ -0,0 +1,167 @@
+package ecmd
+
+import (
+	"context"
+	"github.com/distributed/ecat/ecfr"
+	"time"
+)
+
+// BatchOp is a read or write of a Batch. its results are valid once the
+// batch was executed.
+type BatchOp struct {
+	Addr    ecfr.DatagramAddress
+	Command ecfr.CommandType
+	ExpWC   uint16
+
+	// data to write, or the data read
+	Data []byte
+	// working counter of the last attempt
+	WorkingCounter uint16
+	Err            error
+	Attempts       int
+
+	n          int
+	nFrameLoss int
+	done       bool
+}
+
+// Batch collects reads and writes and executes them together, pending
+// operations share cycles as far as the Commander takes them. operations
+// that fail are retried on their own, as ExecuteReadOptions and
+// ExecuteWriteOptions do.
+type Batch struct {
+	Options Options
+	Ops     []*BatchOp
+}
+
+func (b *Batch) Read(addr ecfr.DatagramAddress, n int, expwc uint16) *BatchOp {
+	op := &BatchOp{Addr: addr, ExpWC: expwc, n: n}
+	op.Command, op.Err = readCommand(addr)
+	op.done = op.Err != nil
+	b.Ops = append(b.Ops, op)
+	return op
+}
+
+func (b *Batch) Write(addr ecfr.DatagramAddress, w []byte, expwc uint16) *BatchOp {
+	op := &BatchOp{Addr: addr, ExpWC: expwc, Data: w, n: len(w)}
+	op.Command, op.Err = writeCommand(addr)
+	op.done = op.Err != nil
+	b.Ops = append(b.Ops, op)
+	return op
+}
+
+// Err returns the error of the first failed operation.
+func (b *Batch) Err() error {
+	for _, op := range b.Ops {
+		if op.Err != nil {
+			return op.Err
+		}
+	}
+	return nil
+}
+
+func (b *Batch) Execute(c Commander) error {
+	return b.ExecuteContext(context.Background(), c)
+}
+
+// ExecuteContext executes all operations not done yet. errors of the
+// Commander and ctx abort the batch and are returned, errors of single
+// operations are stored in the operations, see Err.
+func (b *Batch) ExecuteContext(ctx context.Context, c Commander) (err error) {
+	type placed struct {
+		op *BatchOp
+		ec *ExecutingCommand
+	}
+
+	for round := 0; ; round++ {
+		var pending []placed
+		for _, op := range b.Ops {
+			if !op.done {
+				pending = append(pending, placed{op: op})
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+
+		if round > 0 {
+			err = b.Options.wait(ctx, round)
+			if err != nil {
+				return
+			}
+		}
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+
+		// a cycle only takes so many commands, the pending operations are
+		// spread over as many cycles as they need
+		for len(pending) > 0 {
+			n := 0
+			for ; n < len(pending); n++ {
+				op := pending[n].op
+				var ec *ExecutingCommand
+				ec, err = c.New(op.n)
+				if err == CycleFull && n > 0 {
+					err = nil
+					break
+				}
+				if err != nil {
+					if n > 0 {
+						discard(c)
+					}
+					return
+				}
+
+				dgo := ec.DatagramOut
+				dgo.Command = op.Command
+				dgo.Addr32 = op.Addr.Addr32()
+				if op.Command.DoesWrite() {
+					copy(dgo.Data(), op.Data)
+				}
+
+				op.Attempts++
+				pending[n].ec = ec
+			}
+
+			err = cycleContext(ctx, c)
+			if err != nil {
+				return
+			}
+
+			now := time.Now()
+			for _, p := range pending[:n] {
+				p.op.complete(p.ec, b.Options, now)
+				reportWorkingCounterError(c, p.op.Err)
+			}
+			pending = pending[n:]
+		}
+	}
+}
+
+// evaluates an attempt, op is done unless it is to be retried.
+func (op *BatchOp) complete(ec *ExecutingCommand, opts Options, now time.Time) {
+	op.Err = ChooseDefaultError(ec)
+	if op.Err != nil {
+		if isLoss(op.Err) {
+			op.nFrameLoss++
+			if op.nFrameLoss < opts.getFramelossTries() {
+				return
+			}
+		}
+		op.done = true
+		return
+	}
+
+	op.WorkingCounter = ec.DatagramIn.WorkingCounter
+	if op.Command.DoesRead() {
+		op.Data = append(op.Data[:0], ec.DatagramIn.Data()...)
+	}
+
+	op.Err = ChooseWorkingCounterError(ec, op.ExpWC)
+	if op.Err != nil && now.Before(opts.getWCDeadline()) {
+		return
+	}
+	op.done = true
+}
@@ -0,0 +1,119 @@
+package ecmd_test
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+	"time"
+)
+
+func newBatchTestBus(nslaves int, policy sim.FaultPolicy) (*sim.FaultFramer, ecmd.Commander) {
+	bus := &sim.L2Bus{}
+	for i := 0; i < nslaves; i++ {
+		bus.Slaves = append(bus.Slaves, sim.NewL2Slave())
+	}
+	ff := sim.NewFaultFramer(bus, policy, 1)
+	return ff, ecmd.NewCommandFramer(ff)
+}
+
+func TestBatchSingleCycle(t *testing.T) {
+	ff, c := newBatchTestBus(3, sim.FaultScript{})
+
+	var b ecmd.Batch
+	var reads []*ecmd.BatchOp
+	for i := 0; i < 3; i++ {
+		reads = append(reads, b.Read(ecfr.PositionalAddr(int16(-i), ecad.Type), 1, 1))
+	}
+	write := b.Write(ecfr.PositionalAddr(-1, ecad.ConfiguredStationAddress), []byte{0x01, 0x10}, 1)
+
+	if err := b.Execute(c); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if err := b.Err(); err != nil {
+		t.Fatalf("batch operation failed: %v", err)
+	}
+
+	if ff.Stats.Frames != 1 {
+		t.Fatalf("want all operations in 1 frame, have %d", ff.Stats.Frames)
+	}
+	for i, op := range reads {
+		if len(op.Data) != 1 || op.Attempts != 1 {
+			t.Fatalf("read %d: unexpected result %+v", i, op)
+		}
+	}
+	if write.Attempts != 1 || write.WorkingCounter != 1 {
+		t.Fatalf("unexpected write result %+v", write)
+	}
+
+	addr, err := ecmd.ExecuteRead16(c, ecfr.FixedAddr(0x1001, ecad.ConfiguredStationAddress), 1)
+	if err != nil || addr != 0x1001 {
+		t.Fatalf("station address was not written, have %#04x, err %v", addr, err)
+	}
+}
+
+func TestBatchRetriesFailedSubset(t *testing.T) {
+	ff, c := newBatchTestBus(2, sim.FaultScript{0: {Drop: true}})
+
+	b := ecmd.Batch{Options: ecmd.Options{WCDeadline: time.Now().Add(20 * time.Millisecond)}}
+	good := b.Read(ecfr.PositionalAddr(0, ecad.Type), 1, 1)
+	missing := b.Read(ecfr.PositionalAddr(-5, ecad.Type), 1, 1)
+
+	if err := b.Execute(c); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// first attempt lost, second one succeeded
+	if good.Err != nil || good.Attempts != 2 {
+		t.Fatalf("unexpected result for present slave %+v", good)
+	}
+
+	if !ecmd.IsWorkingCounterError(missing.Err) || missing.Attempts <= 2 {
+		t.Fatalf("unexpected result for missing slave %+v", missing)
+	}
+	if b.Err() != missing.Err {
+		t.Fatalf("Err should return the failed operation's error")
+	}
+
+	// later cycles only carry the failed read
+	if ff.Stats.Frames != missing.Attempts {
+		t.Fatalf("want %d frames, have %d", missing.Attempts, ff.Stats.Frames)
+	}
+}
+
+func TestBatchSpreadsOverCycles(t *testing.T) {
+	_, c := newBatchTestBus(1, sim.FaultScript{})
+	cf := c.(*ecmd.CommandFramer)
+	var cycles []ecmd.CycleStats
+	cf.Collector = collectorFunc(func(s ecmd.CycleStats) { cycles = append(cycles, s) })
+
+	// more operations than datagram indices in a cycle
+	var b ecmd.Batch
+	for i := 0; i < 500; i++ {
+		b.Read(ecfr.PositionalAddr(0, ecad.Type), 1, 1)
+	}
+	if err := b.Execute(c); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if err := b.Err(); err != nil {
+		t.Fatalf("batch operation failed: %v", err)
+	}
+	for i, op := range b.Ops {
+		if op.Attempts != 1 {
+			t.Fatalf("op %d: unexpected result %+v", i, op)
+		}
+	}
+	if len(cycles) != 2 || cycles[0].Commands != 256 || cycles[1].Commands != 244 {
+		t.Fatalf("want the batch in 2 cycles, have %+v", cycles)
+	}
+
+	if _, err := ecmd.ExecuteRead8(c, ecfr.PositionalAddr(0, ecad.Type), 1); err != nil {
+		t.Fatalf("commander unusable after batch: %v", err)
+	}
+}
+
+type collectorFunc func(s ecmd.CycleStats)
+
+func (f collectorFunc) CycleDone(s ecmd.CycleStats)                    { f(s) }
+func (f collectorFunc) WorkingCounterError(e ecmd.WorkingCounterError) {}
@@ -2,7 +2,9 @@ package ecmd
 
 import (
 	"errors"
+	"fmt"
 	"github.com/distributed/ecat/ecfr"
+	"time"
 )
 
 const (
@@ -11,20 +13,96 @@ const (
 
 type outgoingFrame struct {
 	frame *ecfr.Frame
-	cmds  []*ExecutingCommand
 }
 
+// CommandFramerStats counts incoming datagrams that could not be matched to
+// a command of the current cycle.
+type CommandFramerStats struct {
+	// datagrams of a command that arrived already
+	Duplicate int
+	// datagrams with an index not used in the current cycle, left over from
+	// earlier cycles
+	Stale int
+	// datagrams that differ from the command with their index
+	Mismatch int
+}
+
+// CycleStats describes a cycle of a CommandFramer.
+type CycleStats struct {
+	Start    time.Time
+	Duration time.Duration
+
+	FramesOut int
+	FramesIn  int
+	Commands  int
+	// commands that did not arrive
+	Lost int
+	// incoming datagrams not matching a command, see CommandFramerStats
+	Unmatched int
+}
+
+// Collector receives the statistics of a CommandFramer. working counter
+// errors are reported by the Execute functions and Batch when they check
+// the working counter of a command. see package ecstat for an
+// implementation.
+type Collector interface {
+	CycleDone(s CycleStats)
+	WorkingCounterError(e WorkingCounterError)
+}
+
+// implemented by commanders passing working counter errors to a Collector
+type wcReporter interface {
+	reportWorkingCounterError(e WorkingCounterError)
+}
+
+func reportWorkingCounterError(c Commander, err error) {
+	wce, ok := err.(WorkingCounterError)
+	if !ok {
+		return
+	}
+	if r, ok := c.(wcReporter); ok {
+		r.reportWorkingCounterError(wce)
+	}
+}
+
+// implemented by commanders that can drop the commands staged since the
+// last cycle
+type discarder interface {
+	Discard()
+}
+
+func discard(c Commander) {
+	if d, ok := c.(discarder); ok {
+		d.Discard()
+	}
+}
+
+// CommandFramer packs commands into frames of its Framer. commands are
+// reused: an ExecutingCommand and its datagrams are valid until the first
+// New after the Cycle that executed it.
 type CommandFramer struct {
+	// every datagram of a cycle gets an index of its own
 	currentIndex uint8
+	outstanding  [256]*ExecutingCommand
+
+	// commands of the current cycle, cmds[:ncmds] are in use
+	cmds  []*ExecutingCommand
+	ncmds int
+
+	Stats CommandFramerStats
+	// optional
+	Collector Collector
 
 	frameOpen          bool
 	currentFrame       *ecfr.Frame
 	currentFrameLen    uint16
 	currentFrameOffset uint16
 	currentDgram       *ecfr.Datagram
-	currentCmds        []*ExecutingCommand
 
 	frameQueue []outgoingFrame
+	// frames of a discarded cycle, taken from framer already and sent with
+	// the next cycle
+	spare []*ecfr.Frame
 
 	inFrameQueue []*ecfr.Frame
 
@@ -35,6 +113,11 @@ func NewCommandFramer(framer Framer) *CommandFramer {
 	return &CommandFramer{framer: framer}
 }
 
+// CycleFull is returned by New if all datagram indices of the cycle are in
+// use. the commands staged so far stay valid, Cycle executes them and
+// Discard drops them.
+var CycleFull = errors.New("all datagram indices of this cycle are in use")
+
 func (cf *CommandFramer) New(datalen int) (*ExecutingCommand, error) {
 	var err error
 
@@ -43,6 +126,11 @@ func (cf *CommandFramer) New(datalen int) (*ExecutingCommand, error) {
 		return nil, errors.New("datalen exceeds maximum datagram length")
 	}
 
+	// every spare frame keeps an index to carry a NOP if it is not reused
+	if cf.ncmds+len(cf.spare) > 0xff {
+		return nil, CycleFull
+	}
+
 	if cf.frameOpen {
 		if dbgl > int(cf.currentFrameLen-cf.currentFrameOffset) {
 			cf.finishFrame()
@@ -66,32 +154,41 @@ func (cf *CommandFramer) New(datalen int) (*ExecutingCommand, error) {
 		return nil, err
 	}
 	cf.currentDgram = dg
+	dg.Index = cf.currentIndex
 
 	cf.currentFrameOffset += uint16(dbgl)
 
-	cmd := &ExecutingCommand{
-		DatagramOut: dg,
-	}
-	cf.currentCmds = append(cf.currentCmds, cmd)
+	cmd := cf.nextCommand()
+	cmd.DatagramOut = dg
+	cf.outstanding[cf.currentIndex] = cmd
+	cf.currentIndex++
 	return cmd, nil
 }
 
+// returns a cleared command, reusing one of an earlier cycle if possible
+func (cf *CommandFramer) nextCommand() *ExecutingCommand {
+	if cf.ncmds == len(cf.cmds) {
+		cf.cmds = append(cf.cmds, &ExecutingCommand{})
+	}
+	cmd := cf.cmds[cf.ncmds]
+	cf.ncmds++
+	*cmd = ExecutingCommand{}
+	return cmd
+}
+
 func (cf *CommandFramer) finishFrame() {
 	if len(cf.currentFrame.Datagrams) > 0 {
 		for i := 0; i < len(cf.currentFrame.Datagrams)-1; i++ {
 			cf.currentFrame.Datagrams[i].SetLast(false)
 		}
-		cf.currentFrame.Datagrams[0].Index = cf.currentIndex
 		cf.currentFrame.Datagrams[len(cf.currentFrame.Datagrams)-1].SetLast(true)
-		cf.frameQueue = append(cf.frameQueue, outgoingFrame{cf.currentFrame, cf.currentCmds})
+		cf.frameQueue = append(cf.frameQueue, outgoingFrame{cf.currentFrame})
 	}
 
 	cf.frameOpen = false
 	cf.currentFrame = nil
 	cf.currentFrameLen = 0
 	cf.currentFrameOffset = 0xffff
-	cf.currentCmds = nil
-	cf.currentIndex++
 }
 
 func (cf *CommandFramer) newFrame() error {
@@ -107,24 +204,64 @@ func (cf *CommandFramer) newFrame() error {
 	if err != nil {
 		return err
 	}*/
-	frame, err = cf.framer.New(CommandFramerMaxDatagramsLen)
-	if err != nil {
-		return err
+	if n := len(cf.spare); n > 0 {
+		frame = cf.spare[n-1]
+		cf.spare = cf.spare[:n-1]
+	} else {
+		frame, err = cf.framer.New(CommandFramerMaxDatagramsLen)
+		if err != nil {
+			return err
+		}
 	}
 
 	cf.currentFrame = frame
 	cf.currentDgram = nil
-	cf.currentCmds = nil
 	cf.frameOpen = true
 	cf.currentFrameLen = CommandFramerMaxDatagramsLen
 	cf.currentFrameOffset = 0
 	return nil
 }
 
+// Discard drops the commands staged since the last Cycle and releases
+// their indices. the frames they were packed into are reused by the next
+// cycle.
+func (cf *CommandFramer) Discard() {
+	n := len(cf.spare)
+	for _, of := range cf.frameQueue {
+		cf.spare = append(cf.spare, of.frame)
+	}
+	if cf.frameOpen {
+		cf.spare = append(cf.spare, cf.currentFrame)
+		cf.frameOpen = false
+		cf.currentFrame = nil
+		cf.currentDgram = nil
+		cf.currentFrameLen = 0
+		cf.currentFrameOffset = 0xffff
+	}
+	for _, fr := range cf.spare[n:] {
+		fr.Reset(fr.Buffer())
+		fr.Header.SetType(ecfr.FrameTypeCommand)
+	}
+	cf.endCycle()
+}
+
 func (cf *CommandFramer) Cycle() error {
 	if cf.currentFrame != nil && len(cf.currentFrame.Datagrams) > 0 {
 		cf.finishFrame()
 	}
+	defer cf.endCycle()
+
+	// the framer sends all frames it handed out, spares not reused carry a
+	// NOP.
+	for len(cf.spare) > 0 {
+		if err := cf.newFrame(); err != nil {
+			return err
+		}
+		if _, err := cf.New(0); err != nil {
+			return err
+		}
+		cf.finishFrame()
+	}
 
 	/*for i, of := range cf.frameQueue {
 		fr := of.frame
@@ -146,6 +283,9 @@ func (cf *CommandFramer) Cycle() error {
 		fmt.Println()
 	}*/
 
+	start := time.Now()
+	before := cf.Stats
+
 	var err error
 	cf.inFrameQueue, err = cf.framer.Cycle()
 	if err != nil {
@@ -171,60 +311,105 @@ func (cf *CommandFramer) Cycle() error {
 	fmt.Println()*/
 	//}
 
-	oi := 0
 	for _, infr := range cf.inFrameQueue {
-		if oi == len(cf.frameQueue) {
-			// no more outgoing frames to scan
-			break
+		for _, indgram := range infr.Datagrams {
+			cf.match(indgram)
 		}
+	}
 
-		for i := oi; i < len(cf.frameQueue); i++ {
-			// is this outgoing frame a match for the incoming frame?
-			ofr := cf.frameQueue[i].frame
-			if infr.Header.FrameLength() != ofr.Header.FrameLength() {
-				continue
+	if cf.Collector != nil {
+		cs := CycleStats{
+			Start:     start,
+			Duration:  time.Since(start),
+			FramesOut: len(cf.frameQueue),
+			FramesIn:  len(cf.inFrameQueue),
+			Commands:  cf.ncmds,
+			Unmatched: cf.Stats.Duplicate - before.Duplicate +
+				cf.Stats.Stale - before.Stale +
+				cf.Stats.Mismatch - before.Mismatch,
+		}
+		for _, cmd := range cf.cmds[:cf.ncmds] {
+			if !cmd.Arrived {
+				cs.Lost++
 			}
+		}
+		cf.Collector.CycleDone(cs)
+	}
 
-			if len(infr.Datagrams) == 0 || len(ofr.Datagrams) == 0 {
-				continue
-			}
+	return nil
+}
 
-			if len(infr.Datagrams) != len(ofr.Datagrams) {
-				continue
-			}
+func (cf *CommandFramer) reportWorkingCounterError(e WorkingCounterError) {
+	if cf.Collector != nil {
+		cf.Collector.WorkingCounterError(e)
+	}
+}
 
-			if infr.Datagrams[0].Index != ofr.Datagrams[0].Index {
-				continue
-			}
+func (cf *CommandFramer) endCycle() {
+	for _, cmd := range cf.cmds[:cf.ncmds] {
+		cf.outstanding[cmd.DatagramOut.Index] = nil
+	}
+	cf.ncmds = 0
+	cf.frameQueue = cf.frameQueue[:0]
+	cf.inFrameQueue = nil
+}
 
-			// TODO: more criteria
-			for j, ocmd := range cf.frameQueue[i].cmds {
-				odgram := ocmd.DatagramOut
-				indgram := infr.Datagrams[j]
+// matches an incoming datagram to the command with its index
+func (cf *CommandFramer) match(indgram *ecfr.Datagram) {
+	cmd := cf.outstanding[indgram.Index]
+	if cmd == nil {
+		cf.Stats.Stale++
+		return
+	}
 
-				if odgram.Command != indgram.Command {
-					continue
-				}
+	if cmd.Arrived {
+		cf.Stats.Duplicate++
+		return
+	}
 
-				if odgram.DataLength() != indgram.DataLength() {
-					continue
-				}
+	if reason := mismatch(cmd.DatagramOut, indgram); reason != "" {
+		// a matching datagram may still follow, until then the mismatch is
+		// the reason for the command not to arrive.
+		cf.Stats.Mismatch++
+		cmd.Error = MismatchError{
+			Command: cmd.DatagramOut.Command,
+			Addr32:  cmd.DatagramOut.Addr32,
+			Reason:  reason,
+		}
+		return
+	}
 
-				ocmd.DatagramIn = indgram
-				ocmd.Arrived = true
-				ocmd.Overlayed = true
-				ocmd.Error = nil
-			}
+	cmd.DatagramIn = indgram
+	cmd.Arrived = true
+	cmd.Overlayed = true
+	cmd.Error = nil
+}
 
-			// update search start index
-			oi = i
-		}
+// describes how an incoming datagram differs from the outgoing one, returns
+// "" if it is the response to it.
+func mismatch(out, in *ecfr.Datagram) string {
+	if out.Command != in.Command {
+		return fmt.Sprintf("command %v came back as %v", out.Command, in.Command)
 	}
 
-	cf.frameQueue = nil
-	cf.inFrameQueue = nil
+	if out.DataLength() != in.DataLength() {
+		return fmt.Sprintf("data length %d came back as %d", out.DataLength(), in.DataLength())
+	}
 
-	return nil
+	// slaves increment the position part of positional and broadcast
+	// addresses
+	switch ecfr.DatagramAddressFromCommand(out.Addr32, out.Command).Type() {
+	case ecfr.Positional, ecfr.Broadcast:
+		if out.OffsetAddr() != in.OffsetAddr() {
+			return fmt.Sprintf("offset %#04x came back as %#04x", out.OffsetAddr(), in.OffsetAddr())
+		}
+	default:
+		if out.Addr32 != in.Addr32 {
+			return fmt.Sprintf("address %#08x came back as %#08x", out.Addr32, in.Addr32)
+		}
+	}
+
+	return ""
 }
 
 func (cf *CommandFramer) Close() error {
@@ -27,11 +27,11 @@ func TestCommandFramerScheduling(t *testing.T) {
 				[]*ecfr.Datagram{makeLenDgram(CommandFramerMaxDatagramsLen-ecfr.DatagramOverheadLength, 1, true)}}}},
 		cfSchedulingPairs{[]int{128, 96}, expectedCFScheduling{
 			[][]*ecfr.Datagram{
-				[]*ecfr.Datagram{makeLenDgram(128, 0, false), makeLenDgram(96, 0, true)}}}},
+				[]*ecfr.Datagram{makeLenDgram(128, 0, false), makeLenDgram(96, 1, true)}}}},
 		cfSchedulingPairs{[]int{140, 65, 1400}, expectedCFScheduling{
 			[][]*ecfr.Datagram{
-				[]*ecfr.Datagram{makeLenDgram(140, 0, false), makeLenDgram(65, 0, true)},
-				[]*ecfr.Datagram{makeLenDgram(1400, 1, true)}}}},
+				[]*ecfr.Datagram{makeLenDgram(140, 0, false), makeLenDgram(65, 1, true)},
+				[]*ecfr.Datagram{makeLenDgram(1400, 2, true)}}}},
 	}
 
 	for i, pair := range pairs {
@@ -116,3 +116,170 @@ func makeLenDgram(plen int, index uint8, last bool) *ecfr.Datagram {
 
 	return &dgram
 }
+
+// returns the frames of the cycle, after passing them through mangle
+type mangleFramer struct {
+	frames []*ecfr.Frame
+	mangle func([]*ecfr.Frame) []*ecfr.Frame
+}
+
+func (f *mangleFramer) New(maxdatalen int) (*ecfr.Frame, error) {
+	frame, err := ecfr.PointFrameTo(make([]byte, maxdatalen+ecfr.FrameOverheadLen))
+	if err != nil {
+		return nil, err
+	}
+	f.frames = append(f.frames, &frame)
+	return &frame, nil
+}
+
+func (f *mangleFramer) Cycle() ([]*ecfr.Frame, error) {
+	var frames []*ecfr.Frame
+	for _, fr := range f.frames {
+		frames = append(frames, copyTestFrame(fr))
+	}
+	f.frames = nil
+	return f.mangle(frames), nil
+}
+
+func copyTestFrame(fr *ecfr.Frame) *ecfr.Frame {
+	b, err := fr.Commit()
+	if err != nil {
+		panic(err)
+	}
+	cb := make([]byte, len(b))
+	copy(cb, b)
+	var cfr ecfr.Frame
+	if _, err = cfr.Overlay(cb); err != nil {
+		panic(err)
+	}
+	return &cfr
+}
+
+func TestCommandFramerMatching(t *testing.T) {
+	var stale *ecfr.Frame
+	f := &mangleFramer{}
+	cf := NewCommandFramer(f)
+
+	newCmd := func(ct ecfr.CommandType, addr ecfr.DatagramAddress) *ExecutingCommand {
+		ec, err := cf.New(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec.DatagramOut.Command = ct
+		ec.DatagramOut.Addr32 = addr.Addr32()
+		return ec
+	}
+
+	// first cycle: the slave increments the position of the APRD, the
+	// response is duplicated and kept for the next cycle
+	f.mangle = func(frames []*ecfr.Frame) []*ecfr.Frame {
+		frames[0].Datagrams[0].Addr32++
+		stale = copyTestFrame(frames[0])
+		return append(frames, copyTestFrame(frames[0]))
+	}
+	a := newCmd(ecfr.APRD, ecfr.PositionalAddr(0, 0x10))
+	b := newCmd(ecfr.FPRD, ecfr.FixedAddr(0x1001, 0x10))
+	if err := cf.Cycle(); err != nil {
+		t.Fatal(err)
+	}
+	if ChooseDefaultError(a) != nil || ChooseDefaultError(b) != nil {
+		t.Fatalf("commands should have arrived: %v, %v", ChooseDefaultError(a), ChooseDefaultError(b))
+	}
+	if cf.Stats.Duplicate != 2 {
+		t.Fatalf("want 2 duplicate datagrams, have %+v", cf.Stats)
+	}
+
+	// second cycle: the stale frame of the first cycle arrives in place of
+	// the current one. its indices are not in use anymore.
+	f.mangle = func(frames []*ecfr.Frame) []*ecfr.Frame {
+		return []*ecfr.Frame{stale}
+	}
+	c := newCmd(ecfr.BRD, ecfr.PositionalAddr(0, 0x10))
+	if err := cf.Cycle(); err != nil {
+		t.Fatal(err)
+	}
+	if !IsNoFrame(ChooseDefaultError(c)) {
+		t.Fatalf("want NoFrame for command answered by stale frame, have %v", ChooseDefaultError(c))
+	}
+	if cf.Stats.Stale != 2 {
+		t.Fatalf("want 2 stale datagrams, have %+v", cf.Stats)
+	}
+
+	// third cycle: a datagram comes back with a different address
+	f.mangle = func(frames []*ecfr.Frame) []*ecfr.Frame {
+		frames[0].Datagrams[0].Addr32++
+		return frames
+	}
+	d := newCmd(ecfr.FPWR, ecfr.FixedAddr(0x1001, 0x10))
+	if err := cf.Cycle(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ChooseDefaultError(d); !IsMismatch(err) {
+		t.Fatalf("want mismatch, have %v", err)
+	}
+	if cf.Stats.Mismatch != 1 {
+		t.Fatalf("want 1 mismatch, have %+v", cf.Stats)
+	}
+}
+
+func TestCommandFramerDiscard(t *testing.T) {
+	var out []*ecfr.Frame
+	f := &mangleFramer{mangle: func(frames []*ecfr.Frame) []*ecfr.Frame {
+		out = frames
+		return frames
+	}}
+	cf := NewCommandFramer(f)
+
+	for i := 0; i < 256; i++ {
+		if _, err := cf.New(200); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cf.New(2); err != CycleFull {
+		t.Fatalf("want CycleFull, have %v", err)
+	}
+	nframes := len(f.frames)
+
+	// the framer has handed out the frames of the discarded cycle already,
+	// they go out with the next one
+	cf.Discard()
+	ec, err := cf.New(2)
+	if err != nil {
+		t.Fatalf("New after Discard: %v", err)
+	}
+	ec.DatagramOut.Command = ecfr.BRD
+	if err = cf.Cycle(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ChooseDefaultError(ec); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != nframes {
+		t.Fatalf("want %d frames, have %d", nframes, len(out))
+	}
+	nops := 0
+	for _, fr := range out {
+		for _, dg := range fr.Datagrams {
+			if dg.Command == ecfr.NOP {
+				nops++
+			}
+		}
+	}
+	if nops != nframes-1 {
+		t.Fatalf("want a NOP in each unused frame, have %d", nops)
+	}
+	if cf.Stats != (CommandFramerStats{}) {
+		t.Fatalf("unmatched datagrams %+v", cf.Stats)
+	}
+
+	ec, err = cf.New(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cf.Cycle(); err != nil {
+		t.Fatal(err)
+	}
+	if ChooseDefaultError(ec) != nil || len(out) != 1 {
+		t.Fatalf("cycle after recovery: %v, %d frames", ChooseDefaultError(ec), len(out))
+	}
+}
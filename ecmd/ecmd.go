@@ -1,6 +1,7 @@
 package ecmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/distributed/ecat/ecfr"
@@ -38,8 +39,23 @@ func (e WorkingCounterError) Error() string {
 		e.Addr32)
 }
 
+// MismatchError is reported for a command if a datagram with its index
+// came back, but does not match the datagram sent.
+type MismatchError struct {
+	Command ecfr.CommandType
+	Addr32  uint32
+	Reason  string
+}
+
+func (e MismatchError) Error() string {
+	return fmt.Sprintf("response mismatch on %v %#08x: %s", e.Command, e.Addr32, e.Reason)
+}
+
 func ChooseDefaultError(cmd *ExecutingCommand) error {
 	if !cmd.Arrived {
+		if cmd.Error != nil {
+			return cmd.Error
+		}
 		return NoFrame
 	}
 
@@ -54,6 +70,16 @@ func IsNoFrame(err error) bool {
 	return err == NoFrame
 }
 
+func IsMismatch(err error) bool {
+	_, ok := err.(MismatchError)
+	return ok
+}
+
+// frame loss and mismatching responses are retried
+func isLoss(err error) bool {
+	return IsNoFrame(err) || IsMismatch(err)
+}
+
 func IsWorkingCounterError(err error) bool {
 	_, ok := err.(WorkingCounterError)
 	return ok
@@ -79,6 +105,8 @@ const (
 type Options struct {
 	FramelossTries int
 	WCDeadline     time.Time
+	// delay between attempts, nil retries right away
+	Backoff Backoff
 }
 
 func (o Options) getFramelossTries() int {
@@ -89,13 +117,76 @@ func (o Options) getFramelossTries() int {
 }
 func (o Options) getWCDeadline() time.Time { return o.WCDeadline }
 
+// waits before the next attempt, failed is the number of failed attempts.
+func (o Options) wait(ctx context.Context, failed int) error {
+	if o.Backoff == nil {
+		return nil
+	}
+	d := o.Backoff.Delay(failed)
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Backoff returns the delay before the next attempt after failed
+// unsuccessful attempts.
+type Backoff interface {
+	Delay(failed int) time.Duration
+}
+
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Delay(failed int) time.Duration { return time.Duration(b) }
+
+// ExponentialBackoff doubles the delay after every failed attempt, starting
+// at Initial and never exceeding Max if it is set.
+type ExponentialBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+func (b ExponentialBackoff) Delay(failed int) time.Duration {
+	d := b.Initial
+	for i := 1; i < failed; i++ {
+		d *= 2
+		if b.Max > 0 && d >= b.Max {
+			break
+		}
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// ContextCycler is implemented by Commanders whose Cycle can be aborted.
+type ContextCycler interface {
+	CycleContext(ctx context.Context) error
+}
+
+func cycleContext(ctx context.Context, c Commander) error {
+	if cc, ok := c.(ContextCycler); ok {
+		return cc.CycleContext(ctx)
+	}
+	return c.Cycle()
+}
+
 func ExecuteRead8(c Commander, addr ecfr.DatagramAddress, expwc uint16) (d uint8, err error) {
 	return ExecuteRead8Options(c, addr, expwc, Options{})
 }
 
 func ExecuteRead8Options(c Commander, addr ecfr.DatagramAddress, expwc uint16, opts Options) (d uint8, err error) {
 	var ds []byte
-	ds, err = ExecuteRead(c, addr, 1, expwc)
+	ds, err = ExecuteReadOptions(c, addr, 1, expwc, opts)
 	if err != nil {
 		return
 	}
@@ -107,9 +198,9 @@ func ExecuteRead16(c Commander, addr ecfr.DatagramAddress, expwc uint16) (d uint
 	return ExecuteRead16Options(c, addr, expwc, Options{})
 }
 
-func ExecuteRead16Options(c Commander, addr ecfr.DatagramAddress, expwc uint16, opt Options) (d uint16, err error) {
+func ExecuteRead16Options(c Commander, addr ecfr.DatagramAddress, expwc uint16, opts Options) (d uint16, err error) {
 	var ds []byte
-	ds, err = ExecuteRead(c, addr, 2, expwc)
+	ds, err = ExecuteReadOptions(c, addr, 2, expwc, opts)
 	if err != nil {
 		return
 	}
@@ -121,9 +212,9 @@ func ExecuteRead32(c Commander, addr ecfr.DatagramAddress, expwc uint16) (d uint
 	return ExecuteRead32Options(c, addr, expwc, Options{})
 }
 
-func ExecuteRead32Options(c Commander, addr ecfr.DatagramAddress, expwc uint16, opt Options) (d uint32, err error) {
+func ExecuteRead32Options(c Commander, addr ecfr.DatagramAddress, expwc uint16, opts Options) (d uint32, err error) {
 	var ds []byte
-	ds, err = ExecuteRead(c, addr, 4, expwc)
+	ds, err = ExecuteReadOptions(c, addr, 4, expwc, opts)
 	if err != nil {
 		return
 	}
@@ -136,9 +227,31 @@ func ExecuteRead(c Commander, addr ecfr.DatagramAddress, n int, expwc uint16) (d
 }
 
 func ExecuteReadOptions(c Commander, addr ecfr.DatagramAddress, n int, expwc uint16, opts Options) (d []byte, err error) {
-	nFrameLoss := 0
+	d, _, err = ExecuteReadContext(context.Background(), c, addr, n, expwc, opts)
+	return
+}
+
+// ExecuteReadContext reads n bytes at addr. it stops retrying when ctx is
+// done and returns the number of attempts made.
+func ExecuteReadContext(ctx context.Context, c Commander, addr ecfr.DatagramAddress, n int, expwc uint16, opts Options) (d []byte, attempts int, err error) {
+	ct, err := readCommand(addr)
+	if err != nil {
+		return
+	}
+
+	var ec *ExecutingCommand
+	ec, attempts, err = execute(ctx, c, n, expwc, opts, func(dgo *ecfr.Datagram) {
+		dgo.Command = ct
+		dgo.Addr32 = addr.Addr32()
+	})
+	if ec != nil && ec.DatagramIn != nil {
+		// the commander reuses its buffers in later cycles
+		d = append([]byte(nil), ec.DatagramIn.Data()...)
+	}
+	return
+}
 
-	var ct ecfr.CommandType
+func readCommand(addr ecfr.DatagramAddress) (ct ecfr.CommandType, err error) {
 	switch addr.Type() {
 	case ecfr.Positional:
 		ct = ecfr.APRD
@@ -147,28 +260,58 @@ func ExecuteReadOptions(c Commander, addr ecfr.DatagramAddress, n int, expwc uin
 	case ecfr.Broadcast:
 		ct = ecfr.BRD
 	default:
-		err = fmt.Errorf("ExecuteReadOptions: unsupported address type %v", addr.Type())
+		err = fmt.Errorf("unsupported address type %v for read", addr.Type())
 	}
+	return
+}
+
+func writeCommand(addr ecfr.DatagramAddress) (ct ecfr.CommandType, err error) {
+	switch addr.Type() {
+	case ecfr.Positional:
+		ct = ecfr.APWR
+	case ecfr.Fixed:
+		ct = ecfr.FPWR
+	case ecfr.Broadcast:
+		ct = ecfr.BWR
+	default:
+		err = fmt.Errorf("unsupported address type %v for write", addr.Type())
+	}
+	return
+}
+
+// executes a command until it arrives with the expected working counter,
+// frame loss tries are exhausted, the working counter deadline passed or
+// ctx is done. prepare fills in the outgoing datagram.
+func execute(ctx context.Context, c Commander, n int, expwc uint16, opts Options, prepare func(dgo *ecfr.Datagram)) (ec *ExecutingCommand, attempts int, err error) {
+	nFrameLoss := 0
 
 	for {
-		var ec *ExecutingCommand
-		ec, err = c.New(n)
+		if attempts > 0 {
+			err = opts.wait(ctx, attempts)
+			if err != nil {
+				return
+			}
+		}
+		err = ctx.Err()
 		if err != nil {
 			return
 		}
+		attempts++
 
-		dgo := ec.DatagramOut
-		dgo.Command = ct
-		dgo.Addr32 = addr.Addr32()
+		ec, err = c.New(n)
+		if err != nil {
+			return
+		}
+		prepare(ec.DatagramOut)
 
-		err = c.Cycle()
+		err = cycleContext(ctx, c)
 		if err != nil {
 			return
 		}
 
 		err = ChooseDefaultError(ec)
 		if err != nil {
-			if IsNoFrame(err) {
+			if isLoss(err) {
 				nFrameLoss++
 				if nFrameLoss < opts.getFramelossTries() {
 					continue
@@ -179,17 +322,15 @@ func ExecuteReadOptions(c Commander, addr ecfr.DatagramAddress, n int, expwc uin
 
 		err = ChooseWorkingCounterError(ec, expwc)
 		if err != nil {
+			reportWorkingCounterError(c, err)
 			now := time.Now()
 			if now.Before(opts.getWCDeadline()) {
 				continue
 			}
 		}
 
-		d = ec.DatagramIn.Data()
 		return
 	}
-
-	panic("not reached")
 }
 
 func ExecuteWrite8(c Commander, addr ecfr.DatagramAddress, w uint8, expwc uint16) (err error) {
@@ -227,58 +368,22 @@ func ExecuteWrite(c Commander, addr ecfr.DatagramAddress, w []byte, expwc uint16
 }
 
 func ExecuteWriteOptions(c Commander, addr ecfr.DatagramAddress, w []byte, expwc uint16, opts Options) (err error) {
-	nFrameLoss := 0
+	_, err = ExecuteWriteContext(context.Background(), c, addr, w, expwc, opts)
+	return
+}
 
-	var ct ecfr.CommandType
-	switch addr.Type() {
-	case ecfr.Positional:
-		ct = ecfr.APWR
-	case ecfr.Fixed:
-		ct = ecfr.FPWR
-	case ecfr.Broadcast:
-		ct = ecfr.BWR
-	default:
-		err = fmt.Errorf("ExecuteWriteOptions: unsupported address type %v", addr.Type())
+// ExecuteWriteContext writes w to addr. it stops retrying when ctx is done
+// and returns the number of attempts made.
+func ExecuteWriteContext(ctx context.Context, c Commander, addr ecfr.DatagramAddress, w []byte, expwc uint16, opts Options) (attempts int, err error) {
+	ct, err := writeCommand(addr)
+	if err != nil {
+		return
 	}
 
-	for {
-		var ec *ExecutingCommand
-		ec, err = c.New(len(w))
-		if err != nil {
-			return
-		}
-
-		dgo := ec.DatagramOut
+	_, attempts, err = execute(ctx, c, len(w), expwc, opts, func(dgo *ecfr.Datagram) {
 		copy(dgo.Data(), w)
-
 		dgo.Command = ct
 		dgo.Addr32 = addr.Addr32()
-
-		err = c.Cycle()
-		if err != nil {
-			return
-		}
-
-		err = ChooseDefaultError(ec)
-		if err != nil {
-			if IsNoFrame(err) {
-				nFrameLoss++
-				if nFrameLoss < opts.getFramelossTries() {
-					continue
-				}
-			}
-			return
-		}
-
-		err = ChooseWorkingCounterError(ec, expwc)
-		if err != nil {
-			now := time.Now()
-			if now.Before(opts.getWCDeadline()) {
-				continue
-			}
-		}
-
-		return
-	}
-
+	})
+	return
 }
@@ -0,0 +1,91 @@
+package ecmd
+
+import (
+	"context"
+	"github.com/distributed/ecat/ecfr"
+	"testing"
+	"time"
+)
+
+// loses every frame
+type lossyCommander struct {
+	countingCommander
+}
+
+func (c *lossyCommander) Cycle() error {
+	c.cycles++
+	c.cmds = nil
+	return nil
+}
+
+func TestExecuteAttempts(t *testing.T) {
+	c := &lossyCommander{}
+	_, attempts, err := ExecuteReadContext(context.Background(), c, ecfr.PositionalAddr(0, 0), 2, 1, Options{})
+	if !IsNoFrame(err) {
+		t.Fatalf("want NoFrame, have %v", err)
+	}
+	if attempts != DefaultFramelossTries || c.cycles != DefaultFramelossTries {
+		t.Fatalf("want %d attempts, have %d with %d cycles", DefaultFramelossTries, attempts, c.cycles)
+	}
+}
+
+func TestExecuteContextCancel(t *testing.T) {
+	c := &lossyCommander{}
+	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer cancel()
+
+	opts := Options{FramelossTries: 1000, Backoff: ConstantBackoff(10 * time.Millisecond)}
+	attempts, err := ExecuteWriteContext(ctx, c, ecfr.FixedAddr(0x1001, 0x10), []byte{1, 2}, 1, opts)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("want deadline exceeded, have %v", err)
+	}
+	if attempts < 2 || attempts > 4 {
+		t.Fatalf("implausible number of attempts %d", attempts)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}
+	want := []time.Duration{1, 2, 4, 5, 5}
+	for i, w := range want {
+		if d := b.Delay(i + 1); d != w*time.Millisecond {
+			t.Fatalf("delay after %d failures: want %v, have %v", i+1, w*time.Millisecond, d)
+		}
+	}
+}
+
+func TestMultiplexerCycleContext(t *testing.T) {
+	cc := &countingCommander{}
+	m, err := NewMultiplexer(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	a, _ := m.OpenCommander()
+	b, _ := m.OpenCommander()
+
+	// b has a command open, so a mux cycle never happens while b does not
+	// cycle
+	if _, err = b.New(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.New(2); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = a.(ContextCycler).CycleContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("channel cycle: want deadline exceeded, have %v", err)
+	}
+	if err = m.CycleContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("mux cycle: want deadline exceeded, have %v", err)
+	}
+
+	// the withdrawn cycles leave the multiplexer usable
+	cycleAll(t, m, a, b)
+	if cc.cycles != 1 {
+		t.Fatalf("want 1 underlying cycle, have %d", cc.cycles)
+	}
+}
@@ -1,10 +1,40 @@
 package ecmd
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/distributed/ecat/ecfr"
 	"launchpad.net/tomb"
+	"sort"
+	"time"
 )
 
+var MultiplexerClosed = errors.New("multiplexer is closed")
+var ChannelClosed = errors.New("multiplexer channel is closed")
+
+type Priority int
+
+const (
+	// real time channels always take part in the next cycle and go first
+	PriorityRealtime Priority = iota
+	PriorityNormal
+	PriorityBackground
+)
+
+type MultiplexerStats struct {
+	// cycles of the underlying commander
+	Cycles int
+	// datagram bytes sent
+	Bytes int
+	// number of times a cycling channel was deferred, and the bytes of
+	// datagrams deferred
+	Deferrals     int
+	DeferredBytes int
+	// most cycles a channel was deferred in a row
+	MaxDeferral int
+}
+
 type Multiplexer struct {
 	muxlinked bool
 	c         Commander
@@ -18,12 +48,42 @@ type Multiplexer struct {
 
 	cyclepending  bool
 	cycleRespChan chan error
+
+	// free running cycle period, 0 if cycles are triggered by Cycle
+	period time.Duration
+
+	// datagram bytes per cycle
+	budget int
+	stats  MultiplexerStats
 }
 
 func NewMultiplexer(c Commander) (m *Multiplexer, err error) {
 	m = &Multiplexer{
 		c:       c,
 		reqchan: make(chan interface{}),
+		budget:  CommandFramerMaxDatagramsLen,
+	}
+
+	go m.loop()
+
+	return
+}
+
+// NewPeriodicMultiplexer returns a free running multiplexer that cycles the
+// underlying Commander every period by itself. a cycle carries the commands
+// of all channels waiting in Cycle at that time, commands of channels that
+// are not cycling yet are kept for a later cycle.
+func NewPeriodicMultiplexer(c Commander, period time.Duration) (m *Multiplexer, err error) {
+	if period <= 0 {
+		err = errors.New("multiplexer period must be positive")
+		return
+	}
+
+	m = &Multiplexer{
+		c:       c,
+		reqchan: make(chan interface{}),
+		period:  period,
+		budget:  CommandFramerMaxDatagramsLen,
 	}
 
 	go m.loop()
@@ -34,6 +94,13 @@ func NewMultiplexer(c Commander) (m *Multiplexer, err error) {
 func (m *Multiplexer) loop() {
 	defer m.tomb.Done()
 
+	var tick <-chan time.Time
+	if m.period > 0 {
+		ticker := time.NewTicker(m.period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 down:
 	for {
 		if m.cyclepending {
@@ -48,20 +115,9 @@ down:
 
 			if allcycling {
 				//log.Printf("mux calling underlying Cycle\n")
-				err := m.c.Cycle()
+				err := m.cycleChannels(true)
 				//log.Printf("underlying Cycle err %v\n", err)
 
-				nnot := 0
-				for _, cb := range m.chans {
-					if cb.cycling {
-						cb.cyclingChan.responseChan <- err
-						nnot++
-					}
-					cb.cycling = false
-					cb.commandsOpen = false
-				}
-				//log.Printf("notified %d channels\n", nnot)
-
 				m.cyclepending = false
 				m.cycleRespChan <- err
 				m.cycleRespChan = nil
@@ -72,31 +128,75 @@ down:
 		case req := <-m.reqchan:
 			switch req := req.(type) {
 			case muxChanNew:
-				ec, err := m.c.New(req.datalen)
+				cb := m.getCB(req.muxChannel)
+				if cb == nil {
+					req.responseChan <- muxChanNewResponse{nil, ChannelClosed}
+					break
+				}
+
+				if len(cb.cmds) > 0xff {
+					// the underlying commander takes no more for a cycle
+					req.responseChan <- muxChanNewResponse{nil, CycleFull}
+					break
+				}
+				ec, err := stageCommand(req.datalen)
 				req.responseChan <- muxChanNewResponse{ec, err}
-				m.getCB(req.muxChannel).commandsOpen = true
+				cb.commandsOpen = true
+				if ec != nil {
+					cb.cmds = append(cb.cmds, ec)
+				}
 
 			case muxChanCycle:
 				// wait for mux controlled cycle
-				//m.cyclingChans = append(m.cyclingChans, cyclingChan{req.muxChannel, req.responseChan})
 				//log.Printf("mux chan is cycling")
 				cb := m.getCB(req.muxChannel)
+				if cb == nil {
+					req.responseChan <- ChannelClosed
+					break
+				}
 				if cb.cycling {
 					req.responseChan <- errors.New("there already is a concurrent Cycle() pending on this mux channel")
+					break
 				}
 
 				cb.cycling = true
 				cb.cyclingChan = cyclingChan{req.muxChannel, req.responseChan}
 
+			case muxChanWithdraw:
+				cb := m.getCB(req.muxChannel)
+				if cb != nil {
+					cb.resetCycle()
+				}
+
+			case muxChanClose:
+				cb := m.getCB(req.muxChannel)
+				if cb == nil {
+					req.responseChan <- ChannelClosed
+					break
+				}
+				m.dropCB(cb)
+				req.responseChan <- nil
+
 			case muxCycle:
 				// mux controlled cycle
 				//log.Printf("mux make cycle pending\n")
+				if m.period > 0 {
+					req.responseChan <- errors.New("free running multiplexer cannot be cycled explicitly")
+					break
+				}
 				if m.cycleRespChan != nil {
 					req.responseChan <- errors.New("there already is a concurrent Cycle() on this multiplexer")
+					break
 				}
 				m.cyclepending = true
 				m.cycleRespChan = req.responseChan
 
+			case muxCycleWithdraw:
+				if m.cycleRespChan == req.responseChan {
+					m.cyclepending = false
+					m.cycleRespChan = nil
+				}
+
 			case openCommander:
 				c := &muxChannel{
 					mux:             m,
@@ -104,14 +204,195 @@ down:
 					errResponseChan: make(chan error),
 				}
 
-				m.chans = append(m.chans, &muxChanControlBlock{muxChannel: c})
+				m.chans = append(m.chans, &muxChanControlBlock{muxChannel: c, priority: req.priority})
 
 				req.responseChan <- openCommanderResponse{c, nil}
+
+			case muxSetBudget:
+				m.budget = req.budget
+				req.responseChan <- nil
+
+			case muxStats:
+				req.responseChan <- m.stats
 			}
+		case <-tick:
+			m.cycleChannels(false)
 		case <-m.tomb.Dying():
 			break down
 		}
 	}
+
+	// release everyone still waiting for a cycle
+	for _, cb := range m.chans {
+		if cb.cycling {
+			cb.cyclingChan.responseChan <- MultiplexerClosed
+		}
+	}
+	m.chans = nil
+	if m.cycleRespChan != nil {
+		m.cycleRespChan <- MultiplexerClosed
+		m.cycleRespChan = nil
+	}
+}
+
+// commands are staged in a datagram of their own until the channel cycles,
+// they are placed in the underlying commander in priority order.
+func stageCommand(datalen int) (*ExecutingCommand, error) {
+	if datalen+ecfr.DatagramOverheadLength > CommandFramerMaxDatagramsLen {
+		return nil, errors.New("datalen exceeds maximum datagram length")
+	}
+
+	dg, err := ecfr.PointDatagramTo(make([]byte, ecfr.DatagramOverheadLength+datalen))
+	if err != nil {
+		return nil, err
+	}
+	if err = dg.SetDataLen(datalen); err != nil {
+		return nil, err
+	}
+	return &ExecutingCommand{DatagramOut: &dg}, nil
+}
+
+func cloneDatagram(dg *ecfr.Datagram) *ecfr.Datagram {
+	n := len(dg.Data())
+	c, err := ecfr.PointDatagramTo(make([]byte, ecfr.DatagramOverheadLength+n))
+	if err != nil {
+		return nil
+	}
+	c.SetDataLen(n)
+	c.Command = dg.Command
+	c.Index = dg.Index
+	c.Addr32 = dg.Addr32
+	c.LenWord = dg.LenWord
+	c.Interrupt = dg.Interrupt
+	c.WorkingCounter = dg.WorkingCounter
+	copy(c.Data(), dg.Data())
+	return &c
+}
+
+// places the staged commands in the underlying commander
+func (m *Multiplexer) placeCommands(staged []*ExecutingCommand) (placed []*ExecutingCommand, err error) {
+	for _, sec := range staged {
+		var ec *ExecutingCommand
+		ec, err = m.c.New(len(sec.DatagramOut.Data()))
+		if err != nil {
+			// what was placed already must not have any effect
+			for _, pec := range placed {
+				pec.DatagramOut.Command = ecfr.NOP
+			}
+			return nil, err
+		}
+
+		sdg, dg := sec.DatagramOut, ec.DatagramOut
+		dg.Command = sdg.Command
+		dg.Addr32 = sdg.Addr32
+		dg.Interrupt = sdg.Interrupt
+		dg.WorkingCounter = sdg.WorkingCounter
+		copy(dg.Data(), sdg.Data())
+
+		placed = append(placed, ec)
+	}
+	return
+}
+
+// places the commands of all cycling channels in the underlying commander
+// and cycles it. real time channels come first, the other channels follow
+// by priority as long as they fit into the cycle budget and are deferred to
+// the next cycle otherwise. the underlying commander is only cycled if there
+// is something to send, unless always is set.
+func (m *Multiplexer) cycleChannels(always bool) (err error) {
+	var cbs []*muxChanControlBlock
+	for _, cb := range m.chans {
+		if cb.cycling {
+			cbs = append(cbs, cb)
+		}
+	}
+	sort.Stable(byPriority(cbs))
+
+	var (
+		sent   []*muxChanControlBlock
+		placed [][]*ExecutingCommand
+		used   int
+		nbg    int
+	)
+
+	for _, cb := range cbs {
+		n := cb.byteLen()
+		// a channel too large for the budget on its own goes once it was
+		// deferred and no other non real time channel was placed.
+		if cb.priority != PriorityRealtime && used+n > m.budget &&
+			!(nbg == 0 && cb.deferrals > 0) {
+			cb.deferrals++
+			m.stats.Deferrals++
+			m.stats.DeferredBytes += n
+			if cb.deferrals > m.stats.MaxDeferral {
+				m.stats.MaxDeferral = cb.deferrals
+			}
+			continue
+		}
+
+		p, perr := m.placeCommands(cb.cmds)
+		if perr != nil {
+			cb.cyclingChan.responseChan <- perr
+			cb.resetCycle()
+			continue
+		}
+
+		used += n
+		if cb.priority != PriorityRealtime {
+			nbg++
+		}
+		sent = append(sent, cb)
+		placed = append(placed, p)
+	}
+
+	if used == 0 && !always {
+		// channels without commands are done right away
+		for _, cb := range sent {
+			cb.cyclingChan.responseChan <- nil
+			cb.resetCycle()
+		}
+		return
+	}
+
+	err = m.c.Cycle()
+	m.stats.Cycles++
+	m.stats.Bytes += used
+
+	for i, cb := range sent {
+		if err == nil {
+			for j, ec := range placed[i] {
+				sec := cb.cmds[j]
+				if ec.DatagramIn != nil {
+					// the underlying commander reuses its datagrams
+					sec.DatagramIn = cloneDatagram(ec.DatagramIn)
+				}
+				sec.Arrived = ec.Arrived
+				sec.Overlayed = ec.Overlayed
+				sec.Error = ec.Error
+			}
+		}
+		cb.cyclingChan.responseChan <- err
+		cb.resetCycle()
+	}
+	return
+}
+
+// removes a channel from the multiplexer, its staged commands are dropped.
+// a pending Cycle on the channel fails.
+func (m *Multiplexer) dropCB(cb *muxChanControlBlock) {
+	cb.cmds = nil
+
+	if cb.cycling {
+		cb.cyclingChan.responseChan <- ChannelClosed
+		cb.cycling = false
+	}
+
+	for i, ocb := range m.chans {
+		if ocb == cb {
+			m.chans = append(m.chans[:i], m.chans[i+1:]...)
+			break
+		}
+	}
 }
 
 func (m *Multiplexer) getCB(mc *muxChannel) *muxChanControlBlock {
@@ -120,27 +401,137 @@ func (m *Multiplexer) getCB(mc *muxChannel) *muxChanControlBlock {
 			return cb
 		}
 	}
-	panic("missing mux chan control block")
+	return nil
 }
 
+// hands req to the loop, fails if the multiplexer is shutting down. once
+// the loop accepted a request, it always answers it.
+func (m *Multiplexer) request(req interface{}) error {
+	select {
+	case m.reqchan <- req:
+		return nil
+	case <-m.tomb.Dying():
+		return MultiplexerClosed
+	}
+}
+
+// OpenCommander opens a channel of PriorityNormal.
 func (m *Multiplexer) OpenCommander() (Commander, error) {
-	req := openCommander{make(chan openCommanderResponse)}
-	m.reqchan <- req
+	return m.OpenCommanderPriority(PriorityNormal)
+}
+
+func (m *Multiplexer) OpenCommanderPriority(p Priority) (Commander, error) {
+	if p < PriorityRealtime || p > PriorityBackground {
+		return nil, fmt.Errorf("invalid multiplexer channel priority %d", p)
+	}
+
+	req := openCommander{p, make(chan openCommanderResponse)}
+	if err := m.request(req); err != nil {
+		return nil, err
+	}
 	resp := <-req.responseChan
 	return resp.Commander, resp.err
 }
 
-func (c *Multiplexer) Cycle() error {
+func (m *Multiplexer) Cycle() error {
+	return m.CycleContext(context.Background())
+}
+
+// CycleContext is Cycle, but gives up waiting for the channels when ctx is
+// done.
+func (m *Multiplexer) CycleContext(ctx context.Context) error {
 	req := muxCycle{make(chan error)}
-	c.reqchan <- req
+	if err := m.request(req); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-req.responseChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	select {
+	case m.reqchan <- muxCycleWithdraw{req.responseChan}:
+		return ctx.Err()
+	case err := <-req.responseChan:
+		return err
+	}
+}
+
+// SetCycleBudget sets the number of datagram bytes per cycle. channels other
+// than real time channels are deferred if their commands do not fit into
+// the budget anymore. the default is one frame, CommandFramerMaxDatagramsLen.
+func (m *Multiplexer) SetCycleBudget(bytes int) error {
+	if bytes <= 0 {
+		return errors.New("multiplexer cycle budget must be positive")
+	}
+
+	req := muxSetBudget{bytes, make(chan error)}
+	if err := m.request(req); err != nil {
+		return err
+	}
 	return <-req.responseChan
 }
 
+func (m *Multiplexer) Stats() (MultiplexerStats, error) {
+	req := muxStats{make(chan MultiplexerStats)}
+	if err := m.request(req); err != nil {
+		return MultiplexerStats{}, err
+	}
+	return <-req.responseChan, nil
+}
+
+// Close stops the multiplexer. pending Cycle calls on the multiplexer and its
+// channels return MultiplexerClosed, as do all later calls. the underlying
+// Commander is not closed.
+func (m *Multiplexer) Close() error {
+	select {
+	case <-m.tomb.Dying():
+		return MultiplexerClosed
+	default:
+	}
+	m.tomb.Kill(nil)
+	return m.tomb.Wait()
+}
+
 type muxChanControlBlock struct {
 	*muxChannel
 	cyclingChan  cyclingChan
+	cmds         []*ExecutingCommand
 	commandsOpen bool
 	cycling      bool
+
+	priority Priority
+	// number of cycles the channel was deferred in a row
+	deferrals int
+}
+
+// datagram bytes of the staged commands
+func (cb *muxChanControlBlock) byteLen() (n int) {
+	for _, ec := range cb.cmds {
+		n += ec.DatagramOut.ByteLen()
+	}
+	return
+}
+
+func (cb *muxChanControlBlock) resetCycle() {
+	cb.cycling = false
+	cb.commandsOpen = false
+	cb.cmds = nil
+	cb.deferrals = 0
+}
+
+// orders channels by priority, deferred channels first within a priority
+type byPriority []*muxChanControlBlock
+
+func (p byPriority) Len() int      { return len(p) }
+func (p byPriority) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPriority) Less(i, j int) bool {
+	if p[i].priority != p[j].priority {
+		return p[i].priority < p[j].priority
+	}
+	return p[i].deferrals > p[j].deferrals
 }
 
 // cycle bound channel
@@ -151,18 +542,57 @@ type muxChannel struct {
 }
 
 func (mc *muxChannel) New(datalen int) (*ExecutingCommand, error) {
-	mc.mux.reqchan <- muxChanNew{mc, datalen, mc.newResponseChan}
+	if err := mc.mux.request(muxChanNew{mc, datalen, mc.newResponseChan}); err != nil {
+		return nil, err
+	}
 	resp := <-mc.newResponseChan
 	return resp.ExecutingCommand, resp.error
 }
 
 func (mc *muxChannel) Cycle() error {
-	mc.mux.reqchan <- muxChanCycle{mc, mc.errResponseChan}
-	return <-mc.errResponseChan
+	return mc.CycleContext(context.Background())
+}
+
+// CycleContext waits for the multiplexer to cycle the channel. if ctx is
+// done first, the channel withdraws from the cycle and its commands are
+// dropped.
+func (mc *muxChannel) CycleContext(ctx context.Context) error {
+	if err := mc.mux.request(muxChanCycle{mc, mc.errResponseChan}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-mc.errResponseChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	// the loop either takes the withdrawal or is answering the cycle
+	// already. once the loop shut down, it answered all pending cycles.
+	select {
+	case mc.mux.reqchan <- muxChanWithdraw{mc}:
+		return ctx.Err()
+	case err := <-mc.errResponseChan:
+		return err
+	}
 }
 
+// Close removes the channel from the multiplexer, so it does not hold up
+// multiplexer cycles anymore. commands that were not cycled yet are dropped.
 func (mc *muxChannel) Close() error {
-	return errors.New("nimpl")
+	// a Cycle pending on this channel is answered on errResponseChan, so the
+	// close response needs a channel of its own.
+	req := muxChanClose{mc, make(chan error, 1)}
+	if err := mc.mux.request(req); err != nil {
+		return err
+	}
+	return <-req.responseChan
+}
+
+func (mc *muxChannel) reportWorkingCounterError(e WorkingCounterError) {
+	if r, ok := mc.mux.c.(wcReporter); ok {
+		r.reportWorkingCounterError(e)
+	}
 }
 
 func (mc *muxChannel) DebugMessage(m string) {
@@ -185,14 +615,37 @@ type muxChanCycle struct {
 	responseChan chan error
 }
 
+type muxChanClose struct {
+	*muxChannel
+	responseChan chan error
+}
+
+type muxChanWithdraw struct {
+	*muxChannel
+}
+
+type muxCycleWithdraw struct {
+	responseChan chan error
+}
+
 type muxCycle struct {
 	responseChan chan error
 }
 
 type openCommander struct {
+	priority     Priority
 	responseChan chan openCommanderResponse
 }
 
+type muxSetBudget struct {
+	budget       int
+	responseChan chan error
+}
+
+type muxStats struct {
+	responseChan chan MultiplexerStats
+}
+
 type openCommanderResponse struct {
 	Commander Commander
 	err       error
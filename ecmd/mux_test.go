@@ -0,0 +1,321 @@
+package ecmd
+
+import (
+	"github.com/distributed/ecat/ecfr"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// counts cycles and hands out commands backed by standalone datagrams
+type countingCommander struct {
+	cycles int
+	cmds   []*ExecutingCommand
+	// commands of all cycles in the order they were placed
+	sent []ecfr.CommandType
+}
+
+func (c *countingCommander) New(datalen int) (*ExecutingCommand, error) {
+	fr, err := ecfr.PointFrameTo(make([]byte, 2+ecfr.DatagramOverheadLength+datalen))
+	if err != nil {
+		return nil, err
+	}
+	dg, err := fr.NewDatagram(datalen)
+	if err != nil {
+		return nil, err
+	}
+	ec := &ExecutingCommand{DatagramOut: dg}
+	c.cmds = append(c.cmds, ec)
+	return ec, nil
+}
+
+// commands come back unchanged
+func (c *countingCommander) Cycle() error {
+	c.cycles++
+	for _, ec := range c.cmds {
+		c.sent = append(c.sent, ec.DatagramOut.Command)
+		ec.DatagramIn = ec.DatagramOut
+		ec.Arrived = true
+		ec.Overlayed = true
+	}
+	c.cmds = nil
+	return nil
+}
+
+func (c *countingCommander) Close() error { return nil }
+
+func waitErr(t *testing.T, ch <-chan error) error {
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(time.Second):
+		t.Fatalf("timed out")
+	}
+	return nil
+}
+
+func TestMultiplexerChannelClose(t *testing.T) {
+	cc := &countingCommander{}
+	m, err := NewMultiplexer(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	a, _ := m.OpenCommander()
+	b, _ := m.OpenCommander()
+
+	aec, err := a.New(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aec.DatagramOut.Command = ecfr.BRD
+	ec, err := b.New(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec.DatagramOut.Command = ecfr.BWR
+
+	achan := make(chan error)
+	go func() { achan <- a.Cycle() }()
+	mchan := make(chan error)
+	go func() { mchan <- m.Cycle() }()
+
+	// b has open commands and does not cycle, so the mux cycle is blocked
+	select {
+	case <-mchan:
+		t.Fatalf("multiplexer cycled with an open channel not cycling")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err = b.Close(); err != nil {
+		t.Fatalf("closing channel failed: %v", err)
+	}
+	if err = waitErr(t, mchan); err != nil {
+		t.Fatalf("mux cycle failed: %v", err)
+	}
+	if err = waitErr(t, achan); err != nil {
+		t.Fatalf("channel cycle failed: %v", err)
+	}
+
+	if ec.Arrived || !reflect.DeepEqual(cc.sent, []ecfr.CommandType{ecfr.BRD}) {
+		t.Fatalf("command of closed channel was not dropped, sent %v", cc.sent)
+	}
+	if cc.cycles != 1 {
+		t.Fatalf("want 1 underlying cycle, have %d", cc.cycles)
+	}
+
+	if _, err = b.New(2); err != ChannelClosed {
+		t.Fatalf("New on closed channel: want ChannelClosed, have %v", err)
+	}
+	if err = b.Cycle(); err != ChannelClosed {
+		t.Fatalf("Cycle on closed channel: want ChannelClosed, have %v", err)
+	}
+	if err = b.Close(); err != ChannelClosed {
+		t.Fatalf("second Close: want ChannelClosed, have %v", err)
+	}
+}
+
+func TestMultiplexerClose(t *testing.T) {
+	m, err := NewMultiplexer(&countingCommander{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := m.OpenCommander()
+	if _, err = a.New(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mux cycle waits for a
+	mchan := make(chan error)
+	go func() { mchan <- m.Cycle() }()
+	time.Sleep(10 * time.Millisecond)
+
+	if err = m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err = waitErr(t, mchan); err != MultiplexerClosed {
+		t.Fatalf("pending Cycle: want MultiplexerClosed, have %v", err)
+	}
+
+	if err = a.Cycle(); err != MultiplexerClosed {
+		t.Fatalf("channel Cycle after Close: want MultiplexerClosed, have %v", err)
+	}
+	if _, err = m.OpenCommander(); err != MultiplexerClosed {
+		t.Fatalf("OpenCommander after Close: want MultiplexerClosed, have %v", err)
+	}
+	if err = m.Close(); err != MultiplexerClosed {
+		t.Fatalf("second Close: want MultiplexerClosed, have %v", err)
+	}
+}
+
+func TestPeriodicMultiplexer(t *testing.T) {
+	cc := &countingCommander{}
+	m, err := NewPeriodicMultiplexer(cc, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err = m.Cycle(); err == nil {
+		t.Fatalf("explicit Cycle on free running multiplexer should fail")
+	}
+
+	a, _ := m.OpenCommander()
+	slow, _ := m.OpenCommander()
+
+	// slow has a command open but does not cycle, a must not be held up
+	sec, err := slow.New(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ec, err := a.New(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec.DatagramOut.Command = ecfr.BRD
+		ec.DatagramOut.Data()[0] = uint8(i)
+
+		achan := make(chan error)
+		go func() { achan <- a.Cycle() }()
+		if err = waitErr(t, achan); err != nil {
+			t.Fatalf("channel cycle failed: %v", err)
+		}
+
+		if !ec.Arrived || ec.DatagramIn.Command != ecfr.BRD || ec.DatagramIn.Data()[0] != uint8(i) {
+			t.Fatalf("command results not passed back to channel")
+		}
+	}
+
+	if sec.Arrived {
+		t.Fatalf("command of channel not cycling was sent")
+	}
+
+	// the slow channel is carried into a later cycle
+	sec.DatagramOut.Command = ecfr.APRD
+	schan := make(chan error)
+	go func() { schan <- slow.Cycle() }()
+	if err = waitErr(t, schan); err != nil {
+		t.Fatalf("slow channel cycle failed: %v", err)
+	}
+	if !sec.Arrived || sec.DatagramIn.Command != ecfr.APRD {
+		t.Fatalf("carried command did not arrive")
+	}
+	if cc.cycles != 4 {
+		t.Fatalf("want 4 underlying cycles, have %d", cc.cycles)
+	}
+}
+
+func cycleAll(t *testing.T, m *Multiplexer, chans ...Commander) {
+	errs := make(chan error, len(chans))
+	for _, c := range chans {
+		go func(c Commander) { errs <- c.Cycle() }(c)
+	}
+	// give the channels time to get cycling
+	time.Sleep(10 * time.Millisecond)
+	if err := m.Cycle(); err != nil {
+		t.Fatalf("mux cycle failed: %v", err)
+	}
+}
+
+func TestMultiplexerPriority(t *testing.T) {
+	cc := &countingCommander{}
+	m, err := NewMultiplexer(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	bg, _ := m.OpenCommanderPriority(PriorityBackground)
+	normal, _ := m.OpenCommander()
+	rt, _ := m.OpenCommanderPriority(PriorityRealtime)
+
+	if _, err = m.OpenCommanderPriority(Priority(17)); err == nil {
+		t.Fatalf("opening channel with invalid priority should fail")
+	}
+
+	for _, p := range []struct {
+		c  Commander
+		ct ecfr.CommandType
+	}{{bg, ecfr.BRD}, {normal, ecfr.APRD}, {rt, ecfr.LRW}} {
+		ec, err := p.c.New(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec.DatagramOut.Command = p.ct
+	}
+
+	cycleAll(t, m, bg, normal, rt)
+
+	want := []ecfr.CommandType{ecfr.LRW, ecfr.APRD, ecfr.BRD}
+	if !reflect.DeepEqual(cc.sent, want) {
+		t.Fatalf("want commands in order %v, have %v", want, cc.sent)
+	}
+}
+
+func TestMultiplexerBudget(t *testing.T) {
+	cc := &countingCommander{}
+	m, err := NewMultiplexer(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// room for the real time datagram and one more
+	if err = m.SetCycleBudget(2 * (ecfr.DatagramOverheadLength + 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, _ := m.OpenCommanderPriority(PriorityRealtime)
+	bg1, _ := m.OpenCommanderPriority(PriorityBackground)
+	bg2, _ := m.OpenCommanderPriority(PriorityBackground)
+
+	rtcmd := func() {
+		ec, err := rt.New(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec.DatagramOut.Command = ecfr.LRW
+	}
+
+	rtcmd()
+	ec1, _ := bg1.New(4)
+	ec1.DatagramOut.Command = ecfr.APRD
+	ec2, _ := bg2.New(4)
+	ec2.DatagramOut.Command = ecfr.FPRD
+
+	bgerrs := make(chan error, 2)
+	go func() { bgerrs <- bg1.Cycle() }()
+	go func() { bgerrs <- bg2.Cycle() }()
+	cycleAll(t, m, rt)
+	waitErr(t, bgerrs)
+
+	if !ec1.Arrived || ec2.Arrived {
+		t.Fatalf("second background channel should be deferred")
+	}
+
+	// the deferred channel goes in the next cycle
+	rtcmd()
+	cycleAll(t, m, rt)
+	waitErr(t, bgerrs)
+	if !ec2.Arrived {
+		t.Fatalf("deferred channel was not carried into the next cycle")
+	}
+
+	want := []ecfr.CommandType{ecfr.LRW, ecfr.APRD, ecfr.LRW, ecfr.FPRD}
+	if !reflect.DeepEqual(cc.sent, want) {
+		t.Fatalf("want commands %v, have %v", want, cc.sent)
+	}
+
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Cycles != 2 || stats.Deferrals != 1 || stats.MaxDeferral != 1 ||
+		stats.DeferredBytes != ecfr.DatagramOverheadLength+4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
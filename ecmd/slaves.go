@@ -0,0 +1,44 @@
+package ecmd
+
+import (
+	"context"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+)
+
+// CountSlaves counts the slaves by the working counter of a broadcast read.
+// lost frames are retried as often as opts allow.
+func CountSlaves(ctx context.Context, c Commander, opts Options) (n int, err error) {
+	for i := 0; i < opts.getFramelossTries(); i++ {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var ec *ExecutingCommand
+		ec, err = c.New(2)
+		if err != nil {
+			return
+		}
+		ec.DatagramOut.Command = ecfr.BRD
+		ec.DatagramOut.Addr32 = ecfr.BroadcastAddr(ecad.Type).Addr32()
+
+		if err = cycleContext(ctx, c); err != nil {
+			return
+		}
+		if err = ChooseDefaultError(ec); err == nil {
+			n = int(ec.DatagramIn.WorkingCounter)
+			return
+		}
+	}
+	return
+}
+
+// CounterIncrement returns the increment of an ESC error or watchdog counter
+// from prev to cur. the counters only decrease when cleared, the increment
+// since is the counter value.
+func CounterIncrement(cur, prev uint8) uint8 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
@@ -0,0 +1,87 @@
+package ecmd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// loses the first frames, then answers with a working counter of wc
+type flakyCommander struct {
+	countingCommander
+	lose int
+	wc   uint16
+}
+
+func (c *flakyCommander) Cycle() error {
+	if c.lose > 0 {
+		c.lose--
+		c.cycles++
+		c.cmds = nil
+		return nil
+	}
+	for _, ec := range c.cmds {
+		ec.DatagramOut.WorkingCounter = c.wc
+	}
+	return c.countingCommander.Cycle()
+}
+
+// cycles only end with their context
+type blockingCommander struct {
+	countingCommander
+}
+
+func (c *blockingCommander) CycleContext(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCountSlaves(t *testing.T) {
+	ctx := context.Background()
+
+	c := &flakyCommander{lose: 2, wc: 5}
+	n, err := CountSlaves(ctx, c, Options{})
+	if err != nil || n != 5 || c.cycles != 3 {
+		t.Fatalf("want 5 slaves in the third cycle, have %d in %d cycles, %v", n, c.cycles, err)
+	}
+
+	c = &flakyCommander{lose: 2, wc: 5}
+	if _, err = CountSlaves(ctx, c, Options{FramelossTries: 2}); !IsNoFrame(err) || c.cycles != 2 {
+		t.Fatalf("want NoFrame after 2 cycles, have %v after %d", err, c.cycles)
+	}
+
+	lc := &lossyCommander{}
+	if _, err = CountSlaves(ctx, lc, Options{}); !IsNoFrame(err) || lc.cycles != DefaultFramelossTries {
+		t.Fatalf("want NoFrame after %d cycles, have %v after %d", DefaultFramelossTries, err, lc.cycles)
+	}
+}
+
+func TestCountSlavesContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &flakyCommander{wc: 1}
+	if _, err := CountSlaves(canceled, c, Options{}); err != context.Canceled || c.cycles != 0 {
+		t.Fatalf("want canceled without a cycle, have %v after %d cycles", err, c.cycles)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := CountSlaves(ctx, &blockingCommander{}, Options{}); err != context.DeadlineExceeded {
+		t.Fatalf("want deadline exceeded while cycling, have %v", err)
+	}
+}
+
+func TestCounterIncrement(t *testing.T) {
+	for _, c := range []struct{ cur, prev, inc uint8 }{
+		{5, 3, 2},
+		{3, 3, 0},
+		{255, 0, 255},
+		// cleared since, and counted up again
+		{2, 200, 2},
+		{0, 7, 0},
+	} {
+		if inc := CounterIncrement(c.cur, c.prev); inc != c.inc {
+			t.Errorf("from %d to %d: want %d, have %d", c.prev, c.cur, c.inc, inc)
+		}
+	}
+}
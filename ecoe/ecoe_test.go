@@ -0,0 +1,101 @@
+package ecoe
+
+import (
+	"bytes"
+	"context"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+)
+
+func newTestMailbox(t *testing.T, od sim.ObjectDictionary) *Mailbox {
+	slave := sim.NewL2Slave()
+	slave.SetupMailbox(0x1000, 0x30, 0x1080, 0x30)
+	slave.Mailbox = sim.NewCoEServer(od)
+	c := ecmd.NewCommandFramer(&sim.L2Bus{Slaves: []sim.FrameProcessor{slave}})
+
+	m, err := OpenMailbox(context.Background(), c, ecfr.PositionalAddr(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Out != (SyncManager{0x1000, 0x30}) || m.In != (SyncManager{0x1080, 0x30}) {
+		t.Fatalf("unexpected mailbox sync managers %+v %+v", m.Out, m.In)
+	}
+	return m
+}
+
+func TestSDO(t *testing.T) {
+	name := []byte("a device name that does not fit into one mailbox")
+	od := sim.ObjectDictionary{
+		{Index: 0x1000}: {Data: []byte{0x92, 0x01, 0x00, 0x00}, Access: sim.ODReadOnly},
+		{Index: 0x1008}: {Data: name, Access: sim.ODReadOnly, Variable: true},
+		{Index: 0x2000}: {Data: []byte{0x00, 0x00}},
+		{Index: 0x2001}: {Data: make([]byte, 100)},
+	}
+	m := newTestMailbox(t, od)
+	ctx := context.Background()
+
+	d, err := m.Upload(ctx, 0x1000, 0)
+	if err != nil || !bytes.Equal(d, []byte{0x92, 0x01, 0x00, 0x00}) {
+		t.Fatalf("expedited upload returned % x, %v", d, err)
+	}
+	d, err = m.Upload(ctx, 0x1008, 0)
+	if err != nil || !bytes.Equal(d, name) {
+		t.Fatalf("segmented upload returned %q, %v", d, err)
+	}
+
+	if err = m.Download(ctx, 0x2000, 0, []byte{0x34, 0x12}); err != nil {
+		t.Fatal(err)
+	}
+	if d := od[sim.ODAddress{Index: 0x2000}].Data; !bytes.Equal(d, []byte{0x34, 0x12}) {
+		t.Fatalf("expedited download wrote % x", d)
+	}
+
+	long := make([]byte, 100)
+	for i := range long {
+		long[i] = uint8(i)
+	}
+	if err = m.Download(ctx, 0x2001, 0, long); err != nil {
+		t.Fatal(err)
+	}
+	if d := od[sim.ODAddress{Index: 0x2001}].Data; !bytes.Equal(d, long) {
+		t.Fatalf("segmented download wrote % x", d)
+	}
+
+	err = m.Download(ctx, 0x1000, 0, []byte{1, 2, 3, 4})
+	if ae, ok := err.(AbortError); !ok || ae.Code != sim.SDOAbortReadOnly || ae.Index != 0x1000 {
+		t.Fatalf("want read only abort, have %v", err)
+	}
+	if _, err = m.Upload(ctx, 0x3000, 0); err == nil {
+		t.Fatalf("uploading a missing object succeeded")
+	}
+}
+
+func TestDownloadSmallMailbox(t *testing.T) {
+	ctx := context.Background()
+	for _, l := range []uint16{9, 12, ecfr.MailboxHeaderLen + coeHeaderLen + sdoHeaderLen} {
+		m := &Mailbox{Out: SyncManager{0x1000, l}}
+		if err := m.Download(ctx, 0x2000, 0, make([]byte, 8)); err == nil {
+			t.Fatalf("download through a mailbox of %d bytes succeeded", l)
+		}
+	}
+
+	// the smallest mailbox carries a byte with the initiate request
+	od := sim.ObjectDictionary{{Index: 0x2000}: {Data: make([]byte, 20)}}
+	slave := sim.NewL2Slave()
+	slave.SetupMailbox(0x1000, ecfr.MailboxHeaderLen+coeHeaderLen+sdoHeaderLen+1, 0x1080, 0x30)
+	slave.Mailbox = sim.NewCoEServer(od)
+	c := ecmd.NewCommandFramer(&sim.L2Bus{Slaves: []sim.FrameProcessor{slave}})
+	m, err := OpenMailbox(ctx, c, ecfr.PositionalAddr(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("twenty bytes of data")
+	if err = m.Download(ctx, 0x2000, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	if d := od[sim.ODAddress{Index: 0x2000}].Data; !bytes.Equal(d, data) {
+		t.Fatalf("download wrote %q", d)
+	}
+}
@@ -0,0 +1,201 @@
+package ecoe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"time"
+)
+
+const (
+	MailboxTypeError = 0x00
+	MailboxTypeCoE   = 0x03
+
+	smModeMailbox    = 0x02
+	smStatusFull     = 0x08
+	smActivateEnable = 0x01
+
+	DefaultTimeout = time.Second
+)
+
+var Timeout = errors.New("mailbox timeout")
+
+// MailboxError is the error reply of a slave to a message it cannot
+// handle.
+type MailboxError struct {
+	Code uint16
+}
+
+func (e MailboxError) Error() string {
+	return fmt.Sprintf("mailbox error reply, code %#04x", e.Code)
+}
+
+// SyncManager is the area of a mailbox sync manager.
+type SyncManager struct {
+	Start  uint16
+	Length uint16
+}
+
+// Mailbox exchanges messages with a slave through its mailbox sync
+// managers 0 and 1.
+type Mailbox struct {
+	Commander ecmd.Commander
+	// the slave, the offset is ignored
+	Addr ecfr.DatagramAddress
+	// written by the master and read by the master
+	Out, In SyncManager
+	// for the slave to accept or answer a message, 0 for DefaultTimeout
+	Timeout time.Duration
+
+	counter uint8
+}
+
+func smAddr(addr ecfr.DatagramAddress, sm int, offs uint16) ecfr.DatagramAddress {
+	addr.SetOffset(uint16(ecad.SyncMangerBase+sm*ecad.SyncManagerChannelLen) + offs)
+	return addr
+}
+
+// OpenMailbox uses the mailbox sync managers as configured in the slave.
+func OpenMailbox(ctx context.Context, c ecmd.Commander, addr ecfr.DatagramAddress) (m *Mailbox, err error) {
+	m = &Mailbox{Commander: c, Addr: addr}
+	for i, sm := range []*SyncManager{&m.Out, &m.In} {
+		var d []byte
+		d, _, err = ecmd.ExecuteReadContext(ctx, c, smAddr(addr, i, 0), ecad.SyncManagerChannelLen, 1, ecmd.Options{})
+		if err != nil {
+			return nil, err
+		}
+
+		sm.Start = uint16(d[0]) | uint16(d[1])<<8
+		sm.Length = uint16(d[2]) | uint16(d[3])<<8
+		control := d[ecad.SyncManagerControlOffset]
+		activate := d[ecad.SyncManagerActivateOffset]
+		if control&0x03 != smModeMailbox || activate&smActivateEnable == 0 || sm.Length < ecfr.MailboxHeaderLen {
+			return nil, fmt.Errorf("sync manager %d is not an enabled mailbox", i)
+		}
+	}
+	return
+}
+
+// ConfigureMailbox sets up sync managers 0 and 1 as mailboxes, as the
+// master does in INIT from the mailbox configuration of the SII.
+func ConfigureMailbox(ctx context.Context, c ecmd.Commander, addr ecfr.DatagramAddress, out, in SyncManager) (m *Mailbox, err error) {
+	for i, sm := range []SyncManager{out, in} {
+		control := uint8(0x22)
+		if i == 0 {
+			// ECAT writes, interrupt to the PDI
+			control = 0x26
+		}
+		w := []byte{
+			uint8(sm.Start), uint8(sm.Start >> 8),
+			uint8(sm.Length), uint8(sm.Length >> 8),
+			control, 0, smActivateEnable, 0,
+		}
+		_, err = ecmd.ExecuteWriteContext(ctx, c, smAddr(addr, i, 0), w, 1, ecmd.Options{})
+		if err != nil {
+			return
+		}
+	}
+	return &Mailbox{Commander: c, Addr: addr, Out: out, In: in}, nil
+}
+
+func (m *Mailbox) timeout() time.Duration {
+	if m.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return m.Timeout
+}
+
+// waits until the full flag of sync manager sm equals full.
+func (m *Mailbox) waitStatus(ctx context.Context, sm int, full bool) error {
+	deadline := time.Now().Add(m.timeout())
+	for {
+		st, _, err := ecmd.ExecuteReadContext(ctx, m.Commander, smAddr(m.Addr, sm, ecad.SyncManagerStatusOffset), 1, 1, ecmd.Options{})
+		if err != nil {
+			return err
+		}
+		if (st[0]&smStatusFull != 0) == full {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return Timeout
+		}
+	}
+}
+
+// Send writes a message of type typ to the slave.
+func (m *Mailbox) Send(ctx context.Context, typ uint8, data []byte) (err error) {
+	if ecfr.MailboxHeaderLen+len(data) > int(m.Out.Length) {
+		return fmt.Errorf("message of %d bytes exceeds the mailbox of %d bytes", len(data), m.Out.Length-ecfr.MailboxHeaderLen)
+	}
+	if err = m.waitStatus(ctx, 0, false); err != nil {
+		return
+	}
+
+	// the counter cycles through 1 to 7, 0 is reserved
+	m.counter = m.counter%7 + 1
+	msg := make([]byte, m.Out.Length)
+	mh := ecfr.MailboxHeader{Length: uint16(len(data)), Type: typ, Counter: m.counter}
+	b, _ := mh.Encode(msg)
+	copy(b, data)
+
+	// the whole area has to be written for the mailbox to fill up
+	addr := m.Addr
+	addr.SetOffset(m.Out.Start)
+	_, err = ecmd.ExecuteWriteContext(ctx, m.Commander, addr, msg, 1, ecmd.Options{})
+	return
+}
+
+// Receive reads the next message from the slave. error replies are
+// returned as MailboxError.
+func (m *Mailbox) Receive(ctx context.Context) (typ uint8, data []byte, err error) {
+	if err = m.waitStatus(ctx, 1, true); err != nil {
+		return
+	}
+
+	addr := m.Addr
+	addr.SetOffset(m.In.Start)
+	msg, _, err := ecmd.ExecuteReadContext(ctx, m.Commander, addr, int(m.In.Length), 1, ecmd.Options{})
+	if err != nil {
+		return
+	}
+
+	var mh ecfr.MailboxHeader
+	b, err := mh.Overlay(msg)
+	if err != nil {
+		return
+	}
+	if int(mh.Length) > len(b) {
+		err = fmt.Errorf("mailbox message claims %d bytes, mailbox holds %d", mh.Length, len(b))
+		return
+	}
+	typ, data = mh.Type, b[:mh.Length]
+
+	if typ == MailboxTypeError {
+		if len(data) < 4 {
+			err = fmt.Errorf("short mailbox error reply % x", data)
+			return
+		}
+		err = MailboxError{uint16(data[2]) | uint16(data[3])<<8}
+	}
+	return
+}
+
+// Flush discards a message waiting in the read mailbox.
+func (m *Mailbox) Flush(ctx context.Context) error {
+	st, _, err := ecmd.ExecuteReadContext(ctx, m.Commander, smAddr(m.Addr, 1, ecad.SyncManagerStatusOffset), 1, 1, ecmd.Options{})
+	if err != nil {
+		return err
+	}
+	if st[0]&smStatusFull == 0 {
+		return nil
+	}
+
+	_, _, err = m.Receive(ctx)
+	if _, ok := err.(MailboxError); ok {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package ecoe
+
+import (
+	"context"
+	"fmt"
+	"github.com/distributed/ecat/ecfr"
+)
+
+const (
+	coeHeaderLen          = 2
+	coeServiceSDORequest  = 0x02
+	coeServiceSDOResponse = 0x03
+
+	sdoHeaderLen      = 8
+	sdoMinSegmentData = 7
+
+	// client command specifiers
+	sdoDownloadSegment  = 0
+	sdoInitiateDownload = 1
+	sdoInitiateUpload   = 2
+	sdoUploadSegment    = 3
+	sdoAbort            = 4
+
+	// server command specifiers
+	sdoRespUploadSegment    = 0
+	sdoRespDownloadSegment  = 1
+	sdoRespInitiateUpload   = 2
+	sdoRespInitiateDownload = 3
+)
+
+// AbortError is an SDO transfer aborted by the slave.
+type AbortError struct {
+	Index    uint16
+	SubIndex uint8
+	Code     uint32
+}
+
+func (e AbortError) Error() string {
+	return fmt.Sprintf("SDO %#04x:%d aborted with code %#08x", e.Index, e.SubIndex, e.Code)
+}
+
+func sdoHeader(cmd uint8, index uint16, sub uint8, v uint32) []byte {
+	return []byte{
+		cmd, uint8(index), uint8(index >> 8), sub,
+		uint8(v), uint8(v >> 8), uint8(v >> 16), uint8(v >> 24),
+	}
+}
+
+func getUint32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// exchanges an SDO request for its response and turns aborts into errors.
+func (m *Mailbox) sdo(ctx context.Context, index uint16, sub uint8, req []byte) (resp []byte, err error) {
+	msg := make([]byte, coeHeaderLen+len(req))
+	msg[1] = coeServiceSDORequest << 4
+	copy(msg[coeHeaderLen:], req)
+	if err = m.Send(ctx, MailboxTypeCoE, msg); err != nil {
+		return
+	}
+
+	typ, data, err := m.Receive(ctx)
+	if err != nil {
+		return
+	}
+	if typ != MailboxTypeCoE || len(data) < coeHeaderLen+1 || data[1]>>4 != coeServiceSDOResponse {
+		err = fmt.Errorf("unexpected reply to SDO request, type %d: % x", typ, data)
+		return
+	}
+
+	resp = data[coeHeaderLen:]
+	if resp[0]>>5 == sdoAbort {
+		if len(resp) < sdoHeaderLen {
+			return nil, fmt.Errorf("short SDO abort % x", resp)
+		}
+		return nil, AbortError{index, sub, getUint32(resp[4:])}
+	}
+	return
+}
+
+// Upload reads an object from the slave.
+func (m *Mailbox) Upload(ctx context.Context, index uint16, sub uint8) (data []byte, err error) {
+	resp, err := m.sdo(ctx, index, sub, sdoHeader(sdoInitiateUpload<<5, index, sub, 0))
+	if err != nil {
+		return
+	}
+	if len(resp) < sdoHeaderLen || resp[0]>>5 != sdoRespInitiateUpload {
+		return nil, fmt.Errorf("unexpected upload response % x", resp)
+	}
+
+	if resp[0]&0x02 != 0 {
+		// expedited
+		n := 4
+		if resp[0]&0x01 != 0 {
+			n -= int(resp[0]>>2) & 0x03
+		}
+		return append([]byte(nil), resp[4:4+n]...), nil
+	}
+
+	size := int(getUint32(resp[4:]))
+	data = append(data, resp[sdoHeaderLen:]...)
+	toggle := uint8(0)
+	for len(data) < size {
+		req := make([]byte, sdoHeaderLen)
+		req[0] = sdoUploadSegment<<5 | toggle
+		resp, err = m.sdo(ctx, index, sub, req)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp) < 1+sdoMinSegmentData || resp[0]>>5 != sdoRespUploadSegment || resp[0]&0x10 != toggle {
+			return nil, fmt.Errorf("unexpected upload segment % x", resp)
+		}
+
+		seg := resp[1:]
+		if len(seg) == sdoMinSegmentData {
+			seg = seg[:sdoMinSegmentData-int(resp[0]>>1&0x07)]
+		}
+		data = append(data, seg...)
+		toggle ^= 0x10
+
+		if resp[0]&0x01 != 0 {
+			break
+		}
+	}
+
+	if len(data) != size {
+		return nil, fmt.Errorf("uploaded %d bytes, announced %d", len(data), size)
+	}
+	return
+}
+
+// Download writes an object of the slave. up to 4 bytes are transferred
+// expedited, more in a normal transfer followed by segments if they do not
+// fit the mailbox.
+func (m *Mailbox) Download(ctx context.Context, index uint16, sub uint8, data []byte) (err error) {
+	var resp []byte
+	if len(data) <= 4 && len(data) > 0 {
+		req := sdoHeader(sdoInitiateDownload<<5|0x03|uint8(4-len(data))<<2, index, sub, 0)
+		copy(req[4:], data)
+		resp, err = m.sdo(ctx, index, sub, req)
+		if err != nil {
+			return
+		}
+		return checkResponse(resp, sdoRespInitiateDownload)
+	}
+
+	space := int(m.Out.Length) - ecfr.MailboxHeaderLen - coeHeaderLen
+	if space < sdoHeaderLen+1 {
+		return fmt.Errorf("mailbox of %d bytes is too small for a normal download", m.Out.Length)
+	}
+	n := len(data)
+	if n > space-sdoHeaderLen {
+		n = space - sdoHeaderLen
+	}
+	req := append(sdoHeader(sdoInitiateDownload<<5|0x01, index, sub, uint32(len(data))), data[:n]...)
+	resp, err = m.sdo(ctx, index, sub, req)
+	if err != nil {
+		return
+	}
+	if err = checkResponse(resp, sdoRespInitiateDownload); err != nil {
+		return
+	}
+	data = data[n:]
+
+	toggle := uint8(0)
+	for len(data) > 0 {
+		n := len(data)
+		last := true
+		if n > space-1 {
+			n = space - 1
+			last = false
+		}
+
+		seglen := n
+		cmd := uint8(sdoDownloadSegment<<5) | toggle
+		if n < sdoMinSegmentData {
+			cmd |= uint8(sdoMinSegmentData-n) << 1
+			seglen = sdoMinSegmentData
+		}
+		if last {
+			cmd |= 0x01
+		}
+
+		req := make([]byte, 1+seglen)
+		req[0] = cmd
+		copy(req[1:], data[:n])
+		resp, err = m.sdo(ctx, index, sub, req)
+		if err != nil {
+			return
+		}
+		if err = checkResponse(resp, sdoRespDownloadSegment); err != nil {
+			return
+		}
+		if resp[0]&0x10 != toggle {
+			return fmt.Errorf("download segment response with wrong toggle bit % x", resp)
+		}
+
+		data = data[n:]
+		toggle ^= 0x10
+	}
+	return
+}
+
+func checkResponse(resp []byte, scs uint8) error {
+	if len(resp) < 1 || resp[0]>>5 != scs {
+		return fmt.Errorf("unexpected SDO response % x", resp)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package ecstat
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the cycle duration histogram.
+var DefaultBuckets = []time.Duration{
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+type Histogram struct {
+	Buckets []time.Duration
+	// Counts[i] counts observations <= Buckets[i], the last element counts
+	// all observations
+	Counts []uint64
+	Sum    time.Duration
+}
+
+func newHistogram(buckets []time.Duration) Histogram {
+	return Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	for i, b := range h.Buckets {
+		if d <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Counts[len(h.Buckets)]++
+	h.Sum += d
+}
+
+func (h Histogram) Count() uint64 {
+	return h.Counts[len(h.Buckets)]
+}
+
+func (h Histogram) copy() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// WCKey identifies the command and slave of working counter errors.
+type WCKey struct {
+	Command ecfr.CommandType
+	// position or station address of physical commands, logical address
+	// otherwise
+	Address uint32
+}
+
+func (k WCKey) String() string {
+	return fmt.Sprintf("%v %#x", k.Command, k.Address)
+}
+
+type Stats struct {
+	Cycles        uint64
+	CycleDuration Histogram
+
+	Commands     uint64
+	LostCommands uint64
+	// incoming datagrams not matching a command
+	UnmatchedDatagrams uint64
+	WCErrors           map[WCKey]uint64
+
+	// link layer
+	FramesSent     uint64
+	FramesReceived uint64
+	CycleStretches uint64
+}
+
+// Collector implements ecmd.Collector and udp.Collector, set it as the
+// Collector of a CommandFramer and its Framer. it is an http.Handler serving
+// the Prometheus text format and is safe for concurrent use.
+type Collector struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func New() *Collector {
+	return NewWithBuckets(DefaultBuckets)
+}
+
+func NewWithBuckets(buckets []time.Duration) *Collector {
+	c := &Collector{}
+	c.stats.CycleDuration = newHistogram(buckets)
+	c.stats.WCErrors = make(map[WCKey]uint64)
+	return c
+}
+
+func (c *Collector) CycleDone(s ecmd.CycleStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Cycles++
+	c.stats.CycleDuration.Observe(s.Duration)
+	c.stats.Commands += uint64(s.Commands)
+	c.stats.LostCommands += uint64(s.Lost)
+	c.stats.UnmatchedDatagrams += uint64(s.Unmatched)
+}
+
+func (c *Collector) WorkingCounterError(e ecmd.WorkingCounterError) {
+	addr := ecfr.DatagramAddressFromCommand(e.Addr32, e.Command)
+	k := WCKey{Command: e.Command, Address: e.Addr32}
+	if addr.IsPhysical() {
+		k.Address = uint32(addr.PositionOrAddress())
+	}
+
+	c.mu.Lock()
+	c.stats.WCErrors[k]++
+	c.mu.Unlock()
+}
+
+func (c *Collector) FramerCycle(sent, received int) {
+	c.mu.Lock()
+	c.stats.FramesSent += uint64(sent)
+	c.stats.FramesReceived += uint64(received)
+	c.mu.Unlock()
+}
+
+func (c *Collector) CycleStretched() {
+	c.mu.Lock()
+	c.stats.CycleStretches++
+	c.mu.Unlock()
+}
+
+// Stats returns a copy of the statistics collected so far.
+func (c *Collector) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.CycleDuration = s.CycleDuration.copy()
+	s.WCErrors = make(map[WCKey]uint64, len(c.stats.WCErrors))
+	for k, v := range c.stats.WCErrors {
+		s.WCErrors[k] = v
+	}
+	return s
+}
+
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buckets := c.stats.CycleDuration.Buckets
+	c.stats = Stats{}
+	c.stats.CycleDuration = newHistogram(buckets)
+	c.stats.WCErrors = make(map[WCKey]uint64)
+}
+
+// ServeHTTP serves the statistics in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WritePrometheus(w)
+}
+
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	s := c.Stats()
+	pw := &promWriter{w: w}
+
+	pw.metric("ecat_cycles_total", "counter", "Cycles of the command framer.", s.Cycles)
+	pw.histogram("ecat_cycle_duration_seconds", "Duration of command framer cycles.", s.CycleDuration)
+	pw.metric("ecat_commands_total", "counter", "Commands sent.", s.Commands)
+	pw.metric("ecat_commands_lost_total", "counter", "Commands that did not come back.", s.LostCommands)
+	pw.metric("ecat_datagrams_unmatched_total", "counter", "Incoming datagrams not matching a command.", s.UnmatchedDatagrams)
+
+	pw.header("ecat_working_counter_errors_total", "counter", "Working counter errors by command and slave.")
+	keys := make([]WCKey, 0, len(s.WCErrors))
+	for k := range s.WCErrors {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Command != keys[j].Command {
+			return keys[i].Command < keys[j].Command
+		}
+		return keys[i].Address < keys[j].Address
+	})
+	for _, k := range keys {
+		pw.printf("ecat_working_counter_errors_total{command=%q,address=\"%#x\"} %d\n", k.Command.String(), k.Address, s.WCErrors[k])
+	}
+
+	pw.metric("ecat_frames_sent_total", "counter", "Frames sent by the link layer.", s.FramesSent)
+	pw.metric("ecat_frames_received_total", "counter", "Frames received by the link layer.", s.FramesReceived)
+	pw.metric("ecat_cycle_stretches_total", "counter", "Receive deadline extensions waiting for missing frames.", s.CycleStretches)
+
+	return pw.err
+}
+
+// keeps the first write error
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (pw *promWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func (pw *promWriter) header(name, typ, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (pw *promWriter) metric(name, typ, help string, v uint64) {
+	pw.header(name, typ, help)
+	pw.printf("%s %d\n", name, v)
+}
+
+func (pw *promWriter) histogram(name, help string, h Histogram) {
+	pw.header(name, "histogram", help)
+	for i, b := range h.Buckets {
+		pw.printf("%s_bucket{le=\"%g\"} %d\n", name, b.Seconds(), h.Counts[i])
+	}
+	pw.printf("%s_bucket{le=\"+Inf\"} %d\n", name, h.Count())
+	pw.printf("%s_sum %g\n", name, h.Sum.Seconds())
+	pw.printf("%s_count %d\n", name, h.Count())
+}
@@ -0,0 +1,77 @@
+package ecstat
+
+import (
+	"bytes"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	c := New()
+	bus := &sim.L2Bus{Slaves: []sim.FrameProcessor{sim.NewL2Slave()}}
+	ff := sim.NewFaultFramer(bus, sim.FaultScript{1: {Drop: true}}, 1)
+	cf := ecmd.NewCommandFramer(ff)
+	cf.Collector = c
+
+	// arrives, but with the wrong working counter
+	_, err := ecmd.ExecuteRead8(cf, ecfr.PositionalAddr(0, ecad.Type), 2)
+	if !ecmd.IsWorkingCounterError(err) {
+		t.Fatalf("want working counter error, have %v", err)
+	}
+	// lost once, then arrives
+	if _, err = ecmd.ExecuteRead8(cf, ecfr.FixedAddr(0, ecad.Type), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	s := c.Stats()
+	if s.Cycles != 3 || s.Commands != 3 || s.LostCommands != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.CycleDuration.Count() != 3 {
+		t.Fatalf("want 3 observed cycle durations, have %d", s.CycleDuration.Count())
+	}
+	if n := s.WCErrors[WCKey{ecfr.APRD, 0}]; n != 1 {
+		t.Fatalf("want 1 working counter error for APRD at position 0, have %v", s.WCErrors)
+	}
+
+	c.FramerCycle(2, 1)
+	c.CycleStretched()
+
+	var b bytes.Buffer
+	if err = c.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"ecat_cycles_total 3",
+		"ecat_cycle_duration_seconds_count 3",
+		`ecat_cycle_duration_seconds_bucket{le="+Inf"} 3`,
+		"ecat_commands_lost_total 1",
+		`ecat_working_counter_errors_total{command="APRD",address="0x0"} 1`,
+		"ecat_frames_sent_total 2",
+		"ecat_frames_received_total 1",
+		"ecat_cycle_stretches_total 1",
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("exposition lacks %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]time.Duration{time.Millisecond, 10 * time.Millisecond})
+	h.Observe(500 * time.Microsecond)
+	h.Observe(5 * time.Millisecond)
+	h.Observe(time.Second)
+
+	want := []uint64{1, 2, 3}
+	for i, w := range want {
+		if h.Counts[i] != w {
+			t.Fatalf("bucket %d: want %d, have %d", i, w, h.Counts[i])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package ecwd
+
+import (
+	"context"
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"time"
+)
+
+const (
+	// the watchdog divider counts periods of the 25 MHz ESC clock
+	ClockPeriod = 40 * time.Nanosecond
+	// the divider of the ESC reset value, 100 µs
+	DefaultUnit = 2500 * ClockPeriod
+
+	MaxUnit = (0xffff + 2) * ClockPeriod
+	MinUnit = 2 * ClockPeriod
+
+	smWatchdogTrigger = 0x40
+)
+
+// Config sets the watchdogs of a slave. times are rounded up to multiples
+// of Unit, zero times disable a watchdog.
+type Config struct {
+	// resolution of the watchdog times, 0 for DefaultUnit
+	Unit        time.Duration
+	ProcessData time.Duration
+	PDI         time.Duration
+}
+
+func (cfg Config) unit() time.Duration {
+	if cfg.Unit == 0 {
+		return DefaultUnit
+	}
+	return cfg.Unit
+}
+
+// Registers returns the register values for cfg. the unit is rounded to a
+// multiple of ClockPeriod.
+func (cfg Config) Registers() (wd ecad.Watchdog, err error) {
+	u := cfg.unit()
+	if u < MinUnit || u > MaxUnit {
+		return wd, fmt.Errorf("watchdog unit %v is not within %v and %v", u, MinUnit, MaxUnit)
+	}
+	div := (u + ClockPeriod/2) / ClockPeriod
+	wd.Divider = uint16(div - 2)
+	u = div * ClockPeriod
+
+	units := func(what string, d time.Duration) (uint16, error) {
+		if d < 0 {
+			return 0, fmt.Errorf("negative %s watchdog time %v", what, d)
+		}
+		n := (d + u - 1) / u
+		if n > 0xffff {
+			return 0, fmt.Errorf("%s watchdog time %v exceeds %v in units of %v", what, d, 0xffff*u, u)
+		}
+		return uint16(n), nil
+	}
+	if wd.TimeProcessData, err = units("process data", cfg.ProcessData); err != nil {
+		return
+	}
+	wd.TimePDI, err = units("PDI", cfg.PDI)
+	return
+}
+
+// ConfigOf returns the configuration set in the registers of wd.
+func ConfigOf(wd ecad.Watchdog) Config {
+	u := time.Duration(wd.Divider+2) * ClockPeriod
+	return Config{
+		Unit:        u,
+		ProcessData: time.Duration(wd.TimeProcessData) * u,
+		PDI:         time.Duration(wd.TimePDI) * u,
+	}
+}
+
+func put16(v uint16) []byte {
+	return []byte{uint8(v), uint8(v >> 8)}
+}
+
+// Configure writes cfg to the slaves addressed by addr. the offset of addr
+// is ignored, expwc is the expected working counter of each write.
+func Configure(ctx context.Context, c ecmd.Commander, addr ecfr.DatagramAddress, expwc uint16, cfg Config) (err error) {
+	wd, err := cfg.Registers()
+	if err != nil {
+		return
+	}
+
+	b := ecmd.Batch{}
+	for _, w := range []struct {
+		offs uint16
+		v    uint16
+	}{
+		{ecad.WatchdogDivider, wd.Divider},
+		{ecad.WatchdogTimePDI, wd.TimePDI},
+		{ecad.WatchdogTimeProcessData, wd.TimeProcessData},
+	} {
+		addr.SetOffset(w.offs)
+		b.Write(addr, put16(w.v), expwc)
+	}
+	if err = b.ExecuteContext(ctx, c); err != nil {
+		return
+	}
+	return b.Err()
+}
+
+// EnableTrigger sets whether writes to sync manager sm of the slave at addr
+// trigger the process data watchdog. sync managers only take configuration
+// changes while they are disabled, so this goes before their activation.
+func EnableTrigger(ctx context.Context, c ecmd.Commander, addr ecfr.DatagramAddress, sm int, enable bool) (err error) {
+	addr.SetOffset(uint16(ecad.SyncMangerBase + sm*ecad.SyncManagerChannelLen + ecad.SyncManagerControlOffset))
+	d, _, err := ecmd.ExecuteReadContext(ctx, c, addr, 1, 1, ecmd.Options{})
+	if err != nil {
+		return
+	}
+
+	control := d[0] &^ smWatchdogTrigger
+	if enable {
+		control |= smWatchdogTrigger
+	}
+	_, err = ecmd.ExecuteWriteContext(ctx, c, addr, []byte{control}, 1, ecmd.Options{})
+	return
+}
+
+// Read reads the watchdog registers of the slave at addr.
+func Read(ctx context.Context, c ecmd.Commander, addr ecfr.DatagramAddress) (wd ecad.Watchdog, err error) {
+	addr.SetOffset(ecad.WatchdogDivider)
+	d, _, err := ecmd.ExecuteReadContext(ctx, c, addr, wd.Len(), 1, ecmd.Options{})
+	if err != nil {
+		return
+	}
+	err = wd.Decode(d)
+	return
+}
+
+// ClearCounters clears the expiration counters of the slaves addressed by
+// addr.
+func ClearCounters(ctx context.Context, c ecmd.Commander, addr ecfr.DatagramAddress, expwc uint16) (err error) {
+	// writing either counter clears both
+	addr.SetOffset(ecad.WatchdogCounterProcessData)
+	_, err = ecmd.ExecuteWriteContext(ctx, c, addr, make([]byte, 2), expwc, ecmd.Options{})
+	return
+}
@@ -0,0 +1,120 @@
+package ecwd
+
+import (
+	"context"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+	"time"
+)
+
+func TestRegisters(t *testing.T) {
+	wd, err := Config{ProcessData: 100 * time.Millisecond}.Registers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd.Divider != 2498 || wd.TimeProcessData != 1000 || wd.TimePDI != 0 {
+		t.Fatalf("unexpected registers %+v", wd)
+	}
+
+	cfg := Config{Unit: time.Millisecond, ProcessData: 2500 * time.Microsecond, PDI: time.Second}
+	if wd, err = cfg.Registers(); err != nil {
+		t.Fatal(err)
+	}
+	if wd.Divider != 24998 || wd.TimeProcessData != 3 || wd.TimePDI != 1000 {
+		t.Fatalf("unexpected registers %+v", wd)
+	}
+	if have := ConfigOf(wd); have != (Config{time.Millisecond, 3 * time.Millisecond, time.Second}) {
+		t.Fatalf("registers decode to %+v", have)
+	}
+
+	for _, cfg := range []Config{
+		{Unit: 10 * time.Millisecond},
+		{Unit: ClockPeriod},
+		{ProcessData: 10 * time.Second},
+		{PDI: -time.Millisecond},
+	} {
+		if _, err = cfg.Registers(); err == nil {
+			t.Fatalf("accepted %+v", cfg)
+		}
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	bus := &sim.L2Bus{CycleTime: time.Millisecond}
+	var slaves []*sim.L2Slave
+	for i := 0; i < 2; i++ {
+		s := sim.NewL2Slave()
+		slaves = append(slaves, s)
+		bus.Slaves = append(bus.Slaves, s)
+	}
+	// outputs of slave 1 in sync manager 2
+	*slaves[1].SyncManagers[2] = sim.SyncManager{Start: 0x1100, Length: 4, Control: 0x04, Activate: 0x01}
+
+	c := ecmd.NewCommandFramer(bus)
+	ctx := context.Background()
+	if err := Configure(ctx, c, ecfr.BroadcastAddr(0), 2, Config{ProcessData: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableTrigger(ctx, c, ecfr.PositionalAddr(-1, 0), 2, true); err != nil {
+		t.Fatal(err)
+	}
+	if slaves[1].SyncManagers[2].Control != 0x44 {
+		t.Fatalf("watchdog trigger not enabled, control %#02x", slaves[1].SyncManagers[2].Control)
+	}
+	wd, err := Read(ctx, c, ecfr.PositionalAddr(-1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := ConfigOf(wd); cfg.ProcessData != 10*time.Millisecond {
+		t.Fatalf("read back %+v", cfg)
+	}
+
+	outputs := func(cycles int) {
+		t.Helper()
+		for i := 0; i < cycles; i++ {
+			if _, err := ecmd.ExecuteWriteContext(ctx, c, ecfr.PositionalAddr(-1, 0x1100), []byte{1, 2, 3, 4}, 1, ecmd.Options{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	m := NewMonitor()
+	check := func() []Status {
+		t.Helper()
+		st, err := m.Check(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(st) != 2 {
+			t.Fatalf("want 2 slaves, have %v", st)
+		}
+		return st
+	}
+
+	outputs(20)
+	if exp := Expired(check()); len(exp) != 0 {
+		t.Fatalf("watchdogs expired while cycling: %v", exp)
+	}
+
+	// the master stalls
+	bus.SimTime += 50 * time.Millisecond
+	st := check()
+	exp := Expired(st)
+	if len(exp) != 1 || exp[0].Position != 1 || exp[0].Watchdog.ProcessDataOK || exp[0].ProcessDataExpirations != 1 {
+		t.Fatalf("want the watchdog of slave 1 expired, have %v", st)
+	}
+
+	outputs(1)
+	st = check()
+	if exp = Expired(st); len(exp) != 0 || st[1].Watchdog.CounterProcessData != 1 {
+		t.Fatalf("watchdog did not recover: %v", st)
+	}
+
+	if err = m.Clear(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if slaves[1].Watchdog.CounterProcessData != 0 {
+		t.Fatalf("counter not cleared")
+	}
+}
@@ -0,0 +1,131 @@
+package ecwd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+)
+
+// Status is the watchdog state of a slave.
+type Status struct {
+	Position int
+	Watchdog ecad.Watchdog
+	// the PDI watchdog, from the DL status
+	PDIOK bool
+	// expirations since the previous check, from the counters
+	ProcessDataExpirations int
+	PDIExpirations         int
+}
+
+// Expired reports whether a watchdog of the slave is expired or expired
+// since the previous check.
+func (s Status) Expired() bool {
+	return !s.Watchdog.ProcessDataOK || !s.PDIOK || s.ProcessDataExpirations > 0 || s.PDIExpirations > 0
+}
+
+func (s Status) String() string {
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintf(b, "%d: process data ", s.Position)
+	if s.Watchdog.ProcessDataOK {
+		fmt.Fprintf(b, "ok")
+	} else {
+		fmt.Fprintf(b, "expired")
+	}
+	fmt.Fprintf(b, " (+%d), pdi ", s.ProcessDataExpirations)
+	if s.PDIOK {
+		fmt.Fprintf(b, "ok")
+	} else {
+		fmt.Fprintf(b, "expired")
+	}
+	fmt.Fprintf(b, " (+%d)", s.PDIExpirations)
+	return b.String()
+}
+
+// Monitor reads the watchdog state of all slaves and tracks the
+// expiration counters from check to check.
+type Monitor struct {
+	Options ecmd.Options
+
+	prev []ecad.Watchdog
+}
+
+func NewMonitor() *Monitor {
+	return &Monitor{}
+}
+
+// Check reads the watchdogs of all slaves. the expirations of the first
+// check count from 0.
+func (m *Monitor) Check(ctx context.Context, c ecmd.Commander) (st []Status, err error) {
+	n, err := ecmd.CountSlaves(ctx, c, m.Options)
+	if err != nil {
+		return
+	}
+
+	b := ecmd.Batch{Options: m.Options}
+	type reads struct{ dl, wd *ecmd.BatchOp }
+	ops := make([]reads, n)
+	for i := range ops {
+		pos := int16(-i)
+		ops[i].dl = b.Read(ecfr.PositionalAddr(pos, ecad.DLStatus), ecad.DLStatusReg{}.Len(), 1)
+		ops[i].wd = b.Read(ecfr.PositionalAddr(pos, ecad.WatchdogDivider), ecad.Watchdog{}.Len(), 1)
+	}
+	if err = b.ExecuteContext(ctx, c); err != nil {
+		return
+	}
+	if err = b.Err(); err != nil {
+		return
+	}
+
+	st = make([]Status, n)
+	for i, o := range ops {
+		s := &st[i]
+		s.Position = i
+		var dl ecad.DLStatusReg
+		if err = dl.Decode(o.dl.Data); err != nil {
+			return nil, err
+		}
+		s.PDIOK = dl.PDIWatchdogOK
+		if err = s.Watchdog.Decode(o.wd.Data); err != nil {
+			return nil, err
+		}
+
+		var prev ecad.Watchdog
+		if i < len(m.prev) {
+			prev = m.prev[i]
+		}
+		s.ProcessDataExpirations = int(ecmd.CounterIncrement(s.Watchdog.CounterProcessData, prev.CounterProcessData))
+		s.PDIExpirations = int(ecmd.CounterIncrement(s.Watchdog.CounterPDI, prev.CounterPDI))
+	}
+
+	m.prev = m.prev[:0]
+	for _, s := range st {
+		m.prev = append(m.prev, s.Watchdog)
+	}
+	return
+}
+
+// Expired returns the slaves of st with expired watchdogs.
+func Expired(st []Status) (exp []Status) {
+	for _, s := range st {
+		if s.Expired() {
+			exp = append(exp, s)
+		}
+	}
+	return
+}
+
+// Clear clears the expiration counters of all slaves.
+func (m *Monitor) Clear(ctx context.Context, c ecmd.Commander) (err error) {
+	n, err := ecmd.CountSlaves(ctx, c, m.Options)
+	if err != nil {
+		return
+	}
+	if err = ClearCounters(ctx, c, ecfr.BroadcastAddr(0), uint16(n)); err != nil {
+		return
+	}
+	m.prev = nil
+	return
+}
@@ -0,0 +1,312 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	EtherTypeEtherCAT = 0x88a4
+	EtherCATUDPPort   = 0x88a4
+
+	ethHeaderLen  = 14
+	ethMinLen     = 60
+	ipv4HeaderLen = 20
+	udpHeaderLen  = 8
+)
+
+// Encapsulation selects how EtherCAT frames are stored in the capture.
+type Encapsulation int
+
+const (
+	// raw Ethernet frames with EtherType 0x88a4
+	EncapEthernet Encapsulation = iota
+	// Ethernet, IPv4 and UDP to port 0x88a4, as used by the UDP link layer
+	EncapUDP
+)
+
+var (
+	// EtherCAT masters usually send with this source address. slaves set
+	// the locally administered bit on the way, which makes returning frames
+	// distinguishable.
+	masterMAC    = []byte{0x01, 0x01, 0x01, 0x01, 0x01, 0x01}
+	returnedMAC  = []byte{0x03, 0x01, 0x01, 0x01, 0x01, 0x01}
+	broadcastMAC = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+// Encapsulator builds the captured packets around EtherCAT frames.
+type Encapsulator struct {
+	Encapsulation Encapsulation
+	// addresses for EncapUDP, defaults are 0.0.0.0 and 255.255.255.255
+	SrcIP, DstIP net.IP
+
+	buf []byte
+}
+
+// Packet returns the packet for the EtherCAT frame b. the packet is valid
+// until the next call.
+func (e *Encapsulator) Packet(dir Direction, b []byte) []byte {
+	n := ethHeaderLen + len(b)
+	if e.Encapsulation == EncapUDP {
+		n += ipv4HeaderLen + udpHeaderLen
+	}
+	if n < ethMinLen {
+		n = ethMinLen
+	}
+	if cap(e.buf) < n {
+		e.buf = make([]byte, n)
+	}
+	p := e.buf[:n]
+	for i := range p {
+		p[i] = 0
+	}
+
+	copy(p[0:6], broadcastMAC)
+	if dir == DirectionInbound {
+		copy(p[6:12], returnedMAC)
+	} else {
+		copy(p[6:12], masterMAC)
+	}
+
+	if e.Encapsulation != EncapUDP {
+		p[12], p[13] = EtherTypeEtherCAT>>8, EtherTypeEtherCAT&0xff
+		copy(p[ethHeaderLen:], b)
+		return p
+	}
+
+	p[12], p[13] = 0x08, 0x00
+
+	ip := p[ethHeaderLen:]
+	iplen := ipv4HeaderLen + udpHeaderLen + len(b)
+	ip[0] = 0x45
+	ip[2], ip[3] = uint8(iplen>>8), uint8(iplen)
+	ip[8] = 64 // ttl
+	ip[9] = 17 // udp
+	copy(ip[12:16], ipv4Or(e.SrcIP, net.IPv4zero))
+	copy(ip[16:20], ipv4Or(e.DstIP, net.IPv4bcast))
+	sum := ipChecksum(ip[:ipv4HeaderLen])
+	ip[10], ip[11] = uint8(sum>>8), uint8(sum)
+
+	udp := ip[ipv4HeaderLen:]
+	udplen := udpHeaderLen + len(b)
+	udp[0], udp[1] = EtherCATUDPPort>>8, EtherCATUDPPort&0xff
+	udp[2], udp[3] = EtherCATUDPPort>>8, EtherCATUDPPort&0xff
+	udp[4], udp[5] = uint8(udplen>>8), uint8(udplen)
+	// a zero udp checksum means none was computed
+	copy(udp[udpHeaderLen:], b)
+
+	return p
+}
+
+// Decapsulate returns the EtherCAT frame in the Ethernet packet pkt, sent
+// as raw Ethernet, with a VLAN tag or over UDP. Ethernet padding is cut off.
+// the direction is told by the source address, slaves mark returning frames
+// as locally administered.
+func Decapsulate(pkt []byte) (b []byte, dir Direction, err error) {
+	if len(pkt) < ethHeaderLen {
+		err = errors.New("packet too short for an Ethernet header")
+		return
+	}
+
+	dir = DirectionOutbound
+	if pkt[6]&0x02 != 0 {
+		dir = DirectionInbound
+	}
+
+	typ := binary.BigEndian.Uint16(pkt[12:])
+	b = pkt[ethHeaderLen:]
+	if typ == 0x8100 {
+		if len(b) < 4 {
+			err = errors.New("packet too short for a VLAN tag")
+			return
+		}
+		typ = binary.BigEndian.Uint16(b[2:])
+		b = b[4:]
+	}
+
+	switch typ {
+	case EtherTypeEtherCAT:
+	case 0x0800:
+		b, err = udpPayload(b)
+		if err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("not an EtherCAT packet, EtherType %#04x", typ)
+		return
+	}
+
+	if len(b) < ecfr.FrameOverheadLen {
+		err = errors.New("packet too short for an EtherCAT frame header")
+		return
+	}
+	n := int(binary.LittleEndian.Uint16(b)&((1<<11)-1)) + ecfr.FrameOverheadLen
+	if n > len(b) {
+		err = fmt.Errorf("EtherCAT frame of %d bytes exceeds packet", n)
+		return
+	}
+	b = b[:n]
+	return
+}
+
+func udpPayload(ip []byte) (b []byte, err error) {
+	if len(ip) < ipv4HeaderLen || ip[0]>>4 != 4 {
+		err = errors.New("bad IPv4 header")
+		return
+	}
+	ihl := int(ip[0]&0x0f) * 4
+	iplen := int(binary.BigEndian.Uint16(ip[2:]))
+	if ihl < ipv4HeaderLen || iplen < ihl+udpHeaderLen || iplen > len(ip) {
+		err = errors.New("bad IPv4 header")
+		return
+	}
+	if ip[9] != 17 {
+		err = fmt.Errorf("not an EtherCAT packet, IP protocol %d", ip[9])
+		return
+	}
+
+	udp := ip[ihl:iplen]
+	if binary.BigEndian.Uint16(udp[2:]) != EtherCATUDPPort {
+		err = fmt.Errorf("not an EtherCAT packet, UDP port %d", binary.BigEndian.Uint16(udp[2:]))
+		return
+	}
+	return udp[udpHeaderLen:], nil
+}
+
+func ipv4Or(ip, def net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return def.To4()
+}
+
+func ipChecksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(h); i += 2 {
+		sum += uint32(h[i])<<8 | uint32(h[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// CaptureFramer wraps an ecmd.Framer and writes all outgoing and incoming
+// frames to a pcapng capture. errors writing the capture do not disturb
+// cycling, the first one is kept in Err.
+type CaptureFramer struct {
+	Framer ecmd.Framer
+	Err    error
+
+	w       *Writer
+	encap   Encapsulator
+	oframes []*ecfr.Frame
+	closer  io.Closer
+	flusher *bufio.Writer
+}
+
+func NewCaptureFramer(framer ecmd.Framer, w io.Writer, encap Encapsulation) (*CaptureFramer, error) {
+	pw, err := NewWriter(w, LinkTypeEthernet, DefaultSnapLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptureFramer{
+		Framer: framer,
+		w:      pw,
+		encap:  Encapsulator{Encapsulation: encap},
+	}, nil
+}
+
+// CreateCaptureFile captures to a new file at path, the file is closed with
+// the framer.
+func CreateCaptureFile(framer ecmd.Framer, path string, encap Encapsulation) (*CaptureFramer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriter(f)
+	cf, err := NewCaptureFramer(framer, bw, encap)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	cf.closer = f
+	cf.flusher = bw
+	return cf, nil
+}
+
+func (f *CaptureFramer) New(maxdatalen int) (*ecfr.Frame, error) {
+	fr, err := f.Framer.New(maxdatalen)
+	if err != nil {
+		return nil, err
+	}
+	f.oframes = append(f.oframes, fr)
+	return fr, nil
+}
+
+func (f *CaptureFramer) Cycle() (iframes []*ecfr.Frame, err error) {
+	defer func() {
+		f.oframes = f.oframes[:0]
+	}()
+
+	for _, fr := range f.oframes {
+		f.capture(DirectionOutbound, fr)
+	}
+
+	iframes, err = f.Framer.Cycle()
+	for _, fr := range iframes {
+		f.capture(DirectionInbound, fr)
+	}
+	return
+}
+
+func (f *CaptureFramer) capture(dir Direction, fr *ecfr.Frame) {
+	if f.Err != nil {
+		return
+	}
+
+	b, err := fr.Commit()
+	if err != nil {
+		// nothing the link layer could send
+		return
+	}
+	f.Err = f.w.WritePacket(time.Now(), dir, f.encap.Packet(dir, b))
+}
+
+func (f *CaptureFramer) Close() (err error) {
+	if c, ok := f.Framer.(interface {
+		Close() error
+	}); ok {
+		err = c.Close()
+	}
+
+	if f.flusher != nil {
+		if ferr := f.flusher.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	if f.closer != nil {
+		if cerr := f.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+func (f *CaptureFramer) DebugMessage(m string) {
+	if dm, ok := f.Framer.(interface {
+		DebugMessage(string)
+	}); ok {
+		dm.DebugMessage(m)
+	}
+}
@@ -0,0 +1,124 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+)
+
+type testBlock struct {
+	typ  uint32
+	body []byte
+}
+
+func splitBlocks(t *testing.T, b []byte) (blocks []testBlock) {
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block header")
+		}
+		typ := binary.LittleEndian.Uint32(b)
+		total := binary.LittleEndian.Uint32(b[4:])
+		if total < 12 || int(total) > len(b) || total%4 != 0 {
+			t.Fatalf("bad block length %d", total)
+		}
+		if trailer := binary.LittleEndian.Uint32(b[total-4:]); trailer != total {
+			t.Fatalf("block trailer %d does not match length %d", trailer, total)
+		}
+		blocks = append(blocks, testBlock{typ, b[8 : total-4]})
+		b = b[total:]
+	}
+	return
+}
+
+func captureTestCycle(t *testing.T, encap Encapsulation) []testBlock {
+	bus := &sim.L2Bus{Slaves: []sim.FrameProcessor{sim.NewL2Slave(), sim.NewL2Slave()}}
+
+	var buf bytes.Buffer
+	cf, err := NewCaptureFramer(bus, &buf, encap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ecmd.NewCommandFramer(cf)
+
+	_, err = ecmd.ExecuteRead16(c, ecfr.PositionalAddr(-1, ecad.Type), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.Err != nil {
+		t.Fatalf("capture failed: %v", cf.Err)
+	}
+
+	return splitBlocks(t, buf.Bytes())
+}
+
+func TestCaptureEthernet(t *testing.T) {
+	blocks := captureTestCycle(t, EncapEthernet)
+	if len(blocks) != 4 {
+		t.Fatalf("want SHB, IDB and 2 EPBs, have %d blocks", len(blocks))
+	}
+
+	if blocks[0].typ != blockTypeSHB || binary.LittleEndian.Uint32(blocks[0].body) != byteOrderMagic {
+		t.Fatalf("bad section header block")
+	}
+	if blocks[1].typ != blockTypeIDB || binary.LittleEndian.Uint16(blocks[1].body) != LinkTypeEthernet {
+		t.Fatalf("bad interface description block")
+	}
+
+	for i, dir := range []Direction{DirectionOutbound, DirectionInbound} {
+		epb := blocks[2+i]
+		if epb.typ != blockTypeEPB {
+			t.Fatalf("block %d is not an enhanced packet block", 2+i)
+		}
+		caplen := int(binary.LittleEndian.Uint32(epb.body[12:]))
+		pkt := epb.body[20 : 20+caplen]
+		if caplen != ethMinLen {
+			t.Fatalf("want padded packet of %d bytes, have %d", ethMinLen, caplen)
+		}
+		if pkt[12] != 0x88 || pkt[13] != 0xa4 {
+			t.Fatalf("bad ethertype %#02x%02x", pkt[12], pkt[13])
+		}
+
+		opts := epb.body[20+pad4(caplen):]
+		if binary.LittleEndian.Uint16(opts) != optEPBFlags || Direction(binary.LittleEndian.Uint32(opts[4:])) != dir {
+			t.Fatalf("packet %d: want direction %v", i, dir)
+		}
+	}
+
+	// the second slave incremented the working counter of the returning frame
+	for i, wantwc := range []uint16{0, 1} {
+		caplen := int(binary.LittleEndian.Uint32(blocks[2+i].body[12:]))
+		pkt := blocks[2+i].body[20+ethHeaderLen : 20+caplen]
+
+		var fr ecfr.Frame
+		if _, err := fr.Overlay(append([]byte(nil), pkt...)); err != nil {
+			t.Fatalf("packet %d does not hold an EtherCAT frame: %v", i, err)
+		}
+		if len(fr.Datagrams) != 1 || fr.Datagrams[0].WorkingCounter != wantwc {
+			t.Fatalf("packet %d: want 1 datagram with working counter %d", i, wantwc)
+		}
+	}
+}
+
+func TestCaptureUDP(t *testing.T) {
+	blocks := captureTestCycle(t, EncapUDP)
+	if len(blocks) != 4 {
+		t.Fatalf("want SHB, IDB and 2 EPBs, have %d blocks", len(blocks))
+	}
+
+	pkt := blocks[2].body[20:]
+	if pkt[12] != 0x08 || pkt[13] != 0x00 {
+		t.Fatalf("want IPv4 ethertype")
+	}
+	ip := pkt[ethHeaderLen:]
+	if ipChecksum(ip[:ipv4HeaderLen]) != 0 {
+		t.Fatalf("bad IPv4 header checksum")
+	}
+	udp := ip[ipv4HeaderLen:]
+	if binary.BigEndian.Uint16(udp[2:]) != EtherCATUDPPort {
+		t.Fatalf("bad destination port %d", binary.BigEndian.Uint16(udp[2:]))
+	}
+}
@@ -0,0 +1,137 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	LinkTypeEthernet = 1
+
+	blockTypeSHB = 0x0a0d0d0a
+	blockTypeIDB = 0x00000001
+	blockTypeEPB = 0x00000006
+
+	byteOrderMagic = 0x1a2b3c4d
+
+	optEndOfOpt = 0
+	optEPBFlags = 2
+
+	DefaultSnapLen = 65535
+)
+
+// Direction of a packet, stored in the flags of enhanced packet blocks.
+type Direction uint8
+
+const (
+	DirectionUnknown  Direction = 0
+	DirectionInbound  Direction = 1
+	DirectionOutbound Direction = 2
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "inbound"
+	case DirectionOutbound:
+		return "outbound"
+	}
+	return "unknown"
+}
+
+var byteOrder = binary.LittleEndian
+
+// Writer writes a pcapng file with one interface. timestamps have
+// microsecond resolution.
+type Writer struct {
+	w       io.Writer
+	snaplen uint32
+	buf     []byte
+}
+
+// NewWriter writes the section header and interface description and
+// returns a Writer for the packets.
+func NewWriter(w io.Writer, linktype uint16, snaplen uint32) (pw *Writer, err error) {
+	if snaplen == 0 {
+		snaplen = DefaultSnapLen
+	}
+	pw = &Writer{w: w, snaplen: snaplen}
+
+	// byte order magic, version 1.0, unspecified section length
+	shb := make([]byte, 16)
+	byteOrder.PutUint32(shb, byteOrderMagic)
+	byteOrder.PutUint16(shb[4:], 1)
+	byteOrder.PutUint16(shb[6:], 0)
+	byteOrder.PutUint64(shb[8:], 0xffffffffffffffff)
+	if err = pw.writeBlock(blockTypeSHB, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	byteOrder.PutUint16(idb, linktype)
+	byteOrder.PutUint32(idb[4:], snaplen)
+	if err = pw.writeBlock(blockTypeIDB, idb); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+// WritePacket writes data as an enhanced packet block of the interface.
+func (pw *Writer) WritePacket(ts time.Time, dir Direction, data []byte) error {
+	caplen := len(data)
+	if caplen > int(pw.snaplen) {
+		caplen = int(pw.snaplen)
+	}
+
+	// fixed part, padded data, flags option and end of options
+	n := 20 + pad4(caplen) + 8 + 4
+	if cap(pw.buf) < n {
+		pw.buf = make([]byte, n)
+	}
+	b := pw.buf[:n]
+	for i := range b {
+		b[i] = 0
+	}
+
+	us := uint64(ts.UnixNano() / int64(time.Microsecond))
+	byteOrder.PutUint32(b[0:], 0) // interface id
+	byteOrder.PutUint32(b[4:], uint32(us>>32))
+	byteOrder.PutUint32(b[8:], uint32(us))
+	byteOrder.PutUint32(b[12:], uint32(caplen))
+	byteOrder.PutUint32(b[16:], uint32(len(data)))
+	copy(b[20:], data[:caplen])
+
+	o := 20 + pad4(caplen)
+	byteOrder.PutUint16(b[o:], optEPBFlags)
+	byteOrder.PutUint16(b[o+2:], 4)
+	byteOrder.PutUint32(b[o+4:], uint32(dir))
+	byteOrder.PutUint16(b[o+8:], optEndOfOpt)
+
+	return pw.writeBlock(blockTypeEPB, b)
+}
+
+func (pw *Writer) writeBlock(typ uint32, body []byte) error {
+	if len(body)%4 != 0 {
+		return errors.New("pcapng block body is not padded to 32 bits")
+	}
+	total := uint32(12 + len(body))
+
+	var hdr [8]byte
+	byteOrder.PutUint32(hdr[0:], typ)
+	byteOrder.PutUint32(hdr[4:], total)
+	if _, err := pw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := pw.w.Write(body); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(hdr[4:8])
+	return err
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,300 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	blockTypeSPB = 0x00000003
+
+	optIDBTSResol = 9
+
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+
+	// sanity limit against corrupt block lengths
+	maxBlockLen = 16 << 20
+)
+
+// Packet is a packet read from a capture.
+type Packet struct {
+	Timestamp time.Time
+	// from the flags of enhanced packet blocks, DirectionUnknown if the
+	// capture does not record it
+	Direction Direction
+	LinkType  uint16
+	Data      []byte
+}
+
+type iface struct {
+	linktype uint16
+	// timestamp units per second
+	tsres uint64
+}
+
+// Reader reads packets from pcapng files and classic pcap files.
+type Reader struct {
+	r      io.Reader
+	bo     binary.ByteOrder
+	ng     bool
+	ifaces []iface
+}
+
+// NewReader detects the file format and reads the file header.
+func NewReader(r io.Reader) (pr *Reader, err error) {
+	pr = &Reader{r: r}
+
+	var magic [4]byte
+	if _, err = io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("reading capture header: %v", err)
+	}
+
+	if binary.LittleEndian.Uint32(magic[:]) == blockTypeSHB {
+		pr.ng = true
+		if err = pr.readSHB(); err != nil {
+			return nil, err
+		}
+		return pr, nil
+	}
+
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch bo.Uint32(magic[:]) {
+		case pcapMagicMicro:
+			pr.bo = bo
+			err = pr.readPcapHeader(1e6)
+			return
+		case pcapMagicNano:
+			pr.bo = bo
+			err = pr.readPcapHeader(1e9)
+			return
+		}
+	}
+
+	return nil, fmt.Errorf("not a pcap or pcapng file, magic %x", magic)
+}
+
+func (pr *Reader) readPcapHeader(tsres uint64) error {
+	var hdr [20]byte
+	if _, err := io.ReadFull(pr.r, hdr[:]); err != nil {
+		return fmt.Errorf("reading pcap header: %v", err)
+	}
+	linktype := uint16(pr.bo.Uint32(hdr[16:]))
+	pr.ifaces = []iface{{linktype: linktype, tsres: tsres}}
+	return nil
+}
+
+// reads the remainder of a section header block after its type.
+func (pr *Reader) readSHB() error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(pr.r, hdr[:]); err != nil {
+		return fmt.Errorf("reading section header: %v", err)
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(hdr[4:]) == byteOrderMagic:
+		pr.bo = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr[4:]) == byteOrderMagic:
+		pr.bo = binary.BigEndian
+	default:
+		return errors.New("bad pcapng byte order magic")
+	}
+
+	total := pr.bo.Uint32(hdr[0:])
+	if total < 28 || total > maxBlockLen || total%4 != 0 {
+		return fmt.Errorf("bad pcapng section header length %d", total)
+	}
+	// version, section length, options and trailer
+	if _, err := io.CopyN(io.Discard, pr.r, int64(total)-12); err != nil {
+		return fmt.Errorf("reading section header: %v", err)
+	}
+
+	// interface ids are per section
+	pr.ifaces = pr.ifaces[:0]
+	return nil
+}
+
+// ReadPacket returns the next packet, or io.EOF at the end of the capture.
+func (pr *Reader) ReadPacket() (p Packet, err error) {
+	if !pr.ng {
+		return pr.readPcapPacket()
+	}
+
+	for {
+		var hdr [8]byte
+		if _, err = io.ReadFull(pr.r, hdr[:4]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errors.New("truncated pcapng block")
+			}
+			return
+		}
+
+		if binary.LittleEndian.Uint32(hdr[:4]) == blockTypeSHB {
+			if err = pr.readSHB(); err != nil {
+				return
+			}
+			continue
+		}
+
+		if _, err = io.ReadFull(pr.r, hdr[4:]); err != nil {
+			err = errors.New("truncated pcapng block")
+			return
+		}
+		typ := pr.bo.Uint32(hdr[0:])
+		total := pr.bo.Uint32(hdr[4:])
+		if total < 12 || total > maxBlockLen || total%4 != 0 {
+			err = fmt.Errorf("bad pcapng block length %d", total)
+			return
+		}
+
+		body := make([]byte, total-8)
+		if _, err = io.ReadFull(pr.r, body); err != nil {
+			err = errors.New("truncated pcapng block")
+			return
+		}
+		body = body[:len(body)-4]
+
+		var ok bool
+		switch typ {
+		case blockTypeIDB:
+			err = pr.parseIDB(body)
+		case blockTypeEPB:
+			p, ok, err = pr.parseEPB(body)
+		case blockTypeSPB:
+			p, ok, err = pr.parseSPB(body)
+		}
+		if err != nil || ok {
+			return
+		}
+	}
+}
+
+func (pr *Reader) parseIDB(b []byte) error {
+	if len(b) < 8 {
+		return errors.New("short pcapng interface description")
+	}
+	ifc := iface{linktype: pr.bo.Uint16(b), tsres: 1e6}
+
+	for opts := b[8:]; len(opts) >= 4; {
+		code := pr.bo.Uint16(opts)
+		olen := int(pr.bo.Uint16(opts[2:]))
+		if code == optEndOfOpt || 4+olen > len(opts) {
+			break
+		}
+		if code == optIDBTSResol && olen >= 1 {
+			res := opts[4]
+			ifc.tsres = 1
+			for i := uint8(0); i < res&0x7f; i++ {
+				if res&0x80 != 0 {
+					ifc.tsres *= 2
+				} else {
+					ifc.tsres *= 10
+				}
+			}
+		}
+		opts = opts[4+pad4(olen):]
+	}
+
+	pr.ifaces = append(pr.ifaces, ifc)
+	return nil
+}
+
+func (pr *Reader) iface(id uint32) (ifc iface, err error) {
+	if int(id) >= len(pr.ifaces) {
+		err = fmt.Errorf("packet of undescribed interface %d", id)
+		return
+	}
+	return pr.ifaces[id], nil
+}
+
+func (pr *Reader) parseEPB(b []byte) (p Packet, ok bool, err error) {
+	if len(b) < 20 {
+		err = errors.New("short pcapng enhanced packet block")
+		return
+	}
+	ifc, err := pr.iface(pr.bo.Uint32(b))
+	if err != nil {
+		return
+	}
+	ts := uint64(pr.bo.Uint32(b[4:]))<<32 | uint64(pr.bo.Uint32(b[8:]))
+	caplen := int(pr.bo.Uint32(b[12:]))
+	if 20+caplen > len(b) {
+		err = errors.New("pcapng packet exceeds its block")
+		return
+	}
+
+	p.Timestamp = timestamp(ts, ifc.tsres)
+	p.LinkType = ifc.linktype
+	p.Data = b[20 : 20+caplen]
+
+	for opts := b[20+pad4(caplen):]; len(opts) >= 4; {
+		code := pr.bo.Uint16(opts)
+		olen := int(pr.bo.Uint16(opts[2:]))
+		if code == optEndOfOpt || 4+olen > len(opts) {
+			break
+		}
+		if code == optEPBFlags && olen >= 4 {
+			p.Direction = Direction(pr.bo.Uint32(opts[4:]) & 3)
+		}
+		opts = opts[4+pad4(olen):]
+	}
+
+	ok = true
+	return
+}
+
+func (pr *Reader) parseSPB(b []byte) (p Packet, ok bool, err error) {
+	if len(b) < 4 {
+		err = errors.New("short pcapng simple packet block")
+		return
+	}
+	ifc, err := pr.iface(0)
+	if err != nil {
+		return
+	}
+	n := int(pr.bo.Uint32(b))
+	if n > len(b)-4 {
+		n = len(b) - 4
+	}
+	p.LinkType = ifc.linktype
+	p.Data = b[4 : 4+n]
+	ok = true
+	return
+}
+
+func (pr *Reader) readPcapPacket() (p Packet, err error) {
+	var hdr [16]byte
+	if _, err = io.ReadFull(pr.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated pcap record")
+		}
+		return
+	}
+
+	caplen := pr.bo.Uint32(hdr[8:])
+	if caplen > maxBlockLen {
+		err = fmt.Errorf("bad pcap record length %d", caplen)
+		return
+	}
+	p.Data = make([]byte, caplen)
+	if _, err = io.ReadFull(pr.r, p.Data); err != nil {
+		err = errors.New("truncated pcap record")
+		return
+	}
+
+	ifc := pr.ifaces[0]
+	sec := uint64(pr.bo.Uint32(hdr[0:]))
+	frac := uint64(pr.bo.Uint32(hdr[4:]))
+	p.Timestamp = timestamp(sec*ifc.tsres+frac, ifc.tsres)
+	p.LinkType = ifc.linktype
+	return
+}
+
+func timestamp(ts, res uint64) time.Time {
+	sec := ts / res
+	frac := ts % res
+	return time.Unix(int64(sec), int64(frac*uint64(time.Second)/res))
+}
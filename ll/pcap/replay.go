@@ -0,0 +1,275 @@
+package pcap
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/distributed/ecat/ecfr"
+	"io"
+	"os"
+)
+
+const maxReplayDatagramsLen = 1500
+
+// DivergenceError reports an outgoing frame that differs from the recorded
+// request it is replayed against.
+type DivergenceError struct {
+	// number of the recorded request in the capture, counted from 1 like
+	// Wireshark does. 0 if the capture has no more requests.
+	Packet int
+	// index of the differing datagram in the frame, -1 if the frames differ
+	// as a whole
+	Datagram int
+	Reason   string
+}
+
+func (e *DivergenceError) Error() string {
+	if e.Packet == 0 {
+		return fmt.Sprintf("replay diverged: %s", e.Reason)
+	}
+	if e.Datagram < 0 {
+		return fmt.Sprintf("replay diverged at packet %d: %s", e.Packet, e.Reason)
+	}
+	return fmt.Sprintf("replay diverged at packet %d, datagram %d: %s", e.Packet, e.Datagram, e.Reason)
+}
+
+func IsDivergence(err error) bool {
+	_, ok := err.(*DivergenceError)
+	return ok
+}
+
+// a recorded request and its reply, reply is nil if the frame was lost.
+type exchange struct {
+	request ecfr.Frame
+	reply   []byte
+	packet  int
+}
+
+// ReplayFramer is an ecmd.Framer answering from a recorded capture. every
+// outgoing frame is compared against the next recorded request, and the
+// recorded reply to that request is returned with the datagram indices of
+// the outgoing frame. requests whose reply is missing from the capture are
+// replayed as lost frames.
+//
+// the comparison covers commands, addresses, data lengths and the data of
+// writing commands. once an outgoing frame diverged, Cycle keeps returning
+// the DivergenceError. frames are valid until the next call to New or Cycle.
+type ReplayFramer struct {
+	exchanges []exchange
+	next      int
+	err       error
+
+	oframes []*ecfr.Frame
+	iframes []*ecfr.Frame
+	frames  *ecfr.FramePool
+	cycled  bool
+}
+
+// NewReplayFramer reads the whole capture from r. packets that are not
+// EtherCAT command frames are skipped, the direction of packets is taken
+// from the capture if recorded and from the Ethernet source address
+// otherwise.
+func NewReplayFramer(r io.Reader) (*ReplayFramer, error) {
+	pr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &ReplayFramer{}
+	// exchanges waiting for their reply start here
+	unreplied := 0
+	for npkt := 1; ; npkt++ {
+		p, err := pr.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.LinkType != LinkTypeEthernet {
+			continue
+		}
+
+		b, dir, err := Decapsulate(p.Data)
+		if err != nil {
+			continue
+		}
+		// e.g. network variables published on the same segment
+		if t, err := ecfr.PeekFrameType(b); err != nil || t != ecfr.FrameTypeCommand {
+			continue
+		}
+		if p.Direction != DirectionUnknown {
+			dir = p.Direction
+		}
+		b = append([]byte(nil), b...)
+
+		if dir == DirectionOutbound {
+			ex := exchange{packet: npkt}
+			if _, err := ex.request.OverlayStrict(b, ecfr.ParseOptions{}); err != nil {
+				return nil, fmt.Errorf("packet %d: %v", npkt, err)
+			}
+			f.exchanges = append(f.exchanges, ex)
+			continue
+		}
+
+		var reply ecfr.Frame
+		if _, err := reply.OverlayStrict(b, ecfr.ParseOptions{}); err != nil {
+			return nil, fmt.Errorf("packet %d: %v", npkt, err)
+		}
+		// the earliest request that could have turned into this reply
+		for i := unreplied; i < len(f.exchanges); i++ {
+			ex := &f.exchanges[i]
+			if ex.reply == nil && isReplyTo(&reply, &ex.request) {
+				ex.reply = b
+				break
+			}
+		}
+		for unreplied < len(f.exchanges) && f.exchanges[unreplied].reply != nil {
+			unreplied++
+		}
+	}
+
+	return f, nil
+}
+
+// OpenReplayFile reads the capture at path.
+func OpenReplayFile(path string) (*ReplayFramer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewReplayFramer(file)
+}
+
+// slaves change working counters, data and positional addresses, but keep
+// the layout and indices of a frame.
+func isReplyTo(reply, req *ecfr.Frame) bool {
+	if len(reply.Datagrams) != len(req.Datagrams) {
+		return false
+	}
+	for i, rdg := range reply.Datagrams {
+		qdg := req.Datagrams[i]
+		if rdg.Index != qdg.Index || rdg.Command != qdg.Command || rdg.DataLength() != qdg.DataLength() {
+			return false
+		}
+	}
+	return true
+}
+
+// Remaining returns the number of recorded requests not replayed yet.
+func (f *ReplayFramer) Remaining() int {
+	return len(f.exchanges) - f.next
+}
+
+func (f *ReplayFramer) New(maxdatalen int) (fr *ecfr.Frame, err error) {
+	f.recycle()
+
+	fr = f.pool().Get()
+	fr.Header.SetType(ecfr.FrameTypeCommand)
+	f.oframes = append(f.oframes, fr)
+	return
+}
+
+func (f *ReplayFramer) pool() *ecfr.FramePool {
+	if f.frames == nil {
+		f.frames = ecfr.NewFramePool(maxReplayDatagramsLen + ecfr.FrameOverheadLen)
+	}
+	return f.frames
+}
+
+func (f *ReplayFramer) recycle() {
+	if !f.cycled {
+		return
+	}
+	f.cycled = false
+
+	p := f.pool()
+	p.Put(f.oframes...)
+	p.Put(f.iframes...)
+	f.oframes = f.oframes[:0]
+	f.iframes = f.iframes[:0]
+}
+
+func (f *ReplayFramer) Cycle() (iframes []*ecfr.Frame, err error) {
+	f.recycle()
+	defer func() {
+		f.cycled = true
+	}()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	p := f.pool()
+	for _, oframe := range f.oframes {
+		if _, err = oframe.Commit(); err != nil {
+			return
+		}
+
+		if f.next >= len(f.exchanges) {
+			f.err = &DivergenceError{Datagram: -1, Reason: "frame sent after the end of the capture"}
+			return nil, f.err
+		}
+		ex := &f.exchanges[f.next]
+		f.next++
+
+		if dgi, reason := diverges(oframe, &ex.request); reason != "" {
+			f.err = &DivergenceError{Packet: ex.packet, Datagram: dgi, Reason: reason}
+			return nil, f.err
+		}
+
+		if ex.reply == nil {
+			continue
+		}
+
+		iframe := p.Get()
+		b := iframe.Buffer()[:len(ex.reply)]
+		copy(b, ex.reply)
+		if _, err = iframe.Overlay(b); err != nil {
+			p.Put(iframe)
+			return
+		}
+		for i, dg := range iframe.Datagrams {
+			dg.Index = oframe.Datagrams[i].Index
+			if _, err = dg.Commit(); err != nil {
+				p.Put(iframe)
+				return
+			}
+		}
+		f.iframes = append(f.iframes, iframe)
+	}
+
+	iframes = f.iframes
+	return
+}
+
+func diverges(out, rec *ecfr.Frame) (dgi int, reason string) {
+	if len(out.Datagrams) != len(rec.Datagrams) {
+		return -1, fmt.Sprintf("frame has %d datagrams, recorded %d", len(out.Datagrams), len(rec.Datagrams))
+	}
+
+	for i, odg := range out.Datagrams {
+		rdg := rec.Datagrams[i]
+		switch {
+		case odg.Command != rdg.Command:
+			return i, fmt.Sprintf("command %v, recorded %v", odg.Command, rdg.Command)
+		case odg.Addr32 != rdg.Addr32:
+			return i, fmt.Sprintf("address %v, recorded %v",
+				ecfr.DatagramAddressFromCommand(odg.Addr32, odg.Command),
+				ecfr.DatagramAddressFromCommand(rdg.Addr32, rdg.Command))
+		case odg.DataLength() != rdg.DataLength():
+			return i, fmt.Sprintf("data length %d, recorded %d", odg.DataLength(), rdg.DataLength())
+		case writesData(odg.Command) && !bytes.Equal(odg.Data(), rdg.Data()):
+			return i, fmt.Sprintf("data % x, recorded % x", odg.Data(), rdg.Data())
+		}
+	}
+	return -1, ""
+}
+
+// read multiple write commands take their data from the slave read
+func writesData(ct ecfr.CommandType) bool {
+	return ct.DoesWrite() && ct != ecfr.ARMW && ct != ecfr.FRMW
+}
+
+func (f *ReplayFramer) Close() error { return nil }
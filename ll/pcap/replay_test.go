@@ -0,0 +1,116 @@
+package pcap
+
+import (
+	"bytes"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+)
+
+// assigns station addresses to two slaves and reads them back
+func replayTestSession(c ecmd.Commander, base uint16) (addrs []uint16, err error) {
+	for i := 0; i < 2; i++ {
+		err = ecmd.ExecuteWrite16(c, ecfr.PositionalAddr(int16(-i), ecad.ConfiguredStationAddress), base+uint16(i), 1)
+		if err != nil {
+			return
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		var addr uint16
+		addr, err = ecmd.ExecuteRead16(c, ecfr.FixedAddr(base+uint16(i), ecad.ConfiguredStationAddress), 1)
+		if err != nil {
+			return
+		}
+		addrs = append(addrs, addr)
+	}
+	return
+}
+
+func recordTestSession(t *testing.T, policy sim.FaultPolicy) []byte {
+	bus := &sim.L2Bus{Slaves: []sim.FrameProcessor{sim.NewL2Slave(), sim.NewL2Slave()}}
+	ff := sim.NewFaultFramer(bus, policy, 1)
+
+	var buf bytes.Buffer
+	cf, err := NewCaptureFramer(ff, &buf, EncapEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = replayTestSession(ecmd.NewCommandFramer(cf), 0x1000); err != nil {
+		t.Fatalf("recording failed: %v", err)
+	}
+	if cf.Err != nil {
+		t.Fatal(cf.Err)
+	}
+	return buf.Bytes()
+}
+
+func TestReplay(t *testing.T) {
+	capture := recordTestSession(t, sim.FaultScript{})
+
+	rf, err := NewReplayFramer(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.Remaining() != 4 {
+		t.Fatalf("want 4 recorded requests, have %d", rf.Remaining())
+	}
+
+	addrs, err := replayTestSession(ecmd.NewCommandFramer(rf), 0x1000)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if len(addrs) != 2 || addrs[0] != 0x1000 || addrs[1] != 0x1001 {
+		t.Fatalf("unexpected replayed station addresses %#04x", addrs)
+	}
+	if rf.Remaining() != 0 {
+		t.Fatalf("%d recorded requests left", rf.Remaining())
+	}
+}
+
+func TestReplayLostFrame(t *testing.T) {
+	capture := recordTestSession(t, sim.FaultScript{1: {Drop: true}})
+
+	rf, err := NewReplayFramer(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the dropped write is sent again
+	if rf.Remaining() != 5 {
+		t.Fatalf("want 5 recorded requests, have %d", rf.Remaining())
+	}
+
+	if _, err = replayTestSession(ecmd.NewCommandFramer(rf), 0x1000); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if rf.Remaining() != 0 {
+		t.Fatalf("%d recorded requests left", rf.Remaining())
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	capture := recordTestSession(t, sim.FaultScript{})
+
+	rf, err := NewReplayFramer(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = replayTestSession(ecmd.NewCommandFramer(rf), 0x2000)
+	de, ok := err.(*DivergenceError)
+	if !ok {
+		t.Fatalf("want divergence, have %v", err)
+	}
+	if de.Packet != 1 || de.Datagram != 0 {
+		t.Fatalf("unexpected divergence %v", de)
+	}
+
+	// stays diverged
+	_, err = replayTestSession(ecmd.NewCommandFramer(rf), 0x1000)
+	if err != de {
+		t.Fatalf("want the first divergence again, have %v", err)
+	}
+}
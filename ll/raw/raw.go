@@ -0,0 +1,199 @@
+package raw
+
+import (
+	"github.com/distributed/ecat/ecfr"
+	"net"
+	"time"
+)
+
+const (
+	ethBuflen       = 1522
+	maxDatagramsLen = 1498
+)
+
+// Collector receives the statistics of an EthernetFramer, see package ecstat
+// for an implementation.
+type Collector interface {
+	FramerCycle(sent, received int)
+	// the receive deadline was extended because frames were missing
+	CycleStretched()
+}
+
+// sends and receives whole Ethernet frames, without FCS.
+type packetConn interface {
+	WritePacket(b []byte) error
+	// returns an error with Timeout() true once deadline passed
+	ReadPacket(b []byte, deadline time.Time) (int, error)
+	Close() error
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+type timeouter interface {
+	Timeout() bool
+}
+
+func isTimeout(err error) bool {
+	if t, ok := err.(timeouter); ok {
+		return t.Timeout()
+	}
+	return false
+}
+
+// EthernetFramer sends EtherCAT frames directly in Ethernet frames with
+// EtherType 0x88a4. the frames of a cycle are valid until the next call to
+// New or Cycle.
+type EthernetFramer struct {
+	oframes []*ecfr.Frame
+	iframes []*ecfr.Frame
+	frames  *ecfr.FramePool
+	cycled  bool
+
+	conn      packetConn
+	source    ecfr.ETHAddr
+	cycletime time.Duration
+	ethbuf    []byte
+
+	// optional
+	Collector Collector
+}
+
+// NewEthernetFramer opens a raw socket on iface, which usually needs
+// elevated privileges.
+func NewEthernetFramer(iface *net.Interface, cycletime time.Duration) (f *EthernetFramer, err error) {
+	conn, err := openPacketConn(iface)
+	if err != nil {
+		return
+	}
+
+	var src ecfr.ETHAddr
+	copy(src[:], iface.HardwareAddr)
+	return newEthernetFramer(conn, src, cycletime), nil
+}
+
+func newEthernetFramer(conn packetConn, src ecfr.ETHAddr, cycletime time.Duration) *EthernetFramer {
+	return &EthernetFramer{
+		conn:      conn,
+		source:    src,
+		cycletime: cycletime,
+		ethbuf:    make([]byte, ethBuflen),
+		frames:    ecfr.NewFramePool(ethBuflen),
+	}
+}
+
+func (f *EthernetFramer) New(maxdatalen int) (fr *ecfr.Frame, err error) {
+	f.recycle()
+
+	fr = f.frames.Get()
+	fr.Header.SetType(ecfr.FrameTypeCommand)
+	f.oframes = append(f.oframes, fr)
+	return
+}
+
+func (f *EthernetFramer) recycle() {
+	if !f.cycled {
+		return
+	}
+	f.cycled = false
+
+	f.frames.Put(f.oframes...)
+	f.frames.Put(f.iframes...)
+	f.oframes = f.oframes[:0]
+	f.iframes = f.iframes[:0]
+}
+
+func (f *EthernetFramer) Cycle() (iframes []*ecfr.Frame, err error) {
+	f.recycle()
+	defer func() {
+		f.cycled = true
+		iframes = f.iframes
+		if f.Collector != nil {
+			f.Collector.FramerCycle(len(f.oframes), len(f.iframes))
+		}
+	}()
+
+	for _, oframe := range f.oframes {
+		var ef *ecfr.ETHFrame
+		ef, err = ecfr.OverlayETHFrame(f.ethbuf)
+		if err != nil {
+			return
+		}
+		ef.Destination = ecfr.ETHAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		ef.Source = f.source
+		if err = ef.WrapFrame(oframe); err != nil {
+			return
+		}
+
+		if err = f.conn.WritePacket(ef.GetFrameBufNoFCS()); err != nil {
+			return
+		}
+	}
+
+	deadline := time.Now().Add(f.cycletime)
+	stretchcnt := 0
+	for {
+		fr := f.frames.Get()
+
+		var n int
+		n, err = f.conn.ReadPacket(f.ethbuf, deadline)
+		if isTimeout(err) {
+			f.frames.Put(fr)
+			if stretchcnt < 10 && len(f.iframes) < len(f.oframes) {
+				stretchcnt++
+				if f.Collector != nil {
+					f.Collector.CycleStretched()
+				}
+				deadline = time.Now().Add(f.cycletime)
+				continue
+			}
+			err = nil
+			break
+		}
+		if err != nil {
+			f.frames.Put(fr)
+			return
+		}
+
+		if !f.receive(fr, f.ethbuf[:n]) {
+			// not for us, or malformed
+			f.frames.Put(fr)
+			continue
+		}
+		f.iframes = append(f.iframes, fr)
+	}
+
+	return
+}
+
+// overlays fr over a copy of the EtherCAT frame in the Ethernet frame b.
+func (f *EthernetFramer) receive(fr *ecfr.Frame, b []byte) bool {
+	// the socket layer strips the FCS, OverlayETHFrame expects room for it
+	if len(b) < 60 || len(b)+4 > len(f.ethbuf) {
+		return false
+	}
+	ef, err := ecfr.OverlayETHFrame(f.ethbuf[:len(b)+4])
+	if err != nil || ef.Type != ecfr.EtherTypeEtherCAT {
+		return false
+	}
+	// frames we sent, the first slave sets the locally administered bit
+	if ef.Source == f.source {
+		return false
+	}
+
+	pl := ef.GetPayload()
+	if t, err := ecfr.PeekFrameType(pl); err != nil || t != ecfr.FrameTypeCommand {
+		return false
+	}
+
+	// the padding is ignored by Overlay
+	n := copy(fr.Buffer(), pl)
+	_, err = fr.Overlay(fr.Buffer()[:n])
+	return err == nil
+}
+
+func (f *EthernetFramer) Close() error {
+	return f.conn.Close()
+}
@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package raw
+
+import (
+	"github.com/distributed/ecat/ecfr"
+	"net"
+	"syscall"
+	"time"
+)
+
+type linuxConn struct {
+	fd int
+	sa *syscall.SockaddrLinklayer
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func openPacketConn(iface *net.Interface) (packetConn, error) {
+	proto := htons(ecfr.EtherTypeEtherCAT)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return nil, err
+	}
+
+	sa := &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}
+	if err = syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &linuxConn{fd, sa}, nil
+}
+
+func (c *linuxConn) WritePacket(b []byte) error {
+	return syscall.Sendto(c.fd, b, 0, c.sa)
+}
+
+func (c *linuxConn) ReadPacket(b []byte, deadline time.Time) (int, error) {
+	d := time.Until(deadline)
+	// a zero timeout blocks forever
+	if d < time.Microsecond {
+		return 0, timeoutError{}
+	}
+	tv := syscall.NsecToTimeval(d.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return 0, err
+	}
+
+	n, _, err := syscall.Recvfrom(c.fd, b, 0)
+	for err == syscall.EINTR {
+		n, _, err = syscall.Recvfrom(c.fd, b, 0)
+	}
+	if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+		return 0, timeoutError{}
+	}
+	return n, err
+}
+
+func (c *linuxConn) Close() error {
+	return syscall.Close(c.fd)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package raw
+
+import (
+	"errors"
+	"net"
+)
+
+func openPacketConn(iface *net.Interface) (packetConn, error) {
+	return nil, errors.New("raw Ethernet is only supported on linux")
+}
@@ -0,0 +1,82 @@
+package raw
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"testing"
+	"time"
+)
+
+// passes frames through a simulated slave, like a segment looped back to
+// the NIC. our own frames are looped back, too.
+type simConn struct {
+	slave   *sim.L2Slave
+	pending [][]byte
+	lose    int
+}
+
+func (c *simConn) WritePacket(b []byte) error {
+	c.pending = append(c.pending, append([]byte(nil), b...))
+
+	if c.lose > 0 {
+		c.lose--
+		return nil
+	}
+
+	buf := append(append([]byte(nil), b...), 0, 0, 0, 0)
+	ef, err := ecfr.OverlayETHFrame(buf)
+	if err != nil {
+		return err
+	}
+	var fr ecfr.Frame
+	if err = ef.UnwrapFrame(&fr); err != nil {
+		return err
+	}
+	c.slave.ProcessFrame(&fr)
+	if _, err = fr.Commit(); err != nil {
+		return err
+	}
+	ef.Source[0] |= 0x02
+	if err = ef.WriteDown(); err != nil {
+		return err
+	}
+	c.pending = append(c.pending, ef.GetFrameBufNoFCS())
+	return nil
+}
+
+func (c *simConn) ReadPacket(b []byte, deadline time.Time) (int, error) {
+	if len(c.pending) == 0 {
+		return 0, timeoutError{}
+	}
+	n := copy(b, c.pending[0])
+	c.pending = c.pending[1:]
+	return n, nil
+}
+
+func (c *simConn) Close() error { return nil }
+
+func TestEthernetFramer(t *testing.T) {
+	conn := &simConn{slave: sim.NewL2Slave()}
+	f := newEthernetFramer(conn, ecfr.ETHAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, time.Millisecond)
+	c := ecmd.NewCommandFramer(f)
+
+	typ, err := ecmd.ExecuteRead8(c, ecfr.PositionalAddr(0, ecad.Type), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != 0x11 {
+		t.Fatalf("want ESC type 0x11, have %#02x", typ)
+	}
+
+	// the lost frame is retried
+	conn.lose = 1
+	if err = ecmd.ExecuteWrite16(c, ecfr.PositionalAddr(0, ecad.ConfiguredStationAddress), 0x1001, 1); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := ecmd.ExecuteRead16(c, ecfr.FixedAddr(0x1001, ecad.ConfiguredStationAddress), 1)
+	if err != nil || addr != 0x1001 {
+		t.Fatalf("read station address %#04x, %v", addr, err)
+	}
+}
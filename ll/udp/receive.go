@@ -0,0 +1,66 @@
+package udp
+
+import (
+	"errors"
+	"github.com/distributed/ecat/ecfr"
+	"net"
+	"net/netip"
+)
+
+const (
+	// receive buffers, packets beyond wait in the socket
+	receiveQueueLen = 64
+)
+
+// a UDP payload with a valid EtherCAT header, or a receive error
+type packet struct {
+	typ ecfr.FrameType
+	b   []byte
+	buf []byte
+	err error
+}
+
+// receives from the socket until it is closed and hands the packets to
+// Cycle, which returns the buffers to free.
+func (f *UDPFramer) receive() {
+	defer close(f.done)
+
+	for {
+		buf := <-f.free
+
+		n, from, err := f.sock.ReadFromUDPAddrPort(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			f.packets <- packet{buf: buf, err: err}
+			continue
+		}
+		if !f.multicast && netip.AddrPortFrom(from.Addr().Unmap(), from.Port()) != f.target {
+			// a unicast gateway is the only one to answer
+			f.free <- buf
+			continue
+		}
+
+		t, b, err := parseHeader(buf[:n])
+		if err != nil {
+			f.free <- buf
+			continue
+		}
+		f.packets <- packet{typ: t, b: b, buf: buf}
+	}
+}
+
+// identifies a command frame on its way around the segment. slaves change
+// neither the length of a frame nor the command and index of its
+// datagrams.
+type frameKey struct {
+	length int
+	cmd    ecfr.CommandType
+	index  uint8
+}
+
+func keyOf(fr *ecfr.Frame, length int) frameKey {
+	dg := fr.Datagrams[0]
+	return frameKey{length, dg.Command, dg.Index}
+}
@@ -1,14 +1,20 @@
 package udp
 
 import (
-	"golang.org/x/net/ipv4"
+	"errors"
+	"fmt"
 	"github.com/distributed/ecat/ecfr"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 	"net"
+	"net/netip"
 	"time"
 )
 
 const (
 	EthercatUDPPort = 0x88a4
+	// where NewUDPFramer sends debug messages
+	DefaultDebugPort = 1024
 )
 
 const (
@@ -16,156 +22,361 @@ const (
 	maxDatagramsLen  = 1470
 )
 
+// Collector receives the statistics of a UDPFramer, see package ecstat for
+// an implementation.
+type Collector interface {
+	FramerCycle(sent, received int)
+	// the receive deadline was extended because frames were missing
+	CycleStretched()
+}
+
+// Config selects the addresses of a UDPFramer. the address family follows
+// Target.
+type Config struct {
+	// a multicast group the slaves of the segment listen to, or the unicast
+	// address of a single gateway. port 0 for EthercatUDPPort.
+	Target *net.UDPAddr
+	// the interface to join a multicast Target on, unused for unicast
+	Interface *net.Interface
+	// the address to bind to, nil for any address with EthercatUDPPort
+	LocalAddr *net.UDPAddr
+	// time to wait for returning frames
+	CycleTime time.Duration
+	// debug messages go there, nil drops them
+	DebugAddr *net.UDPAddr
+}
+
+// the multicast methods shared by ipv4.PacketConn and ipv6.PacketConn
+type multicastConn interface {
+	SetMulticastInterface(*net.Interface) error
+	JoinGroup(*net.Interface, net.Addr) error
+	SetMulticastLoopback(bool) error
+	Close() error
+}
+
+// UDPFramer reuses its frames, the frames of a cycle are valid until the
+// next call to New or Cycle.
 type UDPFramer struct {
 	oframes []*ecfr.Frame
+	iframes []*ecfr.Frame
+	frames  *ecfr.FramePool
+	cycled  bool
 
-	sock      *net.UDPConn
-	mcsock    *ipv4.PacketConn
-	group     net.IP
-	iface     *net.Interface
-	laddr     *net.UDPAddr
-	groupaddr *net.UDPAddr
+	sock   *net.UDPConn
+	mcsock multicastConn
+	// the target without allocations on every send
+	target    netip.AddrPort
+	multicast bool
+	debugaddr *net.UDPAddr
 	cycletime time.Duration
 
+	// handed between the receive goroutine and Cycle
+	free    chan []byte
+	packets chan packet
+	done    chan struct{}
+	timer   *time.Timer
+	// frames sent in this cycle that did not return yet
+	outstanding []frameKey
+
 	cycnum int
+
+	// the number of frames in flight at most, 0 sends all frames of a cycle
+	// at once
+	Window int
+	// optional
+	Collector Collector
+	// optional, receives network variable frames published on the segment
+	// while cycling. the frame is only valid during the call.
+	NetworkVariables func(*ecfr.NVFrame)
+	nvframe          ecfr.NVFrame
 }
 
+// NewUDPFramer talks to the slaves through the multicast group on iface,
+// IPv4 or IPv6, on EthercatUDPPort.
 func NewUDPFramer(iface *net.Interface, group net.IP, cycletime time.Duration) (f *UDPFramer, err error) {
-	f = &UDPFramer{}
-	f.group = group
-	f.iface = iface
-	f.cycletime = cycletime
-
-	f.laddr = &net.UDPAddr{net.IPv4(0, 0, 0, 0), EthercatUDPPort, ""}
-	f.groupaddr = &net.UDPAddr{f.group, EthercatUDPPort, ""}
+	return NewUDPFramerConfig(Config{
+		Target:    &net.UDPAddr{IP: group, Port: EthercatUDPPort},
+		Interface: iface,
+		CycleTime: cycletime,
+		DebugAddr: &net.UDPAddr{IP: group, Port: DefaultDebugPort},
+	})
+}
 
-	f.sock, err = net.ListenUDP("udp4", f.laddr)
-	if err != nil {
-		return
+// NewUDPFramerConfig opens a UDPFramer as selected in cfg.
+func NewUDPFramerConfig(cfg Config) (f *UDPFramer, err error) {
+	if cfg.Target == nil || cfg.Target.IP == nil {
+		return nil, errors.New("no target address")
+	}
+	target := netip.AddrPortFrom(cfg.Target.AddrPort().Addr().Unmap(), uint16(cfg.Target.Port))
+	if target.Port() == 0 {
+		target = netip.AddrPortFrom(target.Addr(), EthercatUDPPort)
+	}
+	network := "udp4"
+	if target.Addr().Is6() {
+		network = "udp6"
 	}
 
-	f.mcsock = ipv4.NewPacketConn(f.sock)
-
-	err = f.mcsock.SetMulticastInterface(f.iface)
-	if err != nil {
-		return
+	f = &UDPFramer{
+		target:    target,
+		multicast: target.Addr().IsMulticast(),
+		debugaddr: cfg.DebugAddr,
+		cycletime: cfg.CycleTime,
+		frames:    ecfr.NewFramePool(udpReceiveBuflen),
+	}
+	if f.multicast && cfg.Interface == nil {
+		return nil, fmt.Errorf("multicast target %v needs an interface", target)
 	}
 
-	err = f.mcsock.JoinGroup(iface, &net.UDPAddr{IP: group})
+	laddr := cfg.LocalAddr
+	if laddr == nil {
+		laddr = &net.UDPAddr{Port: EthercatUDPPort}
+	}
+	f.sock, err = net.ListenUDP(network, laddr)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	err = f.mcsock.SetMulticastLoopback(false)
-	if err != nil {
-		return
+	if f.multicast {
+		if err = f.joinGroup(cfg.Interface); err != nil {
+			f.Close()
+			return nil, err
+		}
 	}
 
+	f.free = make(chan []byte, receiveQueueLen)
+	for i := 0; i < receiveQueueLen; i++ {
+		f.free <- make([]byte, udpReceiveBuflen)
+	}
+	// never blocks the receiver, there are no more buffers
+	f.packets = make(chan packet, receiveQueueLen)
+	f.done = make(chan struct{})
+	f.timer = time.NewTimer(time.Hour)
+	f.timer.Stop()
+	go f.receive()
 	return
 }
 
-func (f *UDPFramer) New(maxdatalen int) (fr *ecfr.Frame, err error) {
-	var vframe ecfr.Frame
-	buf := make([]byte, maxDatagramsLen+ecfr.FrameOverheadLen)
-	vframe, err = ecfr.PointFrameTo(buf)
-	if err != nil {
+func (f *UDPFramer) joinGroup(iface *net.Interface) (err error) {
+	if f.target.Addr().Is6() {
+		f.mcsock = ipv6.NewPacketConn(f.sock)
+	} else {
+		f.mcsock = ipv4.NewPacketConn(f.sock)
+	}
+
+	if err = f.mcsock.SetMulticastInterface(iface); err != nil {
+		return
+	}
+	if err = f.mcsock.JoinGroup(iface, &net.UDPAddr{IP: f.target.Addr().AsSlice()}); err != nil {
 		return
 	}
+	return f.mcsock.SetMulticastLoopback(false)
+}
+
+// LocalAddr is the address the framer is bound to.
+func (f *UDPFramer) LocalAddr() *net.UDPAddr {
+	return f.sock.LocalAddr().(*net.UDPAddr)
+}
 
-	vframe.Header.SetType(1)
+func (f *UDPFramer) New(maxdatalen int) (fr *ecfr.Frame, err error) {
+	f.recycle()
 
-	fr = &vframe
+	fr = f.frames.Get()
+	fr.Header.SetType(ecfr.FrameTypeCommand)
 	f.oframes = append(f.oframes, fr)
 	return
 }
 
+// frames of the last cycle are handed back to the pool once the next cycle
+// starts.
+func (f *UDPFramer) recycle() {
+	if !f.cycled {
+		return
+	}
+	f.cycled = false
+
+	f.frames.Put(f.oframes...)
+	f.frames.Put(f.iframes...)
+	f.oframes = f.oframes[:0]
+	f.iframes = f.iframes[:0]
+}
+
+// Cycle sends the frames, up to Window at a time, and returns once all of
+// them are back. frames missing after the cycle time are waited for up to
+// 10 more cycle times before they are given up.
 func (f *UDPFramer) Cycle() (iframes []*ecfr.Frame, err error) {
+	f.recycle()
 	defer func() {
 		f.cycnum++
-		f.oframes = nil
+		f.cycled = true
+		iframes = f.iframes
+		if f.Collector != nil {
+			f.Collector.FramerCycle(len(f.oframes), len(f.iframes))
+		}
 	}()
-	// TODO: send/receive concurrently to be independent of queue depth?
 
-	// TODO: write deadline?
-	var obytes []byte
-	for _, oframe := range f.oframes {
-		obytes, err = oframe.Commit()
-		if err != nil {
-			return
+	f.outstanding = f.outstanding[:0]
+	sent, stretchcnt := 0, 0
+	for {
+		n := sent
+		for sent < len(f.oframes) && (f.Window <= 0 || len(f.outstanding) < f.Window) {
+			if err = f.send(f.oframes[sent]); err != nil {
+				return
+			}
+			sent++
 		}
-
-		_, err = f.sock.WriteTo(obytes, f.groupaddr)
-		if err != nil {
-			err = errorMask(err)
-			return
+		if sent > n {
+			// the last frame sent gets the whole cycle time
+			f.resetTimer(f.cycletime)
 		}
-		//fmt.Printf("cycnum %d out %s", f.cycnum, oframe.MultilineSummary())
-	}
 
-	err = f.sock.SetReadDeadline(time.Now().Add(f.cycletime))
-	if err != nil {
-		return
-	}
+		if len(f.outstanding) == 0 {
+			// late answers and network variables that are already there
+			for {
+				select {
+				case p := <-f.packets:
+					if err = f.handle(p); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
 
-	stretchcnt := 0
-	rbuf := make([]byte, udpReceiveBuflen)
-	for {
-		var n int
-		n, _, err = f.sock.ReadFromUDP(rbuf)
-		if isTimeout(err) {
-			if stretchcnt < 10 && len(iframes) < len(f.oframes) {
-				//fmt.Printf("================================= activating cycle stretching %d =======\n", stretchcnt)
+		select {
+		case p := <-f.packets:
+			if err = f.handle(p); err != nil {
+				return
+			}
+		case <-f.timer.C:
+			if stretchcnt < 10 {
 				stretchcnt++
-				f.sock.SetReadDeadline(time.Now().Add(1 * f.cycletime))
+				if f.Collector != nil {
+					f.Collector.CycleStretched()
+				}
+				f.timer.Reset(f.cycletime)
 				continue
 			}
-			err = nil
-			break
-		}
-		if err != nil {
-			return
+			// lost, make room for the frames not sent yet
+			f.outstanding = f.outstanding[:0]
 		}
+	}
+}
 
-		var fr ecfr.Frame
-		_, err = fr.Overlay(rbuf[0:n])
-		if err != nil {
-			// discard malformed frames
-			continue
+func (f *UDPFramer) resetTimer(d time.Duration) {
+	if !f.timer.Stop() {
+		select {
+		case <-f.timer.C:
+		default:
 		}
+	}
+	f.timer.Reset(d)
+}
 
-		//fmt.Printf("cycnum %d in %s", f.cycnum, fr.MultilineSummary())
-		iframes = append(iframes, &fr)
-		rbuf = make([]byte, udpReceiveBuflen)
+func (f *UDPFramer) send(fr *ecfr.Frame) error {
+	b, err := fr.Commit()
+	if err != nil {
+		return err
 	}
 
-	return
+	// TODO: write deadline?
+	if _, err = f.sock.WriteToUDPAddrPort(b, f.target); err != nil {
+		// masked errors lose the frame
+		return errorMask(err)
+	}
+	f.outstanding = append(f.outstanding, keyOf(fr, len(b)))
+	return nil
 }
 
-func (f *UDPFramer) Close() error {
-	if f.mcsock != nil {
-		f.mcsock.Close()
+// takes a received packet and returns its buffer to the receiver.
+func (f *UDPFramer) handle(p packet) error {
+	defer func() {
+		f.free <- p.buf
+	}()
+
+	if p.err != nil {
+		return errorMask(p.err)
 	}
-	if f.sock != nil {
-		return f.Close()
+	if p.typ != ecfr.FrameTypeCommand {
+		f.otherFrame(p.typ, p.b)
+		return nil
 	}
+
+	fr := f.frames.Get()
+	b := fr.Buffer()[:len(p.b)]
+	copy(b, p.b)
+	if _, err := fr.OverlayStrict(b, ecfr.ParseOptions{}); err != nil {
+		// discard malformed frames
+		f.frames.Put(fr)
+		return nil
+	}
+
+	k := keyOf(fr, len(b))
+	for i, o := range f.outstanding {
+		if o == k {
+			f.outstanding = append(f.outstanding[:i], f.outstanding[i+1:]...)
+			f.iframes = append(f.iframes, fr)
+			return nil
+		}
+	}
+	// the late answer to a frame of an earlier cycle
+	f.frames.Put(fr)
 	return nil
 }
 
-func (f *UDPFramer) DebugMessage(m string) {
-	addr := *f.groupaddr
-	addr.Port = 1024
+// frames not answering commands are no business of the command framer
+func (f *UDPFramer) otherFrame(t ecfr.FrameType, b []byte) {
+	if t != ecfr.FrameTypeNetworkVariables || f.NetworkVariables == nil {
+		return
+	}
+	if _, err := f.nvframe.Overlay(b); err != nil {
+		return
+	}
+	f.NetworkVariables(&f.nvframe)
+}
 
-	// the error is intentionally ingored
-	f.sock.WriteTo([]byte(m), &addr)
+// parseHeader checks the EtherCAT header starting the UDP payload b and
+// returns the frame it describes, without trailing bytes.
+func parseHeader(b []byte) (t ecfr.FrameType, frame []byte, err error) {
+	var h ecfr.Header
+	if _, err = h.Overlay(b); err != nil {
+		return
+	}
+	n := ecfr.FrameOverheadLen + int(h.FrameLength())
+	if n > len(b) {
+		err = fmt.Errorf("EtherCAT header claims %d bytes, UDP payload has %d", n, len(b))
+		return
+	}
+	return h.Type(), b[:n], nil
 }
 
-type timeouter interface {
-	Timeout() bool
+func (f *UDPFramer) Close() (err error) {
+	if f.mcsock != nil {
+		// closes the socket, too
+		err = f.mcsock.Close()
+	} else if f.sock != nil {
+		err = f.sock.Close()
+	}
+
+	if f.done == nil {
+		return
+	}
+	// the receiver may wait for a buffer
+	for {
+		select {
+		case <-f.done:
+			return
+		case p := <-f.packets:
+			f.free <- p.buf
+		}
+	}
 }
 
-func isTimeout(err error) bool {
-	if t, ok := err.(timeouter); ok {
-		return t.Timeout()
+func (f *UDPFramer) DebugMessage(m string) {
+	if f.debugaddr == nil {
+		return
 	}
-	return false
+
+	// the error is intentionally ignored
+	f.sock.WriteTo([]byte(m), f.debugaddr)
 }
@@ -0,0 +1,345 @@
+package udp
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/sim"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// answers EtherCAT frames sent over UDP by passing them through simulated
+// slaves, like a gateway in front of a segment.
+type responder struct {
+	conn   *net.UDPConn
+	slaves []*sim.L2Slave
+	// frames to drop without an answer, accessed atomically
+	lose int32
+	// answers to hold back until the next one, accessed atomically
+	hold int32
+	held [][]byte
+	// sent before every answer
+	junk [][]byte
+}
+
+func newSlaves(n int) (slaves []*sim.L2Slave) {
+	for i := 0; i < n; i++ {
+		slaves = append(slaves, sim.NewL2Slave())
+	}
+	return
+}
+
+func newResponder(t *testing.T, network, addr string, slaves []*sim.L2Slave, junk ...[]byte) *responder {
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: net.ParseIP(addr)})
+	if err != nil {
+		t.Skipf("no %s loopback: %v", network, err)
+	}
+	r := &responder{conn: conn, slaves: slaves, junk: junk}
+	go r.serve()
+	t.Cleanup(func() { conn.Close() })
+	return r
+}
+
+func (r *responder) addr() *net.UDPAddr {
+	return r.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// allocation free once running, not to disturb TestCycleAllocs
+func (r *responder) serve() {
+	buf := make([]byte, 1500)
+	var fr ecfr.Frame
+	for {
+		n, from, err := r.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		if atomic.AddInt32(&r.lose, -1) >= 0 {
+			continue
+		}
+		atomic.StoreInt32(&r.lose, 0)
+
+		if _, err = fr.Overlay(buf[:n]); err != nil {
+			continue
+		}
+		for _, s := range r.slaves {
+			s.ProcessFrame(&fr)
+		}
+		b, err := fr.Commit()
+		if err != nil {
+			continue
+		}
+		if atomic.AddInt32(&r.hold, -1) >= 0 {
+			r.held = append(r.held, append([]byte(nil), b...))
+			continue
+		}
+		atomic.StoreInt32(&r.hold, 0)
+
+		for _, j := range r.junk {
+			r.conn.WriteToUDPAddrPort(j, from)
+		}
+		for _, h := range r.held {
+			r.conn.WriteToUDPAddrPort(h, from)
+		}
+		r.held = r.held[:0]
+		r.conn.WriteToUDPAddrPort(b, from)
+	}
+}
+
+func testUnicast(t *testing.T, network, loopback string) {
+	r := newResponder(t, network, loopback, newSlaves(2),
+		[]byte{0x01},
+		// claims more than it carries
+		[]byte{0xff, 0x17, 0x01, 0x00},
+	)
+
+	// an ephemeral port, the responder is not on EthercatUDPPort
+	f, err := NewUDPFramerConfig(Config{
+		Target:    r.addr(),
+		LocalAddr: &net.UDPAddr{IP: net.ParseIP(loopback)},
+		CycleTime: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	c := ecmd.NewCommandFramer(f)
+
+	typ, err := ecmd.ExecuteRead8(c, ecfr.PositionalAddr(-1, ecad.Type), 1)
+	if err != nil || typ != 0x11 {
+		t.Fatalf("read ESC type %#02x, %v", typ, err)
+	}
+
+	if err = ecmd.ExecuteWrite16(c, ecfr.PositionalAddr(-1, ecad.ConfiguredStationAddress), 0x1002, 1); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := ecmd.ExecuteRead16(c, ecfr.FixedAddr(0x1002, ecad.ConfiguredStationAddress), 1)
+	if err != nil || addr != 0x1002 {
+		t.Fatalf("read station address %#04x, %v", addr, err)
+	}
+
+	// a lost frame is retried
+	atomic.StoreInt32(&r.lose, 1)
+	if _, err = ecmd.ExecuteRead16(c, ecfr.BroadcastAddr(ecad.Type), 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnicastIPv4(t *testing.T) {
+	testUnicast(t, "udp4", "127.0.0.1")
+}
+
+func TestUnicastIPv6(t *testing.T) {
+	testUnicast(t, "udp6", "::1")
+}
+
+func newTestFramer(t *testing.T, r *responder, cycletime time.Duration) *UDPFramer {
+	f, err := NewUDPFramerConfig(Config{
+		Target:    r.addr(),
+		LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		CycleTime: cycletime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// queues n frames reading the station address of consecutive slaves.
+func queueReads(t *testing.T, f *UDPFramer, n int) {
+	for i := 0; i < n; i++ {
+		fr, err := f.New(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dg, err := fr.NewDatagram(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dg.Command = ecfr.APRD
+		dg.Index = uint8(i)
+		dg.Addr32 = ecfr.PositionalAddr(int16(-i), ecad.ConfiguredStationAddress).Addr32()
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	slaves := newSlaves(8)
+	for i, s := range slaves {
+		s.BackingMemory[ecad.ConfiguredStationAddress] = uint8(i + 1)
+	}
+	r := newResponder(t, "udp4", "127.0.0.1", slaves)
+	// frames coming back complete the cycle long before the cycle time
+	f := newTestFramer(t, r, 10*time.Second)
+
+	for _, window := range []int{0, 1, 3} {
+		f.Window = window
+		start := time.Now()
+		queueReads(t, f, 8)
+		iframes, err := f.Cycle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("window %d: cycle took %v", window, d)
+		}
+		if len(iframes) != 8 {
+			t.Fatalf("window %d: %d frames returned", window, len(iframes))
+		}
+		for _, fr := range iframes {
+			dg := fr.Datagrams[0]
+			if dg.WorkingCounter != 1 || dg.Data()[0] != dg.Index+1 {
+				t.Fatalf("window %d: unexpected answer %s", window, fr.MultilineSummary())
+			}
+		}
+	}
+}
+
+func TestLateAnswer(t *testing.T) {
+	r := newResponder(t, "udp4", "127.0.0.1", newSlaves(2))
+	f := newTestFramer(t, r, 20*time.Millisecond)
+
+	// the answer to the first cycle comes with that to the second
+	atomic.StoreInt32(&r.hold, 1)
+	queueReads(t, f, 1)
+	iframes, err := f.Cycle()
+	if err != nil || len(iframes) != 0 {
+		t.Fatalf("held back frame returned: %d frames, %v", len(iframes), err)
+	}
+
+	queueReads(t, f, 2)
+	f.oframes[0].Datagrams[0].Index = 7
+	iframes, err = f.Cycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := make(map[uint8]bool)
+	for _, fr := range iframes {
+		idx[fr.Datagrams[0].Index] = true
+	}
+	if len(iframes) != 2 || !idx[7] || !idx[1] {
+		t.Fatalf("want the frames 7 and 1 of the cycle, have %v", idx)
+	}
+}
+
+func TestForeignSender(t *testing.T) {
+	r := newResponder(t, "udp4", "127.0.0.1", newSlaves(1))
+	f, err := NewUDPFramerConfig(Config{
+		Target:    r.addr(),
+		LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		CycleTime: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// someone else answers in place of the gateway
+	atomic.StoreInt32(&r.lose, 1)
+	other, err := net.DialUDP("udp4", nil, f.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	fr, _ := f.New(2)
+	dg, _ := fr.NewDatagram(2)
+	dg.Command = ecfr.BRD
+	b, _ := fr.Commit()
+	if _, err = other.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	iframes, err := f.Cycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(iframes) != 0 {
+		t.Fatalf("accepted %d frames from a foreign sender", len(iframes))
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if _, err := NewUDPFramerConfig(Config{}); err == nil {
+		t.Fatal("opened without a target")
+	}
+	if _, err := NewUDPFramerConfig(Config{Target: &net.UDPAddr{IP: net.IPv4(239, 1, 2, 3)}}); err == nil {
+		t.Fatal("joined a group without an interface")
+	}
+
+	f, err := NewUDPFramerConfig(Config{Target: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, LocalAddr: &net.UDPAddr{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.target.Port() != EthercatUDPPort || !f.target.Addr().Is4() {
+		t.Fatalf("unexpected target %v", f.target)
+	}
+	// no debug address, no messages
+	f.DebugMessage("hello")
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	for _, c := range []struct {
+		b   []byte
+		typ ecfr.FrameType
+		n   int
+	}{
+		{[]byte{0x02, 0x10, 0xaa, 0xbb, 0xcc}, ecfr.FrameTypeCommand, 4},
+		{[]byte{0x00, 0x40}, ecfr.FrameTypeNetworkVariables, 2},
+		{[]byte{0x03, 0x10, 0xaa, 0xbb}, 0, -1},
+		{[]byte{0x02}, 0, -1},
+	} {
+		typ, b, err := parseHeader(c.b)
+		if c.n < 0 {
+			if err == nil {
+				t.Fatalf("% x: accepted", c.b)
+			}
+			continue
+		}
+		if err != nil || typ != c.typ || len(b) != c.n {
+			t.Fatalf("% x: type %v, %d bytes, %v", c.b, typ, len(b), err)
+		}
+	}
+}
+
+func TestCycleAllocs(t *testing.T) {
+	r := newResponder(t, "udp4", "127.0.0.1", newSlaves(2))
+	c := ecmd.NewCommandFramer(newTestFramer(t, r, 20*time.Millisecond))
+
+	cycle := func() {
+		for i := 0; i < 2; i++ {
+			ec, err := c.New(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ec.DatagramOut.Command = ecfr.APRD
+			ec.DatagramOut.Addr32 = ecfr.PositionalAddr(int16(-i), ecad.ConfiguredStationAddress).Addr32()
+		}
+		ec, err := c.New(64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec.DatagramOut.Command = ecfr.BRW
+		ec.DatagramOut.Addr32 = ecfr.BroadcastAddr(0x1000).Addr32()
+		if err = c.Cycle(); err != nil {
+			t.Fatal(err)
+		}
+		if err = ecmd.ChooseDefaultError(ec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the pools fill in the first cycle
+	cycle()
+	if allocs := testing.AllocsPerRun(100, cycle); allocs != 0 {
+		t.Fatalf("want no allocations per cycle, have %v", allocs)
+	}
+}
@@ -1,9 +1,10 @@
 package raweni
 
 import (
-	"github.com/rogpeppe/go-charset/charset"
+	"encoding/hex"
 	"encoding/xml"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/rogpeppe/go-charset/charset"
 	"io"
 	"os"
 	"strconv"
@@ -65,10 +66,11 @@ type LcIdentifiedName struct {
 }
 
 type Device struct {
-	Type   DeviceType
-	Names  []LcIdentifiedName `xml:"Name"`
-	Sms    []Sm               `xml:"Sm"`
-	Eeprom Eeprom
+	Type    DeviceType
+	Names   []LcIdentifiedName `xml:"Name"`
+	Sms     []Sm               `xml:"Sm"`
+	Eeprom  Eeprom
+	Profile Profile
 }
 
 type DeviceType struct {
@@ -125,3 +127,78 @@ type Eeprom struct {
 	ByteSize      uint
 	ConfigDataRaw string `xml:"ConfigData"`
 }
+
+type Profile struct {
+	ProfileNo  string
+	Dictionary Dictionary
+}
+
+type Dictionary struct {
+	DataTypes []DataType `xml:"DataTypes>DataType"`
+	Objects   []Object   `xml:"Objects>Object"`
+}
+
+func (d Dictionary) DataType(name string) (DataType, bool) {
+	for _, dt := range d.DataTypes {
+		if dt.Name == name {
+			return dt, true
+		}
+	}
+	return DataType{}, false
+}
+
+type DataType struct {
+	Name     string
+	BitSize  uint
+	SubItems []DataTypeSubItem `xml:"SubItem"`
+}
+
+type DataTypeSubItem struct {
+	SubIdxRaw string `xml:"SubIdx"`
+	Name      string
+	Type      string
+	BitSize   uint
+	BitOffs   uint
+	Flags     Flags
+}
+
+func (s DataTypeSubItem) SubIdx() uint8 {
+	return uint8(bh2i(s.SubIdxRaw))
+}
+
+type Object struct {
+	IndexRaw string `xml:"Index"`
+	Name     string
+	Type     string
+	BitSize  uint
+	Info     ObjectInfo
+	Flags    Flags
+}
+
+func (o Object) Index() uint16 {
+	return uint16(bh2i(o.IndexRaw))
+}
+
+type ObjectInfo struct {
+	DefaultDataRaw string          `xml:"DefaultData"`
+	SubItems       []ObjectSubItem `xml:"SubItem"`
+}
+
+// DefaultData decodes the hex encoded default data, nil if there is none or
+// it is malformed.
+func (i ObjectInfo) DefaultData() []byte {
+	b, err := hex.DecodeString(strings.TrimSpace(i.DefaultDataRaw))
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+type ObjectSubItem struct {
+	Name string
+	Info ObjectInfo
+}
+
+type Flags struct {
+	Access string
+}
@@ -0,0 +1,342 @@
+package sim
+
+const (
+	mailboxHeaderLen = 6
+
+	MailboxTypeError = 0x00
+	MailboxTypeCoE   = 0x03
+
+	// mailbox error reply codes
+	mailboxErrorSyntax              = 0x01
+	mailboxErrorUnsupportedProtocol = 0x02
+	mailboxErrorInvalidSize         = 0x08
+
+	coeHeaderLen = 2
+	// CoE services
+	coeServiceSDORequest  = 0x02
+	coeServiceSDOResponse = 0x03
+
+	// command specifier, size, index, subindex and data or complete size
+	sdoHeaderLen = 8
+
+	// client command specifiers
+	sdoCCSDownloadSegment  = 0
+	sdoCCSInitiateDownload = 1
+	sdoCCSInitiateUpload   = 2
+	sdoCCSUploadSegment    = 3
+	sdoCCSAbort            = 4
+
+	// segments carry at least this many data bytes
+	sdoMinSegmentData = 7
+)
+
+// SDO abort codes
+const (
+	SDOAbortToggleBit            = 0x05030000
+	SDOAbortCommandSpecifier     = 0x05040001
+	SDOAbortUnsupportedAccess    = 0x06010000
+	SDOAbortWriteOnly            = 0x06010001
+	SDOAbortReadOnly             = 0x06010002
+	SDOAbortObjectDoesNotExist   = 0x06020000
+	SDOAbortLengthMismatch       = 0x06070010
+	SDOAbortLengthTooHigh        = 0x06070012
+	SDOAbortLengthTooLow         = 0x06070013
+	SDOAbortSubIndexDoesNotExist = 0x06090011
+	SDOAbortGeneralError         = 0x08000000
+)
+
+type sdoTransfer struct {
+	addr   ODAddress
+	entry  *ODEntry
+	data   []byte
+	size   int
+	toggle bool
+}
+
+// CoEServer answers SDO upload and download requests against an object
+// dictionary. it is meant to be set as the Mailbox of an L2Slave.
+type CoEServer struct {
+	OD ObjectDictionary
+
+	upload   *sdoTransfer
+	download *sdoTransfer
+}
+
+func NewCoEServer(od ObjectDictionary) *CoEServer {
+	return &CoEServer{OD: od}
+}
+
+func (c *CoEServer) HandleMailbox(msg []byte, maxlen int) [][]byte {
+	if len(msg) < mailboxHeaderLen {
+		return nil
+	}
+
+	l := int(xgetUint16(msg))
+	if mailboxHeaderLen+l > len(msg) {
+		return [][]byte{mailboxError(mailboxErrorInvalidSize)}
+	}
+	typ := msg[5] & 0x0f
+	data := msg[mailboxHeaderLen : mailboxHeaderLen+l]
+
+	if typ != MailboxTypeCoE {
+		return [][]byte{mailboxError(mailboxErrorUnsupportedProtocol)}
+	}
+
+	if len(data) < coeHeaderLen+1 || xgetUint16(data)>>12 != coeServiceSDORequest {
+		return [][]byte{mailboxError(mailboxErrorSyntax)}
+	}
+
+	resp := c.handleSDO(data[coeHeaderLen:], maxlen-mailboxHeaderLen-coeHeaderLen)
+	if resp == nil {
+		return nil
+	}
+	return [][]byte{coeMessage(coeServiceSDOResponse, resp)}
+}
+
+// handles an SDO request, maxlen is the space for the SDO response
+func (c *CoEServer) handleSDO(req []byte, maxlen int) []byte {
+	ccs := req[0] >> 5
+	switch ccs {
+	case sdoCCSInitiateUpload:
+		c.upload = nil
+		return c.initiateUpload(req, maxlen)
+	case sdoCCSUploadSegment:
+		return c.uploadSegment(req, maxlen)
+	case sdoCCSInitiateDownload:
+		c.download = nil
+		return c.initiateDownload(req)
+	case sdoCCSDownloadSegment:
+		return c.downloadSegment(req)
+	case sdoCCSAbort:
+		c.upload = nil
+		c.download = nil
+		return nil
+	}
+
+	return sdoAbort(sdoAddress(req), SDOAbortCommandSpecifier)
+}
+
+func sdoAddress(req []byte) ODAddress {
+	if len(req) < 4 {
+		return ODAddress{}
+	}
+	return ODAddress{xgetUint16(req[1:]), req[3]}
+}
+
+func sdoHeader(cmd uint8, a ODAddress, v uint32) []byte {
+	b := make([]byte, sdoHeaderLen)
+	b[0] = cmd
+	putUint16(b[1:], a.Index)
+	b[3] = a.SubIndex
+	putUint32(b[4:], v)
+	return b
+}
+
+func sdoAbort(a ODAddress, code uint32) []byte {
+	return sdoHeader(sdoCCSAbort<<5, a, code)
+}
+
+func (c *CoEServer) initiateUpload(req []byte, maxlen int) []byte {
+	a := sdoAddress(req)
+	if len(req) < sdoHeaderLen {
+		return sdoAbort(a, SDOAbortGeneralError)
+	}
+	if req[0]&0x10 != 0 {
+		// complete access
+		return sdoAbort(a, SDOAbortUnsupportedAccess)
+	}
+
+	e, abort := c.OD.lookup(a)
+	if abort != 0 {
+		return sdoAbort(a, abort)
+	}
+	if e.Access == ODWriteOnly {
+		return sdoAbort(a, SDOAbortWriteOnly)
+	}
+
+	if len(e.Data) <= 4 && len(e.Data) > 0 {
+		// expedited, size indicated
+		n := uint8(4 - len(e.Data))
+		resp := sdoHeader(0x43|n<<2, a, 0)
+		copy(resp[4:], e.Data)
+		return resp
+	}
+
+	// normal, as much data as fits and the rest in segments
+	resp := sdoHeader(0x41, a, uint32(len(e.Data)))
+	n := len(e.Data)
+	if n > maxlen-sdoHeaderLen {
+		n = maxlen - sdoHeaderLen
+		if n < 0 {
+			n = 0
+		}
+		c.upload = &sdoTransfer{addr: a, entry: e, data: e.Data[n:]}
+	}
+	return append(resp, e.Data[:n]...)
+}
+
+func (c *CoEServer) uploadSegment(req []byte, maxlen int) []byte {
+	t := c.upload
+	if t == nil {
+		return sdoAbort(ODAddress{}, SDOAbortCommandSpecifier)
+	}
+
+	toggle := req[0]&0x10 != 0
+	if toggle != t.toggle {
+		c.upload = nil
+		return sdoAbort(t.addr, SDOAbortToggleBit)
+	}
+	t.toggle = !t.toggle
+
+	// not even a segment of minimum length fits
+	if maxlen < 1+sdoMinSegmentData {
+		c.upload = nil
+		return sdoAbort(t.addr, SDOAbortGeneralError)
+	}
+
+	n := len(t.data)
+	last := true
+	if n > maxlen-1 {
+		n = maxlen - 1
+		last = false
+	}
+
+	cmd := req[0] & 0x10
+	if last {
+		cmd |= 0x01
+		c.upload = nil
+	}
+
+	seglen := n
+	if n < sdoMinSegmentData {
+		cmd |= uint8(sdoMinSegmentData-n) << 1
+		seglen = sdoMinSegmentData
+	}
+
+	resp := make([]byte, 1+seglen)
+	resp[0] = cmd
+	copy(resp[1:], t.data[:n])
+	t.data = t.data[n:]
+	return resp
+}
+
+func (c *CoEServer) initiateDownload(req []byte) []byte {
+	a := sdoAddress(req)
+	if len(req) < sdoHeaderLen {
+		return sdoAbort(a, SDOAbortGeneralError)
+	}
+	if req[0]&0x10 != 0 {
+		return sdoAbort(a, SDOAbortUnsupportedAccess)
+	}
+
+	e, abort := c.OD.lookup(a)
+	if abort != 0 {
+		return sdoAbort(a, abort)
+	}
+	if e.Access == ODReadOnly {
+		return sdoAbort(a, SDOAbortReadOnly)
+	}
+
+	expedited := req[0]&0x02 != 0
+	sized := req[0]&0x01 != 0
+	if expedited {
+		n := 4
+		if sized {
+			n -= int(req[0]>>2) & 0x03
+		}
+		return c.finishDownload(a, e, req[4:4+n])
+	}
+
+	if !sized {
+		return sdoAbort(a, SDOAbortGeneralError)
+	}
+
+	size := int(xgetUint32(req[4:]))
+	data := req[sdoHeaderLen:]
+	if len(data) >= size {
+		return c.finishDownload(a, e, data[:size])
+	}
+
+	c.download = &sdoTransfer{addr: a, entry: e, size: size}
+	c.download.data = append(c.download.data, data...)
+	return sdoHeader(0x60, a, 0)
+}
+
+func (c *CoEServer) downloadSegment(req []byte) []byte {
+	t := c.download
+	if t == nil {
+		return sdoAbort(ODAddress{}, SDOAbortCommandSpecifier)
+	}
+
+	toggle := req[0]&0x10 != 0
+	if toggle != t.toggle {
+		c.download = nil
+		return sdoAbort(t.addr, SDOAbortToggleBit)
+	}
+	t.toggle = !t.toggle
+
+	data := req[1:]
+	if len(data) <= sdoMinSegmentData {
+		unused := int(req[0]>>1) & 0x07
+		if unused > len(data) {
+			unused = len(data)
+		}
+		data = data[:len(data)-unused]
+	}
+	t.data = append(t.data, data...)
+
+	resp := []byte{0x20 | req[0]&0x10, 0, 0, 0, 0, 0, 0, 0}
+	if req[0]&0x01 == 0 {
+		if len(t.data) > t.size {
+			c.download = nil
+			return sdoAbort(t.addr, SDOAbortLengthTooHigh)
+		}
+		return resp
+	}
+
+	c.download = nil
+	if len(t.data) != t.size {
+		return sdoAbort(t.addr, SDOAbortLengthMismatch)
+	}
+	if abort := c.finishDownload(t.addr, t.entry, t.data); abort[0]>>5 == sdoCCSAbort {
+		return abort
+	}
+	return resp
+}
+
+func (c *CoEServer) finishDownload(a ODAddress, e *ODEntry, data []byte) []byte {
+	switch {
+	case e.Variable:
+		if e.MaxLength > 0 && len(data) > e.MaxLength {
+			return sdoAbort(a, SDOAbortLengthTooHigh)
+		}
+		e.Data = append(e.Data[:0], data...)
+	case len(data) > len(e.Data):
+		return sdoAbort(a, SDOAbortLengthTooHigh)
+	case len(data) < len(e.Data):
+		return sdoAbort(a, SDOAbortLengthTooLow)
+	default:
+		copy(e.Data, data)
+	}
+
+	return sdoHeader(0x60, a, 0)
+}
+
+func coeMessage(service uint16, sdo []byte) []byte {
+	b := make([]byte, mailboxHeaderLen+coeHeaderLen+len(sdo))
+	putUint16(b, uint16(coeHeaderLen+len(sdo)))
+	b[5] = MailboxTypeCoE
+	putUint16(b[mailboxHeaderLen:], service<<12)
+	copy(b[mailboxHeaderLen+coeHeaderLen:], sdo)
+	return b
+}
+
+func mailboxError(code uint16) []byte {
+	b := make([]byte, mailboxHeaderLen+4)
+	putUint16(b, 4)
+	b[5] = MailboxTypeError
+	// service 1 is the error reply
+	putUint16(b[mailboxHeaderLen:], 0x01)
+	putUint16(b[mailboxHeaderLen+2:], code)
+	return b
+}
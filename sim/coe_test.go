@@ -0,0 +1,305 @@
+package sim
+
+import (
+	"bytes"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"github.com/distributed/ecat/raweni"
+	"strings"
+	"testing"
+)
+
+const (
+	testMbxOut    = 0x1000
+	testMbxIn     = 0x1080
+	testMbxLength = 0x30
+)
+
+type testMailboxClient struct {
+	t *testing.T
+	c ecmd.Commander
+}
+
+func newCoETestSlave(t *testing.T, od ObjectDictionary) (*L2Slave, *testMailboxClient) {
+	slave := NewL2Slave()
+	slave.SetupMailbox(testMbxOut, testMbxLength, testMbxIn, testMbxLength)
+	slave.Mailbox = NewCoEServer(od)
+	bus := &L2Bus{Slaves: []FrameProcessor{slave}}
+	return slave, &testMailboxClient{t, ecmd.NewCommandFramer(bus)}
+}
+
+// sends an SDO request and returns the SDO part of the response
+func (mc *testMailboxClient) sdo(req []byte) []byte {
+	msg := make([]byte, testMbxLength)
+	putUint16(msg, uint16(coeHeaderLen+len(req)))
+	msg[5] = MailboxTypeCoE
+	putUint16(msg[mailboxHeaderLen:], coeServiceSDORequest<<12)
+	copy(msg[mailboxHeaderLen+coeHeaderLen:], req)
+
+	err := ecmd.ExecuteWrite(mc.c, ecfr.PositionalAddr(0, testMbxOut), msg, 1)
+	if err != nil {
+		mc.t.Fatalf("writing mailbox failed: %v", err)
+	}
+
+	status, err := ecmd.ExecuteRead8(mc.c, ecfr.PositionalAddr(0, ecad.SyncMangerBase+ecad.SyncManagerChannelLen+ecad.SyncManagerStatusOffset), 1)
+	if err != nil {
+		mc.t.Fatalf("reading SM1 status failed: %v", err)
+	}
+	if status&smStatusMailboxFull == 0 {
+		mc.t.Fatalf("no mailbox response")
+	}
+
+	resp, err := ecmd.ExecuteRead(mc.c, ecfr.PositionalAddr(0, testMbxIn), testMbxLength, 1)
+	if err != nil {
+		mc.t.Fatalf("reading mailbox failed: %v", err)
+	}
+
+	if resp[5]&0x0f != MailboxTypeCoE {
+		mc.t.Fatalf("response is not CoE: % x", resp)
+	}
+	l := int(xgetUint16(resp))
+	return resp[mailboxHeaderLen+coeHeaderLen : mailboxHeaderLen+l]
+}
+
+func (mc *testMailboxClient) expectAbort(resp []byte, code uint32) {
+	if resp[0] != 0x80 {
+		mc.t.Fatalf("expected abort, got response % x", resp)
+	}
+	if have := xgetUint32(resp[4:]); have != code {
+		mc.t.Fatalf("want abort code %#08x, have %#08x", code, have)
+	}
+}
+
+func uploadRequest(a ODAddress) []byte {
+	return sdoHeader(0x40, a, 0)
+}
+
+func testOD() ObjectDictionary {
+	return ObjectDictionary{
+		{0x1000, 0}: {Data: []byte{0x92, 0x01, 0x00, 0x00}, Access: ODReadOnly},
+		{0x1008, 0}: {Data: []byte("a device name that does not fit into one mailbox"), Access: ODReadOnly, Variable: true},
+		{0x2000, 0}: {Data: []byte{0x00, 0x00}},
+		{0x2001, 0}: {Data: make([]byte, 64)},
+	}
+}
+
+func TestCoEExpeditedUpload(t *testing.T) {
+	_, mc := newCoETestSlave(t, testOD())
+
+	resp := mc.sdo(uploadRequest(ODAddress{0x1000, 0}))
+	if resp[0] != 0x43 || xgetUint32(resp[4:]) != 0x0192 {
+		t.Fatalf("unexpected upload response % x", resp)
+	}
+
+	mc.expectAbort(mc.sdo(uploadRequest(ODAddress{0x1001, 0})), SDOAbortObjectDoesNotExist)
+	mc.expectAbort(mc.sdo(uploadRequest(ODAddress{0x1000, 1})), SDOAbortSubIndexDoesNotExist)
+}
+
+func TestCoESegmentedUpload(t *testing.T) {
+	od := testOD()
+	_, mc := newCoETestSlave(t, od)
+
+	resp := mc.sdo(uploadRequest(ODAddress{0x1008, 0}))
+	if resp[0] != 0x41 {
+		t.Fatalf("expected normal upload response, got % x", resp)
+	}
+	size := int(xgetUint32(resp[4:]))
+	data := append([]byte(nil), resp[sdoHeaderLen:]...)
+
+	toggle := uint8(0)
+	for {
+		resp = mc.sdo([]byte{0x60 | toggle, 0, 0, 0, 0, 0, 0, 0})
+		if resp[0]&0xe0 != 0 || resp[0]&0x10 != toggle {
+			t.Fatalf("unexpected segment response % x", resp)
+		}
+
+		seg := resp[1:]
+		if len(seg) == sdoMinSegmentData {
+			seg = seg[:sdoMinSegmentData-int(resp[0]>>1&0x07)]
+		}
+		data = append(data, seg...)
+		toggle ^= 0x10
+
+		if resp[0]&0x01 != 0 {
+			break
+		}
+	}
+
+	if len(data) != size || !bytes.Equal(data, od[ODAddress{0x1008, 0}].Data) {
+		t.Fatalf("uploaded %q, want %q", data, od[ODAddress{0x1008, 0}].Data)
+	}
+
+	// a segment request without transfer in progress
+	mc.expectAbort(mc.sdo([]byte{0x60, 0, 0, 0, 0, 0, 0, 0}), SDOAbortCommandSpecifier)
+}
+
+func TestCoEUploadSmallMailbox(t *testing.T) {
+	mc := &testMailboxClient{t: t}
+	for _, maxlen := range []int{0, 1, sdoMinSegmentData} {
+		c := NewCoEServer(testOD())
+		if resp := c.handleSDO(uploadRequest(ODAddress{0x1008, 0}), maxlen); resp[0] != 0x41 {
+			t.Fatalf("expected normal upload response, got % x", resp)
+		}
+		mc.expectAbort(c.handleSDO([]byte{0x60, 0, 0, 0, 0, 0, 0, 0}, maxlen), SDOAbortGeneralError)
+		if c.upload != nil {
+			t.Fatalf("transfer still in progress after abort")
+		}
+	}
+}
+
+func TestCoEDownload(t *testing.T) {
+	od := testOD()
+	_, mc := newCoETestSlave(t, od)
+
+	req := sdoHeader(0x2b, ODAddress{0x2000, 0}, 0xbeef)
+	resp := mc.sdo(req)
+	if resp[0] != 0x60 {
+		t.Fatalf("unexpected download response % x", resp)
+	}
+	if !bytes.Equal(od[ODAddress{0x2000, 0}].Data, []byte{0xef, 0xbe}) {
+		t.Fatalf("download did not change the object, have % x", od[ODAddress{0x2000, 0}].Data)
+	}
+
+	mc.expectAbort(mc.sdo(sdoHeader(0x2f, ODAddress{0x2000, 0}, 1)), SDOAbortLengthTooLow)
+	mc.expectAbort(mc.sdo(sdoHeader(0x23, ODAddress{0x1000, 0}, 1)), SDOAbortReadOnly)
+}
+
+func TestCoESegmentedDownload(t *testing.T) {
+	od := testOD()
+	_, mc := newCoETestSlave(t, od)
+
+	want := make([]byte, 64)
+	for i := range want {
+		want[i] = uint8(i)
+	}
+
+	// initiate with as much data as fits
+	space := testMbxLength - mailboxHeaderLen - coeHeaderLen - sdoHeaderLen
+	req := append(sdoHeader(0x21, ODAddress{0x2001, 0}, uint32(len(want))), want[:space]...)
+	resp := mc.sdo(req)
+	if resp[0] != 0x60 {
+		t.Fatalf("unexpected initiate download response % x", resp)
+	}
+
+	rest := want[space:]
+	toggle := uint8(0)
+	for len(rest) > 0 {
+		n := len(rest)
+		if n > 20 {
+			n = 20
+		}
+		cmd := toggle
+		if n == len(rest) {
+			cmd |= 0x01
+		}
+		seg := append([]byte{cmd}, rest[:n]...)
+		if n < sdoMinSegmentData {
+			seg[0] |= uint8(sdoMinSegmentData-n) << 1
+			seg = append(seg, make([]byte, sdoMinSegmentData-n)...)
+		}
+
+		resp = mc.sdo(seg)
+		if resp[0] != 0x20|toggle {
+			t.Fatalf("unexpected download segment response % x", resp)
+		}
+		rest = rest[n:]
+		toggle ^= 0x10
+	}
+
+	if !bytes.Equal(od[ODAddress{0x2001, 0}].Data, want) {
+		t.Fatalf("segmented download produced % x", od[ODAddress{0x2001, 0}].Data)
+	}
+
+	// wrong toggle bit
+	resp = mc.sdo(req)
+	if resp[0] != 0x60 {
+		t.Fatalf("unexpected initiate download response % x", resp)
+	}
+	mc.expectAbort(mc.sdo([]byte{0x10, 0, 0, 0, 0, 0, 0, 0}), SDOAbortToggleBit)
+}
+
+const testESIDictionary = `<?xml version="1.0"?>
+<EtherCATInfo>
+  <Descriptions>
+    <Devices>
+      <Device>
+        <Type ProductCode="#x1" RevisionNo="#x1">Test</Type>
+        <Profile>
+          <Dictionary>
+            <DataTypes>
+              <DataType><Name>UDINT</Name><BitSize>32</BitSize></DataType>
+              <DataType><Name>USINT</Name><BitSize>8</BitSize></DataType>
+              <DataType>
+                <Name>DT1018</Name><BitSize>48</BitSize>
+                <SubItem><SubIdx>0</SubIdx><Name>SubIndex 000</Name><Type>USINT</Type><BitSize>8</BitSize><BitOffs>0</BitOffs><Flags><Access>ro</Access></Flags></SubItem>
+                <SubItem><SubIdx>1</SubIdx><Name>Vendor ID</Name><Type>UDINT</Type><BitSize>32</BitSize><BitOffs>16</BitOffs><Flags><Access>ro</Access></Flags></SubItem>
+              </DataType>
+            </DataTypes>
+            <Objects>
+              <Object><Index>#x1000</Index><Name>Device type</Name><Type>UDINT</Type><BitSize>32</BitSize><Info><DefaultData>92010000</DefaultData></Info><Flags><Access>ro</Access></Flags></Object>
+              <Object>
+                <Index>#x1018</Index><Name>Identity</Name><Type>DT1018</Type><BitSize>48</BitSize>
+                <Info>
+                  <SubItem><Name>SubIndex 000</Name><Info><DefaultData>01</DefaultData></Info></SubItem>
+                  <SubItem><Name>Vendor ID</Name><Info><DefaultData>02000000</DefaultData></Info></SubItem>
+                </Info>
+              </Object>
+            </Objects>
+          </Dictionary>
+        </Profile>
+      </Device>
+    </Devices>
+  </Descriptions>
+</EtherCATInfo>`
+
+func TestCoEObjectDictionaryFromESI(t *testing.T) {
+	eci, err := raweni.ReadEtherCATInfo(strings.NewReader(testESIDictionary))
+	if err != nil {
+		t.Fatalf("reading ESI failed: %v", err)
+	}
+
+	od, err := NewObjectDictionaryFromESI(eci.Descriptions.Devices[0].Profile.Dictionary)
+	if err != nil {
+		t.Fatalf("NewObjectDictionaryFromESI failed: %v", err)
+	}
+
+	_, mc := newCoETestSlave(t, od)
+
+	resp := mc.sdo(uploadRequest(ODAddress{0x1018, 1}))
+	if resp[0] != 0x43 || xgetUint32(resp[4:]) != 2 {
+		t.Fatalf("unexpected upload response for vendor id % x", resp)
+	}
+
+	resp = mc.sdo(uploadRequest(ODAddress{0x1018, 0}))
+	if resp[0] != 0x4f || resp[4] != 1 {
+		t.Fatalf("unexpected upload response for subindex 0 % x", resp)
+	}
+
+	mc.expectAbort(mc.sdo(sdoHeader(0x23, ODAddress{0x1018, 1}, 1)), SDOAbortReadOnly)
+}
+
+func TestSyncManagerChannelWrite(t *testing.T) {
+	slave := NewL2Slave()
+	c := ecmd.NewCommandFramer(&L2Bus{Slaves: []FrameProcessor{slave}})
+
+	// the whole channel, as a master configures a mailbox. the status byte
+	// is read only, the write counts nevertheless.
+	w := []byte{0x00, 0x10, testMbxLength, 0x00, 0x26, 0xff, 0x01, 0x00}
+	err := ecmd.ExecuteWrite(c, ecfr.PositionalAddr(0, ecad.SyncMangerBase), w, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := slave.SyncManagers[0]
+	if sm.Start != 0x1000 || sm.Length != testMbxLength || sm.Control != 0x26 || !sm.enabled() {
+		t.Fatalf("channel not configured: %+v", sm)
+	}
+
+	status, err := ecmd.ExecuteRead8(c, ecfr.PositionalAddr(0, ecad.SyncMangerBase+ecad.SyncManagerStatusOffset), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status&smStatusMailboxFull != 0 {
+		t.Fatalf("status was written, %#02x", status)
+	}
+}
@@ -0,0 +1,319 @@
+package sim
+
+// DistributedClock simulates the DC unit of an ESC. all times are in
+// nanoseconds. simulated time is the time base of the bus, local time is
+// what the slave's free running clock shows.
+type DistributedClock struct {
+	// local time at simulated time 0
+	Offset int64
+	// relative deviation of the local clock rate, 50e-6 is 50 ppm fast
+	Drift float64
+
+	ReceiveTimes         [NumPorts]uint32
+	ReceiveTimeEPU       uint64
+	SystemTimeOffset     uint64
+	SystemTimeDelay      uint32
+	SystemTimeDifference uint32
+	DiffFilterDepth      uint8
+
+	CyclicUnitControl uint8
+	Activation        uint8
+	PulseLength       uint16
+	StartTime         uint64
+	Sync0CycleTime    uint32
+	Sync1CycleTime    uint32
+
+	// number of SYNC0 events generated so far
+	Sync0Events int
+	Sync0Status bool
+
+	now        int64
+	correction int64
+	// rate adjustment applied by the time control loop
+	rate            float64
+	lastControlTime int64
+
+	portTimes    [NumPorts]int64
+	portPassed   [NumPorts]bool
+	latchPending bool
+
+	sync0Armed bool
+	nextSync0  uint64
+
+	store [dcRegLength]byte
+}
+
+const (
+	dcRegLength = 0xb0
+
+	dcActivateCyclic = 0x01
+	dcActivateSync0  = 0x02
+
+	defaultDiffFilterDepth = 4
+)
+
+func NewDistributedClock() *DistributedClock {
+	return &DistributedClock{DiffFilterDepth: defaultDiffFilterDepth}
+}
+
+func (dc *DistributedClock) LocalTime(simtime int64) int64 {
+	return dc.Offset + simtime + int64(float64(simtime)*(dc.Drift+dc.rate)) + dc.correction
+}
+
+// system time as seen by the slave at the current simulated time
+func (dc *DistributedClock) SystemTime() uint64 {
+	return uint64(dc.LocalTime(dc.now)) + dc.SystemTimeOffset
+}
+
+// advances the clock to simtime and generates the SYNC0 events due up to
+// then.
+func (dc *DistributedClock) setTime(simtime int64) {
+	if simtime > dc.now {
+		dc.now = simtime
+	}
+
+	if !dc.sync0Armed {
+		return
+	}
+
+	st := dc.SystemTime()
+	if st < dc.nextSync0 {
+		return
+	}
+
+	dc.Sync0Status = true
+	if dc.Sync0CycleTime == 0 {
+		// single shot
+		dc.Sync0Events++
+		dc.sync0Armed = false
+		return
+	}
+
+	n := (st-dc.nextSync0)/uint64(dc.Sync0CycleTime) + 1
+	dc.Sync0Events += int(n)
+	dc.nextSync0 += n * uint64(dc.Sync0CycleTime)
+}
+
+// records that the current frame passed port at simulated time simtime.
+func (dc *DistributedClock) rxPort(port int, simtime int64) {
+	dc.portTimes[port] = dc.LocalTime(simtime)
+	dc.portPassed[port] = true
+}
+
+// called when the frame has left the bus. receive times are latched if the
+// frame wrote to the receive time register.
+func (dc *DistributedClock) frameDone() {
+	if dc.latchPending {
+		for i := range dc.portTimes {
+			if dc.portPassed[i] {
+				dc.ReceiveTimes[i] = uint32(dc.portTimes[i])
+			}
+		}
+	}
+
+	dc.latchPending = false
+	for i := range dc.portPassed {
+		dc.portPassed[i] = false
+	}
+}
+
+// time control loop, received is the system time written by the reference
+// clock. with a 32 bit write only the lower half is compared.
+func (dc *DistributedClock) controlLoop(received uint64, wide bool) {
+	var diff int64
+	local := dc.SystemTime()
+	if wide {
+		diff = int64(received + uint64(dc.SystemTimeDelay) - local)
+	} else {
+		diff = int64(int32(uint32(received) + dc.SystemTimeDelay - uint32(local)))
+	}
+
+	mag := diff
+	dc.SystemTimeDifference = 0
+	if diff > 0 {
+		// local copy of the system time is smaller than the received one
+		dc.SystemTimeDifference = 1 << 31
+	} else {
+		mag = -diff
+	}
+	if mag > 0x7fffffff {
+		mag = 0x7fffffff
+	}
+	dc.SystemTimeDifference |= uint32(mag)
+
+	step := diff >> dc.DiffFilterDepth
+	if step == 0 && diff != 0 {
+		if diff > 0 {
+			step = 1
+		} else {
+			step = -1
+		}
+	}
+	dc.correction += step
+
+	// the remaining difference per elapsed time adjusts the clock rate,
+	// which compensates drift. the local time stays continuous.
+	if dt := dc.now - dc.lastControlTime; dt > 0 && dc.lastControlTime != 0 {
+		drate := float64(diff) / float64(dt) / float64(int64(1)<<dc.DiffFilterDepth)
+		dc.correction -= int64(float64(dc.now) * drate)
+		dc.rate += drate
+	}
+	dc.lastControlTime = dc.now
+}
+
+func (dc *DistributedClock) Reg() *DCRegisterSet {
+	return &DCRegisterSet{dc}
+}
+
+type DCRegisterSet struct{ *DistributedClock }
+
+func byteOf(v uint64, i uint16) uint8 {
+	return uint8(v >> (8 * i))
+}
+
+func (dc *DCRegisterSet) Read(offs uint16, dp *uint8) bool {
+	switch {
+	case offs < 0x10:
+		*dp = byteOf(uint64(dc.ReceiveTimes[offs/4]), offs%4)
+	case offs < 0x18:
+		*dp = byteOf(dc.SystemTime(), offs-0x10)
+	case offs < 0x20:
+		*dp = byteOf(dc.ReceiveTimeEPU, offs-0x18)
+	case offs < 0x28:
+		*dp = byteOf(dc.SystemTimeOffset, offs-0x20)
+	case offs < 0x2c:
+		*dp = byteOf(uint64(dc.SystemTimeDelay), offs-0x28)
+	case offs < 0x30:
+		*dp = byteOf(uint64(dc.SystemTimeDifference), offs-0x2c)
+	case offs == 0x34:
+		*dp = dc.DiffFilterDepth
+	case offs == 0x80:
+		*dp = dc.CyclicUnitControl
+	case offs == 0x81:
+		*dp = dc.Activation
+	case offs == 0x82 || offs == 0x83:
+		*dp = byteOf(uint64(dc.PulseLength), offs-0x82)
+	case offs == 0x8e:
+		// cleared by reading
+		*dp = 0
+		if dc.Sync0Status {
+			*dp = 0x01
+		}
+		dc.Sync0Status = false
+	case offs >= 0x90 && offs < 0x98:
+		// reads return the time of the next SYNC0 pulse
+		*dp = byteOf(dc.nextSync0, offs-0x90)
+	case offs >= 0xa0 && offs < 0xa4:
+		*dp = byteOf(uint64(dc.Sync0CycleTime), offs-0xa0)
+	case offs >= 0xa4 && offs < 0xa8:
+		*dp = byteOf(uint64(dc.Sync1CycleTime), offs-0xa4)
+	default:
+		*dp = dc.store[offs]
+	}
+
+	return true
+}
+
+func (dc *DCRegisterSet) WriteInteract(offs uint16) bool {
+	switch {
+	case offs >= 0x18 && offs < 0x20:
+		// receive time EPU
+		return false
+	case offs >= 0x2c && offs < 0x30:
+		// system time difference
+		return false
+	case offs == 0x8e || offs == 0x8f:
+		// SYNC status
+		return false
+	}
+	return true
+}
+
+// applies the written bytes of shadow to the little endian value v.
+func applyShadow(v uint64, shadow []byte, shadowWriteMask []bool) (uint64, bool) {
+	written := false
+	for i := range shadow {
+		if shadowWriteMask[i] {
+			v &^= 0xff << (8 * uint(i))
+			v |= uint64(shadow[i]) << (8 * uint(i))
+			written = true
+		}
+	}
+	return v, written
+}
+
+func (dc *DCRegisterSet) Latch(shadow []byte, shadowWriteMask []bool) {
+	if shadowWriteMask[0] {
+		// any write to the receive time register of port 0 latches the
+		// receive times of all ports
+		dc.latchPending = true
+		dc.ReceiveTimeEPU = uint64(dc.LocalTime(dc.now))
+	}
+
+	if v, ok := applyShadow(0, shadow[0x10:0x18], shadowWriteMask[0x10:0x18]); ok {
+		wide := false
+		for _, w := range shadowWriteMask[0x14:0x18] {
+			wide = wide || w
+		}
+		dc.controlLoop(v, wide)
+	}
+
+	var (
+		v  uint64
+		ok bool
+	)
+
+	if v, ok = applyShadow(dc.SystemTimeOffset, shadow[0x20:0x28], shadowWriteMask[0x20:0x28]); ok {
+		dc.SystemTimeOffset = v
+	}
+
+	if v, ok = applyShadow(uint64(dc.SystemTimeDelay), shadow[0x28:0x2c], shadowWriteMask[0x28:0x2c]); ok {
+		dc.SystemTimeDelay = uint32(v)
+	}
+
+	if shadowWriteMask[0x34] {
+		dc.DiffFilterDepth = shadow[0x34] & 0x0f
+	}
+
+	if shadowWriteMask[0x80] {
+		dc.CyclicUnitControl = shadow[0x80]
+	}
+
+	if v, ok = applyShadow(uint64(dc.PulseLength), shadow[0x82:0x84], shadowWriteMask[0x82:0x84]); ok {
+		dc.PulseLength = uint16(v)
+	}
+
+	if v, ok = applyShadow(dc.StartTime, shadow[0x90:0x98], shadowWriteMask[0x90:0x98]); ok {
+		dc.StartTime = v
+	}
+
+	if v, ok = applyShadow(uint64(dc.Sync0CycleTime), shadow[0xa0:0xa4], shadowWriteMask[0xa0:0xa4]); ok {
+		dc.Sync0CycleTime = uint32(v)
+	}
+
+	if v, ok = applyShadow(uint64(dc.Sync1CycleTime), shadow[0xa4:0xa8], shadowWriteMask[0xa4:0xa8]); ok {
+		dc.Sync1CycleTime = uint32(v)
+	}
+
+	if shadowWriteMask[0x81] {
+		dc.Activation = shadow[0x81]
+		act := uint8(dcActivateCyclic | dcActivateSync0)
+		if dc.Activation&act == act {
+			// a start time in the past is never reached
+			dc.nextSync0 = dc.StartTime
+			dc.sync0Armed = dc.StartTime >= dc.SystemTime()
+		} else {
+			dc.sync0Armed = false
+		}
+	}
+
+	// registers without simulated function are plain storage
+	for i := range shadow {
+		if !shadowWriteMask[i] {
+			continue
+		}
+		if (i >= 0x30 && i < 0x34) || i == 0x35 || (i > 0x83 && i < 0x8e) || (i >= 0x98 && i < 0xa0) {
+			dc.store[i] = shadow[i]
+		}
+	}
+}
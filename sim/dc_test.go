@@ -0,0 +1,149 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"testing"
+	"time"
+)
+
+func newDCTestTopology(t *testing.T, n int, cableDelay time.Duration) ([]*L2Slave, *Topology, ecmd.Commander) {
+	slaves := newTestSlaves(n)
+	topo := NewTopology()
+	err := topo.Chain(slaves...)
+	if err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	for _, s := range slaves {
+		for _, p := range s.Ports {
+			if p.cable != nil {
+				p.cable.Delay = cableDelay
+			}
+		}
+	}
+	return slaves, topo, ecmd.NewCommandFramer(topo)
+}
+
+func TestDCReceiveTimeLatch(t *testing.T) {
+	slaves, _, c := newDCTestTopology(t, 3, 100*time.Nanosecond)
+	slaves[1].DC.Offset = 5000
+
+	err := ecmd.ExecuteWrite32(c, ecfr.FixedAddr(0, ecad.DCReceiveTimePort0), 0, 3)
+	if err != nil {
+		t.Fatalf("latching receive times failed: %v", err)
+	}
+
+	// the frame enters every slave on port 0 and returns on port 1 of all but
+	// the last one.
+	want := [][2]uint32{{100, 500}, {5200, 5400}, {300, 0}}
+	for i, s := range slaves {
+		rt := s.DC.ReceiveTimes
+		if rt[0] != want[i][0] || rt[1] != want[i][1] {
+			t.Fatalf("slave %d: want receive times %v, have %v", i, want[i], rt[:2])
+		}
+	}
+
+	// the loop delay of the first slave is the sum of all cable delays
+	rt, err := ecmd.ExecuteRead(c, ecfr.PositionalAddr(0, ecad.DCReceiveTimePort0), 8, 1)
+	if err != nil {
+		t.Fatalf("reading receive times failed: %v", err)
+	}
+	p0, p1 := uint32(rt[0])|uint32(rt[1])<<8|uint32(rt[2])<<16|uint32(rt[3])<<24,
+		uint32(rt[4])|uint32(rt[5])<<8|uint32(rt[6])<<16|uint32(rt[7])<<24
+	if p1-p0 != 400 {
+		t.Fatalf("want loop delay 400, have %d", p1-p0)
+	}
+}
+
+func TestDCSystemTimeControlLoop(t *testing.T) {
+	slaves, topo, c := newDCTestTopology(t, 3, 0)
+	topo.CycleTime = time.Millisecond
+
+	slaves[1].DC.Offset = 1234567
+	slaves[1].DC.Drift = 50e-6
+	slaves[2].DC.Offset = -7654321
+	slaves[2].DC.Drift = -30e-6
+
+	for i := range slaves {
+		addr := ecfr.PositionalAddr(-int16(i), ecad.ConfiguredStationAddress)
+		err := ecmd.ExecuteWrite16(c, addr, uint16(0x1000+i), 1)
+		if err != nil {
+			t.Fatalf("setting station address failed: %v", err)
+		}
+
+		// compensate the initial offset to the reference clock
+		s := slaves[i]
+		off := uint64(slaves[0].DC.LocalTime(int64(topo.SimTime)) - s.DC.LocalTime(int64(topo.SimTime)))
+		addr = ecfr.FixedAddr(uint16(0x1000+i), ecad.DCSystemTimeOffset)
+		err = ecmd.ExecuteWrite(c, addr, []byte{uint8(off), uint8(off >> 8), uint8(off >> 16), uint8(off >> 24),
+			uint8(off >> 32), uint8(off >> 40), uint8(off >> 48), uint8(off >> 56)}, 1)
+		if err != nil {
+			t.Fatalf("setting system time offset failed: %v", err)
+		}
+	}
+
+	for cyc := 0; cyc < 1000; cyc++ {
+		ec, err := c.New(8)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		ec.DatagramOut.Command = ecfr.FRMW
+		ec.DatagramOut.Addr32 = ecfr.FixedAddr(0x1000, ecad.DCSystemTime).Addr32()
+
+		err = c.Cycle()
+		if err != nil {
+			t.Fatalf("Cycle failed: %v", err)
+		}
+
+		err = ecmd.ChooseDefaultError(ec)
+		if err == nil {
+			err = ecmd.ChooseWorkingCounterError(ec, 3)
+		}
+		if err != nil {
+			t.Fatalf("FRMW failed: %v", err)
+		}
+	}
+
+	for i, s := range slaves[1:] {
+		diff := s.DC.SystemTimeDifference &^ (1 << 31)
+		if diff > 20 {
+			t.Fatalf("slave %d: system time difference %d ns after control loop", i+1, diff)
+		}
+	}
+}
+
+func TestDCSync0Events(t *testing.T) {
+	slaves, topo, c := newDCTestTopology(t, 1, 0)
+	topo.CycleTime = 100 * time.Microsecond
+	dc := slaves[0].DC
+
+	start := dc.SystemTime() + uint64(time.Millisecond)
+	addr := ecfr.PositionalAddr(0, ecad.DCStartTimeCyclic)
+	err := ecmd.ExecuteWrite(c, addr, []byte{uint8(start), uint8(start >> 8), uint8(start >> 16), uint8(start >> 24),
+		uint8(start >> 32), uint8(start >> 40), uint8(start >> 48), uint8(start >> 56)}, 1)
+	if err != nil {
+		t.Fatalf("writing start time failed: %v", err)
+	}
+
+	err = ecmd.ExecuteWrite32(c, ecfr.PositionalAddr(0, ecad.DCSync0CycleTime), uint32(time.Millisecond), 1)
+	if err != nil {
+		t.Fatalf("writing SYNC0 cycle time failed: %v", err)
+	}
+
+	err = ecmd.ExecuteWrite8(c, ecfr.PositionalAddr(0, ecad.DCActivation), dcActivateCyclic|dcActivateSync0, 1)
+	if err != nil {
+		t.Fatalf("activating SYNC0 failed: %v", err)
+	}
+
+	for dc.SystemTime() < start+uint64(9500*time.Microsecond) {
+		_, err = ecmd.ExecuteRead8(c, ecfr.PositionalAddr(0, ecad.DCSync0Status), 1)
+		if err != nil {
+			t.Fatalf("reading SYNC0 status failed: %v", err)
+		}
+	}
+
+	if dc.Sync0Events != 10 {
+		t.Fatalf("want 10 SYNC0 events, have %d", dc.Sync0Events)
+	}
+}
@@ -0,0 +1,113 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+)
+
+const errorCountersLength = 0x14
+
+// error counters of a port, they saturate at 0xff like on an ESC.
+type PortErrorCounters struct {
+	// frames received with a CRC error
+	InvalidFrames uint8
+	// physical layer errors
+	RXErrors uint8
+	// frames received with an error marked by a previous ESC
+	ForwardedRXErrors uint8
+	LostLinks         uint8
+}
+
+func incSaturating(c *uint8) {
+	if *c != 0xff {
+		*c++
+	}
+}
+
+// the state of a frame crossing corrupting cables
+type frameDamage int
+
+const (
+	frameIntact frameDamage = iota
+	// damaged, but not detected by an ESC yet
+	frameCorrupted
+	// an ESC detected the damage and marked the frame
+	frameMarked
+)
+
+// counts the reception of a frame on port in and returns the damage state it
+// is forwarded with.
+func (s *L2Slave) countRX(in int, dmg frameDamage) frameDamage {
+	c := &s.Ports[in].Errors
+	switch dmg {
+	case frameCorrupted:
+		incSaturating(&c.InvalidFrames)
+		incSaturating(&c.RXErrors)
+		return frameMarked
+	case frameMarked:
+		incSaturating(&c.ForwardedRXErrors)
+	}
+	return dmg
+}
+
+// ErrorCountersReg maps the error counters from ecad.RXErrorCounter to the
+// lost link counters. writing any of the RX error counters clears all of
+// them, the same goes for the lost link counters.
+type ErrorCountersReg struct{ *L2Slave }
+
+func (s *L2Slave) ErrorCountersReg() ErrorCountersReg { return ErrorCountersReg{s} }
+
+func (r ErrorCountersReg) Read(offs uint16, dp *uint8) bool {
+	addr := ecad.RXErrorCounter + offs
+	var d uint8
+	switch {
+	case addr < ecad.ForwardedRXErrorCounter:
+		c := r.Ports[offs/2].Errors
+		d = c.InvalidFrames
+		if offs%2 == 1 {
+			d = c.RXErrors
+		}
+	case addr < ecad.ECATProcessingUnitErrorCounter:
+		d = r.Ports[addr-ecad.ForwardedRXErrorCounter].Errors.ForwardedRXErrors
+	case addr == ecad.ECATProcessingUnitErrorCounter:
+		d = r.ProcessingUnitErrors
+	case addr == ecad.PDIErrorCounter:
+		d = r.PDIErrors
+	case addr >= ecad.LostLinkCounter:
+		d = r.Ports[addr-ecad.LostLinkCounter].Errors.LostLinks
+	}
+	*dp = d
+	return true
+}
+
+func (r ErrorCountersReg) WriteInteract(offs uint16) bool { return true }
+
+func (r ErrorCountersReg) Latch(shadow []byte, shadowWriteMask []bool) {
+	var clearRX, clearLostLinks bool
+	for offs := range shadow {
+		if !shadowWriteMask[offs] {
+			continue
+		}
+
+		addr := ecad.RXErrorCounter + uint16(offs)
+		switch {
+		case addr < ecad.ECATProcessingUnitErrorCounter:
+			clearRX = true
+		case addr == ecad.ECATProcessingUnitErrorCounter:
+			r.ProcessingUnitErrors = 0
+		case addr == ecad.PDIErrorCounter:
+			r.PDIErrors = 0
+		case addr >= ecad.LostLinkCounter:
+			clearLostLinks = true
+		}
+	}
+
+	for i := range r.Ports {
+		c := &r.Ports[i].Errors
+		if clearRX {
+			c.InvalidFrames, c.RXErrors, c.ForwardedRXErrors = 0, 0, 0
+		}
+		if clearLostLinks {
+			c.LostLinks = 0
+		}
+	}
+}
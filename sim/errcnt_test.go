@@ -0,0 +1,65 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"testing"
+)
+
+func TestErrorCounters(t *testing.T) {
+	slaves := newTestSlaves(3)
+	topo := NewTopology()
+	if err := topo.Chain(slaves...); err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	c := ecmd.NewCommandFramer(topo)
+
+	slaves[1].Ports[1].cable.CorruptFrames = 1
+	if n := countSlaves(t, c); n != 0 {
+		t.Fatalf("want the corrupted frame to be lost, have wc %d", n)
+	}
+
+	read := func(pos int16) (e ecad.ErrorCounters) {
+		d, err := ecmd.ExecuteRead(c, ecfr.PositionalAddr(-pos, ecad.RXErrorCounter), e.Len(), 1)
+		if err != nil {
+			t.Fatalf("reading error counters failed: %v", err)
+		}
+		if err = e.Decode(d); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if e := read(2); e.InvalidFrames[0] != 1 || e.RXErrors[0] != 1 {
+		t.Fatalf("want the error counted at slave 2 port 0, have %v", e)
+	}
+	for pos := int16(0); pos < 2; pos++ {
+		if e := read(pos); e.ForwardedRXErrors[1] != 1 || e.RXErrors != [4]uint8{} {
+			t.Fatalf("want a forwarded error at slave %d port 1, have %v", pos, e)
+		}
+	}
+
+	if err := topo.BreakCable(slaves[1], 1); err != nil {
+		t.Fatal(err)
+	}
+	if e := read(1); e.LostLinks[1] != 1 || e.ForwardedRXErrors[1] != 1 {
+		t.Fatalf("want a lost link at slave 1 port 1, have %v", e)
+	}
+
+	// writing one counter of a group clears the group
+	err := ecmd.ExecuteWrite8(c, ecfr.PositionalAddr(-1, ecad.ForwardedRXErrorCounter), 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := read(1); e.ForwardedRXErrors[1] != 0 || e.LostLinks[1] != 1 {
+		t.Fatalf("want rx errors cleared and lost links kept, have %v", e)
+	}
+	err = ecmd.ExecuteWrite8(c, ecfr.PositionalAddr(-1, ecad.LostLinkCounter+3), 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := read(1); e.LostLinks[1] != 0 {
+		t.Fatalf("want lost links cleared, have %v", e)
+	}
+}
@@ -0,0 +1,260 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"math/rand"
+)
+
+// Fault describes what happens to a frame returned by the wrapped Framer.
+type Fault struct {
+	Drop bool
+	// number of cycles the frame is held back
+	Delay int
+	// deliver the frame twice
+	Duplicate bool
+	// deliver the frame after all others of its cycle
+	Reorder bool
+	// keep only this many datagrams, 0 keeps all
+	Truncate int
+	// number of random bit flips. frames that fail to overlay afterwards are
+	// discarded, like link layers discard malformed frames.
+	Corrupt int
+	// added to the working counter of every datagram
+	WCDelta int
+}
+
+// FaultPolicy decides on the fault for every frame. seq counts all frames
+// returned by the wrapped Framer, starting at 0.
+type FaultPolicy interface {
+	Fault(cycle, seq int, rng *rand.Rand) Fault
+}
+
+// FaultScript injects faults into the frames with the given sequence
+// numbers.
+type FaultScript map[int]Fault
+
+func (s FaultScript) Fault(cycle, seq int, rng *rand.Rand) Fault {
+	return s[seq]
+}
+
+// RandomFaults injects every fault with the given probability.
+type RandomFaults struct {
+	Drop, Delay, Duplicate, Reorder, Truncate, Corrupt, WC float64
+
+	// delays are uniformly distributed from 1 to MaxDelay cycles
+	MaxDelay int
+}
+
+func (r RandomFaults) Fault(cycle, seq int, rng *rand.Rand) (f Fault) {
+	f.Drop = rng.Float64() < r.Drop
+	if rng.Float64() < r.Delay {
+		f.Delay = 1
+		if r.MaxDelay > 1 {
+			f.Delay += rng.Intn(r.MaxDelay)
+		}
+	}
+	f.Duplicate = rng.Float64() < r.Duplicate
+	f.Reorder = rng.Float64() < r.Reorder
+	if rng.Float64() < r.Truncate {
+		f.Truncate = 1
+	}
+	if rng.Float64() < r.Corrupt {
+		f.Corrupt = 1
+	}
+	if rng.Float64() < r.WC {
+		f.WCDelta = -1
+	}
+	return
+}
+
+// FaultStats counts the frames of a FaultFramer by the faults injected.
+type FaultStats struct {
+	Frames     int
+	Dropped    int
+	Delayed    int
+	Duplicated int
+	Reordered  int
+	Truncated  int
+	Corrupted  int
+	Discarded  int
+	WCAltered  int
+}
+
+type delayedFrame struct {
+	frame *ecfr.Frame
+	due   int
+}
+
+// FaultFramer wraps an ecmd.Framer and injects faults into the frames it
+// returns according to Policy. with the same seed and policy, the same
+// faults are injected.
+type FaultFramer struct {
+	Framer ecmd.Framer
+	Policy FaultPolicy
+	Stats  FaultStats
+
+	rng     *rand.Rand
+	cycle   int
+	seq     int
+	delayed []delayedFrame
+}
+
+func NewFaultFramer(framer ecmd.Framer, policy FaultPolicy, seed int64) *FaultFramer {
+	return &FaultFramer{
+		Framer: framer,
+		Policy: policy,
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (f *FaultFramer) New(maxdatalen int) (*ecfr.Frame, error) {
+	return f.Framer.New(maxdatalen)
+}
+
+func (f *FaultFramer) Cycle() (iframes []*ecfr.Frame, err error) {
+	defer func() {
+		f.cycle++
+	}()
+
+	var in []*ecfr.Frame
+	in, err = f.Framer.Cycle()
+	if err != nil {
+		return
+	}
+
+	// frames held back in earlier cycles arrive first
+	var pending []delayedFrame
+	for _, df := range f.delayed {
+		if df.due <= f.cycle {
+			iframes = append(iframes, df.frame)
+		} else {
+			pending = append(pending, df)
+		}
+	}
+	f.delayed = pending
+
+	var tail []*ecfr.Frame
+	for _, fr := range in {
+		fault := f.Policy.Fault(f.cycle, f.seq, f.rng)
+		f.seq++
+		f.Stats.Frames++
+
+		if fault.Drop {
+			f.Stats.Dropped++
+			continue
+		}
+
+		fr = f.apply(fr, fault)
+		if fr == nil {
+			f.Stats.Discarded++
+			continue
+		}
+
+		frames := []*ecfr.Frame{fr}
+		if fault.Duplicate {
+			f.Stats.Duplicated++
+			if dup := copyFrame(fr); dup != nil {
+				frames = append(frames, dup)
+			}
+		}
+
+		switch {
+		case fault.Delay > 0:
+			f.Stats.Delayed++
+			for _, dfr := range frames {
+				// the wrapped framer may reuse the frame in the next cycle
+				if dfr = copyFrame(dfr); dfr != nil {
+					f.delayed = append(f.delayed, delayedFrame{dfr, f.cycle + fault.Delay})
+				}
+			}
+		case fault.Reorder:
+			f.Stats.Reordered++
+			tail = append(tail, frames...)
+		default:
+			iframes = append(iframes, frames...)
+		}
+	}
+
+	iframes = append(iframes, tail...)
+	return
+}
+
+// applies the faults that modify the frame. returns nil if the modified
+// frame does not overlay anymore.
+func (f *FaultFramer) apply(fr *ecfr.Frame, fault Fault) *ecfr.Frame {
+	if fault.Truncate > 0 && fault.Truncate < len(fr.Datagrams) {
+		f.Stats.Truncated++
+		// the frame belongs to the wrapped framer, the copy is truncated
+		fr = copyFrame(fr)
+		if fr == nil {
+			return nil
+		}
+		fr.Datagrams = fr.Datagrams[:fault.Truncate]
+		fr.Datagrams[len(fr.Datagrams)-1].SetLast(true)
+		// updates the length in the header
+		if _, err := fr.Commit(); err != nil {
+			return nil
+		}
+	}
+
+	if fault.WCDelta != 0 {
+		f.Stats.WCAltered++
+		for _, dg := range fr.Datagrams {
+			dg.WorkingCounter = uint16(int(dg.WorkingCounter) + fault.WCDelta)
+		}
+	}
+
+	if fault.Corrupt > 0 {
+		f.Stats.Corrupted++
+		b, err := fr.Commit()
+		if err != nil {
+			return nil
+		}
+		cb := make([]byte, len(b))
+		copy(cb, b)
+		for i := 0; i < fault.Corrupt; i++ {
+			bit := f.rng.Intn(len(cb) * 8)
+			cb[bit/8] ^= 1 << uint(bit%8)
+		}
+
+		fr = new(ecfr.Frame)
+		if _, err = fr.Overlay(cb); err != nil {
+			return nil
+		}
+	}
+
+	return fr
+}
+
+func copyFrame(fr *ecfr.Frame) *ecfr.Frame {
+	b, err := fr.Commit()
+	if err != nil {
+		return nil
+	}
+	cb := make([]byte, len(b))
+	copy(cb, b)
+
+	cfr := new(ecfr.Frame)
+	if _, err = cfr.Overlay(cb); err != nil {
+		return nil
+	}
+	return cfr
+}
+
+func (f *FaultFramer) Close() error {
+	if c, ok := f.Framer.(interface {
+		Close() error
+	}); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (f *FaultFramer) DebugMessage(m string) {
+	if dm, ok := f.Framer.(interface {
+		DebugMessage(string)
+	}); ok {
+		dm.DebugMessage(m)
+	}
+}
@@ -0,0 +1,146 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"reflect"
+	"testing"
+)
+
+func newFaultTestCommander(policy FaultPolicy, seed int64) (*FaultFramer, ecmd.Commander) {
+	bus := &L2Bus{Slaves: []FrameProcessor{NewL2Slave()}}
+	ff := NewFaultFramer(bus, policy, seed)
+	return ff, ecmd.NewCommandFramer(ff)
+}
+
+func TestFaultFramerFrameLossRetry(t *testing.T) {
+	ff, c := newFaultTestCommander(FaultScript{0: {Drop: true}, 1: {Drop: true}}, 1)
+	addr := ecfr.PositionalAddr(0, ecad.Type)
+
+	_, err := ecmd.ExecuteRead8(c, addr, 1)
+	if err != nil {
+		t.Fatalf("read should succeed on third try, got %v", err)
+	}
+
+	if ff.Stats.Dropped != 2 || ff.Stats.Frames != 3 {
+		t.Fatalf("unexpected fault stats %+v", ff.Stats)
+	}
+
+	ff.Policy = FaultScript{3: {Drop: true}, 4: {Drop: true}, 5: {Drop: true}}
+	_, err = ecmd.ExecuteRead8(c, addr, 1)
+	if !ecmd.IsNoFrame(err) {
+		t.Fatalf("want NoFrame after exhausting retries, got %v", err)
+	}
+}
+
+func TestFaultFramerWC(t *testing.T) {
+	_, c := newFaultTestCommander(FaultScript{0: {WCDelta: -1}}, 1)
+
+	_, err := ecmd.ExecuteRead8(c, ecfr.PositionalAddr(0, ecad.Type), 1)
+	if !ecmd.IsWorkingCounterError(err) {
+		t.Fatalf("want working counter error, got %v", err)
+	}
+}
+
+func TestFaultFramerDelayDuplicate(t *testing.T) {
+	ff, _ := newFaultTestCommander(FaultScript{0: {Delay: 1, Duplicate: true}}, 1)
+
+	cycle := func() []*ecfr.Frame {
+		fr, err := ff.New(2)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		dg, err := fr.NewDatagram(2)
+		if err != nil {
+			t.Fatalf("NewDatagram failed: %v", err)
+		}
+		dg.Command = ecfr.BRD
+		dg.SetLast(true)
+
+		frames, err := ff.Cycle()
+		if err != nil {
+			t.Fatalf("Cycle failed: %v", err)
+		}
+		return frames
+	}
+
+	if n := len(cycle()); n != 0 {
+		t.Fatalf("delayed frame should not arrive in its own cycle, got %d frames", n)
+	}
+
+	if n := len(cycle()); n != 3 {
+		t.Fatalf("want 2 delayed frames and 1 current frame, got %d", n)
+	}
+}
+
+func TestFaultFramerReproducible(t *testing.T) {
+	run := func(seed int64) []bool {
+		policy := RandomFaults{Drop: 0.3}
+		_, c := newFaultTestCommander(policy, seed)
+
+		var lost []bool
+		for i := 0; i < 50; i++ {
+			_, err := ecmd.ExecuteRead8Options(c, ecfr.PositionalAddr(0, ecad.Type), 1, ecmd.Options{FramelossTries: 1})
+			lost = append(lost, ecmd.IsNoFrame(err))
+		}
+		return lost
+	}
+
+	a, b := run(42), run(42)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("same seed produced different faults")
+	}
+
+	nlost := 0
+	for _, l := range a {
+		if l {
+			nlost++
+		}
+	}
+	if nlost == 0 || nlost == len(a) {
+		t.Fatalf("implausible number of lost frames %d", nlost)
+	}
+}
+
+// keeps the frames returned by the wrapped framer
+type recordingFramer struct {
+	ecmd.Framer
+	frames []*ecfr.Frame
+}
+
+func (r *recordingFramer) Cycle() (frames []*ecfr.Frame, err error) {
+	frames, err = r.Framer.Cycle()
+	r.frames = frames
+	return
+}
+
+func TestFaultFramerTruncate(t *testing.T) {
+	rf := &recordingFramer{Framer: &L2Bus{Slaves: []FrameProcessor{NewL2Slave()}}}
+	ff := NewFaultFramer(rf, FaultScript{0: {Truncate: 1}}, 1)
+	c := ecmd.NewCommandFramer(ff)
+
+	var ecs []*ecmd.ExecutingCommand
+	for i := 0; i < 2; i++ {
+		ec, err := c.New(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec.DatagramOut.Command = ecfr.BRD
+		ec.DatagramOut.Addr32 = ecfr.BroadcastAddr(ecad.Type).Addr32()
+		ecs = append(ecs, ec)
+	}
+	if err := c.Cycle(); err != nil {
+		t.Fatal(err)
+	}
+	if ecmd.ChooseDefaultError(ecs[0]) != nil || !ecmd.IsNoFrame(ecmd.ChooseDefaultError(ecs[1])) {
+		t.Fatalf("want the second datagram cut off, have %v, %v", ecmd.ChooseDefaultError(ecs[0]), ecmd.ChooseDefaultError(ecs[1]))
+	}
+
+	// the frame of the wrapped framer is left alone, its first datagram is
+	// still followed by another
+	fr := rf.frames[0]
+	if len(fr.Datagrams) != 2 || fr.Datagrams[0].LenWord&0x8000 == 0 {
+		t.Fatalf("wrapped frame was modified: %s", fr.MultilineSummary())
+	}
+}
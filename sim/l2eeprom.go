@@ -1,11 +1,25 @@
 package sim
 
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecee"
+	"io"
+	"os"
+)
+
 type L2EEPROM struct {
 	Array [8 * 1024]uint16
 
 	Addr        uint32
 	DataScratch [8]byte // already in wire encoding
 
+	// number of frames an EEPROM command keeps the interface busy. with 0,
+	// commands complete in the frame they are issued in.
+	BusyCycles int
+
+	// called with the ESC configuration area after a successful reload.
+	ReloadHook func(config []uint16)
+
 	PDIControl         bool
 	WriteEnable        bool
 	ChecksumError      bool
@@ -13,8 +27,21 @@ type L2EEPROM struct {
 	MissingAcknowledge bool
 	ErrorWriteEnable   bool
 	Busy               bool
+
+	pendingCommand uint8
+	busyRemaining  int
 }
 
+const (
+	eeCommandIdle   = 0x00
+	eeCommandRead   = 0x01
+	eeCommandWrite  = 0x02
+	eeCommandReload = 0x04
+
+	eeConfigAreaWords = 8
+	eeChecksumWord    = 7
+)
+
 func NewL2EEPROM() *L2EEPROM {
 	ee := &L2EEPROM{}
 
@@ -48,6 +75,7 @@ func (ee *L2EEPROMRegisterSet) Read(offs uint16, dp *uint8) bool {
 		*dp |= 0xc0 // 2 address bytes, support 8 bytes
 	case 3:
 		// lower 3 bits are command
+		*dp |= ee.pendingCommand & 0x07
 		if ee.ChecksumError {
 			*dp |= 1 << (11 - 8)
 		}
@@ -91,6 +119,17 @@ func (ee *L2EEPROMRegisterSet) WriteInteract(offs uint16) bool {
 }
 
 func (ee L2EEPROMRegisterSet) Latch(shadow []byte, shadowWriteMask []bool) {
+	// commands are only accepted if the interface was idle while the frame
+	// was processed, see WriteInteract
+	acceptCommand := !ee.Busy
+
+	if ee.Busy {
+		ee.busyRemaining--
+		if ee.busyRemaining <= 0 {
+			ee.complete()
+		}
+	}
+
 	for offs := 0; offs < len(shadow); offs++ {
 		switch {
 		case offs == 0:
@@ -104,7 +143,7 @@ func (ee L2EEPROMRegisterSet) Latch(shadow []byte, shadowWriteMask []bool) {
 		case offs == 1:
 			// pdi access state. we don't even fake that
 		case offs == 2:
-			if shadowWriteMask[2] {
+			if shadowWriteMask[2] && acceptCommand {
 				if shadow[2]&0x01 != 0 {
 					ee.WriteEnable = true
 				} else {
@@ -112,20 +151,8 @@ func (ee L2EEPROMRegisterSet) Latch(shadow []byte, shadowWriteMask []bool) {
 				}
 			}
 		case offs == 3:
-			if shadowWriteMask[3] {
-				switch shadow[3] & 0x03 {
-				case 0x00:
-					ee.ChecksumError = false
-					ee.EENotLoaded = false
-					ee.MissingAcknowledge = false
-					ee.ErrorWriteEnable = false
-				case 0x01:
-					// TODO: busy time/cycles
-					ee.Busy = false
-					ee.readIntoScratch()
-				default:
-					// write/reload not supported
-				}
+			if shadowWriteMask[3] && acceptCommand {
+				ee.issue(shadow[3] & 0x07)
 			}
 		case offs == 4:
 			if shadowWriteMask[4] {
@@ -145,7 +172,7 @@ func (ee L2EEPROMRegisterSet) Latch(shadow []byte, shadowWriteMask []bool) {
 		case offs == 7:
 			if shadowWriteMask[7] {
 				ee.Addr &^= 0xff000000
-				ee.Addr |= uint32(shadow[7]) << 32
+				ee.Addr |= uint32(shadow[7]) << 24
 			}
 
 		case offs >= 8 && offs < 16:
@@ -154,6 +181,56 @@ func (ee L2EEPROMRegisterSet) Latch(shadow []byte, shadowWriteMask []bool) {
 			}
 		}
 	}
+
+	// write enable is self-clearing, it only applies to the frame it was
+	// written in.
+	ee.WriteEnable = false
+
+	// the command register was written in this frame, so the data registers
+	// are latched by now.
+	if ee.Busy && ee.busyRemaining <= 0 {
+		ee.complete()
+	}
+}
+
+func (ee *L2EEPROM) issue(cmd uint8) {
+	switch cmd {
+	case eeCommandIdle:
+		ee.ChecksumError = false
+		ee.EENotLoaded = false
+		ee.MissingAcknowledge = false
+		ee.ErrorWriteEnable = false
+		return
+	case eeCommandRead, eeCommandReload:
+	case eeCommandWrite:
+		if !ee.WriteEnable {
+			ee.ErrorWriteEnable = true
+			return
+		}
+		ee.ErrorWriteEnable = false
+	default:
+		// invalid command, the ESC ignores it
+		return
+	}
+
+	ee.pendingCommand = cmd
+	ee.busyRemaining = ee.BusyCycles
+	ee.Busy = true
+}
+
+func (ee *L2EEPROM) complete() {
+	switch ee.pendingCommand {
+	case eeCommandRead:
+		ee.readIntoScratch()
+	case eeCommandWrite:
+		ee.writeFromScratch()
+	case eeCommandReload:
+		ee.reload()
+	}
+
+	ee.pendingCommand = eeCommandIdle
+	ee.busyRemaining = 0
+	ee.Busy = false
 }
 
 func (ee *L2EEPROM) readIntoScratch() {
@@ -163,3 +240,70 @@ func (ee *L2EEPROM) readIntoScratch() {
 		ee.DataScratch[i*2+1] = uint8(w16 >> 8)
 	}
 }
+
+func (ee *L2EEPROM) writeFromScratch() {
+	w16 := uint16(ee.DataScratch[0]) | uint16(ee.DataScratch[1])<<8
+	ee.Array[int(ee.Addr)%len(ee.Array)] = w16
+}
+
+// reload checks the ESC configuration area and hands it to ReloadHook if the
+// checksum matches.
+func (ee *L2EEPROM) reload() {
+	b := make([]byte, 2*eeChecksumWord)
+	for i, w := range ee.Array[:eeChecksumWord] {
+		b[2*i], b[2*i+1] = uint8(w), uint8(w>>8)
+	}
+
+	if ecee.Checksum(b) != uint8(ee.Array[eeChecksumWord]) {
+		ee.ChecksumError = true
+		ee.EENotLoaded = true
+		return
+	}
+
+	ee.ChecksumError = false
+	ee.EENotLoaded = false
+
+	if ee.ReloadHook != nil {
+		ee.ReloadHook(ee.Array[:eeConfigAreaWords])
+	}
+}
+
+// LoadSII replaces the EEPROM contents with the SII image read from r and
+// reloads the ESC configuration area. the image is a sequence of little endian
+// words, space not covered by it reads as erased (0xffff).
+func (ee *L2EEPROM) LoadSII(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if len(b)%2 != 0 {
+		return fmt.Errorf("SII image has odd length %d", len(b))
+	}
+
+	if len(b)/2 > len(ee.Array) {
+		return fmt.Errorf("SII image of %d bytes exceeds EEPROM size of %d bytes", len(b), len(ee.Array)*2)
+	}
+
+	for i := range ee.Array {
+		if i*2 < len(b) {
+			ee.Array[i] = uint16(b[i*2]) | uint16(b[i*2+1])<<8
+		} else {
+			ee.Array[i] = 0xffff
+		}
+	}
+
+	ee.reload()
+
+	return nil
+}
+
+func (ee *L2EEPROM) LoadSIIFromFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ee.LoadSII(f)
+}
@@ -0,0 +1,137 @@
+package sim
+
+import (
+	"bytes"
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecee"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"testing"
+)
+
+func newEEPROMTestBus(busyCycles int) (*L2Slave, ecmd.Commander) {
+	slave := NewL2Slave()
+	slave.EEPROM.BusyCycles = busyCycles
+	bus := &L2Bus{Slaves: []FrameProcessor{slave}}
+	return slave, ecmd.NewCommandFramer(bus)
+}
+
+func TestL2EEPROMWriteRead(t *testing.T) {
+	for _, busyCycles := range []int{0, 1, 5} {
+		slave, cmdr := newEEPROMTestBus(busyCycles)
+
+		ee, err := ecee.New(cmdr, ecfr.PositionalAddr(0, 0))
+		if err != nil {
+			t.Fatalf("busy cycles %d: ecee.New failed: %v", busyCycles, err)
+		}
+
+		err = ee.WriteWord(0x42, 0xbeef)
+		if err != nil {
+			t.Fatalf("busy cycles %d: WriteWord failed: %v", busyCycles, err)
+		}
+
+		if slave.EEPROM.Array[0x42] != 0xbeef {
+			t.Fatalf("busy cycles %d: want EEPROM word %#04x, have %#04x", busyCycles, 0xbeef, slave.EEPROM.Array[0x42])
+		}
+
+		w, err := ee.ReadWord(0x42)
+		if err != nil {
+			t.Fatalf("busy cycles %d: ReadWord failed: %v", busyCycles, err)
+		}
+
+		if w != 0xbeef {
+			t.Fatalf("busy cycles %d: want to read %#04x, got %#04x", busyCycles, 0xbeef, w)
+		}
+	}
+}
+
+func TestL2EEPROMBusy(t *testing.T) {
+	slave, cmdr := newEEPROMTestBus(3)
+
+	addr := ecfr.PositionalAddr(0, ecad.EEPROMControlStatus)
+	err := ecmd.ExecuteWrite(cmdr, addr, []byte{0x00, 0x01}, 1)
+	if err != nil {
+		t.Fatalf("issuing read command failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !slave.EEPROM.Busy {
+			t.Fatalf("EEPROM should be busy after %d cycles", i)
+		}
+
+		// a command written while busy is rejected
+		err = ecmd.ExecuteWrite(cmdr, addr, []byte{0x00, 0x01}, 1)
+		if !ecmd.IsWorkingCounterError(err) {
+			t.Fatalf("expected working counter error for command while busy, got %v", err)
+		}
+	}
+
+	if slave.EEPROM.Busy {
+		t.Fatalf("EEPROM should be idle after 3 cycles")
+	}
+}
+
+func TestL2EEPROMWriteWithoutEnable(t *testing.T) {
+	slave, cmdr := newEEPROMTestBus(0)
+	before := slave.EEPROM.Array[0]
+
+	addr := ecfr.PositionalAddr(0, ecad.EEPROMControlStatus)
+	err := ecmd.ExecuteWrite(cmdr, addr, []byte{0x00, 0x02}, 1)
+	if err != nil {
+		t.Fatalf("issuing write command failed: %v", err)
+	}
+
+	if !slave.EEPROM.ErrorWriteEnable {
+		t.Fatalf("write command without write enable should set the error write enable bit")
+	}
+
+	if slave.EEPROM.Array[0] != before {
+		t.Fatalf("write command without write enable changed the EEPROM")
+	}
+}
+
+func TestL2EEPROMLoadSIIReload(t *testing.T) {
+	slave, cmdr := newEEPROMTestBus(0)
+
+	config := []uint16{0x0c08, 0x6e00, 0x0000, 0x0000, 0x1234, 0x0000, 0x0000}
+
+	var img []byte
+	for _, w := range config {
+		img = append(img, uint8(w), uint8(w>>8))
+	}
+	img = append(img, ecee.Checksum(img), 0)
+
+	err := slave.EEPROM.LoadSII(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("LoadSII failed: %v", err)
+	}
+
+	if slave.EEPROM.ChecksumError || slave.EEPROM.EENotLoaded {
+		t.Fatalf("valid configuration area flagged as not loaded")
+	}
+
+	alias, err := ecmd.ExecuteRead16(cmdr, ecfr.PositionalAddr(0, ecad.ConfiguredStationAlias), 1)
+	if err != nil {
+		t.Fatalf("reading station alias failed: %v", err)
+	}
+
+	if alias != 0x1234 {
+		t.Fatalf("want station alias %#04x after reload, have %#04x", 0x1234, alias)
+	}
+
+	if slave.EEPROM.Array[eeConfigAreaWords] != 0xffff {
+		t.Fatalf("space not covered by SII image should read as erased")
+	}
+
+	// corrupt the checksum and reload via the command register
+	slave.EEPROM.Array[eeChecksumWord] ^= 0xff
+	addr := ecfr.PositionalAddr(0, ecad.EEPROMControlStatus)
+	err = ecmd.ExecuteWrite(cmdr, addr, []byte{0x00, 0x04}, 1)
+	if err != nil {
+		t.Fatalf("issuing reload command failed: %v", err)
+	}
+
+	if !slave.EEPROM.ChecksumError {
+		t.Fatalf("reload with bad checksum should set checksum error")
+	}
+}
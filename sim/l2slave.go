@@ -3,6 +3,7 @@ package sim
 import (
 	"github.com/distributed/ecat/ecad"
 	"github.com/distributed/ecat/ecfr"
+	"time"
 )
 
 const (
@@ -23,6 +24,25 @@ type L2Slave struct {
 
 	ALStatusControl *ALStatusControl
 	EEPROM          *L2EEPROM
+
+	Ports          [NumPorts]Port
+	dlControlStore [4]byte
+
+	ProcessingUnitErrors uint8
+	PDIErrors            uint8
+
+	DC       *DistributedClock
+	Watchdog *Watchdog
+	// time a frame takes to pass from one port of the ESC to the next
+	ForwardDelay time.Duration
+
+	SyncManagers   [NumSyncManagers]*SyncManager
+	Mailbox        MailboxHandler
+	mailboxQueue   [][]byte
+	mailboxCounter uint8
+
+	// data of read/write commands, reused
+	wdata []byte
 }
 
 func NewL2Slave() *L2Slave {
@@ -36,19 +56,44 @@ func NewL2Slave() *L2Slave {
 	s.regMappings = append(s.regMappings, DevMapping{ecad.ALStatus, 0x06, s.ALStatusControl.StatusReg()})
 
 	s.EEPROM = NewL2EEPROM()
+	s.EEPROM.ReloadHook = s.loadEEPROMConfig
 	s.regMappings = append(s.regMappings, DevMapping{ecad.ESIEEPROMInterface, 0x10, s.EEPROM.Reg()})
 
+	// matches the port descriptor of the signature above
+	s.Ports[0].Type = PortMII
+	s.Ports[1].Type = PortEBUS
+	s.regMappings = append(s.regMappings, DevMapping{ecad.PortDescriptor, 0x01, s.PortDescriptorReg()})
+	s.regMappings = append(s.regMappings, DevMapping{ecad.DLControl, 0x04, s.DLControlReg()})
+	s.regMappings = append(s.regMappings, DevMapping{ecad.DLStatus, 0x02, s.DLStatusReg()})
+	s.regMappings = append(s.regMappings, DevMapping{ecad.RXErrorCounter, errorCountersLength, s.ErrorCountersReg()})
+
+	s.DC = NewDistributedClock()
+	s.regMappings = append(s.regMappings, DevMapping{ecad.DCReceiveTimePort0, dcRegLength, s.DC.Reg()})
+
+	s.Watchdog = NewWatchdog()
+	s.regMappings = append(s.regMappings, DevMapping{ecad.WatchdogDivider, watchdogRegLength, s.Watchdog.Reg()})
+
+	for i := range s.SyncManagers {
+		s.SyncManagers[i] = &SyncManager{}
+		start := uint16(ecad.SyncMangerBase + i*ecad.SyncManagerChannelLen)
+		s.regMappings = append(s.regMappings, DevMapping{start, ecad.SyncManagerChannelLen, s.SyncManagers[i].Reg()})
+	}
+
 	return s
 }
 
 // returns true if interaction happened
-func (s L2Slave) llread8p(addr uint16, dp *uint8) bool {
+func (s *L2Slave) llread8p(addr uint16, dp *uint8) bool {
 	if addr < regAreaLength {
 		// register access
 		m := s.addrToMapping(addr)
 		if m != nil {
 			return m.Device().Read(addr-m.Start(), dp)
 		}
+	} else if sm := s.mailboxSM(addr); sm != nil {
+		if !s.smRead(sm, addr) {
+			return false
+		}
 	}
 
 	*dp = s.BackingMemory[addr]
@@ -68,7 +113,15 @@ func (s *L2Slave) llwrite8(addr uint16, d uint8) bool {
 		}
 	}
 
-	// no support for sync managers so far
+	if sm := s.mailboxSM(addr); sm != nil {
+		if !s.smWrite(sm, addr) {
+			return false
+		}
+	} else if s.watchdogSM(addr) != nil {
+		s.Watchdog.triggered = true
+	}
+
+	// buffered process data sync managers write through to memory
 	s.BackingMemory[addr] = d
 	return true
 }
@@ -88,57 +141,100 @@ func (s *L2Slave) ProcessFrame(infr *ecfr.Frame) (ofr *ecfr.Frame) {
 
 	for _, dg := range infr.Datagrams {
 		// TODO: should ecfr.Frame contain a DatagramAddress instead of Addr32?
-		if s.isPhysicalAddr(dg.Command, dg.Addr32) {
-			dga := ecfr.DatagramAddressFromCommand(dg.Addr32, dg.Command)
-			physaddressed := s.isPhysicallyAdressed(dga)
+		if !s.isPhysicalAddr(dg.Command, dg.Addr32) {
+			// no support for logical addresses
+			continue
+		}
+
+		dga := ecfr.DatagramAddressFromCommand(dg.Addr32, dg.Command)
+		physaddressed := s.isPhysicallyAdressed(dga)
+		if dga.Type() == ecfr.Positional || dga.Type() == ecfr.Broadcast {
 			dga.IncrementSlaveAddr()
 			dg.Addr32 = dga.Addr32()
-			if !physaddressed {
-				continue
-			}
+		}
+
+		doRead := dg.Command.DoesRead()
+		doWrite := dg.Command.DoesWrite()
+		if dg.Command == ecfr.ARMW || dg.Command == ecfr.FRMW {
+			// the addressed slave reads, all others write
+			doRead = physaddressed
+			doWrite = !physaddressed
+		} else if !physaddressed {
+			continue
+		}
+
+		physbase := dga.Offset()
 
-			readUnmasked := true
-			if dg.Command.DoesRead() {
-				physbase := dga.Offset()
-				for i := uint16(0); i < dg.DataLength(); i++ {
-					//di := dg.Data()[i]
-					readUnmasked = s.llread8p(physbase+i, &(dg.Data()[i])) && readUnmasked
-					//do := dg.Data()[i]
-					//fmt.Printf("llread8p di %02x -> do %02x  @  %p\n", di, do, &(dg.Data()[i]))
-				}
+		// read/write commands write the data they carried in, not what
+		// was read
+		wdata := dg.Data()
+		if doRead && doWrite {
+			s.wdata = append(s.wdata[:0], dg.Data()...)
+			wdata = s.wdata
+		}
+
+		readUnmasked := true
+		if doRead {
+			for i := uint16(0); i < dg.DataLength(); i++ {
+				readUnmasked = s.llread8p(physbase+i, &(dg.Data()[i])) && readUnmasked
 			}
+		}
 
-			writeUnmasked := true
-			if dg.Command.DoesWrite() {
-				physbase := dga.Offset()
-				for i := uint16(0); i < dg.DataLength(); i++ {
-					writeUnmasked = s.llwrite8(physbase+i, dg.Data()[i]) && writeUnmasked
-				}
+		writeUnmasked := true
+		if doWrite {
+			for i := uint16(0); i < dg.DataLength(); i++ {
+				writeUnmasked = s.llwrite8(physbase+i, wdata[i]) && writeUnmasked
 			}
+		}
 
-			// working counter update logic
-			if dg.Command.DoesRead() && dg.Command.DoesWrite() {
-				// TODO: RW/ARMW update logic
-			} else if dg.Command.DoesRead() {
-				if readUnmasked {
-					dg.WorkingCounter++
-				}
-			} else if dg.Command.DoesWrite() {
-				if writeUnmasked {
-					dg.WorkingCounter++
-				}
+		// working counter update logic
+		switch {
+		case dg.Command == ecfr.ARMW || dg.Command == ecfr.FRMW:
+			if (doRead && readUnmasked) || (doWrite && writeUnmasked) {
+				dg.WorkingCounter++
+			}
+		case doRead && doWrite:
+			if readUnmasked {
+				dg.WorkingCounter++
+			}
+			if writeUnmasked {
+				dg.WorkingCounter += 2
+			}
+		case doRead:
+			if readUnmasked {
+				dg.WorkingCounter++
+			}
+		case doWrite:
+			if writeUnmasked {
+				dg.WorkingCounter++
 			}
 		}
-		// no support for logical addresses
 	}
 
 	// latch register shadow into registers
 	s.latchRegs()
+	s.processMailbox()
 	// frame is processed
 
 	return
 }
 
+// advances the slave to simulated time simtime, in nanoseconds.
+func (s *L2Slave) setTime(simtime int64) {
+	s.DC.setTime(simtime)
+	s.Watchdog.setTime(simtime)
+}
+
+// records that the current frame passed port at simulated time simtime.
+func (s *L2Slave) rxPort(port int, simtime int64) {
+	s.DC.rxPort(port, simtime)
+}
+
+func (s *L2Slave) frameDone() {
+	s.DC.frameDone()
+	s.Watchdog.frameDone()
+}
+
 func (s *L2Slave) latchRegs() {
 	for _, m := range s.regMappings {
 		start := m.Start()
@@ -146,6 +242,24 @@ func (s *L2Slave) latchRegs() {
 		m.Device().Latch(s.registerShadow[start:end],
 			s.registerShadowWriteMask[start:end])
 	}
+
+	// writes only latch once, the next frame starts with a clean mask
+	for i := range s.registerShadowWriteMask {
+		s.registerShadowWriteMask[i] = false
+	}
+}
+
+// copies the ESC configuration area of the EEPROM into the registers it is
+// loaded to on reload.
+func (s *L2Slave) loadEEPROMConfig(config []uint16) {
+	put16 := func(addr uint16, v uint16) {
+		s.BackingMemory[addr] = uint8(v)
+		s.BackingMemory[addr+1] = uint8(v >> 8)
+	}
+
+	put16(ecad.PDIControl, config[0])
+	put16(ecad.PDIConfiguration, config[1])
+	put16(ecad.ConfiguredStationAlias, config[4])
 }
 
 func (s *L2Slave) isPhysicalAddr(ct ecfr.CommandType, addr32 uint32) bool {
@@ -163,8 +277,9 @@ func (s *L2Slave) isPhysicallyAdressed(addr ecfr.DatagramAddress) bool {
 	}
 
 	if addr.Type() == ecfr.Fixed {
-		// TODO: station address reg
-		return false
+		station := uint16(s.BackingMemory[ecad.ConfiguredStationAddress]) |
+			uint16(s.BackingMemory[ecad.ConfiguredStationAddress+1])<<8
+		return addr.PositionOrAddress() == station
 	}
 
 	return false
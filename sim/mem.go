@@ -2,39 +2,74 @@ package sim
 
 import (
 	"github.com/distributed/ecat/ecfr"
+	"time"
 )
 
 const (
 	maxDatagramsLen = 1470
 )
 
+// L2Bus reuses its frames, the frames of a cycle are valid until the next
+// call to New or Cycle.
 type L2Bus struct {
 	oframes []*ecfr.Frame
+	iframes []*ecfr.Frame
+	// incoming frames taken from the pool, slaves may answer with frames
+	// of their own
+	cframes []*ecfr.Frame
+	frames  *ecfr.FramePool
+	cycled  bool
 
 	Slaves []FrameProcessor
+
+	// simulated time, advanced by the wire time of every frame and by
+	// CycleTime after every cycle
+	SimTime   time.Duration
+	CycleTime time.Duration
+	// delay between adjacent slaves
+	PropagationDelay time.Duration
 }
 
 func (b *L2Bus) New(maxdatalen int) (fr *ecfr.Frame, err error) {
-	var vframe ecfr.Frame
-	buf := make([]byte, maxDatagramsLen+ecfr.FrameOverheadLen)
-	vframe, err = ecfr.PointFrameTo(buf)
-	if err != nil {
-		return
-	}
-
-	vframe.Header.SetType(1)
+	b.recycle()
 
-	fr = &vframe
+	fr = b.pool().Get()
+	fr.Header.SetType(ecfr.FrameTypeCommand)
 	b.oframes = append(b.oframes, fr)
 	return
 }
 
+func (b *L2Bus) pool() *ecfr.FramePool {
+	if b.frames == nil {
+		b.frames = ecfr.NewFramePool(maxDatagramsLen + ecfr.FrameOverheadLen)
+	}
+	return b.frames
+}
+
+// frames of the last cycle are handed back to the pool once the next cycle
+// starts.
+func (b *L2Bus) recycle() {
+	if !b.cycled {
+		return
+	}
+	b.cycled = false
+
+	p := b.pool()
+	p.Put(b.oframes...)
+	p.Put(b.cframes...)
+	b.oframes = b.oframes[:0]
+	b.iframes = b.iframes[:0]
+	b.cframes = b.cframes[:0]
+}
+
 func (b *L2Bus) Cycle() (iframes []*ecfr.Frame, err error) {
+	b.recycle()
 	defer func() {
-		b.oframes = nil
+		b.cycled = true
 	}()
 
-	for i, oframe := range b.oframes {
+	p := b.pool()
+	for _, oframe := range b.oframes {
 		var obytes []byte
 
 		obytes, err = oframe.Commit()
@@ -42,35 +77,69 @@ func (b *L2Bus) Cycle() (iframes []*ecfr.Frame, err error) {
 			return
 		}
 
-		_ = i
-		//fmt.Printf("oframe #%d: %s", i, oframe.MultilineSummary())
+		//fmt.Printf("oframe: %s", oframe.MultilineSummary())
 
-		coframe := new(ecfr.Frame)
-		cbytes := make([]byte, len(obytes))
+		coframe := p.Get()
+		cbytes := coframe.Buffer()[:len(obytes)]
 		copy(cbytes, obytes)
 		_, err = coframe.Overlay(cbytes)
 		if err != nil {
+			p.Put(coframe)
 			return
 		}
 
-		for _, slave := range b.Slaves {
-			coframe = slave.ProcessFrame(coframe)
-			if coframe == nil {
-				break
+		ifr := b.pass(coframe)
+		if ifr == coframe {
+			b.cframes = append(b.cframes, coframe)
+		} else {
+			p.Put(coframe)
+		}
+		if ifr != nil {
+			b.iframes = append(b.iframes, ifr)
+		}
+	}
+
+	b.SimTime += b.CycleTime
+
+	iframes = b.iframes
+	return
+}
+
+// passes the frame through the slaves in order. the bus is a line, the frame
+// enters every slave at port 0 and returns through port 1 of all but the last
+// slave.
+func (b *L2Bus) pass(fr *ecfr.Frame) *ecfr.Frame {
+	t0 := int64(b.SimTime)
+	delay := int64(b.PropagationDelay)
+	n := len(b.Slaves)
+
+	b.SimTime += wireTime(fr)
+
+	defer func() {
+		for i, slave := range b.Slaves {
+			if ts, ok := slave.(timedFrameProcessor); ok {
+				if fr != nil && i < n-1 {
+					ts.rxPort(1, t0+int64(2*n-2-i)*delay)
+				}
+				ts.frameDone()
 			}
 		}
+	}()
 
-		if coframe != nil {
-			iframes = append(iframes, coframe)
+	for i, slave := range b.Slaves {
+		if ts, ok := slave.(timedFrameProcessor); ok {
+			t := t0 + int64(i)*delay
+			ts.rxPort(0, t)
+			ts.setTime(t)
 		}
-	}
 
-	for i, iframe := range iframes {
-		_, _ = i, iframe
-		//fmt.Printf("iframe #%d: %s", i, iframe.MultilineSummary())
+		fr = slave.ProcessFrame(fr)
+		if fr == nil {
+			return nil
+		}
 	}
 
-	return
+	return fr
 }
 
 func (b *L2Bus) Close() error { return nil }
@@ -0,0 +1,118 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"testing"
+)
+
+// one cycle reading the type register of every slave, writing their
+// station addresses and exchanging a block of their memory
+func l2BusTestCycle(c *ecmd.CommandFramer, nslaves int) error {
+	for i := 0; i < nslaves; i++ {
+		ec, err := c.New(1)
+		if err != nil {
+			return err
+		}
+		ec.DatagramOut.Command = ecfr.APRD
+		ec.DatagramOut.Addr32 = ecfr.PositionalAddr(int16(-i), ecad.Type).Addr32()
+
+		ec, err = c.New(2)
+		if err != nil {
+			return err
+		}
+		ec.DatagramOut.Command = ecfr.APWR
+		ec.DatagramOut.Addr32 = ecfr.PositionalAddr(int16(-i), ecad.ConfiguredStationAddress).Addr32()
+		ec.DatagramOut.Data()[0] = uint8(i)
+
+		ec, err = c.New(64)
+		if err != nil {
+			return err
+		}
+		ec.DatagramOut.Command = ecfr.APRW
+		ec.DatagramOut.Addr32 = ecfr.PositionalAddr(int16(-i), 0x1000).Addr32()
+	}
+	return c.Cycle()
+}
+
+func newL2BusTestCommander(nslaves int) *ecmd.CommandFramer {
+	bus := &L2Bus{}
+	for i := 0; i < nslaves; i++ {
+		bus.Slaves = append(bus.Slaves, NewL2Slave())
+	}
+	return ecmd.NewCommandFramer(bus)
+}
+
+func TestL2BusCycleAllocs(t *testing.T) {
+	const nslaves = 4
+	c := newL2BusTestCommander(nslaves)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := l2BusTestCycle(c, nslaves); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("want no allocations per cycle, have %v", allocs)
+	}
+}
+
+func BenchmarkL2BusCycle(b *testing.B) {
+	const nslaves = 4
+	c := newL2BusTestCommander(nslaves)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := l2BusTestCycle(c, nslaves); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// answers every frame with a copy of its own
+type copyingProcessor struct {
+	frames []*ecfr.Frame
+}
+
+func (p *copyingProcessor) ProcessFrame(fr *ecfr.Frame) *ecfr.Frame {
+	b, err := fr.Commit()
+	if err != nil {
+		return nil
+	}
+	var cfr ecfr.Frame
+	if _, err = cfr.Overlay(append([]byte(nil), b...)); err != nil {
+		return nil
+	}
+	p.frames = append(p.frames, &cfr)
+	return &cfr
+}
+
+func TestL2BusForeignFrame(t *testing.T) {
+	p := &copyingProcessor{}
+	bus := &L2Bus{Slaves: []FrameProcessor{NewL2Slave(), p}}
+
+	for i := 0; i < 2; i++ {
+		fr, err := bus.New(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dg, err := fr.NewDatagram(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dg.Command = ecfr.BRD
+		dg.Addr32 = ecfr.BroadcastAddr(ecad.Type).Addr32()
+
+		iframes, err := bus.Cycle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(iframes) != 1 || iframes[0] != p.frames[i] {
+			t.Fatalf("cycle %d: want the frame of the processor", i)
+		}
+		if wc := iframes[0].Datagrams[0].WorkingCounter; wc != 1 {
+			t.Fatalf("cycle %d: working counter %d", i, wc)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/raweni"
+	"strings"
+)
+
+type ODAccess uint8
+
+const (
+	ODReadWrite ODAccess = iota
+	ODReadOnly
+	ODWriteOnly
+)
+
+type ODAddress struct {
+	Index    uint16
+	SubIndex uint8
+}
+
+func (a ODAddress) String() string {
+	return fmt.Sprintf("%04x:%02x", a.Index, a.SubIndex)
+}
+
+type ODEntry struct {
+	Data   []byte
+	Access ODAccess
+	// entries of variable length like strings accept writes of any length
+	// up to MaxLength, 0 means no limit.
+	Variable  bool
+	MaxLength int
+}
+
+type ObjectDictionary map[ODAddress]*ODEntry
+
+func (od ObjectDictionary) hasIndex(index uint16) bool {
+	for a := range od {
+		if a.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+func (od ObjectDictionary) lookup(a ODAddress) (*ODEntry, uint32) {
+	if e, ok := od[a]; ok {
+		return e, 0
+	}
+	if od.hasIndex(a.Index) {
+		return nil, SDOAbortSubIndexDoesNotExist
+	}
+	return nil, SDOAbortObjectDoesNotExist
+}
+
+// NewObjectDictionaryFromESI builds an object dictionary from the objects of
+// an ESI dictionary, using their default data as values.
+func NewObjectDictionaryFromESI(dict raweni.Dictionary) (ObjectDictionary, error) {
+	od := make(ObjectDictionary)
+
+	for _, o := range dict.Objects {
+		index := o.Index()
+		if index == 0 {
+			return nil, fmt.Errorf("object %q has invalid index %q", o.Name, o.IndexRaw)
+		}
+
+		// base types are not necessarily listed
+		dt, ok := dict.DataType(o.Type)
+		if !ok {
+			dt = raweni.DataType{Name: o.Type, BitSize: o.BitSize}
+		}
+
+		if len(dt.SubItems) == 0 {
+			od[ODAddress{index, 0}] = newODEntry(dt, o.BitSize, o.Info.DefaultData(), o.Flags.Access)
+			continue
+		}
+
+		for _, si := range dt.SubItems {
+			var data []byte
+			for _, osi := range o.Info.SubItems {
+				if osi.Name == si.Name {
+					data = osi.Info.DefaultData()
+					break
+				}
+			}
+
+			access := si.Flags.Access
+			if access == "" {
+				access = o.Flags.Access
+			}
+
+			sidt, ok := dict.DataType(si.Type)
+			if !ok {
+				sidt = raweni.DataType{Name: si.Type, BitSize: si.BitSize}
+			}
+			od[ODAddress{index, si.SubIdx()}] = newODEntry(sidt, si.BitSize, data, access)
+		}
+	}
+
+	return od, nil
+}
+
+func newODEntry(dt raweni.DataType, bitsize uint, data []byte, access string) *ODEntry {
+	e := &ODEntry{}
+
+	switch strings.ToLower(access) {
+	case "ro":
+		e.Access = ODReadOnly
+	case "wo":
+		e.Access = ODWriteOnly
+	}
+
+	if strings.HasPrefix(dt.Name, "STRING") || strings.HasPrefix(dt.Name, "OCTET_STRING") {
+		e.Variable = true
+		e.MaxLength = int(bitsize / 8)
+	}
+
+	// values are at least one byte, bit sized values are padded
+	n := int(bitsize+7) / 8
+	if e.Variable {
+		n = len(data)
+	}
+	e.Data = make([]byte, n)
+	copy(e.Data, data)
+
+	return e
+}
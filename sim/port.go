@@ -0,0 +1,163 @@
+package sim
+
+const (
+	NumPorts = 4
+)
+
+type PortType uint8
+
+const (
+	PortNotImplemented PortType = 0x00
+	PortNotConfigured  PortType = 0x01
+	PortEBUS           PortType = 0x02
+	PortMII            PortType = 0x03
+)
+
+func (pt PortType) Implemented() bool {
+	return pt == PortEBUS || pt == PortMII
+}
+
+// loop control settings of the DL control register, 2 bits per port
+type LoopControl uint8
+
+const (
+	LoopAuto      LoopControl = 0x00
+	LoopAutoClose LoopControl = 0x01
+	LoopOpen      LoopControl = 0x02
+	LoopClosed    LoopControl = 0x03
+)
+
+type Port struct {
+	Type PortType
+	Loop LoopControl
+
+	Errors PortErrorCounters
+
+	cable *Cable
+	// set by link loss in LoopAutoClose mode, cleared by writing the
+	// auto close setting again or by receiving a frame.
+	autoClosed bool
+}
+
+func (p *Port) LinkUp() bool {
+	return p.Type.Implemented() && p.cable != nil && !p.cable.Broken
+}
+
+func (p *Port) LoopClosed() bool {
+	switch p.Loop {
+	case LoopAuto:
+		return !p.LinkUp()
+	case LoopAutoClose:
+		return !p.LinkUp() || p.autoClosed
+	case LoopOpen:
+		return false
+	}
+	return true
+}
+
+func (p *Port) linkDown() {
+	if p.Loop == LoopAutoClose {
+		p.autoClosed = true
+	}
+}
+
+// in the order 0 -> 3 -> 1 -> 2 -> 0, this is the port a frame received on
+// port i is forwarded to.
+var nextPort = [NumPorts]int{3, 2, 0, 1}
+
+type PortDescriptorReg struct{ *L2Slave }
+
+func (s *L2Slave) PortDescriptorReg() PortDescriptorReg { return PortDescriptorReg{s} }
+
+func (r PortDescriptorReg) Read(offs uint16, dp *uint8) bool {
+	var d uint8
+	for i := 0; i < NumPorts; i++ {
+		d |= uint8(r.Ports[i].Type&0x03) << uint(2*i)
+	}
+	*dp = d
+	return true
+}
+
+func (r PortDescriptorReg) WriteInteract(offs uint16) bool { return false }
+
+func (r PortDescriptorReg) Latch(shadow []byte, shadowWriteMask []bool) {}
+
+type DLControlReg struct{ *L2Slave }
+
+func (s *L2Slave) DLControlReg() DLControlReg { return DLControlReg{s} }
+
+func (r DLControlReg) Read(offs uint16, dp *uint8) bool {
+	switch offs {
+	case 1:
+		var d uint8
+		for i := 0; i < NumPorts; i++ {
+			d |= uint8(r.Ports[i].Loop&0x03) << uint(2*i)
+		}
+		*dp = d
+	default:
+		*dp = r.dlControlStore[offs]
+	}
+	return true
+}
+
+func (r DLControlReg) WriteInteract(offs uint16) bool { return true }
+
+func (r DLControlReg) Latch(shadow []byte, shadowWriteMask []bool) {
+	for offs := range shadow {
+		if !shadowWriteMask[offs] {
+			continue
+		}
+
+		if offs != 1 {
+			r.dlControlStore[offs] = shadow[offs]
+			continue
+		}
+
+		for i := 0; i < NumPorts; i++ {
+			p := &r.Ports[i]
+			p.Loop = LoopControl(shadow[1]>>uint(2*i)) & 0x03
+			if p.Loop == LoopAutoClose && p.LinkUp() {
+				// writing auto close again reopens the port
+				p.autoClosed = false
+			}
+		}
+	}
+}
+
+type DLStatusReg struct{ *L2Slave }
+
+func (s *L2Slave) DLStatusReg() DLStatusReg { return DLStatusReg{s} }
+
+func (r DLStatusReg) Read(offs uint16, dp *uint8) bool {
+	var d uint8
+	switch offs {
+	case 0:
+		if !r.EEPROM.EENotLoaded {
+			// PDI operational
+			d |= 0x01
+		}
+		// there is no PDI to let its watchdog expire
+		d |= 0x02
+		for i := 0; i < NumPorts; i++ {
+			if r.Ports[i].LinkUp() {
+				d |= 1 << uint(4+i)
+			}
+		}
+	case 1:
+		for i := 0; i < NumPorts; i++ {
+			p := &r.Ports[i]
+			if p.LoopClosed() {
+				d |= 1 << uint(2*i)
+			}
+			if p.LinkUp() {
+				d |= 1 << uint(2*i+1)
+			}
+		}
+	}
+	*dp = d
+	return true
+}
+
+func (r DLStatusReg) WriteInteract(offs uint16) bool { return false }
+
+func (r DLStatusReg) Latch(shadow []byte, shadowWriteMask []bool) {}
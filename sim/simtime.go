@@ -0,0 +1,34 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecfr"
+	"time"
+)
+
+// implemented by frame processors that take part in simulated time. times
+// are simulated nanoseconds.
+type timedFrameProcessor interface {
+	setTime(simtime int64)
+	rxPort(port int, simtime int64)
+	frameDone()
+}
+
+const (
+	// 100 MBit/s
+	byteTime = 80 * time.Nanosecond
+
+	// ethernet header and FCS around the ethercat frame
+	ethEncapsulationLen = 14 + 4
+	ethMinFrameLen      = 64
+	// preamble, SFD and interframe gap
+	ethLineOverheadLen = 8 + 12
+)
+
+// time the frame occupies the wire
+func wireTime(fr *ecfr.Frame) time.Duration {
+	l := fr.ByteLen() + ethEncapsulationLen
+	if l < ethMinFrameLen {
+		l = ethMinFrameLen
+	}
+	return time.Duration(l+ethLineOverheadLen) * byteTime
+}
@@ -0,0 +1,222 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+)
+
+const (
+	NumSyncManagers = 8
+
+	smModeMask    = 0x03
+	smModeMailbox = 0x02
+	smDirMask     = 0x0c
+	smDirWrite    = 0x04 // ECAT writes, PDI reads
+
+	smStatusMailboxFull = 0x08
+	smActivateEnable    = 0x01
+)
+
+// MailboxHandler plays the application side of the mailbox. it is handed
+// every complete message written to the write mailbox and returns the
+// messages to put into the read mailbox. maxlen is the size of the read
+// mailbox.
+type MailboxHandler interface {
+	HandleMailbox(msg []byte, maxlen int) [][]byte
+}
+
+type SyncManager struct {
+	Start      uint16
+	Length     uint16
+	Control    uint8
+	Activate   uint8
+	PDIControl uint8
+
+	Full bool
+
+	// set when the buffer was completed by an ECAT access, processed at
+	// the end of the frame.
+	completed bool
+}
+
+func (sm *SyncManager) enabled() bool {
+	return sm.Activate&smActivateEnable != 0 && sm.Length > 0
+}
+
+func (sm *SyncManager) isMailbox() bool {
+	return sm.Control&smModeMask == smModeMailbox
+}
+
+func (sm *SyncManager) ecatWrites() bool {
+	return sm.Control&smDirMask == smDirWrite
+}
+
+func (sm *SyncManager) contains(addr uint16) bool {
+	return addr >= sm.Start && uint32(addr) < uint32(sm.Start)+uint32(sm.Length)
+}
+
+func (sm *SyncManager) last(addr uint16) bool {
+	return uint32(addr) == uint32(sm.Start)+uint32(sm.Length)-1
+}
+
+func (sm *SyncManager) Reg() *SyncManagerReg {
+	return &SyncManagerReg{sm}
+}
+
+type SyncManagerReg struct{ *SyncManager }
+
+func (r *SyncManagerReg) Read(offs uint16, dp *uint8) bool {
+	switch offs {
+	case ecad.SyncManagerPhysStartAddrOffset:
+		*dp = uint8(r.Start)
+	case ecad.SyncManagerPhysStartAddrOffset + 1:
+		*dp = uint8(r.Start >> 8)
+	case ecad.SyncManagerLengthOffset:
+		*dp = uint8(r.Length)
+	case ecad.SyncManagerLengthOffset + 1:
+		*dp = uint8(r.Length >> 8)
+	case ecad.SyncManagerControlOffset:
+		*dp = r.Control
+	case ecad.SyncManagerStatusOffset:
+		*dp = 0
+		if r.Full {
+			*dp |= smStatusMailboxFull
+		}
+	case ecad.SyncManagerActivateOffset:
+		*dp = r.Activate
+	case ecad.SyncManagerPDIControlOffset:
+		*dp = r.PDIControl
+	}
+	return true
+}
+
+// writes to the status and PDI control bytes are ignored, but count as
+// accesses like on an ESC. masters write the whole channel at once.
+func (r *SyncManagerReg) WriteInteract(offs uint16) bool {
+	return true
+}
+
+func (r *SyncManagerReg) Latch(shadow []byte, shadowWriteMask []bool) {
+	if shadowWriteMask[0] {
+		r.Start = r.Start&0xff00 | uint16(shadow[0])
+	}
+	if shadowWriteMask[1] {
+		r.Start = r.Start&0x00ff | uint16(shadow[1])<<8
+	}
+	if shadowWriteMask[2] {
+		r.Length = r.Length&0xff00 | uint16(shadow[2])
+	}
+	if shadowWriteMask[3] {
+		r.Length = r.Length&0x00ff | uint16(shadow[3])<<8
+	}
+	if shadowWriteMask[4] {
+		r.Control = shadow[4]
+	}
+	if shadowWriteMask[6] {
+		r.Activate = shadow[6]
+		if !r.enabled() {
+			r.Full = false
+			r.completed = false
+		}
+	}
+}
+
+// returns the enabled mailbox sync manager covering addr, nil if there is
+// none.
+func (s *L2Slave) mailboxSM(addr uint16) *SyncManager {
+	for _, sm := range s.SyncManagers {
+		if sm.enabled() && sm.isMailbox() && sm.contains(addr) {
+			return sm
+		}
+	}
+	return nil
+}
+
+func (s *L2Slave) findMailboxSM(ecatWrites bool) *SyncManager {
+	for _, sm := range s.SyncManagers {
+		if sm.enabled() && sm.isMailbox() && sm.ecatWrites() == ecatWrites {
+			return sm
+		}
+	}
+	return nil
+}
+
+// returns false if the access is rejected by the sync manager
+func (s *L2Slave) smRead(sm *SyncManager, addr uint16) bool {
+	if sm.ecatWrites() {
+		return true
+	}
+	if !sm.Full {
+		return false
+	}
+	if sm.last(addr) {
+		sm.completed = true
+	}
+	return true
+}
+
+func (s *L2Slave) smWrite(sm *SyncManager, addr uint16) bool {
+	if !sm.ecatWrites() || sm.Full {
+		return false
+	}
+	if sm.last(addr) {
+		sm.completed = true
+	}
+	return true
+}
+
+// SetupMailbox configures and enables sync managers 0 and 1 as write and
+// read mailbox, like a master does from the SII.
+func (s *L2Slave) SetupMailbox(outStart, outLength, inStart, inLength uint16) {
+	*s.SyncManagers[0] = SyncManager{Start: outStart, Length: outLength, Control: 0x26, Activate: smActivateEnable}
+	*s.SyncManagers[1] = SyncManager{Start: inStart, Length: inLength, Control: 0x22, Activate: smActivateEnable}
+}
+
+// mailbox processing of the application side at the end of a frame
+func (s *L2Slave) processMailbox() {
+	out := s.findMailboxSM(true)
+	if out != nil && out.completed {
+		out.completed = false
+		msg := make([]byte, out.Length)
+		copy(msg, s.BackingMemory[out.Start:])
+
+		// the application reads the mailbox right away
+		out.Full = false
+
+		if s.Mailbox != nil {
+			in := s.findMailboxSM(false)
+			maxlen := 0
+			if in != nil {
+				maxlen = int(in.Length)
+			}
+			s.mailboxQueue = append(s.mailboxQueue, s.Mailbox.HandleMailbox(msg, maxlen)...)
+		}
+	}
+
+	in := s.findMailboxSM(false)
+	if in == nil {
+		return
+	}
+
+	if in.completed {
+		in.completed = false
+		in.Full = false
+	}
+
+	if !in.Full && len(s.mailboxQueue) > 0 {
+		msg := s.mailboxQueue[0]
+		s.mailboxQueue = s.mailboxQueue[1:]
+
+		// the counter of the mailbox header cycles through 1 to 7
+		s.mailboxCounter = s.mailboxCounter%7 + 1
+		if len(msg) >= mailboxHeaderLen {
+			msg[5] = msg[5]&0x8f | s.mailboxCounter<<4
+		}
+
+		buf := s.BackingMemory[in.Start : uint32(in.Start)+uint32(in.Length)]
+		n := copy(buf, msg)
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0
+		}
+		in.Full = true
+	}
+}
@@ -0,0 +1,318 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/distributed/ecat/ecfr"
+	"time"
+)
+
+const (
+	MasterPrimary   = 0
+	MasterSecondary = 1
+)
+
+// a frame passing more hops than this is considered circulating and dropped
+const maxHopsPerSlave = 2 * NumPorts
+
+type endpoint struct {
+	slave *L2Slave // nil for master ports
+	port  int
+}
+
+type Cable struct {
+	ends   [2]endpoint
+	Broken bool
+	// propagation delay of the cable
+	Delay time.Duration
+	// number of frames to corrupt, in either direction. frames damaged
+	// already are not counted. the next ESC counts
+	// the damage as an RX error and marks the frame, ESCs further down count
+	// forwarded RX errors. damaged frames are not processed and the master
+	// discards them.
+	CorruptFrames int
+}
+
+func (c *Cable) peer(e endpoint) endpoint {
+	if c.ends[0] == e {
+		return c.ends[1]
+	}
+	return c.ends[0]
+}
+
+// Topology is a bus of L2Slaves which are wired up by their ports. frames
+// are forwarded along the port order of the ESCs, honoring links and loop
+// control. Topology implements the ecmd Framer interface, frames are sent
+// from the primary master port.
+type Topology struct {
+	oframes []*ecfr.Frame
+
+	// simulated time, advanced by the wire time of every frame and by
+	// CycleTime after every cycle
+	SimTime   time.Duration
+	CycleTime time.Duration
+
+	masterCables [2]*Cable
+	cables       []*Cable
+	slaves       []*L2Slave
+}
+
+func NewTopology() *Topology {
+	return &Topology{}
+}
+
+func (t *Topology) addSlave(s *L2Slave) {
+	for _, ts := range t.slaves {
+		if ts == s {
+			return
+		}
+	}
+	t.slaves = append(t.slaves, s)
+}
+
+func checkPort(s *L2Slave, port int) error {
+	if port < 0 || port >= NumPorts {
+		return fmt.Errorf("invalid port number %d", port)
+	}
+	if !s.Ports[port].Type.Implemented() {
+		return fmt.Errorf("port %d is not implemented", port)
+	}
+	if s.Ports[port].cable != nil {
+		return fmt.Errorf("port %d is already connected", port)
+	}
+	return nil
+}
+
+// ConnectMaster connects master port mp to port of slave s.
+func (t *Topology) ConnectMaster(mp int, s *L2Slave, port int) (*Cable, error) {
+	if mp != MasterPrimary && mp != MasterSecondary {
+		return nil, fmt.Errorf("invalid master port %d", mp)
+	}
+	if t.masterCables[mp] != nil {
+		return nil, fmt.Errorf("master port %d is already connected", mp)
+	}
+	if err := checkPort(s, port); err != nil {
+		return nil, err
+	}
+
+	c := &Cable{ends: [2]endpoint{{nil, mp}, {s, port}}}
+	t.masterCables[mp] = c
+	s.Ports[port].cable = c
+	t.addSlave(s)
+	return c, nil
+}
+
+// Connect wires port aport of slave a to port bport of slave b.
+func (t *Topology) Connect(a *L2Slave, aport int, b *L2Slave, bport int) (*Cable, error) {
+	if err := checkPort(a, aport); err != nil {
+		return nil, err
+	}
+	if err := checkPort(b, bport); err != nil {
+		return nil, err
+	}
+	if a == b && aport == bport {
+		return nil, fmt.Errorf("cannot connect port %d to itself", aport)
+	}
+
+	c := &Cable{ends: [2]endpoint{{a, aport}, {b, bport}}}
+	t.cables = append(t.cables, c)
+	a.Ports[aport].cable = c
+	b.Ports[bport].cable = c
+	t.addSlave(a)
+	t.addSlave(b)
+	return c, nil
+}
+
+// Chain connects slaves in a line, port 1 of each slave to port 0 of the
+// next one. the first slave is connected to the primary master port.
+func (t *Topology) Chain(slaves ...*L2Slave) error {
+	for i, s := range slaves {
+		var err error
+		if i == 0 {
+			_, err = t.ConnectMaster(MasterPrimary, s, 0)
+		} else {
+			_, err = t.Connect(slaves[i-1], 1, s, 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BreakCable injects a cable break at the cable connected to port of slave
+// s. the ports on both ends lose their link.
+func (t *Topology) BreakCable(s *L2Slave, port int) error {
+	return t.setBroken(s, port, true)
+}
+
+func (t *Topology) RepairCable(s *L2Slave, port int) error {
+	return t.setBroken(s, port, false)
+}
+
+func (t *Topology) setBroken(s *L2Slave, port int, broken bool) error {
+	if port < 0 || port >= NumPorts {
+		return fmt.Errorf("invalid port number %d", port)
+	}
+	c := s.Ports[port].cable
+	if c == nil {
+		return fmt.Errorf("no cable connected to port %d", port)
+	}
+
+	if broken && !c.Broken {
+		for _, e := range c.ends {
+			if e.slave != nil {
+				p := &e.slave.Ports[e.port]
+				if p.LinkUp() {
+					incSaturating(&p.Errors.LostLinks)
+				}
+				p.linkDown()
+			}
+		}
+	}
+	c.Broken = broken
+	return nil
+}
+
+// Transmit sends fr out of master port mp and returns the frame and the
+// master port it was received on. if the frame is lost, nil and -1 are
+// returned.
+func (t *Topology) Transmit(mp int, fr *ecfr.Frame) (*ecfr.Frame, int) {
+	if mp != MasterPrimary && mp != MasterSecondary {
+		return nil, -1
+	}
+
+	now := t.SimTime
+	t.SimTime += wireTime(fr)
+	defer func() {
+		for _, s := range t.slaves {
+			s.frameDone()
+		}
+	}()
+
+	e := endpoint{nil, mp}
+	c := t.masterCables[mp]
+	dmg := frameIntact
+	maxHops := maxHopsPerSlave * (len(t.slaves) + 1)
+	for hops := 0; hops < maxHops; hops++ {
+		if c == nil || c.Broken {
+			return nil, -1
+		}
+
+		now += c.Delay
+		if c.CorruptFrames > 0 && dmg == frameIntact {
+			c.CorruptFrames--
+			dmg = frameCorrupted
+		}
+		e = c.peer(e)
+		if e.slave == nil {
+			if dmg != frameIntact {
+				return nil, -1
+			}
+			return fr, e.port
+		}
+
+		var out int
+		out, fr, dmg, now = e.slave.forward(e.port, fr, dmg, now)
+		if fr == nil {
+			return nil, -1
+		}
+
+		e = endpoint{e.slave, out}
+		c = e.slave.Ports[out].cable
+	}
+
+	// circulating frame
+	return nil, -1
+}
+
+// forward passes a frame received on port in through the ESC and returns
+// the port it leaves the ESC on. the frame is processed when it passes
+// port 0, either received there or through the closed loop of port 0,
+// unless it is damaged.
+func (s *L2Slave) forward(in int, fr *ecfr.Frame, dmg frameDamage, now time.Duration) (int, *ecfr.Frame, frameDamage, time.Duration) {
+	s.Ports[in].autoClosed = false
+	if s.Ports[in].LoopClosed() {
+		// closed ports do not accept frames from the outside
+		return -1, nil, dmg, now
+	}
+	dmg = s.countRX(in, dmg)
+
+	// register writes latch at the end of the frame, so the frame is
+	// forwarded with the loop settings in effect when it was received.
+	var closed [NumPorts]bool
+	for i := range closed {
+		closed[i] = s.Ports[i].LoopClosed()
+	}
+
+	port := in
+	for i := 0; i < NumPorts; i++ {
+		if !closed[port] {
+			s.rxPort(port, int64(now))
+		}
+		if port == 0 && dmg == frameIntact {
+			s.setTime(int64(now))
+			fr = s.ProcessFrame(fr)
+			if fr == nil {
+				return -1, nil, dmg, now
+			}
+		}
+
+		now += s.ForwardDelay
+		out := nextPort[port]
+		if out == in || !closed[out] {
+			return out, fr, dmg, now
+		}
+		port = out
+	}
+
+	panic("not reached")
+}
+
+func (t *Topology) New(maxdatalen int) (fr *ecfr.Frame, err error) {
+	var vframe ecfr.Frame
+	buf := make([]byte, maxDatagramsLen+ecfr.FrameOverheadLen)
+	vframe, err = ecfr.PointFrameTo(buf)
+	if err != nil {
+		return
+	}
+
+	vframe.Header.SetType(ecfr.FrameTypeCommand)
+
+	fr = &vframe
+	t.oframes = append(t.oframes, fr)
+	return
+}
+
+func (t *Topology) Cycle() (iframes []*ecfr.Frame, err error) {
+	defer func() {
+		t.oframes = nil
+	}()
+
+	for _, oframe := range t.oframes {
+		var obytes []byte
+
+		obytes, err = oframe.Commit()
+		if err != nil {
+			return
+		}
+
+		coframe := new(ecfr.Frame)
+		cbytes := make([]byte, len(obytes))
+		copy(cbytes, obytes)
+		_, err = coframe.Overlay(cbytes)
+		if err != nil {
+			return
+		}
+
+		coframe, _ = t.Transmit(MasterPrimary, coframe)
+		if coframe != nil {
+			iframes = append(iframes, coframe)
+		}
+	}
+
+	t.SimTime += t.CycleTime
+
+	return
+}
+
+func (t *Topology) Close() error { return nil }
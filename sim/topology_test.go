@@ -0,0 +1,198 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"github.com/distributed/ecat/ecfr"
+	"github.com/distributed/ecat/ecmd"
+	"testing"
+)
+
+func newTestSlaves(n int) []*L2Slave {
+	slaves := make([]*L2Slave, n)
+	for i := range slaves {
+		slaves[i] = NewL2Slave()
+	}
+	return slaves
+}
+
+func countSlaves(t *testing.T, c ecmd.Commander) uint16 {
+	ec, err := c.New(2)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ec.DatagramOut.Command = ecfr.BRD
+	ec.DatagramOut.Addr32 = ecfr.FixedAddr(0, ecad.Type).Addr32()
+
+	err = c.Cycle()
+	if err != nil {
+		t.Fatalf("Cycle failed: %v", err)
+	}
+
+	err = ecmd.ChooseDefaultError(ec)
+	if ecmd.IsNoFrame(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return ec.DatagramIn.WorkingCounter
+}
+
+func TestTopologyCableBreak(t *testing.T) {
+	slaves := newTestSlaves(3)
+	topo := NewTopology()
+	err := topo.Chain(slaves...)
+	if err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	c := ecmd.NewCommandFramer(topo)
+
+	if n := countSlaves(t, c); n != 3 {
+		t.Fatalf("want 3 slaves, have %d", n)
+	}
+
+	err = topo.BreakCable(slaves[1], 1)
+	if err != nil {
+		t.Fatalf("BreakCable failed: %v", err)
+	}
+
+	if n := countSlaves(t, c); n != 2 {
+		t.Fatalf("want 2 slaves after cable break, have %d", n)
+	}
+
+	dls, err := ecmd.ExecuteRead16(c, ecfr.PositionalAddr(-1, ecad.DLStatus), 1)
+	if err != nil {
+		t.Fatalf("reading DL status failed: %v", err)
+	}
+
+	// port 0 link and communication, port 1 no link and loop closed
+	if dls&(1<<4) == 0 || dls&(1<<5) != 0 {
+		t.Fatalf("unexpected link bits in DL status %#04x", dls)
+	}
+	if dls&(1<<8) != 0 || dls&(1<<10) == 0 {
+		t.Fatalf("unexpected loop bits in DL status %#04x", dls)
+	}
+
+	err = topo.RepairCable(slaves[1], 1)
+	if err != nil {
+		t.Fatalf("RepairCable failed: %v", err)
+	}
+
+	if n := countSlaves(t, c); n != 3 {
+		t.Fatalf("want 3 slaves after repair, have %d", n)
+	}
+}
+
+func TestTopologyBranchOrder(t *testing.T) {
+	slaves := newTestSlaves(4)
+	// junction with a branch on port 3
+	slaves[0].Ports[3].Type = PortEBUS
+
+	topo := NewTopology()
+	err := topo.Chain(slaves[0], slaves[1], slaves[2])
+	if err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	_, err = topo.Connect(slaves[0], 3, slaves[3], 0)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	c := ecmd.NewCommandFramer(topo)
+
+	// the branch on port 3 is visited before port 1
+	want := []*L2Slave{slaves[0], slaves[3], slaves[1], slaves[2]}
+	for pos, s := range want {
+		addr := ecfr.PositionalAddr(-int16(pos), ecad.ConfiguredStationAddress)
+		err = ecmd.ExecuteWrite16(c, addr, uint16(0x1000+pos), 1)
+		if err != nil {
+			t.Fatalf("writing station address at position %d failed: %v", pos, err)
+		}
+
+		have := uint16(s.BackingMemory[ecad.ConfiguredStationAddress]) |
+			uint16(s.BackingMemory[ecad.ConfiguredStationAddress+1])<<8
+		if have != uint16(0x1000+pos) {
+			t.Fatalf("position %d was not assigned to the expected slave", pos)
+		}
+	}
+}
+
+func TestTopologyLoopControl(t *testing.T) {
+	slaves := newTestSlaves(2)
+	topo := NewTopology()
+	err := topo.Chain(slaves...)
+	if err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	c := ecmd.NewCommandFramer(topo)
+
+	// force port 1 of the last slave open, there is no link so frames are lost
+	addr := ecfr.PositionalAddr(-1, ecad.DLControl+1)
+	err = ecmd.ExecuteWrite8(c, addr, uint8(LoopOpen)<<2, 1)
+	if err != nil {
+		t.Fatalf("writing DL control failed: %v", err)
+	}
+
+	if n := countSlaves(t, c); n != 0 {
+		t.Fatalf("frames should be lost at open port without link, have wc %d", n)
+	}
+
+	// close port 1 of the first slave, the second slave is cut off
+	slaves[1].Ports[1].Loop = LoopAuto
+	slaves[0].Ports[1].Loop = LoopClosed
+	if n := countSlaves(t, c); n != 1 {
+		t.Fatalf("want 1 slave with closed port, have %d", n)
+	}
+}
+
+func TestTopologyRedundancy(t *testing.T) {
+	slaves := newTestSlaves(3)
+	topo := NewTopology()
+	err := topo.Chain(slaves...)
+	if err != nil {
+		t.Fatalf("Chain failed: %v", err)
+	}
+	_, err = topo.ConnectMaster(MasterSecondary, slaves[2], 1)
+	if err != nil {
+		t.Fatalf("ConnectMaster failed: %v", err)
+	}
+
+	send := func(mp int) (*ecfr.Frame, int) {
+		buf := make([]byte, 64)
+		fr, err := ecfr.PointFrameTo(buf)
+		if err != nil {
+			t.Fatalf("PointFrameTo failed: %v", err)
+		}
+		dg, err := fr.NewDatagram(2)
+		if err != nil {
+			t.Fatalf("NewDatagram failed: %v", err)
+		}
+		dg.Command = ecfr.BRD
+		dg.SetLast(true)
+		_, err = fr.Commit()
+		if err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		return topo.Transmit(mp, &fr)
+	}
+
+	// intact ring, the frame passes all slaves and arrives at the other port
+	fr, mp := send(MasterPrimary)
+	if fr == nil || mp != MasterSecondary || fr.Datagrams[0].WorkingCounter != 3 {
+		t.Fatalf("unexpected result on intact ring, master port %d", mp)
+	}
+
+	err = topo.BreakCable(slaves[1], 1)
+	if err != nil {
+		t.Fatalf("BreakCable failed: %v", err)
+	}
+
+	fr, mp = send(MasterPrimary)
+	if fr == nil || mp != MasterPrimary || fr.Datagrams[0].WorkingCounter != 2 {
+		t.Fatalf("unexpected result on primary segment, master port %d", mp)
+	}
+
+	fr, mp = send(MasterSecondary)
+	if fr == nil || mp != MasterSecondary || fr.Datagrams[0].WorkingCounter != 1 {
+		t.Fatalf("unexpected result on secondary segment, master port %d", mp)
+	}
+}
@@ -0,0 +1,151 @@
+package sim
+
+import (
+	"github.com/distributed/ecat/ecad"
+	"time"
+)
+
+const (
+	watchdogRegLength = 0x44
+
+	smWatchdogTrigger = 0x40
+
+	// ESC reset values, 100 µs units and 100 ms
+	defaultWatchdogDivider = 2498
+	defaultWatchdogTime    = 1000
+
+	watchdogClockPeriod = 40 * time.Nanosecond
+)
+
+// Watchdog is the process data watchdog of an ESC. it is triggered by
+// writes to buffered sync managers with the watchdog trigger enabled and
+// expires TimeProcessData units after the last trigger. there is no PDI,
+// the PDI watchdog time is kept but never expires.
+type Watchdog struct {
+	Divider         uint16
+	TimePDI         uint16
+	TimeProcessData uint16
+
+	Expired            bool
+	CounterProcessData uint8
+	CounterPDI         uint8
+
+	now         int64
+	lastTrigger int64
+	running     bool
+	// set by a write in the current frame
+	triggered bool
+}
+
+func NewWatchdog() *Watchdog {
+	return &Watchdog{
+		Divider:         defaultWatchdogDivider,
+		TimePDI:         defaultWatchdogTime,
+		TimeProcessData: defaultWatchdogTime,
+	}
+}
+
+func (w *Watchdog) timeout() int64 {
+	return int64(w.TimeProcessData) * int64(w.Divider+2) * int64(watchdogClockPeriod)
+}
+
+// advances the watchdog to simtime, in nanoseconds.
+func (w *Watchdog) setTime(simtime int64) {
+	if simtime > w.now {
+		w.now = simtime
+	}
+	if !w.running || w.TimeProcessData == 0 {
+		return
+	}
+	if w.now-w.lastTrigger > w.timeout() {
+		w.Expired = true
+		w.running = false
+		incSaturating(&w.CounterProcessData)
+	}
+}
+
+// restarts the watchdog if the frame triggered it.
+func (w *Watchdog) frameDone() {
+	if !w.triggered {
+		return
+	}
+	w.triggered = false
+	w.lastTrigger = w.now
+	w.running = true
+	w.Expired = false
+}
+
+// returns the enabled buffered sync manager covering addr that triggers the
+// watchdog, nil if there is none.
+func (s *L2Slave) watchdogSM(addr uint16) *SyncManager {
+	for _, sm := range s.SyncManagers {
+		if sm.enabled() && !sm.isMailbox() && sm.Control&smWatchdogTrigger != 0 && sm.contains(addr) {
+			return sm
+		}
+	}
+	return nil
+}
+
+func (w *Watchdog) Reg() *WatchdogReg {
+	return &WatchdogReg{w}
+}
+
+type WatchdogReg struct{ *Watchdog }
+
+func (r *WatchdogReg) Read(offs uint16, dp *uint8) bool {
+	addr := ecad.WatchdogDivider + offs
+	var d uint8
+	switch addr {
+	case ecad.WatchdogDivider:
+		d = uint8(r.Divider)
+	case ecad.WatchdogDivider + 1:
+		d = uint8(r.Divider >> 8)
+	case ecad.WatchdogTimePDI:
+		d = uint8(r.TimePDI)
+	case ecad.WatchdogTimePDI + 1:
+		d = uint8(r.TimePDI >> 8)
+	case ecad.WatchdogTimeProcessData:
+		d = uint8(r.TimeProcessData)
+	case ecad.WatchdogTimeProcessData + 1:
+		d = uint8(r.TimeProcessData >> 8)
+	case ecad.WatchdogStatusProcessData:
+		// set while active or disabled
+		if !r.Expired {
+			d = 0x01
+		}
+	case ecad.WatchdogCounterProcessData:
+		d = r.CounterProcessData
+	case ecad.WatchdogCounterPDI:
+		d = r.CounterPDI
+	}
+	*dp = d
+	return true
+}
+
+func (r *WatchdogReg) WriteInteract(offs uint16) bool { return true }
+
+func (r *WatchdogReg) Latch(shadow []byte, shadowWriteMask []bool) {
+	apply := func(v *uint16, addr uint16) {
+		offs := addr - ecad.WatchdogDivider
+		if shadowWriteMask[offs] {
+			*v = *v&0xff00 | uint16(shadow[offs])
+		}
+		if shadowWriteMask[offs+1] {
+			*v = *v&0x00ff | uint16(shadow[offs+1])<<8
+		}
+	}
+	apply(&r.Divider, ecad.WatchdogDivider)
+	apply(&r.TimePDI, ecad.WatchdogTimePDI)
+	apply(&r.TimeProcessData, ecad.WatchdogTimeProcessData)
+	if r.TimeProcessData == 0 {
+		r.Expired = false
+		r.running = false
+	}
+
+	// writing either counter clears both
+	c := ecad.WatchdogCounterProcessData - ecad.WatchdogDivider
+	if shadowWriteMask[c] || shadowWriteMask[c+1] {
+		r.CounterProcessData = 0
+		r.CounterPDI = 0
+	}
+}